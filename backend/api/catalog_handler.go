@@ -1,10 +1,13 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	_ "strconv"
 
 	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/optimizer"
+	"retr0-kernel/optiquery/parser"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,6 +20,14 @@ func NewAddTableHandler(cm *catalog.CatalogManager) gin.HandlerFunc {
 			return
 		}
 
+		if err := schema.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if schema.RowCount == 0 && schema.Partitioning != nil {
+			schema.RowCount = schema.Partitioning.RowCount()
+		}
+
 		if err := cm.AddTable(&schema); err != nil {
 			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 			return
@@ -69,3 +80,61 @@ func NewUpdateStatsHandler(cm *catalog.CatalogManager) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Statistics updated successfully"})
 	}
 }
+
+// NewAddMaterializedViewHandler registers a view from its defining SQL. The
+// view's rows are read through a table of the same name, which gets the
+// given statistics.
+func NewAddMaterializedViewHandler(cm *catalog.CatalogManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name        string                    `json:"name" binding:"required"`
+			Query       string                    `json:"query" binding:"required"`
+			RowCount    int64                     `json:"row_count"`
+			ColumnStats map[string]catalog.Column `json:"column_stats"`
+			Indexes     []catalog.Index           `json:"indexes"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		definition, err := parser.ParseSQL(req.Query)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schema, err := optimizer.MaterializedViewSchema(req.Name, definition, cm)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schema.Indexes = req.Indexes
+		if err := schema.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// The view is registered with its statistics, so no optimization
+		// sees it without them.
+		for name := range req.ColumnStats {
+			if column := schema.Column(name); column == nil || column.Name != name {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("column_stats names column %s, which view %s does not output", name, req.Name)})
+				return
+			}
+		}
+		schema.SetStats(req.RowCount, req.ColumnStats)
+
+		view := &catalog.MaterializedView{Name: req.Name, Query: req.Query, Definition: definition}
+		if err := cm.AddMaterializedView(view, schema); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Materialized view added successfully", "columns": schema.Columns})
+	}
+}
+
+func NewGetMaterializedViewsHandler(cm *catalog.CatalogManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"views": cm.GetMaterializedViews()})
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"retr0-kernel/optiquery/logical_plan"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PlanDiffRequest struct {
+	Before *logical_plan.LogicalPlan `json:"before" binding:"required"`
+	After  *logical_plan.LogicalPlan `json:"after" binding:"required"`
+}
+
+type PlanDiffResponse struct {
+	Diff  *logical_plan.PlanDiff `json:"diff"`
+	Error string                 `json:"error,omitempty"`
+}
+
+func PlanDiffHandler(c *gin.Context) {
+	var req PlanDiffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, PlanDiffResponse{
+			Error: "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, PlanDiffResponse{
+		Diff: logical_plan.Diff(req.Before, req.After),
+	})
+}
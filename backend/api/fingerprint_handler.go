@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"retr0-kernel/optiquery/logical_plan"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PlanFingerprintRequest struct {
+	LogicalPlan *logical_plan.LogicalPlan `json:"logicalPlan" binding:"required"`
+}
+
+type PlanFingerprintResponse struct {
+	Shape    string `json:"shape"`
+	Exact    string `json:"exact"`
+	Physical string `json:"physical"`
+	Error    string `json:"error,omitempty"`
+}
+
+func PlanFingerprintHandler(c *gin.Context) {
+	var req PlanFingerprintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, PlanFingerprintResponse{
+			Error: "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, PlanFingerprintResponse{
+		Shape: logical_plan.Fingerprint(req.LogicalPlan, logical_plan.FingerprintOptions{}),
+		Exact: logical_plan.Fingerprint(req.LogicalPlan, logical_plan.FingerprintOptions{
+			KeepLiterals: true,
+			OrderedJoins: true,
+		}),
+		Physical: logical_plan.Fingerprint(req.LogicalPlan, logical_plan.FingerprintOptions{
+			KeepLiterals:    true,
+			IncludePhysical: true,
+			OrderedJoins:    true,
+		}),
+	})
+}
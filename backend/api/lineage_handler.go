@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/lineage"
+	"retr0-kernel/optiquery/logical_plan"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LineageRequest struct {
+	LogicalPlan *logical_plan.LogicalPlan `json:"logicalPlan" binding:"required"`
+}
+
+type LineageResponse struct {
+	Lineage *lineage.Graph `json:"lineage,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func NewLineageHandler(cm *catalog.CatalogManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LineageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, LineageResponse{
+				Error: "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		graph, err := lineage.Extract(req.LogicalPlan, cm)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, LineageResponse{
+				Error: "Lineage error: " + err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, LineageResponse{Lineage: graph})
+	}
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/config"
 	"retr0-kernel/optiquery/logical_plan"
 	"retr0-kernel/optiquery/optimizer"
 
@@ -11,49 +15,156 @@ import (
 
 type OptimizeRequest struct {
 	LogicalPlan *logical_plan.LogicalPlan `json:"logicalPlan" binding:"required"`
-	Strategy    string                    `json:"strategy" binding:"required,oneof=cost rule"`
+	Strategy    string                    `json:"strategy" binding:"required,oneof=cost rule cascades"`
+	Format      string                    `json:"format" binding:"omitempty,oneof=json dot mermaid"`
+	Options     *optimizer.Options        `json:"options"`
 }
 
 type OptimizeResponse struct {
 	OptimizedPlan *logical_plan.LogicalPlan `json:"optimizedPlan"`
 	Explain       *optimizer.ExplainResult  `json:"explain"`
+	Options       *optimizer.Options        `json:"options,omitempty"`
+	Fingerprint   string                    `json:"fingerprint,omitempty"`
+	Cached        bool                      `json:"cached,omitempty"`
+	Rendered      string                    `json:"rendered,omitempty"`
 	Error         string                    `json:"error,omitempty"`
 }
 
-func OptimizeHandler(c *gin.Context) {
-	var req OptimizeRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, OptimizeResponse{
-			Error: "Invalid request: " + err.Error(),
-		})
-		return
+// optimizerDefaults are the options requests leave unset, from the server's
+// configuration.
+func optimizerDefaults(cfg *config.Config) optimizer.Options {
+	defaults := optimizer.DefaultOptions()
+	if cfg == nil {
+		return defaults
 	}
+	return optimizer.Options{
+		DisabledRules:      cfg.DisabledRules,
+		MaxIterations:      cfg.MaxOptimizerIterations,
+		DPThreshold:        cfg.JoinDPThreshold,
+		MaxPlans:           cfg.MaxQueryPlans,
+		MaxMemoExpressions: cfg.MaxMemoExpressions,
+	}.WithDefaults(defaults)
+}
 
-	var optimizedPlan *logical_plan.LogicalPlan
-	var explain *optimizer.ExplainResult
-	var err error
+// ValidateOptimizerDefaults checks the optimizer options the configuration
+// sets, so a bad setting stops the server at startup rather than failing every
+// request.
+func ValidateOptimizerDefaults(cfg *config.Config) error {
+	if err := optimizerDefaults(cfg).Validate(); err != nil {
+		return fmt.Errorf("invalid optimizer configuration: %w", err)
+	}
+	return nil
+}
 
-	switch req.Strategy {
-	case "rule":
-		optimizedPlan, explain, err = optimizer.OptimizeWithRules(req.LogicalPlan)
-	case "cost":
-		optimizedPlan, explain, err = optimizer.OptimizeWithCost(req.LogicalPlan)
-	default:
-		c.JSON(http.StatusBadRequest, OptimizeResponse{
-			Error: "Unsupported strategy: " + req.Strategy,
-		})
-		return
+// strategyEnabled reports whether the configuration allows a strategy. The
+// cascades strategy is cost-based.
+func strategyEnabled(cfg *config.Config, strategy string) bool {
+	if cfg == nil {
+		return true
 	}
+	if strategy == "rule" {
+		return cfg.EnableRuleBasedOptimizer
+	}
+	return cfg.EnableCostBasedOptimizer
+}
+
+var optimizeCache = optimizer.NewResultCache(256)
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, OptimizeResponse{
-			Error: "Optimization error: " + err.Error(),
+// requestContext is the request's context, canceled when the client goes
+// away, bounded by the configured optimization timeout.
+func requestContext(c *gin.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	if cfg == nil || cfg.OptimizationTimeout <= 0 {
+		return context.WithCancel(c.Request.Context())
+	}
+	return context.WithTimeout(c.Request.Context(), cfg.OptimizationTimeout)
+}
+
+func NewOptimizeHandler(cm *catalog.CatalogManager, cfg *config.Config) gin.HandlerFunc {
+	defaults := optimizerDefaults(cfg)
+	return func(c *gin.Context) {
+		var req OptimizeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, OptimizeResponse{
+				Error: "Invalid request: " + err.Error(),
+			})
+			return
+		}
+		if req.Format == "" {
+			req.Format = c.Query("format")
+		}
+		if req.Format != "" && req.Format != "json" && req.Format != "dot" && req.Format != "mermaid" {
+			c.JSON(http.StatusBadRequest, OptimizeResponse{
+				Error: "Unsupported format: " + req.Format,
+			})
+			return
+		}
+
+		if !strategyEnabled(cfg, req.Strategy) {
+			c.JSON(http.StatusBadRequest, OptimizeResponse{
+				Error: "Strategy " + req.Strategy + " is disabled by the server configuration",
+			})
+			return
+		}
+
+		// The defaults were checked at startup, so only what the client
+		// sent is validated here.
+		var opts optimizer.Options
+		if req.Options != nil {
+			opts = *req.Options
+		}
+		if err := opts.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, OptimizeResponse{
+				Error: "Invalid options: " + err.Error(),
+			})
+			return
+		}
+		opts = opts.WithDefaults(defaults)
+
+		fingerprint := logical_plan.Fingerprint(req.LogicalPlan, logical_plan.FingerprintOptions{})
+		cacheKey := optimizer.CacheKey(req.Strategy, opts, req.LogicalPlan, cm.Version())
+		if optimizedPlan, explain, ok := optimizeCache.Get(cacheKey); ok {
+			c.JSON(http.StatusOK, OptimizeResponse{
+				OptimizedPlan: optimizedPlan,
+				Explain:       explain,
+				Options:       &opts,
+				Fingerprint:   fingerprint,
+				Cached:        true,
+				Rendered:      renderPlan(optimizedPlan, req.Format),
+			})
+			return
+		}
+
+		ctx, cancel := requestContext(c, cfg)
+		defer cancel()
+		optimizedPlan, explain, err := optimizer.Optimize(ctx, req.Strategy, req.LogicalPlan, cm, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, OptimizeResponse{
+				Error: "Optimization error: " + err.Error(),
+			})
+			return
+		}
+
+		if !explain.Partial {
+			optimizeCache.Put(cacheKey, optimizedPlan, explain)
+		}
+
+		c.JSON(http.StatusOK, OptimizeResponse{
+			OptimizedPlan: optimizedPlan,
+			Explain:       explain,
+			Options:       &opts,
+			Fingerprint:   fingerprint,
+			Rendered:      renderPlan(optimizedPlan, req.Format),
 		})
-		return
 	}
+}
 
-	c.JSON(http.StatusOK, OptimizeResponse{
-		OptimizedPlan: optimizedPlan,
-		Explain:       explain,
-	})
+func renderPlan(plan *logical_plan.LogicalPlan, format string) string {
+	switch format {
+	case "dot":
+		return logical_plan.ToDOT(plan)
+	case "mermaid":
+		return logical_plan.ToMermaid(plan)
+	default:
+		return ""
+	}
 }
@@ -3,6 +3,8 @@ package api
 import (
 	"net/http"
 
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/config"
 	"retr0-kernel/optiquery/logical_plan"
 	"retr0-kernel/optiquery/simulator"
 
@@ -20,24 +22,28 @@ type SimulateResponse struct {
 	Error   string                      `json:"error,omitempty"`
 }
 
-func SimulateHandler(c *gin.Context) {
-	var req SimulateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, SimulateResponse{
-			Error: "Invalid request: " + err.Error(),
+func NewSimulateHandler(cm *catalog.CatalogManager, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SimulateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, SimulateResponse{
+				Error: "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		ctx, cancel := requestContext(c, cfg)
+		defer cancel()
+		metrics, err := simulator.SimulateExecution(ctx, req.Plan, req.Connector, req.Options, cm)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, SimulateResponse{
+				Error: "Simulation error: " + err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, SimulateResponse{
+			Metrics: metrics,
 		})
-		return
 	}
-
-	metrics, err := simulator.SimulateExecution(req.Plan, req.Connector, req.Options)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, SimulateResponse{
-			Error: "Simulation error: " + err.Error(),
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, SimulateResponse{
-		Metrics: metrics,
-	})
 }
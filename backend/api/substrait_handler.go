@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+	"retr0-kernel/optiquery/substrait"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubstraitExportRequest struct {
+	LogicalPlan *logical_plan.LogicalPlan `json:"logicalPlan" binding:"required"`
+}
+
+type SubstraitExportResponse struct {
+	Plan        *substrait.Plan `json:"plan,omitempty"`
+	Unsupported []string        `json:"unsupported,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+type SubstraitImportRequest struct {
+	Plan *substrait.Plan `json:"plan" binding:"required"`
+}
+
+type SubstraitImportResponse struct {
+	LogicalPlan *logical_plan.LogicalPlan `json:"logicalPlan,omitempty"`
+	Unsupported []string                  `json:"unsupported,omitempty"`
+	Error       string                    `json:"error,omitempty"`
+}
+
+func NewSubstraitExportHandler(cm *catalog.CatalogManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SubstraitExportRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, SubstraitExportResponse{
+				Error: "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		plan, err := substrait.Export(req.LogicalPlan, cm)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, SubstraitExportResponse{
+				Unsupported: unsupportedIssues(err),
+				Error:       "Export error: " + err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, SubstraitExportResponse{Plan: plan})
+	}
+}
+
+func NewSubstraitImportHandler(cm *catalog.CatalogManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SubstraitImportRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, SubstraitImportResponse{
+				Error: "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		plan, err := substrait.Import(req.Plan, cm)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, SubstraitImportResponse{
+				Unsupported: unsupportedIssues(err),
+				Error:       "Import error: " + err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, SubstraitImportResponse{LogicalPlan: plan})
+	}
+}
+
+func unsupportedIssues(err error) []string {
+	var unsupported *substrait.UnsupportedError
+	if errors.As(err, &unsupported) {
+		return unsupported.Issues
+	}
+	return nil
+}
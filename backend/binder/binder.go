@@ -0,0 +1,388 @@
+package binder
+
+import (
+	"fmt"
+	"strings"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// Column is one column of a node's output. Relation is the qualifier
+// expressions use for it; Table and SourceName are set only for columns read
+// straight from a table.
+type Column struct {
+	Relation   string                `json:"relation,omitempty"`
+	Name       string                `json:"name"`
+	Table      string                `json:"table,omitempty"`
+	SourceName string                `json:"source_name,omitempty"`
+	DataType   logical_plan.DataType `json:"data_type,omitempty"`
+}
+
+func (c Column) String() string {
+	return logical_plan.ColumnRef{Table: c.Relation, Name: c.Name}.String()
+}
+
+// Scope is the ordered list of columns visible to a node's expressions.
+type Scope []Column
+
+// Resolve finds the column a reference points to. A qualifier matches either
+// the relation name or, for table columns, the table name.
+func (s Scope) Resolve(ref logical_plan.ColumnRef) (int, error) {
+	match := -1
+	for i, column := range s {
+		if !strings.EqualFold(column.Name, ref.Name) {
+			continue
+		}
+		if ref.Table != "" && !strings.EqualFold(column.Relation, ref.Table) && !strings.EqualFold(column.Table, ref.Table) {
+			continue
+		}
+		if match >= 0 {
+			return -1, fmt.Errorf("column %s is ambiguous", ref)
+		}
+		match = i
+	}
+	if match < 0 {
+		return -1, fmt.Errorf("column %s not found", ref)
+	}
+	return match, nil
+}
+
+// BoundPlan records the input and output scope of every node in a plan.
+type BoundPlan struct {
+	inputs  map[*logical_plan.LogicalPlan]Scope
+	outputs map[*logical_plan.LogicalPlan]Scope
+}
+
+// Input is the scope a node's own expressions are resolved against: its
+// child's output, or both children's outputs side by side for a join.
+func (bp *BoundPlan) Input(node *logical_plan.LogicalPlan) Scope {
+	return bp.inputs[node]
+}
+
+func (bp *BoundPlan) Output(node *logical_plan.LogicalPlan) Scope {
+	return bp.outputs[node]
+}
+
+// ResolveInput resolves a reference made by one of node's expressions.
+func (bp *BoundPlan) ResolveInput(node *logical_plan.LogicalPlan, ref logical_plan.ColumnRef) (int, error) {
+	return bp.inputs[node].Resolve(ref)
+}
+
+type binder struct {
+	catalog *catalog.CatalogManager
+	bound   *BoundPlan
+	// inferred holds the columns of tables the catalog does not know, taken
+	// from the references the plan makes to them.
+	inferred map[string][]string
+	errors   []string
+	seen     map[string]bool
+}
+
+// Bind resolves every column reference in the plan. Table schemas come from
+// the catalog; for tables it does not know (or with a nil catalog) the schema
+// is inferred from the references the plan makes.
+func Bind(plan *logical_plan.LogicalPlan, cm *catalog.CatalogManager) (*BoundPlan, error) {
+	if plan == nil {
+		return nil, fmt.Errorf("cannot bind nil plan")
+	}
+
+	b := &binder{
+		catalog: cm,
+		seen:    make(map[string]bool),
+		bound: &BoundPlan{
+			inputs:  make(map[*logical_plan.LogicalPlan]Scope),
+			outputs: make(map[*logical_plan.LogicalPlan]Scope),
+		},
+	}
+	b.inferred = b.inferSchemas(plan)
+	b.bind(plan)
+
+	if len(b.errors) > 0 {
+		return b.bound, fmt.Errorf("binding failed: %s", strings.Join(b.errors, "; "))
+	}
+	return b.bound, nil
+}
+
+func (b *binder) errorf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if !b.seen[message] {
+		b.seen[message] = true
+		b.errors = append(b.errors, message)
+	}
+}
+
+func (b *binder) bind(node *logical_plan.LogicalPlan) Scope {
+	var input Scope
+	for _, child := range node.Children {
+		input = append(input, b.bind(child)...)
+	}
+	b.bound.inputs[node] = input
+
+	var output Scope
+	switch node.NodeType {
+	case logical_plan.NodeTypeScan:
+		output = b.scanColumns(node)
+		b.bound.inputs[node] = output
+
+	case logical_plan.NodeTypeProject:
+		for _, column := range node.Projections {
+			output = append(output, b.projectColumns(node, input, column)...)
+		}
+
+	case logical_plan.NodeTypeAggregate:
+		for _, column := range node.GroupBy {
+			if index, ok := b.resolve(node, input, logical_plan.ColumnRef{Table: column.Table, Name: column.Name}); ok {
+				output = append(output, input[index])
+			}
+		}
+		for _, agg := range node.Aggregates {
+			name := agg.Alias
+			if name == "" {
+				name = strings.ToLower(string(agg.Type))
+			}
+			output = append(output, Column{Name: name, DataType: aggregateType(agg, input)})
+		}
+
+	case logical_plan.NodeTypeUnion:
+		if len(node.Children) > 0 {
+			output = b.bound.outputs[node.Children[0]]
+		}
+
+	case logical_plan.NodeTypeEmpty:
+		for _, column := range node.Projections {
+			output = append(output, Column{Relation: column.Table, Name: column.Name})
+		}
+
+	case logical_plan.NodeTypeJoin:
+		output = input
+		if node.JoinType.LeftOnly() && len(node.Children) == 2 {
+			output = b.bound.outputs[node.Children[0]]
+		}
+
+	case logical_plan.NodeTypeSubquery:
+		for _, column := range input {
+			column.Relation = node.Alias
+			output = append(output, column)
+		}
+
+	default:
+		output = input
+	}
+
+	b.checkReferences(node, input)
+	b.bound.outputs[node] = output
+	return output
+}
+
+func (b *binder) scanColumns(node *logical_plan.LogicalPlan) Scope {
+	var names []string
+	types := make(map[string]logical_plan.DataType)
+
+	if b.catalog != nil {
+		if table, err := b.catalog.GetTable(node.TableName); err == nil {
+			for _, column := range table.Columns {
+				names = append(names, column.Name)
+				types[column.Name] = logical_plan.DataType(column.DataType)
+			}
+		}
+	}
+	if names == nil {
+		names = b.inferred[node.RelationName()]
+	}
+
+	scope := make(Scope, len(names))
+	for i, name := range names {
+		scope[i] = Column{
+			Relation:   node.RelationName(),
+			Name:       name,
+			Table:      node.TableName,
+			SourceName: name,
+			DataType:   types[name],
+		}
+	}
+	return scope
+}
+
+func (b *binder) projectColumns(node *logical_plan.LogicalPlan, input Scope, column logical_plan.Column) Scope {
+	if column.Expression != nil {
+		name := column.Alias
+		if name == "" {
+			name = column.Name
+		}
+		return Scope{{Name: name}}
+	}
+
+	if column.Name == "*" {
+		var columns Scope
+		for _, c := range input {
+			if column.Table == "" || strings.EqualFold(c.Relation, column.Table) || strings.EqualFold(c.Table, column.Table) {
+				columns = append(columns, c)
+			}
+		}
+		return columns
+	}
+
+	index, ok := b.resolve(node, input, logical_plan.ColumnRef{Table: column.Table, Name: column.Name})
+	if !ok {
+		return nil
+	}
+	c := input[index]
+	if column.Alias != "" {
+		c = Column{Name: column.Alias, DataType: c.DataType}
+	}
+	return Scope{c}
+}
+
+// checkReferences resolves every expression the node holds against its input.
+func (b *binder) checkReferences(node *logical_plan.LogicalPlan, input Scope) {
+	for _, ref := range References(node) {
+		b.resolve(node, input, ref)
+	}
+}
+
+func (b *binder) resolve(node *logical_plan.LogicalPlan, input Scope, ref logical_plan.ColumnRef) (int, bool) {
+	index, err := input.Resolve(ref)
+	if err != nil {
+		b.errorf("%s: %v", node.Label(), err)
+		return -1, false
+	}
+	return index, true
+}
+
+// References lists the columns read by a node's own expressions, not those
+// of its children. Plain projections and group-by columns are included.
+func References(node *logical_plan.LogicalPlan) []logical_plan.ColumnRef {
+	var refs []logical_plan.ColumnRef
+	collect := func(expr *logical_plan.Expression) {
+		refs = append(refs, ExpressionReferences(expr)...)
+	}
+
+	if node.Predicate != nil {
+		collect(node.Predicate.Expression)
+	}
+	if node.JoinCondition != nil {
+		collect(node.JoinCondition.Left)
+		collect(node.JoinCondition.Right)
+	}
+	projections := node.Projections
+	if node.NodeType == logical_plan.NodeTypeEmpty {
+		// An empty node's columns describe its schema rather than read anything.
+		projections = nil
+	}
+	for _, column := range projections {
+		if column.Expression != nil {
+			collect(column.Expression)
+		} else if column.Name != "*" {
+			refs = append(refs, logical_plan.ColumnRef{Table: column.Table, Name: column.Name})
+		}
+	}
+	for _, column := range node.GroupBy {
+		refs = append(refs, logical_plan.ColumnRef{Table: column.Table, Name: column.Name})
+	}
+	for _, agg := range node.Aggregates {
+		collect(agg.Column)
+	}
+	for _, ob := range node.OrderBy {
+		collect(ob.Expression)
+	}
+	return refs
+}
+
+// ExpressionReferences lists the column references in an expression, not
+// descending into subqueries.
+func ExpressionReferences(expr *logical_plan.Expression) []logical_plan.ColumnRef {
+	var refs []logical_plan.ColumnRef
+	logical_plan.TransformExpression(expr, func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if e.IsColumn() {
+			refs = append(refs, *e.Column)
+		}
+		return e, false, nil
+	})
+	return refs
+}
+
+// inferSchemas collects, for every scan whose table the catalog does not know,
+// the column names the plan references through its relation name. Unqualified
+// references are attributed to such a scan only when it is the only one, and
+// the name is not a known column or an alias defined in the plan.
+func (b *binder) inferSchemas(plan *logical_plan.LogicalPlan) map[string][]string {
+	unknown := make(map[string]string)
+	defined := make(map[string]bool)
+	var refs []logical_plan.ColumnRef
+	var walk func(node *logical_plan.LogicalPlan)
+	walk = func(node *logical_plan.LogicalPlan) {
+		if node.NodeType == logical_plan.NodeTypeScan {
+			var table *catalog.TableSchema
+			if b.catalog != nil {
+				table, _ = b.catalog.GetTable(node.TableName)
+			}
+			if table == nil {
+				unknown[node.RelationName()] = node.TableName
+			} else {
+				for _, column := range table.Columns {
+					defined[strings.ToLower(column.Name)] = true
+				}
+			}
+		}
+		for _, column := range node.Projections {
+			if column.Alias != "" {
+				defined[strings.ToLower(column.Alias)] = true
+			} else if column.Expression != nil {
+				defined[strings.ToLower(column.Name)] = true
+			}
+		}
+		for _, agg := range node.Aggregates {
+			if agg.Alias != "" {
+				defined[strings.ToLower(agg.Alias)] = true
+			}
+		}
+		refs = append(refs, References(node)...)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(plan)
+
+	inferred := make(map[string][]string)
+	seen := make(map[string]bool)
+	add := func(relation, name string) {
+		key := relation + "." + strings.ToLower(name)
+		if !seen[key] {
+			seen[key] = true
+			inferred[relation] = append(inferred[relation], name)
+		}
+	}
+
+	for _, ref := range refs {
+		if ref.Table == "" {
+			if len(unknown) == 1 && !defined[strings.ToLower(ref.Name)] {
+				for relation := range unknown {
+					add(relation, ref.Name)
+				}
+			}
+			continue
+		}
+		for relation, table := range unknown {
+			if strings.EqualFold(ref.Table, relation) || strings.EqualFold(ref.Table, table) {
+				add(relation, ref.Name)
+			}
+		}
+	}
+	return inferred
+}
+
+func aggregateType(agg logical_plan.AggregateFunction, input Scope) logical_plan.DataType {
+	switch agg.Type {
+	case logical_plan.AggregateCount:
+		return logical_plan.DataTypeInt
+	case logical_plan.AggregateAvg:
+		return logical_plan.DataTypeFloat
+	}
+	if agg.Column != nil && agg.Column.IsColumn() {
+		if index, err := input.Resolve(*agg.Column.Column); err == nil {
+			return input[index].DataType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,352 @@
+// Package cascades is a memo-based optimizer in the style of Cascades. The
+// memo holds groups of logically equivalent expressions; transformation
+// rules add expressions to groups, implementation rules turn them into
+// physical operators, and a top-down search picks the cheapest operator for
+// each group and required sort order, pruning alternatives that cannot beat
+// the best plan found so far.
+package cascades
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// Memo is the search space: every group and the expressions in it.
+type Memo struct {
+	groups      []*Group
+	expressions map[string]*GroupExpression
+	// joinGroups finds the group of a tree of inner joins by the inputs it
+	// joins and the conditions applied in it, so that every order of the
+	// same joins ends up in one group.
+	joinGroups map[string]*Group
+	root       *Group
+}
+
+// Group is a set of logically equivalent expressions, which share their
+// logical properties: the rows they produce and the relations they read.
+type Group struct {
+	ID          int
+	Expressions []*GroupExpression
+
+	relations map[string]bool
+	// leaves and conjuncts are set for groups of inner joins: the groups
+	// joined, and every condition applied at or below the group.
+	leaves    []int
+	conjuncts []string
+
+	representative *logical_plan.LogicalPlan
+	rows           int64
+	cost           float64
+	estimated      bool
+
+	explored bool
+	winners  map[string]*winner
+	// failed records, per required ordering, the highest cost bound no plan
+	// could be found under.
+	failed map[string]float64
+}
+
+// GroupExpression is one operator whose inputs are groups.
+type GroupExpression struct {
+	ID       int
+	Node     *logical_plan.LogicalPlan
+	Children []*Group
+	// Rule is the transformation that added the expression, or empty for an
+	// expression of the original plan.
+	Rule string
+
+	group   *Group
+	applied map[string]bool
+}
+
+func newMemo() *Memo {
+	return &Memo{
+		expressions: make(map[string]*GroupExpression),
+		joinGroups:  make(map[string]*Group),
+	}
+}
+
+// insert copies a plan into the memo, one group per node.
+func (m *Memo) insert(plan *logical_plan.LogicalPlan) *Group {
+	children := make([]*Group, len(plan.Children))
+	for i, child := range plan.Children {
+		children[i] = m.insert(child)
+	}
+	expression, _ := m.add(operator(plan), children, "", nil)
+	return expression.group
+}
+
+// add puts an expression into target, or when target is nil into the group
+// of an equivalent join tree or a new group. It returns the existing
+// expression and false when the memo already has it.
+func (m *Memo) add(node *logical_plan.LogicalPlan, children []*Group, rule string, target *Group) (*GroupExpression, bool) {
+	key := expressionKey(node, children)
+	if existing, ok := m.expressions[key]; ok {
+		return existing, false
+	}
+
+	var joinKey string
+	var leaves []int
+	var conjuncts []string
+	if isInnerJoin(node) {
+		leaves, conjuncts = joinSignature(node, children)
+		joinKey = fmt.Sprint(leaves) + "|" + strings.Join(conjuncts, " AND ")
+		if target == nil {
+			target = m.joinGroups[joinKey]
+		}
+	}
+	if target == nil {
+		target = &Group{
+			ID:        len(m.groups),
+			leaves:    leaves,
+			conjuncts: conjuncts,
+			winners:   make(map[string]*winner),
+			failed:    make(map[string]float64),
+		}
+		m.groups = append(m.groups, target)
+		if joinKey != "" {
+			m.joinGroups[joinKey] = target
+		}
+	}
+
+	expression := &GroupExpression{
+		ID:       len(m.expressions),
+		Node:     node,
+		Children: children,
+		Rule:     rule,
+		group:    target,
+		applied:  make(map[string]bool),
+	}
+	m.expressions[key] = expression
+	target.Expressions = append(target.Expressions, expression)
+	return expression, true
+}
+
+// Representative is a logical plan the group produces: its first expression
+// over the representatives of its inputs. Logical properties are estimated
+// on it.
+func (g *Group) Representative() *logical_plan.LogicalPlan {
+	if g.representative == nil {
+		expression := g.Expressions[0]
+		plan := expression.Node.Clone()
+		plan.Children = make([]*logical_plan.LogicalPlan, len(expression.Children))
+		for i, child := range expression.Children {
+			plan.Children[i] = child.Representative()
+		}
+		g.representative = plan
+	}
+	return g.representative
+}
+
+// Relations are the lower-cased names the group's columns can be qualified
+// with.
+func (g *Group) Relations() map[string]bool {
+	if g.relations == nil {
+		g.relations = make(map[string]bool)
+		var walk func(node *logical_plan.LogicalPlan)
+		walk = func(node *logical_plan.LogicalPlan) {
+			switch {
+			case node.NodeType == logical_plan.NodeTypeSubquery && node.Alias != "":
+				g.relations[strings.ToLower(node.Alias)] = true
+				return
+			case node.NodeType == logical_plan.NodeTypeScan:
+				g.relations[strings.ToLower(node.RelationName())] = true
+			}
+			for _, child := range node.Children {
+				walk(child)
+			}
+		}
+		walk(g.Representative())
+	}
+	return g.relations
+}
+
+// operator copies a plan node without its children.
+func operator(plan *logical_plan.LogicalPlan) *logical_plan.LogicalPlan {
+	node := *plan
+	node.Children = nil
+	clone := node.Clone()
+	clone.ID = plan.ID
+	clone.Children = nil
+	clone.EstimatedRows, clone.EstimatedCost = nil, nil
+	return clone
+}
+
+func isInnerJoin(node *logical_plan.LogicalPlan) bool {
+	if node.NodeType != logical_plan.NodeTypeJoin {
+		return false
+	}
+	switch node.JoinType {
+	case logical_plan.JoinTypeInner, logical_plan.JoinTypeCross, "":
+		return true
+	}
+	return false
+}
+
+// expressionKey identifies an expression. Joins are compared by their
+// inputs and conditions, every other operator by the node it came from.
+func expressionKey(node *logical_plan.LogicalPlan, children []*Group) string {
+	ids := make([]string, len(children))
+	for i, child := range children {
+		ids[i] = fmt.Sprint(child.ID)
+	}
+	if node.NodeType != logical_plan.NodeTypeJoin {
+		return fmt.Sprintf("%s %s (%s)", node.NodeType, node.ID, strings.Join(ids, ", "))
+	}
+	joinType := node.JoinType
+	if isInnerJoin(node) {
+		joinType = logical_plan.JoinTypeInner
+	}
+	return fmt.Sprintf("%s join (%s) ON %s", joinType, strings.Join(ids, ", "), strings.Join(conjunctStrings(joinConjuncts(node)), " AND "))
+}
+
+// joinSignature lists the inputs of a tree of inner joins that are not inner
+// joins themselves, and every condition of the tree.
+func joinSignature(node *logical_plan.LogicalPlan, children []*Group) ([]int, []string) {
+	var leaves []int
+	conjuncts := conjunctStrings(joinConjuncts(node))
+	for _, child := range children {
+		if child.leaves != nil {
+			leaves = append(leaves, child.leaves...)
+			conjuncts = append(conjuncts, child.conjuncts...)
+		} else {
+			leaves = append(leaves, child.ID)
+		}
+	}
+	sort.Ints(leaves)
+	sort.Strings(conjuncts)
+	return leaves, conjuncts
+}
+
+func joinConjuncts(node *logical_plan.LogicalPlan) []*logical_plan.Expression {
+	var conjuncts []*logical_plan.Expression
+	if node.JoinCondition != nil {
+		conjuncts = append(conjuncts, logical_plan.NewBinaryOpExpression(
+			logical_plan.BinaryOperator(node.JoinCondition.Operator), node.JoinCondition.Left, node.JoinCondition.Right))
+	}
+	if node.Predicate != nil {
+		conjuncts = append(conjuncts, logical_plan.SplitConjuncts(node.Predicate.Expression)...)
+	}
+	return conjuncts
+}
+
+// conjunctStrings writes conjuncts sorted, with the operands of symmetric
+// comparisons in a fixed order, so a = b and b = a compare equal.
+func conjunctStrings(conjuncts []*logical_plan.Expression) []string {
+	strs := make([]string, len(conjuncts))
+	for i, conjunct := range conjuncts {
+		strs[i] = conjunct.String()
+		if conjunct.Kind == logical_plan.ExprBinaryOp {
+			if flipped, ok := flippedComparisons[conjunct.BinaryOp]; ok {
+				other := logical_plan.NewBinaryOpExpression(flipped, conjunct.Right, conjunct.Left).String()
+				if other < strs[i] {
+					strs[i] = other
+				}
+			}
+		}
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+var flippedComparisons = map[logical_plan.BinaryOperator]logical_plan.BinaryOperator{
+	logical_plan.OpEq:    logical_plan.OpEq,
+	logical_plan.OpNotEq: logical_plan.OpNotEq,
+	logical_plan.OpLt:    logical_plan.OpGt,
+	logical_plan.OpLtEq:  logical_plan.OpGtEq,
+	logical_plan.OpGt:    logical_plan.OpLt,
+	logical_plan.OpGtEq:  logical_plan.OpLtEq,
+}
+
+// MemoExport is the explored search space, for display.
+type MemoExport struct {
+	Root       int           `json:"root"`
+	Groups     []GroupExport `json:"groups"`
+	Statistics Statistics    `json:"statistics"`
+}
+
+type GroupExport struct {
+	ID          int                `json:"id"`
+	Rows        int64              `json:"rows"`
+	Relations   []string           `json:"relations,omitempty"`
+	Expressions []ExpressionExport `json:"expressions"`
+	Winners     []WinnerExport     `json:"winners,omitempty"`
+}
+
+type ExpressionExport struct {
+	ID       int    `json:"id"`
+	Operator string `json:"operator"`
+	Children []int  `json:"children,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+}
+
+// WinnerExport is the cheapest physical operator found for a group under a
+// required ordering. Expression is absent for a sort added to enforce the
+// ordering.
+type WinnerExport struct {
+	Required   string        `json:"required"`
+	Expression *int          `json:"expression,omitempty"`
+	Operator   string        `json:"operator"`
+	Cost       float64       `json:"cost"`
+	Inputs     []InputExport `json:"inputs,omitempty"`
+}
+
+type InputExport struct {
+	Group    int    `json:"group"`
+	Required string `json:"required"`
+}
+
+// Statistics counts the work of a search.
+type Statistics struct {
+	Groups               int            `json:"groups"`
+	LogicalExpressions   int            `json:"logical_expressions"`
+	PhysicalAlternatives int            `json:"physical_alternatives"`
+	Pruned               int            `json:"pruned"`
+	RuleApplications     map[string]int `json:"rule_applications"`
+	ExplorationCapped    bool           `json:"exploration_capped,omitempty"`
+	// ExplorationInterrupted is set when the context ended exploration, so
+	// only the expressions found until then were costed.
+	ExplorationInterrupted bool `json:"exploration_interrupted,omitempty"`
+}
+
+// Export describes every group, its expressions and its winners.
+func (m *Memo) Export(stats Statistics) *MemoExport {
+	export := &MemoExport{Root: m.root.ID, Statistics: stats}
+	for _, g := range m.groups {
+		group := GroupExport{ID: g.ID, Rows: g.rows}
+		for relation := range g.Relations() {
+			group.Relations = append(group.Relations, relation)
+		}
+		sort.Strings(group.Relations)
+		for _, expression := range g.Expressions {
+			e := ExpressionExport{ID: expression.ID, Operator: expression.Node.Label(), Rule: expression.Rule}
+			for _, child := range expression.Children {
+				e.Children = append(e.Children, child.ID)
+			}
+			group.Expressions = append(group.Expressions, e)
+		}
+
+		keys := make([]string, 0, len(g.winners))
+		for key := range g.winners {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			w := g.winners[key]
+			winner := WinnerExport{Required: key, Operator: w.operator, Cost: w.cost}
+			if w.expression != nil {
+				id := w.expression.ID
+				winner.Expression = &id
+			}
+			for _, input := range w.inputs {
+				winner.Inputs = append(winner.Inputs, InputExport{Group: input.group.ID, Required: input.required.String()})
+			}
+			group.Winners = append(group.Winners, winner)
+		}
+		export.Groups = append(export.Groups, group)
+	}
+	return export
+}
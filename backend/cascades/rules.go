@@ -0,0 +1,483 @@
+package cascades
+
+import (
+	"math"
+	"strings"
+
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// TransformationRule adds logically equivalent expressions to the memo.
+// Apply returns how many expressions it added.
+type TransformationRule interface {
+	Name() string
+	Apply(m *Memo, expression *GroupExpression) int
+}
+
+// JoinCommutativityRule swaps the inputs of an inner join.
+type JoinCommutativityRule struct{}
+
+func (r *JoinCommutativityRule) Name() string {
+	return "JoinCommutativity"
+}
+
+func (r *JoinCommutativityRule) Apply(m *Memo, expression *GroupExpression) int {
+	if !isInnerJoin(expression.Node) || len(expression.Children) != 2 {
+		return 0
+	}
+	left, right := expression.Children[0], expression.Children[1]
+	node := buildJoin(right, left, joinConjuncts(expression.Node))
+	if _, added := m.add(node, []*Group{right, left}, r.Name(), expression.group); added {
+		return 1
+	}
+	return 0
+}
+
+// JoinAssociativityRule turns (A ⋈ B) ⋈ C into A ⋈ (B ⋈ C), moving each
+// condition to the lowest join that has the columns it reads. It does not
+// create cross joins.
+type JoinAssociativityRule struct{}
+
+func (r *JoinAssociativityRule) Name() string {
+	return "JoinAssociativity"
+}
+
+func (r *JoinAssociativityRule) Apply(m *Memo, expression *GroupExpression) int {
+	if !isInnerJoin(expression.Node) || len(expression.Children) != 2 {
+		return 0
+	}
+	added := 0
+	outer, c := expression.Children[0], expression.Children[1]
+	for i := 0; i < len(outer.Expressions); i++ {
+		inner := outer.Expressions[i]
+		if !isInnerJoin(inner.Node) || len(inner.Children) != 2 {
+			continue
+		}
+		a, b := inner.Children[0], inner.Children[1]
+
+		bc := make(map[string]bool)
+		for relation := range b.Relations() {
+			bc[relation] = true
+		}
+		for relation := range c.Relations() {
+			bc[relation] = true
+		}
+		var lower, upper []*logical_plan.Expression
+		known := true
+		for _, conjunct := range append(joinConjuncts(inner.Node), joinConjuncts(expression.Node)...) {
+			relations, ok := conjunctRelations(conjunct)
+			if !ok {
+				known = false
+				break
+			}
+			if subset(relations, bc) {
+				lower = append(lower, conjunct)
+			} else {
+				upper = append(upper, conjunct)
+			}
+		}
+		if !known || len(lower) == 0 || len(upper) == 0 {
+			continue
+		}
+
+		bcNode := buildJoin(b, c, lower)
+		bcExpression, isNew := m.add(bcNode, []*Group{b, c}, r.Name(), nil)
+		if isNew {
+			added++
+		}
+		if _, isNew := m.add(buildJoin(a, bcExpression.group, upper), []*Group{a, bcExpression.group}, r.Name(), expression.group); isNew {
+			added++
+		}
+	}
+	return added
+}
+
+// buildJoin joins two groups on conjuncts. The first comparison between the
+// two inputs becomes the join condition, oriented with the left input's
+// operand first, and the rest the join's predicate.
+func buildJoin(left, right *Group, conjuncts []*logical_plan.Expression) *logical_plan.LogicalPlan {
+	var condition *logical_plan.JoinCondition
+	var residual []*logical_plan.Expression
+	for _, conjunct := range conjuncts {
+		if condition == nil && conjunct.Kind == logical_plan.ExprBinaryOp && conjunct.BinaryOp.IsComparison() {
+			switch {
+			case reads(conjunct.Left, left) && reads(conjunct.Right, right):
+				condition = &logical_plan.JoinCondition{Left: conjunct.Left, Right: conjunct.Right, Operator: string(conjunct.BinaryOp)}
+				continue
+			case reads(conjunct.Left, right) && reads(conjunct.Right, left):
+				condition = &logical_plan.JoinCondition{Left: conjunct.Right, Right: conjunct.Left, Operator: string(flippedComparisons[conjunct.BinaryOp])}
+				continue
+			}
+		}
+		residual = append(residual, conjunct)
+	}
+
+	joinType := logical_plan.JoinTypeInner
+	if condition == nil && len(residual) == 0 {
+		joinType = logical_plan.JoinTypeCross
+	}
+	node := logical_plan.NewJoinNode(nil, nil, joinType, condition)
+	node.Children = nil
+	if len(residual) > 0 {
+		node.Predicate = &logical_plan.Predicate{Expression: logical_plan.CombineConjuncts(residual)}
+	}
+	return node
+}
+
+// conjunctRelations lists the relations a conjunct reads. It fails for
+// unqualified columns and subqueries.
+func conjunctRelations(conjunct *logical_plan.Expression) (map[string]bool, bool) {
+	relations := make(map[string]bool)
+	known := true
+	logical_plan.TransformExpression(conjunct, func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		switch {
+		case e.Kind == logical_plan.ExprSubquery:
+			known = false
+		case e.IsColumn():
+			if e.Column.Table == "" {
+				known = false
+			}
+			relations[strings.ToLower(e.Column.Table)] = true
+		}
+		return e, false, nil
+	})
+	return relations, known
+}
+
+// reads reports whether expr reads columns of group and no other.
+func reads(expr *logical_plan.Expression, group *Group) bool {
+	relations, ok := conjunctRelations(expr)
+	return ok && len(relations) > 0 && subset(relations, group.Relations())
+}
+
+func subset(a, b map[string]bool) bool {
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// implementation is one physical operator for an expression: what it needs
+// from its inputs, the ordering it produces and its own cost.
+type implementation struct {
+	operator  string
+	metadata  map[string]interface{}
+	required  []logical_plan.Ordering
+	provides  logical_plan.Ordering
+	localCost float64
+	// elided marks a sort whose input is already ordered, which is dropped
+	// from the plan, and limit a top-N over such input, which becomes a
+	// limit.
+	elided bool
+	limit  bool
+}
+
+// ImplementationRule lists the physical operators that can execute an
+// expression and deliver the required ordering. Operators that cannot are
+// left out; the search adds a sort on top where that is cheaper.
+type ImplementationRule interface {
+	Name() string
+	Implement(s *search, expression *GroupExpression, required logical_plan.Ordering) []implementation
+}
+
+// ScanImplementationRule reads a table sequentially or, to deliver a
+// required ordering, through a btree index on the ordering's columns.
+type ScanImplementationRule struct{}
+
+func (r *ScanImplementationRule) Name() string {
+	return "ScanImplementation"
+}
+
+func (r *ScanImplementationRule) Implement(s *search, expression *GroupExpression, required logical_plan.Ordering) []implementation {
+	node := expression.Node
+	if node.NodeType != logical_plan.NodeTypeScan {
+		return nil
+	}
+	if len(required) == 0 {
+		return []implementation{{
+			operator:  "seq_scan",
+			metadata:  map[string]interface{}{"scan_type": "sequential"},
+			localCost: s.localCost(expression.group),
+		}}
+	}
+	table, err := s.catalog.GetTable(node.TableName)
+	if err != nil {
+		return nil
+	}
+	columns := make([]string, len(required))
+	for i, key := range required {
+		if key.Column.Table != "" && !strings.EqualFold(key.Column.Table, node.RelationName()) || key.Descending != required[0].Descending {
+			return nil
+		}
+		columns[i] = key.Column.Name
+	}
+	index := table.OrderedIndex(columns)
+	if index == nil {
+		return nil
+	}
+	metadata := map[string]interface{}{"scan_type": "index", "index_name": index.Name, "ordered": true}
+	if required[0].Descending {
+		metadata["scan_direction"] = "backward"
+	}
+	return []implementation{{
+		operator:  "index_scan",
+		metadata:  metadata,
+		provides:  required,
+		localCost: s.localCost(expression.group),
+	}}
+}
+
+// PassThroughImplementationRule executes filters and projections on the
+// rows of their input in order, so they keep any ordering the input has.
+type PassThroughImplementationRule struct{}
+
+func (r *PassThroughImplementationRule) Name() string {
+	return "PassThroughImplementation"
+}
+
+func (r *PassThroughImplementationRule) Implement(s *search, expression *GroupExpression, required logical_plan.Ordering) []implementation {
+	node := expression.Node
+	switch node.NodeType {
+	case logical_plan.NodeTypeFilter:
+	case logical_plan.NodeTypeProject:
+		if !projectionKeeps(node, required) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return []implementation{{
+		operator:  string(node.NodeType),
+		required:  []logical_plan.Ordering{required},
+		provides:  required,
+		localCost: s.localCost(expression.group),
+	}}
+}
+
+// JoinImplementationRule offers hash joins building either input, nested
+// loop joins, and for equi-joins on columns a sort-merge join, which needs
+// both inputs ordered on the join keys and returns rows in that order.
+type JoinImplementationRule struct{}
+
+func (r *JoinImplementationRule) Name() string {
+	return "JoinImplementation"
+}
+
+func (r *JoinImplementationRule) Implement(s *search, expression *GroupExpression, required logical_plan.Ordering) []implementation {
+	node := expression.Node
+	if node.NodeType != logical_plan.NodeTypeJoin || len(expression.Children) != 2 {
+		return nil
+	}
+	left, right := expression.Children[0], expression.Children[1]
+	leftRows, rightRows := float64(s.rows(left)), float64(s.rows(right))
+	outputRows := float64(s.rows(expression.group))
+	cm := s.costModel
+	none := []logical_plan.Ordering{nil, nil}
+
+	var alternatives []implementation
+	if len(required) == 0 {
+		buildSides := []string{"left", "right"}
+		switch {
+		case node.JoinType.LeftOnly(), node.JoinType == logical_plan.JoinTypeLeft:
+			buildSides = []string{"right"}
+		case node.JoinType == logical_plan.JoinTypeRight:
+			buildSides = []string{"left"}
+		}
+		if node.JoinCondition != nil && node.JoinCondition.Operator == "=" {
+			for _, side := range buildSides {
+				build, probe := leftRows, rightRows
+				if side == "right" {
+					build, probe = rightRows, leftRows
+				}
+				alternatives = append(alternatives, implementation{
+					operator:  "hash_join",
+					metadata:  map[string]interface{}{"physical_operator": "hash_join", "build_side": side},
+					required:  none,
+					localCost: (build*cm.HashCostFactor + probe + outputRows) * cm.CPUCostPerTuple,
+				})
+			}
+		}
+		alternatives = append(alternatives, implementation{
+			operator:  "nested_loop_join",
+			metadata:  map[string]interface{}{"physical_operator": "nested_loop_join"},
+			required:  none,
+			localCost: leftRows * rightRows * cm.CPUCostPerTuple * cm.JoinCostFactor,
+		})
+	}
+
+	if isInnerJoin(node) && node.JoinCondition != nil && node.JoinCondition.Operator == "=" &&
+		node.JoinCondition.Left.IsColumn() && node.JoinCondition.Right.IsColumn() {
+		leftKey, rightKey := *node.JoinCondition.Left.Column, *node.JoinCondition.Right.Column
+		if !reads(node.JoinCondition.Left, left) {
+			leftKey, rightKey = rightKey, leftKey
+		}
+		provides := logical_plan.Ordering{{Column: leftKey}}
+		if required.SatisfiedBy(provides) && reads(logical_plan.NewColumnExpression(leftKey.Table, leftKey.Name), left) &&
+			reads(logical_plan.NewColumnExpression(rightKey.Table, rightKey.Name), right) {
+			alternatives = append(alternatives, implementation{
+				operator:  "sort_merge_join",
+				metadata:  map[string]interface{}{"physical_operator": "sort_merge_join", "presorted": true},
+				required:  []logical_plan.Ordering{provides, {{Column: rightKey}}},
+				provides:  provides,
+				localCost: (leftRows + rightRows + outputRows) * cm.CPUCostPerTuple,
+			})
+		}
+	}
+	return alternatives
+}
+
+// AggregateImplementationRule offers a hash aggregate and, with a GROUP BY,
+// a sort aggregate, which needs its input ordered on the group-by columns
+// and returns groups in that order.
+type AggregateImplementationRule struct{}
+
+func (r *AggregateImplementationRule) Name() string {
+	return "AggregateImplementation"
+}
+
+func (r *AggregateImplementationRule) Implement(s *search, expression *GroupExpression, required logical_plan.Ordering) []implementation {
+	node := expression.Node
+	if node.NodeType != logical_plan.NodeTypeAggregate || len(expression.Children) != 1 {
+		return nil
+	}
+	inputRows := float64(s.rows(expression.Children[0]))
+	cm := s.costModel
+
+	var alternatives []implementation
+	if len(required) == 0 {
+		alternatives = append(alternatives, implementation{
+			operator:  "hash_aggregate",
+			metadata:  map[string]interface{}{"physical_operator": "hash_aggregate"},
+			required:  []logical_plan.Ordering{nil},
+			localCost: inputRows * cm.CPUCostPerTuple * cm.HashCostFactor,
+		})
+	}
+	if len(node.GroupBy) > 0 {
+		var ordering logical_plan.Ordering
+		for _, column := range node.GroupBy {
+			ordering = append(ordering, logical_plan.OrderKey{Column: logical_plan.ColumnRef{Table: column.Table, Name: column.Name}})
+		}
+		if required.SatisfiedBy(ordering) {
+			alternatives = append(alternatives, implementation{
+				operator:  "sort_aggregate",
+				metadata:  map[string]interface{}{"physical_operator": "sort_aggregate", "presorted": true},
+				required:  []logical_plan.Ordering{ordering},
+				provides:  ordering,
+				localCost: inputRows * cm.CPUCostPerTuple,
+			})
+		}
+	}
+	return alternatives
+}
+
+// SortImplementationRule sorts its input in memory or externally, or drops
+// the sort when the input can be produced in the order it asks for.
+type SortImplementationRule struct{}
+
+func (r *SortImplementationRule) Name() string {
+	return "SortImplementation"
+}
+
+func (r *SortImplementationRule) Implement(s *search, expression *GroupExpression, required logical_plan.Ordering) []implementation {
+	node := expression.Node
+	if node.NodeType != logical_plan.NodeTypeSort || len(expression.Children) != 1 {
+		return nil
+	}
+	ordering, ok := logical_plan.OrderingOf(node.OrderBy)
+	if ok && !required.SatisfiedBy(ordering) || !ok && len(required) > 0 {
+		return nil
+	}
+	alternatives := []implementation{{
+		operator:  sortOperator(s.rows(expression.group)),
+		metadata:  map[string]interface{}{"physical_operator": sortOperator(s.rows(expression.group))},
+		required:  []logical_plan.Ordering{nil},
+		provides:  ordering,
+		localCost: s.sortCost(s.rows(expression.group)),
+	}}
+	if ok {
+		alternatives = append(alternatives, implementation{
+			operator: "sorted_input",
+			required: []logical_plan.Ordering{ordering},
+			provides: ordering,
+			elided:   true,
+		})
+	}
+	return alternatives
+}
+
+// DefaultImplementationRule executes the remaining operators one way, at the
+// cost the cost model gives them, except that a top-N over input already in
+// its order only needs to take the first rows.
+type DefaultImplementationRule struct{}
+
+func (r *DefaultImplementationRule) Name() string {
+	return "DefaultImplementation"
+}
+
+func (r *DefaultImplementationRule) Implement(s *search, expression *GroupExpression, required logical_plan.Ordering) []implementation {
+	node := expression.Node
+	var metadata map[string]interface{}
+	var alternatives []implementation
+	switch node.NodeType {
+	case logical_plan.NodeTypeLimit, logical_plan.NodeTypeUnion, logical_plan.NodeTypeSubquery, logical_plan.NodeTypeEmpty, logical_plan.NodeTypeSpool:
+	case logical_plan.NodeTypeTopN:
+		metadata = map[string]interface{}{"physical_operator": "heap_top_n"}
+		if ordering, ok := logical_plan.OrderingOf(node.OrderBy); ok && required.SatisfiedBy(ordering) {
+			alternatives = append(alternatives, implementation{
+				operator:  "ordered_limit",
+				required:  []logical_plan.Ordering{ordering},
+				provides:  ordering,
+				localCost: float64(s.rows(expression.group)) * s.costModel.CPUCostPerTuple,
+				limit:     true,
+			})
+		}
+	default:
+		return nil
+	}
+	if len(required) > 0 {
+		return alternatives
+	}
+	return append(alternatives, implementation{
+		operator:  string(node.NodeType),
+		metadata:  metadata,
+		required:  make([]logical_plan.Ordering, len(expression.Children)),
+		localCost: s.localCost(expression.group),
+	})
+}
+
+// projectionKeeps reports whether every column of the ordering passes
+// through the projection unchanged.
+func projectionKeeps(project *logical_plan.LogicalPlan, ordering logical_plan.Ordering) bool {
+	for _, key := range ordering {
+		found := false
+		for _, column := range project.Projections {
+			if column.Expression == nil && column.Alias == "" &&
+				(column.Name == "*" || strings.EqualFold(column.Name, key.Column.Name) && strings.EqualFold(column.Table, key.Column.Table)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func sortOperator(rows int64) string {
+	if rows < 100000 {
+		return "quicksort"
+	}
+	return "external_sort"
+}
+
+// sortCost is the cost model's n·log(n) cost of sorting rows.
+func (s *search) sortCost(rows int64) float64 {
+	if rows <= 1 {
+		return 0
+	}
+	n := float64(rows)
+	return n * math.Log2(n) * s.costModel.CPUCostPerTuple * s.costModel.SortCostFactor
+}
@@ -0,0 +1,312 @@
+package cascades
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// DefaultMaxExpressions caps the logical expressions exploration may add, as
+// every bushy order of n joins is about 3^n expressions.
+const DefaultMaxExpressions = 5000
+
+// Optimizer searches a memo for the cheapest physical plan.
+type Optimizer struct {
+	Catalog        *catalog.CatalogManager
+	CostModel      *cost_model.SimpleCostModel
+	MaxExpressions int
+	// DisabledRules names transformation rules exploration skips.
+	DisabledRules map[string]bool
+}
+
+func NewOptimizer(catalogMgr *catalog.CatalogManager) *Optimizer {
+	if catalogMgr == nil {
+		catalogMgr = catalog.NewCatalogManager()
+	}
+	return &Optimizer{
+		Catalog:        catalogMgr,
+		CostModel:      cost_model.NewSimpleCostModel(),
+		MaxExpressions: DefaultMaxExpressions,
+	}
+}
+
+var transformationRules = []TransformationRule{
+	&JoinCommutativityRule{},
+	&JoinAssociativityRule{},
+}
+
+// TransformationRuleNames lists the transformation rules exploration applies.
+func TransformationRuleNames() []string {
+	names := make([]string, len(transformationRules))
+	for i, rule := range transformationRules {
+		names[i] = rule.Name()
+	}
+	return names
+}
+
+var implementationRules = []ImplementationRule{
+	&ScanImplementationRule{},
+	&PassThroughImplementationRule{},
+	&JoinImplementationRule{},
+	&AggregateImplementationRule{},
+	&SortImplementationRule{},
+	&DefaultImplementationRule{},
+}
+
+// Result is the cheapest plan found, with physical operators in each node's
+// metadata, and the memo it was found in.
+type Result struct {
+	Plan         *logical_plan.LogicalPlan
+	Cost         float64
+	OriginalCost float64
+	Memo         *MemoExport
+}
+
+// Optimize explores and costs the plan's memo. Once ctx is done exploration
+// stops and the cheapest plan among the expressions found so far is
+// returned, with ExplorationInterrupted set in the memo's statistics.
+func (o *Optimizer) Optimize(ctx context.Context, plan *logical_plan.LogicalPlan) (*Result, error) {
+	if plan == nil {
+		return nil, fmt.Errorf("cannot optimize nil plan")
+	}
+	s := &search{
+		ctx:            ctx,
+		memo:           newMemo(),
+		catalog:        o.Catalog,
+		costModel:      o.CostModel,
+		maxExpressions: o.MaxExpressions,
+		disabled:       o.DisabledRules,
+		stats:          Statistics{RuleApplications: make(map[string]int)},
+	}
+	s.memo.root = s.memo.insert(plan)
+
+	best := s.optimizeGroup(s.memo.root, nil, math.Inf(1))
+	if best == nil {
+		return nil, fmt.Errorf("no physical plan found")
+	}
+
+	for _, g := range s.memo.groups {
+		s.estimate(g)
+	}
+	s.stats.Groups = len(s.memo.groups)
+	s.stats.LogicalExpressions = len(s.memo.expressions)
+	return &Result{
+		Plan:         s.extract(s.memo.root, nil),
+		Cost:         best.cost,
+		OriginalCost: s.memo.root.cost,
+		Memo:         s.memo.Export(s.stats),
+	}, nil
+}
+
+type search struct {
+	ctx            context.Context
+	memo           *Memo
+	catalog        *catalog.CatalogManager
+	costModel      *cost_model.SimpleCostModel
+	maxExpressions int
+	disabled       map[string]bool
+	stats          Statistics
+}
+
+type winner struct {
+	expression *GroupExpression
+	impl       implementation
+	operator   string
+	inputs     []goal
+	cost       float64
+}
+
+type goal struct {
+	group    *Group
+	required logical_plan.Ordering
+}
+
+// explore applies the transformation rules to every expression of a group,
+// including the ones they add, after exploring the groups they read.
+func (s *search) explore(g *Group) {
+	if g.explored {
+		return
+	}
+	g.explored = true
+	for i := 0; i < len(g.Expressions); i++ {
+		if s.ctx.Err() != nil {
+			s.stats.ExplorationInterrupted = true
+			return
+		}
+		expression := g.Expressions[i]
+		for _, child := range expression.Children {
+			s.explore(child)
+		}
+		for _, rule := range transformationRules {
+			if expression.applied[rule.Name()] || s.disabled[rule.Name()] {
+				continue
+			}
+			if len(s.memo.expressions) >= s.maxExpressions {
+				s.stats.ExplorationCapped = true
+				break
+			}
+			expression.applied[rule.Name()] = true
+			if added := rule.Apply(s.memo, expression); added > 0 {
+				s.stats.RuleApplications[rule.Name()] += added
+			}
+		}
+	}
+}
+
+// optimizeGroup returns the cheapest plan for a group that delivers the
+// required ordering and costs less than bound, or nil when there is none.
+// Alternatives are abandoned as soon as their cost reaches the bound, which
+// tightens to the best plan found so far.
+func (s *search) optimizeGroup(g *Group, required logical_plan.Ordering, bound float64) *winner {
+	key := required.String()
+	if w, ok := g.winners[key]; ok {
+		if w.cost < bound {
+			return w
+		}
+		return nil
+	}
+	if failed, ok := g.failed[key]; ok && bound <= failed {
+		return nil
+	}
+	s.explore(g)
+
+	limit := bound
+	var best *winner
+	for _, expression := range g.Expressions {
+		for _, rule := range implementationRules {
+			for _, impl := range rule.Implement(s, expression, required) {
+				s.stats.PhysicalAlternatives++
+				if w := s.optimizeInputs(expression, impl, limit); w != nil {
+					best, limit = w, w.cost
+				} else {
+					s.stats.Pruned++
+				}
+			}
+		}
+	}
+
+	// Any plan for the group can be sorted into the required order.
+	if len(required) > 0 {
+		s.stats.PhysicalAlternatives++
+		cost := s.sortCost(s.rows(g))
+		if input := s.optimizeGroup(g, nil, limit-cost); input != nil && cost+input.cost < limit {
+			best = &winner{
+				operator: sortOperator(s.rows(g)),
+				inputs:   []goal{{group: g}},
+				cost:     cost + input.cost,
+			}
+		} else {
+			s.stats.Pruned++
+		}
+	}
+
+	if best == nil {
+		g.failed[key] = math.Max(g.failed[key], bound)
+		return nil
+	}
+	g.winners[key] = best
+	return best
+}
+
+// optimizeInputs costs an implementation with the best plans for its inputs,
+// giving up once the total reaches bound.
+func (s *search) optimizeInputs(expression *GroupExpression, impl implementation, bound float64) *winner {
+	cost := impl.localCost
+	if cost >= bound {
+		return nil
+	}
+	inputs := make([]goal, len(expression.Children))
+	for i, child := range expression.Children {
+		var required logical_plan.Ordering
+		if i < len(impl.required) {
+			required = impl.required[i]
+		}
+		input := s.optimizeGroup(child, required, bound-cost)
+		if input == nil {
+			return nil
+		}
+		cost += input.cost
+		if cost >= bound {
+			return nil
+		}
+		inputs[i] = goal{group: child, required: required}
+	}
+	return &winner{expression: expression, impl: impl, operator: impl.operator, inputs: inputs, cost: cost}
+}
+
+// extract builds the plan of the winner for a group and required ordering.
+func (s *search) extract(g *Group, required logical_plan.Ordering) *logical_plan.LogicalPlan {
+	w := g.winners[required.String()]
+	if w.expression == nil {
+		input := s.extract(g, nil)
+		orderBy := make([]logical_plan.OrderBy, len(required))
+		for i, key := range required {
+			orderBy[i] = logical_plan.OrderBy{
+				Expression: logical_plan.NewColumnExpression(key.Column.Table, key.Column.Name),
+				Ascending:  !key.Descending,
+			}
+		}
+		sortNode := logical_plan.NewSortNode(input, orderBy)
+		sortNode.Metadata["physical_operator"] = w.operator
+		sortNode.Metadata["enforced"] = true
+		s.annotate(sortNode, g, w)
+		return sortNode
+	}
+	if w.impl.elided {
+		return s.extract(w.inputs[0].group, w.inputs[0].required)
+	}
+
+	node := w.expression.Node.Clone()
+	if w.impl.limit {
+		node = logical_plan.NewLimitNode(nil, node.LimitCount, node.OffsetCount)
+	}
+	node.ID = w.expression.Node.ID
+	node.Children = make([]*logical_plan.LogicalPlan, len(w.inputs))
+	for i, input := range w.inputs {
+		node.Children[i] = s.extract(input.group, input.required)
+	}
+	for k, v := range w.impl.metadata {
+		node.Metadata[k] = v
+	}
+	s.annotate(node, g, w)
+	return node
+}
+
+func (s *search) annotate(node *logical_plan.LogicalPlan, g *Group, w *winner) {
+	rows, cost := s.rows(g), w.cost
+	node.EstimatedRows = &rows
+	node.EstimatedCost = &cost
+}
+
+// estimate fills in a group's logical properties from the cost model: the
+// rows and the cost of its representative plan.
+func (s *search) estimate(g *Group) {
+	if g.estimated {
+		return
+	}
+	g.estimated = true
+	if estimate, err := s.costModel.EstimateCost(g.Representative(), s.catalog); err == nil {
+		g.rows, g.cost = estimate.Cardinality, estimate.TotalCost
+	}
+}
+
+func (s *search) rows(g *Group) int64 {
+	s.estimate(g)
+	return g.rows
+}
+
+// localCost is what the cost model charges for a group's operator itself:
+// its representative's cost minus that of its inputs.
+func (s *search) localCost(g *Group) float64 {
+	s.estimate(g)
+	cost := g.cost
+	for _, child := range g.Expressions[0].Children {
+		s.estimate(child)
+		cost -= child.cost
+	}
+	return math.Max(cost, 0)
+}
@@ -2,7 +2,10 @@ package catalog
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+
+	"retr0-kernel/optiquery/logical_plan"
 )
 
 type DataType string
@@ -24,6 +27,8 @@ type Column struct {
 	MaxValue  *string  `json:"max_value,omitempty"`
 	Histogram []Bucket `json:"histogram,omitempty"`
 	NullCount *int64   `json:"null_count,omitempty"`
+	// AvgWidth is the average stored size of a value in bytes.
+	AvgWidth *int64 `json:"avg_width,omitempty"`
 }
 
 type Bucket struct {
@@ -34,11 +39,16 @@ type Bucket struct {
 }
 
 type TableSchema struct {
-	Name     string            `json:"name"`
-	Columns  []Column          `json:"columns"`
-	RowCount int64             `json:"row_count"`
-	Indexes  []Index           `json:"indexes,omitempty"`
-	Metadata map[string]string `json:"metadata,omitempty"`
+	Name        string            `json:"name"`
+	Columns     []Column          `json:"columns"`
+	RowCount    int64             `json:"row_count"`
+	Indexes     []Index           `json:"indexes,omitempty"`
+	PrimaryKey  []string          `json:"primary_key,omitempty"`
+	ForeignKeys []ForeignKey      `json:"foreign_keys,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Partitioning, when set, splits the table's rows into partitions scans
+	// can skip.
+	Partitioning *Partitioning `json:"partitioning,omitempty"`
 }
 
 type Index struct {
@@ -48,14 +58,26 @@ type Index struct {
 	Type    string   `json:"type"` // btree, hash, etc.
 }
 
+// MaterializedView is a stored query result. Its rows are read through the
+// table of the same name, whose columns are the view's output columns and
+// carry its statistics; Definition is the plan of Query.
+type MaterializedView struct {
+	Name       string                    `json:"name"`
+	Query      string                    `json:"query"`
+	Definition *logical_plan.LogicalPlan `json:"definition"`
+}
+
 type CatalogManager struct {
-	tables map[string]*TableSchema
-	mu     sync.RWMutex
+	tables  map[string]*TableSchema
+	views   map[string]*MaterializedView
+	version uint64
+	mu      sync.RWMutex
 }
 
 func NewCatalogManager() *CatalogManager {
 	return &CatalogManager{
 		tables: make(map[string]*TableSchema),
+		views:  make(map[string]*MaterializedView),
 	}
 }
 
@@ -68,9 +90,39 @@ func (cm *CatalogManager) AddTable(schema *TableSchema) error {
 	}
 
 	cm.tables[schema.Name] = schema
+	cm.version++
+	return nil
+}
+
+// AddMaterializedView registers a view together with the table its rows are
+// read from.
+func (cm *CatalogManager) AddMaterializedView(view *MaterializedView, table *TableSchema) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.tables[table.Name]; exists {
+		return fmt.Errorf("table %s already exists", table.Name)
+	}
+
+	cm.tables[table.Name] = table
+	cm.views[view.Name] = view
+	cm.version++
 	return nil
 }
 
+// GetMaterializedViews returns every registered view, sorted by name.
+func (cm *CatalogManager) GetMaterializedViews() []*MaterializedView {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	views := make([]*MaterializedView, 0, len(cm.views))
+	for _, view := range cm.views {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views
+}
+
 func (cm *CatalogManager) GetTable(tableName string) (*TableSchema, error) {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
@@ -104,18 +156,35 @@ func (cm *CatalogManager) UpdateTableStats(tableName string, rowCount int64, col
 		return fmt.Errorf("table %s not found", tableName)
 	}
 
-	table.RowCount = rowCount
-	for i, col := range table.Columns {
+	table.SetStats(rowCount, columnStats)
+	cm.version++
+
+	return nil
+}
+
+// SetStats replaces the table's row count and the statistics of the columns
+// given. Columns the table does not have are ignored.
+func (s *TableSchema) SetStats(rowCount int64, columnStats map[string]Column) {
+	s.RowCount = rowCount
+	for i, col := range s.Columns {
 		if stats, hasStats := columnStats[col.Name]; hasStats {
-			table.Columns[i].NDV = stats.NDV
-			table.Columns[i].MinValue = stats.MinValue
-			table.Columns[i].MaxValue = stats.MaxValue
-			table.Columns[i].Histogram = stats.Histogram
-			table.Columns[i].NullCount = stats.NullCount
+			s.Columns[i].NDV = stats.NDV
+			s.Columns[i].MinValue = stats.MinValue
+			s.Columns[i].MaxValue = stats.MaxValue
+			s.Columns[i].Histogram = stats.Histogram
+			s.Columns[i].NullCount = stats.NullCount
+			s.Columns[i].AvgWidth = stats.AvgWidth
 		}
 	}
+}
 
-	return nil
+// Version changes whenever a table is added or its statistics are updated, so
+// results derived from the catalog can be invalidated.
+func (cm *CatalogManager) Version() uint64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return cm.version
 }
 
 func (cm *CatalogManager) GetColumnStats(tableName, columnName string) (*Column, error) {
@@ -153,3 +222,23 @@ func (cm *CatalogManager) EstimateSelectivity(tableName, columnName, operator st
 		return 0.5, nil
 	}
 }
+
+var defaultWidths = map[DataType]int64{
+	DataTypeInt:     8,
+	DataTypeFloat:   8,
+	DataTypeString:  32,
+	DataTypeBoolean: 1,
+	DataTypeDate:    8,
+}
+
+// Width is the column's average size in bytes: AvgWidth when known, and
+// otherwise a default for its data type.
+func (c Column) Width() int64 {
+	if c.AvgWidth != nil && *c.AvgWidth > 0 {
+		return *c.AvgWidth
+	}
+	if width, ok := defaultWidths[c.DataType]; ok {
+		return width
+	}
+	return 16
+}
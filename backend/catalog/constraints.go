@@ -0,0 +1,138 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ForeignKey declares that every non-null value of Columns appears in
+// RefColumns of RefTable, which must be its primary key or a unique index.
+type ForeignKey struct {
+	Name       string   `json:"name,omitempty"`
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+}
+
+// Validate checks that the primary key, foreign keys, indexes and
+// partitioning only name columns of the table, and that partitions are well
+// formed. Referenced tables may be added later, so they are not checked.
+func (s *TableSchema) Validate() error {
+	if err := s.checkColumns("primary key", s.PrimaryKey); err != nil {
+		return err
+	}
+	for _, index := range s.Indexes {
+		if err := s.checkColumns("index "+index.Name, index.Columns); err != nil {
+			return err
+		}
+	}
+	for _, fk := range s.ForeignKeys {
+		if err := s.checkColumns("foreign key "+fk.Name, fk.Columns); err != nil {
+			return err
+		}
+		if fk.RefTable == "" {
+			return fmt.Errorf("foreign key %s of table %s has no referenced table", fk.Name, s.Name)
+		}
+		if len(fk.Columns) == 0 || len(fk.Columns) != len(fk.RefColumns) {
+			return fmt.Errorf("foreign key %s of table %s has %d columns but references %d", fk.Name, s.Name, len(fk.Columns), len(fk.RefColumns))
+		}
+	}
+	if s.Partitioning != nil {
+		return s.validatePartitioning(s.Partitioning, make(map[string]bool))
+	}
+	return nil
+}
+
+func (s *TableSchema) checkColumns(what string, columns []string) error {
+	for _, name := range columns {
+		if s.Column(name) == nil {
+			return fmt.Errorf("%s of table %s references unknown column %s", what, s.Name, name)
+		}
+	}
+	return nil
+}
+
+// Column finds a column by name, ignoring case.
+func (s *TableSchema) Column(name string) *Column {
+	for i := range s.Columns {
+		if strings.EqualFold(s.Columns[i].Name, name) {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
+
+// IsUnique reports whether no two rows can share values for columns: they
+// include the primary key or every column of a unique index.
+func (s *TableSchema) IsUnique(columns []string) bool {
+	if len(s.PrimaryKey) > 0 && containsAll(columns, s.PrimaryKey) {
+		return true
+	}
+	for _, index := range s.Indexes {
+		if index.Unique && len(index.Columns) > 0 && containsAll(columns, index.Columns) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForeignKeyTo finds a foreign key from exactly columns to refColumns of
+// refTable, matching the column pairs in any order.
+func (s *TableSchema) ForeignKeyTo(columns []string, refTable string, refColumns []string) *ForeignKey {
+	for i, fk := range s.ForeignKeys {
+		if !strings.EqualFold(fk.RefTable, refTable) || len(fk.Columns) != len(columns) {
+			continue
+		}
+		matched := true
+		for j := range columns {
+			k := indexOf(fk.Columns, columns[j])
+			if k < 0 || !strings.EqualFold(fk.RefColumns[k], refColumns[j]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &s.ForeignKeys[i]
+		}
+	}
+	return nil
+}
+
+func containsAll(columns, required []string) bool {
+	for _, name := range required {
+		if indexOf(columns, name) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func indexOf(columns []string, name string) int {
+	for i, column := range columns {
+		if strings.EqualFold(column, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// OrderedIndex finds an index that returns rows ordered on columns: a btree
+// index whose leading columns are columns, in order.
+func (s *TableSchema) OrderedIndex(columns []string) *Index {
+	for i, index := range s.Indexes {
+		if index.Type != "" && !strings.EqualFold(index.Type, "btree") || len(index.Columns) < len(columns) || len(columns) == 0 {
+			continue
+		}
+		matched := true
+		for j, name := range columns {
+			if !strings.EqualFold(index.Columns[j], name) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &s.Indexes[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package catalog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+type PartitionType string
+
+const (
+	PartitionRange PartitionType = "range"
+	PartitionList  PartitionType = "list"
+	PartitionHash  PartitionType = "hash"
+)
+
+// Partitioning splits a table's rows into partitions by the value of Column.
+// A partition can itself be split by another column, as a table partitioned
+// by date and then by region.
+type Partitioning struct {
+	Type       PartitionType `json:"type"`
+	Column     string        `json:"column"`
+	Partitions []Partition   `json:"partitions"`
+}
+
+type Partition struct {
+	Name string `json:"name"`
+	// RowCount is ignored for a partition with subpartitions, which holds
+	// the rows of its subpartitions.
+	RowCount int64 `json:"row_count"`
+	// From and To bound a range partition to the values v with From <= v < To.
+	// A missing bound is open.
+	From *string `json:"from,omitempty"`
+	To   *string `json:"to,omitempty"`
+	// Values are the values of a list partition. A Default list partition
+	// holds every value no other partition lists.
+	Values  []string `json:"values,omitempty"`
+	Default bool     `json:"default,omitempty"`
+	// Remainder picks the rows of a hash partition: those whose value hashes
+	// to it modulo the number of partitions (see HashPartition).
+	Remainder     int           `json:"remainder,omitempty"`
+	Subpartitions *Partitioning `json:"subpartitions,omitempty"`
+}
+
+// Rows is the partition's row count, or that of its subpartitions.
+func (p Partition) Rows() int64 {
+	if p.Subpartitions != nil {
+		return p.Subpartitions.RowCount()
+	}
+	return p.RowCount
+}
+
+// RowCount sums the rows of every partition.
+func (p *Partitioning) RowCount() int64 {
+	var rows int64
+	for _, partition := range p.Partitions {
+		rows += partition.Rows()
+	}
+	return rows
+}
+
+// Leaves lists the partitions rows are stored in: those without
+// subpartitions, in order.
+func (p *Partitioning) Leaves() []Partition {
+	var leaves []Partition
+	for _, partition := range p.Partitions {
+		if partition.Subpartitions != nil {
+			leaves = append(leaves, partition.Subpartitions.Leaves()...)
+		} else {
+			leaves = append(leaves, partition)
+		}
+	}
+	return leaves
+}
+
+// HashPartition is the remainder a value goes to among count hash partitions:
+// the FNV-1a hash of its text modulo count.
+func HashPartition(value string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return int(h.Sum32() % uint32(count))
+}
+
+// PartitionFraction is the share of the table's rows in the named leaf
+// partitions, by their row counts. Without row counts every partition counts
+// the same.
+func (s *TableSchema) PartitionFraction(names []string) float64 {
+	if s.Partitioning == nil {
+		return 1
+	}
+	leaves := s.Partitioning.Leaves()
+	if len(leaves) == 0 {
+		return 1
+	}
+	var rows, total int64
+	var count int
+	for _, leaf := range leaves {
+		total += leaf.RowCount
+		if indexOf(names, leaf.Name) >= 0 {
+			rows += leaf.RowCount
+			count++
+		}
+	}
+	if total == 0 {
+		return float64(count) / float64(len(leaves))
+	}
+	return float64(rows) / float64(total)
+}
+
+// validatePartitioning checks that partitions split on columns of the table,
+// have names unique across every level and bounds fitting their type.
+func (s *TableSchema) validatePartitioning(p *Partitioning, names map[string]bool) error {
+	if s.Column(p.Column) == nil {
+		return fmt.Errorf("partitioning of table %s references unknown column %s", s.Name, p.Column)
+	}
+	if len(p.Partitions) == 0 {
+		return fmt.Errorf("partitioning of table %s on %s has no partitions", s.Name, p.Column)
+	}
+	defaults := 0
+	remainders := make(map[int]bool)
+	for _, partition := range p.Partitions {
+		if partition.Name == "" {
+			return fmt.Errorf("a partition of table %s on %s has no name", s.Name, p.Column)
+		}
+		if names[strings.ToLower(partition.Name)] {
+			return fmt.Errorf("table %s has two partitions named %s", s.Name, partition.Name)
+		}
+		names[strings.ToLower(partition.Name)] = true
+		if partition.RowCount < 0 {
+			return fmt.Errorf("partition %s of table %s has a negative row count", partition.Name, s.Name)
+		}
+
+		switch p.Type {
+		case PartitionRange:
+			if partition.From != nil && partition.To != nil && !boundBelow(*partition.From, *partition.To) {
+				return fmt.Errorf("range partition %s of table %s is empty: from %s is not below to %s", partition.Name, s.Name, *partition.From, *partition.To)
+			}
+		case PartitionList:
+			if partition.Default {
+				defaults++
+			} else if len(partition.Values) == 0 {
+				return fmt.Errorf("list partition %s of table %s has no values", partition.Name, s.Name)
+			}
+		case PartitionHash:
+			if partition.Remainder < 0 || partition.Remainder >= len(p.Partitions) || remainders[partition.Remainder] {
+				return fmt.Errorf("hash partition %s of table %s needs a remainder of its own between 0 and %d", partition.Name, s.Name, len(p.Partitions)-1)
+			}
+			remainders[partition.Remainder] = true
+		default:
+			return fmt.Errorf("partitioning of table %s has unknown type %s, expected range, list or hash", s.Name, p.Type)
+		}
+
+		if partition.Subpartitions != nil {
+			if err := s.validatePartitioning(partition.Subpartitions, names); err != nil {
+				return err
+			}
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("partitioning of table %s on %s has %d default partitions", s.Name, p.Column, defaults)
+	}
+	return nil
+}
+
+// boundBelow compares range bounds as numbers when both are, and as text
+// otherwise, which orders ISO dates.
+func boundBelow(from, to string) bool {
+	a, errA := strconv.ParseFloat(from, 64)
+	b, errB := strconv.ParseFloat(to, 64)
+	if errA == nil && errB == nil {
+		return a < b
+	}
+	return from < to
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -33,6 +34,10 @@ type Config struct {
 	OptimizationTimeout      time.Duration
 	EnableCostBasedOptimizer bool
 	EnableRuleBasedOptimizer bool
+	MaxOptimizerIterations   int
+	JoinDPThreshold          int
+	MaxMemoExpressions       int
+	DisabledRules            []string
 }
 
 func LoadConfig() (*Config, error) {
@@ -62,6 +67,10 @@ func LoadConfig() (*Config, error) {
 		OptimizationTimeout:      getEnvAsDuration("OPTIMIZATION_TIMEOUT", 30*time.Second),
 		EnableCostBasedOptimizer: getEnvAsBool("ENABLE_COST_BASED_OPTIMIZER", true),
 		EnableRuleBasedOptimizer: getEnvAsBool("ENABLE_RULE_BASED_OPTIMIZER", true),
+		MaxOptimizerIterations:   getEnvAsInt("MAX_OPTIMIZER_ITERATIONS", 10),
+		JoinDPThreshold:          getEnvAsInt("JOIN_DP_THRESHOLD", 4),
+		MaxMemoExpressions:       getEnvAsInt("MAX_MEMO_EXPRESSIONS", 5000),
+		DisabledRules:            getEnvAsList("DISABLED_RULES"),
 	}
 
 	config.DatabaseURL = getEnv("DATABASE_URL",
@@ -116,6 +125,16 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -2,6 +2,7 @@ package cost_model
 
 import (
 	"math"
+	"strings"
 
 	"retr0-kernel/optiquery/catalog"
 	"retr0-kernel/optiquery/logical_plan"
@@ -61,6 +62,19 @@ func (cm *SimpleCostModel) EstimateCost(plan *logical_plan.LogicalPlan, catalogM
 		return cm.estimateSortCost(plan, catalogMgr)
 	case logical_plan.NodeTypeLimit:
 		return cm.estimateLimitCost(plan, catalogMgr)
+	case logical_plan.NodeTypeTopN:
+		return cm.estimateTopNCost(plan, catalogMgr)
+	case logical_plan.NodeTypeEmpty:
+		return &CostEstimate{}, nil
+	case logical_plan.NodeTypeSpool:
+		return cm.estimateSpoolReadCost(plan, catalogMgr)
+	case logical_plan.NodeTypeUnion:
+		return cm.estimateUnionCost(plan, catalogMgr)
+	case logical_plan.NodeTypeSubquery:
+		if len(plan.Children) == 0 {
+			return &CostEstimate{}, nil
+		}
+		return cm.EstimateCost(plan.Children[0], catalogMgr)
 	default:
 
 		cardinality, _ := cm.EstimateCardinality(plan, catalogMgr)
@@ -83,7 +97,7 @@ func (cm *SimpleCostModel) EstimateCardinality(plan *logical_plan.LogicalPlan, c
 		if err != nil {
 			return 1000, nil
 		}
-		return table.RowCount, nil
+		return ScannedRows(plan, table), nil
 
 	case logical_plan.NodeTypeFilter:
 		if len(plan.Children) == 0 {
@@ -94,8 +108,8 @@ func (cm *SimpleCostModel) EstimateCardinality(plan *logical_plan.LogicalPlan, c
 			return 0, err
 		}
 
-		selectivity := cm.estimateSelectivity(plan.Predicate, catalogMgr)
-		return int64(float64(childCardinality) * selectivity), nil
+		selectivity := cm.estimateSelectivity(plan.Predicate, plan.Children[0], catalogMgr)
+		return filteredRows(plan.Children[0], childCardinality, selectivity, catalogMgr), nil
 
 	case logical_plan.NodeTypeProject:
 		if len(plan.Children) == 0 {
@@ -116,20 +130,21 @@ func (cm *SimpleCostModel) EstimateCardinality(plan *logical_plan.LogicalPlan, c
 			return 0, err
 		}
 
+		selectivity := cm.joinSelectivity(plan, catalogMgr)
+		inner := int64(float64(leftCard) * float64(rightCard) * selectivity)
 		switch plan.JoinType {
-		case logical_plan.JoinTypeCross:
-			return leftCard * rightCard, nil
-		case logical_plan.JoinTypeInner:
-
-			return int64(float64(leftCard*rightCard) * 0.1), nil
+		case logical_plan.JoinTypeSemi:
+			return int64(float64(leftCard) * cm.matchedFraction(plan, rightCard, catalogMgr)), nil
+		case logical_plan.JoinTypeAnti:
+			return int64(float64(leftCard) * (1 - cm.matchedFraction(plan, rightCard, catalogMgr))), nil
 		case logical_plan.JoinTypeLeft:
-			return leftCard, nil
+			return max(leftCard, inner), nil
 		case logical_plan.JoinTypeRight:
-			return rightCard, nil
+			return max(rightCard, inner), nil
 		case logical_plan.JoinTypeFull:
-			return leftCard + rightCard, nil
+			return max(leftCard+rightCard, inner), nil
 		default:
-			return int64(float64(leftCard*rightCard) * 0.1), nil
+			return inner, nil
 		}
 
 	case logical_plan.NodeTypeAggregate:
@@ -145,6 +160,9 @@ func (cm *SimpleCostModel) EstimateCardinality(plan *logical_plan.LogicalPlan, c
 			return 1, nil
 		}
 
+		if groups := groupCount(plan, childCard, catalogMgr); groups > 0 {
+			return groups, nil
+		}
 		return int64(float64(childCard) * 0.1), nil
 
 	case logical_plan.NodeTypeSort:
@@ -170,6 +188,42 @@ func (cm *SimpleCostModel) EstimateCardinality(plan *logical_plan.LogicalPlan, c
 		}
 		return cm.EstimateCardinality(plan.Children[0], catalogMgr)
 
+	case logical_plan.NodeTypeTopN:
+		if len(plan.Children) == 0 {
+			return 0, nil
+		}
+		childCard, err := cm.EstimateCardinality(plan.Children[0], catalogMgr)
+		if err != nil {
+			return 0, err
+		}
+		if plan.OffsetCount != nil {
+			childCard = max(childCard-*plan.OffsetCount, 0)
+		}
+		if plan.LimitCount != nil {
+			return min(childCard, *plan.LimitCount), nil
+		}
+		return childCard, nil
+
+	case logical_plan.NodeTypeEmpty:
+		return 0, nil
+
+	case logical_plan.NodeTypeSpool, logical_plan.NodeTypeSubquery:
+		if len(plan.Children) == 0 {
+			return 0, nil
+		}
+		return cm.EstimateCardinality(plan.Children[0], catalogMgr)
+
+	case logical_plan.NodeTypeUnion:
+		var rows int64
+		for _, child := range plan.Children {
+			childRows, err := cm.EstimateCardinality(child, catalogMgr)
+			if err != nil {
+				return 0, err
+			}
+			rows += childRows
+		}
+		return rows, nil
+
 	default:
 		return 1000, nil
 	}
@@ -187,19 +241,64 @@ func (cm *SimpleCostModel) estimateScanCost(plan *logical_plan.LogicalPlan, cata
 		}, nil
 	}
 
-	pages := float64(table.RowCount) / 100.0
+	rows := ScannedRows(plan, table)
+	pages := float64(rows) / 100.0 * ScannedFraction(plan, table)
 	if pages < 1 {
 		pages = 1
 	}
 
 	ioCost := pages * cm.SeqScanCostPerPage
-	cpuCost := float64(table.RowCount) * cm.CPUCostPerTuple
+	cpuCost := float64(rows) * cm.CPUCostPerTuple
 
 	return &CostEstimate{
 		TotalCost:   ioCost + cpuCost,
 		IOCost:      ioCost,
 		CPUCost:     cpuCost,
-		Cardinality: table.RowCount,
+		Cardinality: rows,
+	}, nil
+}
+
+// estimateUnionCost computes every input and, without ALL, hashes their rows
+// to remove duplicates.
+func (cm *SimpleCostModel) estimateUnionCost(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*CostEstimate, error) {
+	total := &CostEstimate{}
+	for _, child := range plan.Children {
+		childCost, err := cm.EstimateCost(child, catalogMgr)
+		if err != nil {
+			return nil, err
+		}
+		total.TotalCost += childCost.TotalCost
+		total.CPUCost += childCost.CPUCost
+		total.IOCost += childCost.IOCost
+		total.NetworkCost += childCost.NetworkCost
+		total.MemoryCost += childCost.MemoryCost
+		total.Cardinality += childCost.Cardinality
+	}
+	if !plan.UnionAll {
+		dedupCost := float64(total.Cardinality) * cm.CPUCostPerTuple * cm.HashCostFactor
+		total.TotalCost += dedupCost
+		total.CPUCost += dedupCost
+		total.MemoryCost += float64(total.Cardinality) * 0.1
+	}
+	return total, nil
+}
+
+// estimateSpoolReadCost is what one parent pays to read a spool back. Producing
+// the spool is paid once, not per parent, so it is left to whoever costs the
+// whole plan.
+func (cm *SimpleCostModel) estimateSpoolReadCost(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*CostEstimate, error) {
+	rows, err := cm.EstimateCardinality(plan, catalogMgr)
+	if err != nil {
+		return nil, err
+	}
+	pages := math.Max(1, float64(rows)/100.0)
+	ioCost := pages * cm.SeqScanCostPerPage
+	cpuCost := float64(rows) * cm.CPUCostPerTuple
+	return &CostEstimate{
+		TotalCost:   ioCost + cpuCost,
+		IOCost:      ioCost,
+		CPUCost:     cpuCost,
+		Cardinality: rows,
 	}, nil
 }
 
@@ -213,8 +312,8 @@ func (cm *SimpleCostModel) estimateFilterCost(plan *logical_plan.LogicalPlan, ca
 		return nil, err
 	}
 
-	selectivity := cm.estimateSelectivity(plan.Predicate, catalogMgr)
-	outputCardinality := int64(float64(childCost.Cardinality) * selectivity)
+	selectivity := cm.estimateSelectivity(plan.Predicate, plan.Children[0], catalogMgr)
+	outputCardinality := filteredRows(plan.Children[0], childCost.Cardinality, selectivity, catalogMgr)
 
 	filterCpuCost := float64(childCost.Cardinality) * cm.CPUCostPerTuple * 0.5
 
@@ -328,6 +427,50 @@ func (cm *SimpleCostModel) estimateSortCost(plan *logical_plan.LogicalPlan, cata
 	}, nil
 }
 
+// estimateTopNCost costs a bounded heap of limit+offset rows: every input
+// row is compared against the heap, so n·log(k) instead of a full sort's
+// n·log(n).
+func (cm *SimpleCostModel) estimateTopNCost(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*CostEstimate, error) {
+	if len(plan.Children) == 0 {
+		return &CostEstimate{}, nil
+	}
+
+	childCost, err := cm.EstimateCost(plan.Children[0], catalogMgr)
+	if err != nil {
+		return nil, err
+	}
+
+	heapSize := TopNHeapSize(plan, childCost.Cardinality)
+	if childCost.Cardinality <= 1 || heapSize == 0 {
+		return childCost, nil
+	}
+
+	outputCardinality, _ := cm.EstimateCardinality(plan, catalogMgr)
+	topNCpuCost := float64(childCost.Cardinality) * math.Log2(float64(max(heapSize, 2))) * cm.CPUCostPerTuple * cm.SortCostFactor
+
+	return &CostEstimate{
+		TotalCost:   childCost.TotalCost + topNCpuCost,
+		CPUCost:     childCost.CPUCost + topNCpuCost,
+		IOCost:      childCost.IOCost,
+		NetworkCost: childCost.NetworkCost,
+		MemoryCost:  childCost.MemoryCost + float64(heapSize)*0.2,
+		Cardinality: outputCardinality,
+	}, nil
+}
+
+// TopNHeapSize is the number of rows a top-N keeps in its heap: limit plus
+// offset, capped at its input.
+func TopNHeapSize(plan *logical_plan.LogicalPlan, inputRows int64) int64 {
+	if plan.LimitCount == nil {
+		return inputRows
+	}
+	heapSize := *plan.LimitCount
+	if plan.OffsetCount != nil {
+		heapSize += *plan.OffsetCount
+	}
+	return min(heapSize, inputRows)
+}
+
 func (cm *SimpleCostModel) estimateLimitCost(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*CostEstimate, error) {
 	if len(plan.Children) == 0 {
 		return &CostEstimate{}, nil
@@ -355,26 +498,120 @@ func (cm *SimpleCostModel) estimateLimitCost(plan *logical_plan.LogicalPlan, cat
 	return childCost, nil
 }
 
-func (cm *SimpleCostModel) estimateSelectivity(predicate *logical_plan.Predicate, catalogMgr *catalog.CatalogManager) float64 {
-	if predicate == nil || predicate.Expression == nil {
+// ScannedFraction is the share of each row's bytes a scan reads: the width
+// of its ScanColumns over the width of every column in the table. Scans that
+// do not list their columns read whole rows.
+func ScannedFraction(plan *logical_plan.LogicalPlan, table *catalog.TableSchema) float64 {
+	if len(plan.ScanColumns) == 0 || len(table.Columns) == 0 {
 		return 1.0
 	}
 
-	expr := predicate.Expression
-	switch expr.Value {
-	case "=":
-		return 0.1
-	case "<", ">", "<=", ">=":
-		return 0.33
-	case "LIKE":
-		return 0.2
-	case "IN":
-		return 0.3
-	case "IS NULL":
-		return 0.05
-	case "IS NOT NULL":
-		return 0.95
-	default:
-		return 0.5
+	var total, read int64
+	for _, column := range table.Columns {
+		width := column.Width()
+		total += width
+		for _, name := range plan.ScanColumns {
+			if strings.EqualFold(name, column.Name) {
+				read += width
+				break
+			}
+		}
+	}
+	if total == 0 || read == 0 {
+		return 1.0
+	}
+	return float64(read) / float64(total)
+}
+
+// joinSelectivity estimates the fraction of the cross product a join keeps:
+// 1/max(NDV) for an equi-join on columns with statistics, times the
+// selectivity of any extra predicate on the join.
+func (cm *SimpleCostModel) joinSelectivity(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	if plan.JoinCondition == nil && plan.Predicate == nil {
+		if plan.JoinType == logical_plan.JoinTypeCross || plan.JoinType.LeftOnly() {
+			return 1.0
+		}
+		return defaultEqualitySelectivity
+	}
+
+	selectivity := 1.0
+	if jc := plan.JoinCondition; jc != nil {
+		switch jc.Operator {
+		case string(logical_plan.OpEq):
+			left := distinctValues(jc.Left, plan.Children[0], catalogMgr)
+			right := distinctValues(jc.Right, plan.Children[1], catalogMgr)
+			selectivity = defaultEqualitySelectivity
+			if ndv := math.Max(left, right); ndv > 0 {
+				selectivity = 1 / ndv
+			}
+		case string(logical_plan.OpNotEq):
+			selectivity = 1 - defaultEqualitySelectivity
+		default:
+			selectivity = defaultRangeSelectivity
+		}
+	}
+	return selectivity * cm.estimateSelectivity(plan.Predicate, plan, catalogMgr)
+}
+
+// groupCount is the number of groups an aggregate produces: the product of
+// the NDVs of its group-by columns, at most one group per input row. It is 0
+// when a column has no NDV.
+func groupCount(plan *logical_plan.LogicalPlan, inputRows int64, catalogMgr *catalog.CatalogManager) int64 {
+	groups := 1.0
+	for _, column := range plan.GroupBy {
+		ndv := distinctValues(logical_plan.NewColumnExpression(column.Table, column.Name), plan.Children[0], catalogMgr)
+		if ndv == 0 {
+			return 0
+		}
+		groups *= ndv
+	}
+	return int64(math.Max(math.Min(groups, float64(inputRows)), 1))
+}
+
+// matchedFraction estimates the fraction of left rows a semi or anti join
+// finds a match for. On an equi-join with statistics on both columns, the
+// join keys of the smaller side are taken to appear on the larger one, so
+// ndv(right)/ndv(left) of the left keys match, where the right side cannot
+// have more distinct keys than rows. Otherwise half the rows match. Any other
+// predicate of the join narrows the matches further.
+func (cm *SimpleCostModel) matchedFraction(plan *logical_plan.LogicalPlan, rightRows int64, catalogMgr *catalog.CatalogManager) float64 {
+	fraction := defaultMatchedFraction
+	if jc := plan.JoinCondition; jc != nil && jc.Operator == string(logical_plan.OpEq) {
+		left := distinctValues(jc.Left, plan.Children[0], catalogMgr)
+		right := math.Min(distinctValues(jc.Right, plan.Children[1], catalogMgr), float64(rightRows))
+		if left > 0 && right > 0 {
+			fraction = math.Min(right/left, 1)
+		}
+	}
+	return fraction * cm.estimateSelectivity(plan.Predicate, plan, catalogMgr)
+}
+
+// ScannedRows is the number of rows a scan reads: those of the partitions it
+// is limited to, or the whole table.
+func ScannedRows(plan *logical_plan.LogicalPlan, table *catalog.TableSchema) int64 {
+	if plan.Partitions == nil {
+		return table.RowCount
+	}
+	return int64(float64(table.RowCount) * table.PartitionFraction(plan.Partitions))
+}
+
+// filteredRows applies a filter's selectivity to its input. The partitions a
+// pruned scan skipped were already left out by the same predicate, so the
+// result is never taken below the selectivity of the whole table.
+func filteredRows(child *logical_plan.LogicalPlan, childRows int64, selectivity float64, catalogMgr *catalog.CatalogManager) int64 {
+	rows := int64(float64(childRows) * selectivity)
+	if child.NodeType != logical_plan.NodeTypeScan || child.Partitions == nil {
+		return rows
+	}
+	table, err := catalogMgr.GetTable(child.TableName)
+	if err != nil {
+		return rows
+	}
+	if whole := int64(float64(table.RowCount) * selectivity); whole > rows {
+		rows = whole
+	}
+	if rows > childRows {
+		rows = childRows
 	}
+	return rows
 }
@@ -0,0 +1,212 @@
+package cost_model
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+const (
+	defaultEqualitySelectivity = 0.1
+	defaultRangeSelectivity    = 0.33
+	defaultLikeSelectivity     = 0.2
+	defaultInSelectivity       = 0.3
+	defaultNullSelectivity     = 0.05
+	defaultSelectivity         = 0.5
+	defaultMatchedFraction     = 0.5
+)
+
+// estimateSelectivity estimates the fraction of input rows a predicate keeps,
+// using the catalog statistics of the columns it compares where available.
+// Columns are looked up in the scans of input. Conjuncts are treated as
+// independent.
+func (cm *SimpleCostModel) estimateSelectivity(predicate *logical_plan.Predicate, input *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	if predicate == nil || predicate.Expression == nil {
+		return 1.0
+	}
+	return expressionSelectivity(predicate.Expression, input, catalogMgr)
+}
+
+func expressionSelectivity(expr *logical_plan.Expression, input *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	switch expr.Kind {
+	case logical_plan.ExprLiteral:
+		if value, ok := expr.Literal.Value.(bool); ok && !value {
+			return 0
+		}
+		if expr.Literal.IsNull() {
+			return 0
+		}
+		return 1
+
+	case logical_plan.ExprBinaryOp:
+		switch expr.BinaryOp {
+		case logical_plan.OpAnd:
+			return expressionSelectivity(expr.Left, input, catalogMgr) * expressionSelectivity(expr.Right, input, catalogMgr)
+		case logical_plan.OpOr:
+			left := expressionSelectivity(expr.Left, input, catalogMgr)
+			right := expressionSelectivity(expr.Right, input, catalogMgr)
+			return left + right - left*right
+		case logical_plan.OpEq:
+			return equalitySelectivity(expr, input, catalogMgr)
+		case logical_plan.OpNotEq:
+			return 1 - equalitySelectivity(expr, input, catalogMgr)
+		case logical_plan.OpLt, logical_plan.OpGt, logical_plan.OpLtEq, logical_plan.OpGtEq:
+			return rangeSelectivity(expr, input, catalogMgr)
+		case logical_plan.OpLike:
+			return defaultLikeSelectivity
+		case logical_plan.OpIn:
+			return inSelectivity(expr, input, catalogMgr)
+		}
+
+	case logical_plan.ExprUnaryOp:
+		switch expr.UnaryOp {
+		case logical_plan.OpNot:
+			return 1 - expressionSelectivity(expr.Operand, input, catalogMgr)
+		case logical_plan.OpIsNull:
+			return nullFraction(expr.Operand, input, catalogMgr)
+		case logical_plan.OpIsNotNull:
+			return 1 - nullFraction(expr.Operand, input, catalogMgr)
+		}
+	}
+	return defaultSelectivity
+}
+
+// equalitySelectivity is 1/NDV for a column compared with a constant, and
+// 1/max(NDV) for two columns compared with each other.
+func equalitySelectivity(expr *logical_plan.Expression, input *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	left := distinctValues(expr.Left, input, catalogMgr)
+	right := distinctValues(expr.Right, input, catalogMgr)
+	switch {
+	case expr.Left.IsColumn() && expr.Right.IsColumn():
+		if ndv := math.Max(left, right); ndv > 0 {
+			return 1 / ndv
+		}
+	case left > 0:
+		return 1 / left
+	case right > 0:
+		return 1 / right
+	}
+	return defaultEqualitySelectivity
+}
+
+// rangeSelectivity interpolates a comparison with a numeric constant between
+// the column's minimum and maximum value.
+func rangeSelectivity(expr *logical_plan.Expression, input *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	column, literal, op := expr.Left, expr.Right, expr.BinaryOp
+	if !column.IsColumn() {
+		column, literal = literal, column
+		op = map[logical_plan.BinaryOperator]logical_plan.BinaryOperator{
+			logical_plan.OpLt:   logical_plan.OpGt,
+			logical_plan.OpLtEq: logical_plan.OpGtEq,
+			logical_plan.OpGt:   logical_plan.OpLt,
+			logical_plan.OpGtEq: logical_plan.OpLtEq,
+		}[op]
+	}
+	if !column.IsColumn() || !literal.IsLiteral() {
+		return defaultRangeSelectivity
+	}
+
+	stats, _ := columnStats(*column.Column, input, catalogMgr)
+	value, ok := literalNumber(literal.Literal)
+	if stats == nil || stats.MinValue == nil || stats.MaxValue == nil || !ok {
+		return defaultRangeSelectivity
+	}
+	low, errLow := strconv.ParseFloat(*stats.MinValue, 64)
+	high, errHigh := strconv.ParseFloat(*stats.MaxValue, 64)
+	if errLow != nil || errHigh != nil || high <= low {
+		return defaultRangeSelectivity
+	}
+
+	below := math.Min(math.Max((value-low)/(high-low), 0), 1)
+	if op == logical_plan.OpLt || op == logical_plan.OpLtEq {
+		return below
+	}
+	return 1 - below
+}
+
+func inSelectivity(expr *logical_plan.Expression, input *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	ndv := distinctValues(expr.Left, input, catalogMgr)
+	if ndv <= 0 || expr.Right == nil || expr.Right.Kind != logical_plan.ExprList {
+		return defaultInSelectivity
+	}
+	return math.Min(float64(len(expr.Right.Args))/ndv, 1)
+}
+
+func nullFraction(expr *logical_plan.Expression, input *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	if expr == nil || !expr.IsColumn() {
+		return defaultNullSelectivity
+	}
+	stats, table := columnStats(*expr.Column, input, catalogMgr)
+	if stats == nil || stats.NullCount == nil || table.RowCount <= 0 {
+		return defaultNullSelectivity
+	}
+	return math.Min(float64(*stats.NullCount)/float64(table.RowCount), 1)
+}
+
+// distinctValues returns the NDV of a column expression, or 0 when unknown.
+func distinctValues(expr *logical_plan.Expression, input *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) float64 {
+	if expr == nil || !expr.IsColumn() {
+		return 0
+	}
+	stats, _ := columnStats(*expr.Column, input, catalogMgr)
+	if stats == nil || stats.NDV == nil || *stats.NDV <= 0 {
+		return 0
+	}
+	return float64(*stats.NDV)
+}
+
+// columnStats finds the statistics of a referenced column among the tables
+// scanned by plan. A qualified reference matches a scan's alias or table
+// name; an unqualified one must match a single scanned table.
+func columnStats(ref logical_plan.ColumnRef, plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*catalog.Column, *catalog.TableSchema) {
+	if plan == nil || catalogMgr == nil {
+		return nil, nil
+	}
+
+	var found *catalog.Column
+	var foundTable *catalog.TableSchema
+	ambiguous := false
+	var walk func(node *logical_plan.LogicalPlan)
+	walk = func(node *logical_plan.LogicalPlan) {
+		if node.NodeType == logical_plan.NodeTypeScan {
+			if ref.Table != "" && !strings.EqualFold(ref.Table, node.RelationName()) && !strings.EqualFold(ref.Table, node.TableName) {
+				return
+			}
+			table, err := catalogMgr.GetTable(node.TableName)
+			if err != nil {
+				return
+			}
+			for i := range table.Columns {
+				if strings.EqualFold(table.Columns[i].Name, ref.Name) {
+					if found != nil {
+						ambiguous = true
+					}
+					found, foundTable = &table.Columns[i], table
+				}
+			}
+			return
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(plan)
+
+	if ambiguous {
+		return nil, nil
+	}
+	return found, foundTable
+}
+
+func literalNumber(l *logical_plan.Literal) (float64, bool) {
+	switch v := l.Value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
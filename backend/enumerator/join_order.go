@@ -0,0 +1,425 @@
+package enumerator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// Relation is one input of a join graph: the subtree that produces it,
+// usually a scan with its filters, and the name it is shown under.
+type Relation struct {
+	Name string
+	Plan *logical_plan.LogicalPlan
+}
+
+// JoinPredicate is one conjunct of the conditions joining relations, with the
+// indexes of the relations it references. For comparisons, LeftRelations and
+// RightRelations are the relations each operand references.
+type JoinPredicate struct {
+	Expression     *logical_plan.Expression
+	Relations      []int
+	LeftRelations  []int
+	RightRelations []int
+}
+
+// JoinTree is a join order over relations: either a leaf with a Relation
+// index, or the join of Left and Right.
+type JoinTree struct {
+	Relation int
+	Left     *JoinTree
+	Right    *JoinTree
+}
+
+type JoinOrder struct {
+	Order string  `json:"order"`
+	Cost  float64 `json:"cost"`
+}
+
+type JoinOrderResult struct {
+	Plan       *logical_plan.LogicalPlan `json:"-"`
+	Strategy   string                    `json:"strategy"`
+	Original   *JoinOrder                `json:"original,omitempty"`
+	Chosen     JoinOrder                 `json:"chosen"`
+	Considered []JoinOrder               `json:"considered"`
+	// Partial is set when the context ended the search before every order
+	// was considered.
+	Partial bool `json:"partial,omitempty"`
+}
+
+type joinCandidate struct {
+	mask int
+	plan *logical_plan.LogicalPlan
+	cost float64
+}
+
+type joinGraph struct {
+	relations  []Relation
+	predicates []JoinPredicate
+	masks      []int
+	leftMasks  []int
+	rightMasks []int
+}
+
+// OrderJoins finds the cheapest order to inner join the relations. Each
+// predicate is placed on the lowest join that covers every relation it
+// references. When original is given its cost is reported too, and it is
+// kept unless another order is strictly cheaper. Once ctx is done, dynamic
+// programming gives way to one greedy pass and the result is partial.
+//
+// When leading lists relations, they are joined first, left-deep in that
+// order, and only the other relations are ordered around that join; the
+// original order is then never kept in its place.
+func (pe *PlanEnumerator) OrderJoins(ctx context.Context, relations []Relation, predicates []JoinPredicate, original *JoinTree, leading []int) (*JoinOrderResult, error) {
+	if len(relations) < 2 {
+		return nil, fmt.Errorf("need at least two relations to order joins, got %d", len(relations))
+	}
+	if len(relations) >= 31 {
+		return nil, fmt.Errorf("too many relations to order joins: %d", len(relations))
+	}
+
+	graph := &joinGraph{relations: relations, predicates: predicates}
+	for _, predicate := range predicates {
+		graph.masks = append(graph.masks, relationMask(predicate.Relations))
+		graph.leftMasks = append(graph.leftMasks, relationMask(predicate.LeftRelations))
+		graph.rightMasks = append(graph.rightMasks, relationMask(predicate.RightRelations))
+	}
+
+	leaves := make([]*joinCandidate, len(relations))
+	for i, relation := range relations {
+		cost, err := pe.costModel.EstimateCost(relation.Plan, pe.catalogMgr)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = &joinCandidate{mask: 1 << i, plan: relation.Plan, cost: cost.TotalCost}
+	}
+
+	inputs, err := pe.leadingInputs(graph, leaves, leading)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *JoinOrderResult
+	switch {
+	case len(inputs) == 1:
+		result = &JoinOrderResult{
+			Plan:     inputs[0].plan,
+			Strategy: "leading",
+			Chosen:   JoinOrder{Order: logical_plan.JoinOrder(inputs[0].plan), Cost: inputs[0].cost},
+		}
+		result.Considered = []JoinOrder{result.Chosen}
+	case len(relations) <= pe.threshold():
+		result, err = pe.orderWithDP(ctx, graph, inputs)
+		switch err {
+		case errPlanBudget:
+			result, err = pe.orderGreedily(ctx, graph, inputs)
+			if result != nil {
+				result.Strategy = fmt.Sprintf("greedy (dynamic programming exceeded %d plans)", pe.maxPlans)
+			}
+		case errInterrupted:
+			result, err = pe.orderGreedily(ctx, graph, inputs)
+			if result != nil {
+				result.Strategy = "greedy (dynamic programming interrupted)"
+				result.Partial = true
+			}
+		}
+	default:
+		result, err = pe.orderGreedily(ctx, graph, inputs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(leading) > 0 && result.Strategy != "leading" {
+		result.Strategy += " after leading"
+	}
+
+	if original != nil {
+		candidate, err := pe.buildTree(graph, leaves, original)
+		if err != nil {
+			return nil, err
+		}
+		result.Original = &JoinOrder{Order: logical_plan.JoinOrder(candidate.plan), Cost: candidate.cost}
+		if len(leading) == 0 && candidate.cost <= result.Chosen.Cost {
+			result.Plan = candidate.plan
+			result.Chosen = *result.Original
+		}
+		result.Considered = appendOrder(result.Considered, *result.Original)
+	}
+
+	sort.SliceStable(result.Considered, func(i, j int) bool {
+		return result.Considered[i].Cost < result.Considered[j].Cost
+	})
+	return result, nil
+}
+
+// leadingInputs replaces the leading relations with their left-deep join,
+// which the search then treats as a single input. Without leading relations
+// the inputs are the leaves.
+func (pe *PlanEnumerator) leadingInputs(graph *joinGraph, leaves []*joinCandidate, leading []int) ([]*joinCandidate, error) {
+	if len(leading) == 0 {
+		return leaves, nil
+	}
+	var joined *joinCandidate
+	for _, relation := range leading {
+		if relation < 0 || relation >= len(leaves) {
+			return nil, fmt.Errorf("leading relation %d out of range", relation)
+		}
+		leaf := leaves[relation]
+		if joined == nil {
+			joined = leaf
+			continue
+		}
+		if joined.mask&leaf.mask != 0 {
+			return nil, fmt.Errorf("leading relation %s given twice", graph.relations[relation].Name)
+		}
+		candidate, _, err := pe.join(graph, joined, leaf, true)
+		if err != nil {
+			return nil, err
+		}
+		joined = candidate
+	}
+	inputs := []*joinCandidate{joined}
+	for _, leaf := range leaves {
+		if joined.mask&leaf.mask == 0 {
+			inputs = append(inputs, leaf)
+		}
+	}
+	return inputs, nil
+}
+
+// errPlanBudget stops dynamic programming that costed more plans than the
+// enumerator's budget.
+var errPlanBudget = errors.New("join order search exceeded its plan budget")
+
+// errInterrupted stops dynamic programming once its context is done.
+var errInterrupted = errors.New("join order search interrupted")
+
+// orderWithDP builds the cheapest plan for every subset of relations from the
+// cheapest plans of its two halves. Cross products are only considered when
+// the join graph is not connected.
+func (pe *PlanEnumerator) orderWithDP(ctx context.Context, graph *joinGraph, leaves []*joinCandidate) (*JoinOrderResult, error) {
+	n := len(graph.relations)
+	fullMask := (1 << n) - 1
+	costed := 0
+
+	for _, allowCross := range []bool{false, true} {
+		best := make(map[int]*joinCandidate)
+		for _, leaf := range leaves {
+			best[leaf.mask] = leaf
+		}
+		var considered []JoinOrder
+
+		for size := 2; size <= n; size++ {
+			for _, subset := range pe.generateSubsets(n, size) {
+				if ctx.Err() != nil {
+					return nil, errInterrupted
+				}
+				for leftMask := (subset - 1) & subset; leftMask > 0; leftMask = (leftMask - 1) & subset {
+					left, right := best[leftMask], best[subset^leftMask]
+					if left == nil || right == nil {
+						continue
+					}
+					candidate, ok, err := pe.join(graph, left, right, allowCross)
+					if err != nil {
+						return nil, err
+					}
+					if !ok {
+						continue
+					}
+					if costed++; costed > pe.maxPlans {
+						return nil, errPlanBudget
+					}
+					if subset == fullMask {
+						considered = appendOrder(considered, JoinOrder{Order: logical_plan.JoinOrder(candidate.plan), Cost: candidate.cost})
+					}
+					if current := best[subset]; current == nil || candidate.cost < current.cost {
+						best[subset] = candidate
+					}
+				}
+			}
+		}
+
+		if chosen := best[fullMask]; chosen != nil {
+			return &JoinOrderResult{
+				Plan:       chosen.plan,
+				Strategy:   "dynamic_programming",
+				Chosen:     JoinOrder{Order: logical_plan.JoinOrder(chosen.plan), Cost: chosen.cost},
+				Considered: considered,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no join order found")
+}
+
+// orderGreedily builds one left-deep plan per starting relation, each time
+// adding the relation that makes the cheapest join, and keeps the best. Once
+// ctx is done no further starting relations are tried.
+func (pe *PlanEnumerator) orderGreedily(ctx context.Context, graph *joinGraph, leaves []*joinCandidate) (*JoinOrderResult, error) {
+	var considered []JoinOrder
+	var chosen *joinCandidate
+	partial := false
+
+	for _, start := range leaves {
+		if chosen != nil && ctx.Err() != nil {
+			partial = true
+			break
+		}
+		current := start
+		for current.mask != (1<<len(graph.relations))-1 {
+			var next *joinCandidate
+			for _, allowCross := range []bool{false, true} {
+				for _, leaf := range leaves {
+					if current.mask&leaf.mask != 0 {
+						continue
+					}
+					candidate, ok, err := pe.join(graph, current, leaf, allowCross)
+					if err != nil {
+						return nil, err
+					}
+					if ok && (next == nil || candidate.cost < next.cost) {
+						next = candidate
+					}
+				}
+				if next != nil {
+					break
+				}
+			}
+			current = next
+		}
+
+		considered = appendOrder(considered, JoinOrder{Order: logical_plan.JoinOrder(current.plan), Cost: current.cost})
+		if chosen == nil || current.cost < chosen.cost {
+			chosen = current
+		}
+	}
+
+	return &JoinOrderResult{
+		Plan:       chosen.plan,
+		Strategy:   "greedy",
+		Chosen:     JoinOrder{Order: logical_plan.JoinOrder(chosen.plan), Cost: chosen.cost},
+		Considered: considered,
+		Partial:    partial,
+	}, nil
+}
+
+func (pe *PlanEnumerator) buildTree(graph *joinGraph, leaves []*joinCandidate, tree *JoinTree) (*joinCandidate, error) {
+	if tree.Left == nil || tree.Right == nil {
+		if tree.Relation < 0 || tree.Relation >= len(leaves) {
+			return nil, fmt.Errorf("join tree references unknown relation %d", tree.Relation)
+		}
+		return leaves[tree.Relation], nil
+	}
+
+	left, err := pe.buildTree(graph, leaves, tree.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := pe.buildTree(graph, leaves, tree.Right)
+	if err != nil {
+		return nil, err
+	}
+	candidate, _, err := pe.join(graph, left, right, true)
+	return candidate, err
+}
+
+// join joins two disjoint candidates on every predicate that becomes
+// evaluable once both are available. The first comparison between the two
+// sides becomes the join condition and the rest stay on the join as its
+// predicate. Column equalities already implied by those applied below or
+// earlier are skipped. Without any predicate it is a cross join, built only
+// when allowCross is set.
+func (pe *PlanEnumerator) join(graph *joinGraph, left, right *joinCandidate, allowCross bool) (*joinCandidate, bool, error) {
+	mask := left.mask | right.mask
+
+	classes := logical_plan.NewEquivalenceClasses()
+	for i, predicate := range graph.predicates {
+		if covered := graph.masks[i]; covered&left.mask == covered || covered&right.mask == covered {
+			classes.Add(predicate.Expression)
+		}
+	}
+
+	var condition *logical_plan.JoinCondition
+	var residual []*logical_plan.Expression
+	for i, predicate := range graph.predicates {
+		covered := graph.masks[i]
+		if covered&mask != covered || covered&left.mask == covered || covered&right.mask == covered {
+			continue
+		}
+		if classes.Implies(predicate.Expression) {
+			continue
+		}
+		classes.Add(predicate.Expression)
+		if condition == nil {
+			if condition = joinCondition(predicate.Expression, graph.leftMasks[i], graph.rightMasks[i], left.mask, right.mask); condition != nil {
+				continue
+			}
+		}
+		residual = append(residual, predicate.Expression)
+	}
+
+	joinType := logical_plan.JoinTypeInner
+	if condition == nil && len(residual) == 0 {
+		if !allowCross {
+			return nil, false, nil
+		}
+		joinType = logical_plan.JoinTypeCross
+	}
+
+	plan := logical_plan.NewJoinNode(left.plan, right.plan, joinType, condition)
+	if len(residual) > 0 {
+		plan.Predicate = &logical_plan.Predicate{Expression: logical_plan.CombineConjuncts(residual)}
+	}
+
+	cost, err := pe.costModel.EstimateCost(plan, pe.catalogMgr)
+	if err != nil {
+		return nil, false, err
+	}
+	return &joinCandidate{mask: mask, plan: plan, cost: cost.TotalCost}, true, nil
+}
+
+var flippedOperators = map[logical_plan.BinaryOperator]logical_plan.BinaryOperator{
+	logical_plan.OpEq:    logical_plan.OpEq,
+	logical_plan.OpNotEq: logical_plan.OpNotEq,
+	logical_plan.OpLt:    logical_plan.OpGt,
+	logical_plan.OpLtEq:  logical_plan.OpGtEq,
+	logical_plan.OpGt:    logical_plan.OpLt,
+	logical_plan.OpGtEq:  logical_plan.OpLtEq,
+}
+
+// joinCondition turns a comparison whose operands each read from one side
+// into a join condition, with the left input's operand first.
+func joinCondition(expr *logical_plan.Expression, exprLeft, exprRight, left, right int) *logical_plan.JoinCondition {
+	if expr.Kind != logical_plan.ExprBinaryOp || !expr.BinaryOp.IsComparison() || exprLeft == 0 || exprRight == 0 {
+		return nil
+	}
+	switch {
+	case exprLeft&left == exprLeft && exprRight&right == exprRight:
+		return &logical_plan.JoinCondition{Left: expr.Left, Right: expr.Right, Operator: string(expr.BinaryOp)}
+	case exprLeft&right == exprLeft && exprRight&left == exprRight:
+		return &logical_plan.JoinCondition{Left: expr.Right, Right: expr.Left, Operator: string(flippedOperators[expr.BinaryOp])}
+	}
+	return nil
+}
+
+func relationMask(relations []int) int {
+	mask := 0
+	for _, relation := range relations {
+		mask |= 1 << relation
+	}
+	return mask
+}
+
+// appendOrder adds an order, keeping only the cheapest cost seen for it.
+func appendOrder(orders []JoinOrder, order JoinOrder) []JoinOrder {
+	for i, existing := range orders {
+		if existing.Order == order.Order {
+			orders[i].Cost = math.Min(existing.Cost, order.Cost)
+			return orders
+		}
+	}
+	return append(orders, order)
+}
@@ -0,0 +1,306 @@
+package lineage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// Kind summarises what happened to a source column's values on the way to an
+// output column, from weakest to strongest.
+type Kind string
+
+const (
+	KindIdentity    Kind = "identity"
+	KindFiltered    Kind = "filtered"
+	KindJoined      Kind = "joined"
+	KindTransformed Kind = "transformed"
+	KindAggregated  Kind = "aggregated"
+)
+
+var kindRank = map[Kind]int{
+	KindIdentity:    0,
+	KindFiltered:    1,
+	KindJoined:      2,
+	KindTransformed: 3,
+	KindAggregated:  4,
+}
+
+// IndirectKind says how a source column influences which rows are produced,
+// rather than their values.
+type IndirectKind string
+
+const (
+	IndirectFilter  IndirectKind = "filter"
+	IndirectJoin    IndirectKind = "join"
+	IndirectGroupBy IndirectKind = "group_by"
+	IndirectSort    IndirectKind = "sort"
+)
+
+// Graph links every output column to the source table columns it is derived
+// from. Edges point from outputs to sources.
+type Graph struct {
+	Outputs  []OutputColumn `json:"outputs"`
+	Sources  []SourceColumn `json:"sources"`
+	Edges    []Edge         `json:"edges"`
+	Indirect []IndirectEdge `json:"indirect,omitempty"`
+}
+
+type OutputColumn struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+}
+
+type SourceColumn struct {
+	ID     string `json:"id"`
+	Table  string `json:"table"`
+	Column string `json:"column"`
+}
+
+type Edge struct {
+	Output string `json:"output"`
+	Source string `json:"source"`
+	Kind   Kind   `json:"kind"`
+	Path   []Step `json:"path"`
+}
+
+// Step is one operator a value passed through, from the source upwards.
+type Step struct {
+	NodeID   string                `json:"node_id"`
+	NodeType logical_plan.NodeType `json:"node_type"`
+	Label    string                `json:"label"`
+}
+
+type IndirectEdge struct {
+	Source string       `json:"source"`
+	Kind   IndirectKind `json:"kind"`
+	NodeID string       `json:"node_id"`
+	Label  string       `json:"label"`
+}
+
+type path struct {
+	source SourceColumn
+	kind   Kind
+	steps  []Step
+}
+
+type extractor struct {
+	bound    *binder.BoundPlan
+	sources  map[string]SourceColumn
+	indirect []IndirectEdge
+	seen     map[string]bool
+}
+
+// Extract binds the plan against the catalog and traces every output column
+// back to the table columns it reads.
+func Extract(plan *logical_plan.LogicalPlan, cm *catalog.CatalogManager) (*Graph, error) {
+	bound, err := binder.Bind(plan, cm)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &extractor{
+		bound:   bound,
+		sources: make(map[string]SourceColumn),
+		seen:    make(map[string]bool),
+	}
+	columns := e.trace(plan)
+
+	graph := &Graph{
+		Outputs:  []OutputColumn{},
+		Sources:  []SourceColumn{},
+		Edges:    []Edge{},
+		Indirect: e.indirect,
+	}
+	for i, column := range bound.Output(plan) {
+		output := OutputColumn{ID: fmt.Sprintf("output.%d", i), Index: i, Name: column.Name}
+		graph.Outputs = append(graph.Outputs, output)
+
+		strongest := make(map[string]path)
+		var order []string
+		for _, p := range columns[i] {
+			existing, ok := strongest[p.source.ID]
+			if !ok {
+				order = append(order, p.source.ID)
+			}
+			if !ok || kindRank[p.kind] > kindRank[existing.kind] {
+				strongest[p.source.ID] = p
+			}
+		}
+		for _, id := range order {
+			p := strongest[id]
+			graph.Edges = append(graph.Edges, Edge{Output: output.ID, Source: id, Kind: p.kind, Path: p.steps})
+		}
+	}
+
+	for _, source := range e.sources {
+		graph.Sources = append(graph.Sources, source)
+	}
+	sort.Slice(graph.Sources, func(i, j int) bool { return graph.Sources[i].ID < graph.Sources[j].ID })
+	return graph, nil
+}
+
+// trace returns, for each output column of node, the paths to its sources.
+func (e *extractor) trace(node *logical_plan.LogicalPlan) [][]path {
+	var input [][]path
+	childOutputs := make([][][]path, len(node.Children))
+	for i, child := range node.Children {
+		childOutputs[i] = e.trace(child)
+		input = append(input, childOutputs[i]...)
+	}
+
+	step := Step{NodeID: node.ID, NodeType: node.NodeType, Label: node.Label()}
+
+	switch node.NodeType {
+	case logical_plan.NodeTypeScan:
+		var output [][]path
+		for _, column := range e.bound.Output(node) {
+			source := SourceColumn{ID: column.Table + "." + column.SourceName, Table: column.Table, Column: column.SourceName}
+			e.sources[source.ID] = source
+			output = append(output, []path{{source: source, kind: KindIdentity}})
+		}
+		if node.Predicate != nil {
+			e.addIndirect(node, output, IndirectFilter, binder.ExpressionReferences(node.Predicate.Expression))
+			output = extendAll(output, step, KindFiltered)
+		}
+		return output
+
+	case logical_plan.NodeTypeFilter:
+		if node.Predicate != nil {
+			e.addIndirect(node, input, IndirectFilter, binder.ExpressionReferences(node.Predicate.Expression))
+		}
+		return extendAll(input, step, KindFiltered)
+
+	case logical_plan.NodeTypeJoin:
+		var refs []logical_plan.ColumnRef
+		if node.JoinCondition != nil {
+			refs = append(binder.ExpressionReferences(node.JoinCondition.Left), binder.ExpressionReferences(node.JoinCondition.Right)...)
+		}
+		if node.Predicate != nil {
+			refs = append(refs, binder.ExpressionReferences(node.Predicate.Expression)...)
+		}
+		e.addIndirect(node, input, IndirectJoin, refs)
+		if node.JoinType.LeftOnly() && len(childOutputs) == 2 {
+			// The right input only decides which left rows are kept.
+			return extendAll(childOutputs[0], step, KindFiltered)
+		}
+		return extendAll(input, step, KindJoined)
+
+	case logical_plan.NodeTypeProject:
+		var output [][]path
+		for _, column := range node.Projections {
+			switch {
+			case column.Expression != nil:
+				output = append(output, extend(e.collect(node, input, binder.ExpressionReferences(column.Expression)), step, KindTransformed))
+			case column.Name == "*":
+				for i, c := range e.bound.Input(node) {
+					if i < len(input) && (column.Table == "" || strings.EqualFold(c.Relation, column.Table) || strings.EqualFold(c.Table, column.Table)) {
+						output = append(output, input[i])
+					}
+				}
+			default:
+				output = append(output, e.collect(node, input, []logical_plan.ColumnRef{{Table: column.Table, Name: column.Name}}))
+			}
+		}
+		return output
+
+	case logical_plan.NodeTypeAggregate:
+		var output [][]path
+		var groupRefs []logical_plan.ColumnRef
+		for _, column := range node.GroupBy {
+			ref := logical_plan.ColumnRef{Table: column.Table, Name: column.Name}
+			groupRefs = append(groupRefs, ref)
+			output = append(output, extend(e.collect(node, input, []logical_plan.ColumnRef{ref}), step, KindIdentity))
+		}
+		e.addIndirect(node, input, IndirectGroupBy, groupRefs)
+		for _, agg := range node.Aggregates {
+			output = append(output, extend(e.collect(node, input, binder.ExpressionReferences(agg.Column)), step, KindAggregated))
+		}
+		return output
+
+	case logical_plan.NodeTypeSort, logical_plan.NodeTypeTopN:
+		var refs []logical_plan.ColumnRef
+		for _, ob := range node.OrderBy {
+			refs = append(refs, binder.ExpressionReferences(ob.Expression)...)
+		}
+		e.addIndirect(node, input, IndirectSort, refs)
+		return extendAll(input, step, KindIdentity)
+
+	case logical_plan.NodeTypeEmpty:
+		return make([][]path, len(node.Projections))
+
+	case logical_plan.NodeTypeUnion:
+		if len(childOutputs) == 0 {
+			return nil
+		}
+		output := make([][]path, len(childOutputs[0]))
+		for _, child := range childOutputs {
+			for i := range output {
+				if i < len(child) {
+					output[i] = append(output[i], child[i]...)
+				}
+			}
+		}
+		return extendAll(output, step, KindIdentity)
+
+	default:
+		return extendAll(input, step, KindIdentity)
+	}
+}
+
+// collect gathers the paths of the input columns the references resolve to.
+func (e *extractor) collect(node *logical_plan.LogicalPlan, input [][]path, refs []logical_plan.ColumnRef) []path {
+	var paths []path
+	for _, ref := range refs {
+		index, err := e.bound.ResolveInput(node, ref)
+		if err != nil || index >= len(input) {
+			continue
+		}
+		paths = append(paths, input[index]...)
+	}
+	return paths
+}
+
+func (e *extractor) addIndirect(node *logical_plan.LogicalPlan, input [][]path, kind IndirectKind, refs []logical_plan.ColumnRef) {
+	for _, p := range e.collect(node, input, refs) {
+		key := string(kind) + "|" + node.ID + "|" + p.source.ID
+		if e.seen[key] {
+			continue
+		}
+		e.seen[key] = true
+		e.indirect = append(e.indirect, IndirectEdge{
+			Source: p.source.ID,
+			Kind:   kind,
+			NodeID: node.ID,
+			Label:  node.Label(),
+		})
+	}
+}
+
+func extendAll(columns [][]path, step Step, kind Kind) [][]path {
+	result := make([][]path, len(columns))
+	for i, paths := range columns {
+		result[i] = extend(paths, step, kind)
+	}
+	return result
+}
+
+// extend appends a step to every path, raising its kind to at least kind.
+func extend(paths []path, step Step, kind Kind) []path {
+	result := make([]path, len(paths))
+	for i, p := range paths {
+		steps := make([]Step, len(p.steps), len(p.steps)+1)
+		copy(steps, p.steps)
+		p.steps = append(steps, step)
+		if kindRank[kind] > kindRank[p.kind] {
+			p.kind = kind
+		}
+		result[i] = p
+	}
+	return result
+}
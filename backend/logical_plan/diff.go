@@ -0,0 +1,381 @@
+package logical_plan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type PlanDiff struct {
+	Identical        bool             `json:"identical"`
+	Added            []NodeRef        `json:"added,omitempty"`
+	Removed          []NodeRef        `json:"removed,omitempty"`
+	Moved            []NodeMove       `json:"moved,omitempty"`
+	PredicateChanges []PropertyChange `json:"predicate_changes,omitempty"`
+	PropertyChanges  []PropertyChange `json:"property_changes,omitempty"`
+	PhysicalChanges  []PropertyChange `json:"physical_changes,omitempty"`
+	JoinOrderChange  *JoinOrderChange `json:"join_order_change,omitempty"`
+}
+
+type NodeRef struct {
+	ID       string   `json:"id"`
+	NodeType NodeType `json:"node_type"`
+	Label    string   `json:"label"`
+}
+
+type NodeMove struct {
+	Before     NodeRef `json:"before"`
+	After      NodeRef `json:"after"`
+	FromParent string  `json:"from_parent"`
+	ToParent   string  `json:"to_parent"`
+}
+
+type PropertyChange struct {
+	Before   NodeRef `json:"before"`
+	After    NodeRef `json:"after"`
+	Property string  `json:"property"`
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+}
+
+type JoinOrderChange struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Diff compares two plans structurally. Node IDs are ignored because every
+// Clone assigns new ones; instead nodes are paired by content, then by content
+// alone when they sit above different relations (a pushed-down filter), and
+// finally by position when only their content changed.
+func Diff(before, after *LogicalPlan) *PlanDiff {
+	diff := &PlanDiff{}
+
+	beforeNodes := flattenForDiff(before, nil)
+	afterNodes := flattenForDiff(after, nil)
+
+	pairs := make(map[*diffNode]*diffNode)
+	reverse := make(map[*diffNode]*diffNode)
+
+	matchPass := func(key func(*diffNode) string) []*diffNode {
+		var matched []*diffNode
+		queues := make(map[string][]*diffNode)
+		for _, node := range afterNodes {
+			if reverse[node] == nil {
+				k := key(node)
+				queues[k] = append(queues[k], node)
+			}
+		}
+		for _, node := range beforeNodes {
+			if pairs[node] != nil {
+				continue
+			}
+			k := key(node)
+			if queue := queues[k]; len(queue) > 0 {
+				pairs[node] = queue[0]
+				reverse[queue[0]] = node
+				queues[k] = queue[1:]
+				matched = append(matched, node)
+			}
+		}
+		return matched
+	}
+
+	matchPass(func(n *diffNode) string { return n.positionKey + "|" + n.contentKey })
+	matchPass(func(n *diffNode) string { return string(n.plan.NodeType) + "|" + n.contentKey })
+	modified := matchPass(func(n *diffNode) string { return n.positionKey })
+
+	for _, node := range beforeNodes {
+		if pairs[node] == nil {
+			diff.Removed = append(diff.Removed, node.ref())
+		}
+	}
+	for _, node := range afterNodes {
+		if reverse[node] == nil {
+			diff.Added = append(diff.Added, node.ref())
+		}
+	}
+
+	for _, node := range modified {
+		diffContent(diff, node.plan, pairs[node].plan)
+	}
+
+	for _, node := range beforeNodes {
+		partner := pairs[node]
+		if partner == nil {
+			continue
+		}
+		if isMoved(node, partner, pairs, reverse) {
+			diff.Moved = append(diff.Moved, NodeMove{
+				Before:     node.ref(),
+				After:      partner.ref(),
+				FromParent: parentLabel(node),
+				ToParent:   parentLabel(partner),
+			})
+		}
+		diffMetadata(diff, node.plan, partner.plan)
+	}
+
+	beforeOrder := JoinOrder(before)
+	afterOrder := JoinOrder(after)
+	if beforeOrder != afterOrder {
+		diff.JoinOrderChange = &JoinOrderChange{Before: beforeOrder, After: afterOrder}
+	}
+
+	diff.Identical = len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Moved) == 0 &&
+		len(diff.PredicateChanges) == 0 && len(diff.PropertyChanges) == 0 &&
+		len(diff.PhysicalChanges) == 0 && diff.JoinOrderChange == nil
+
+	return diff
+}
+
+// JoinOrder renders only the join structure of a plan, e.g. "((a ⋈ b) ⋈ c)",
+// with ⋉ for semi joins and ▷ for anti joins. Unary operators between joins
+// are skipped.
+func JoinOrder(plan *LogicalPlan) string {
+	if plan == nil {
+		return ""
+	}
+
+	switch plan.NodeType {
+	case NodeTypeScan:
+		return plan.RelationName()
+	case NodeTypeJoin:
+		parts := make([]string, len(plan.Children))
+		for i, child := range plan.Children {
+			parts[i] = JoinOrder(child)
+		}
+		symbol := " ⋈ "
+		switch plan.JoinType {
+		case JoinTypeSemi:
+			symbol = " ⋉ "
+		case JoinTypeAnti:
+			symbol = " ▷ "
+		}
+		return "(" + strings.Join(parts, symbol) + ")"
+	default:
+		parts := make([]string, 0, len(plan.Children))
+		for _, child := range plan.Children {
+			if order := JoinOrder(child); order != "" {
+				parts = append(parts, order)
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+}
+
+type diffNode struct {
+	plan        *LogicalPlan
+	parent      *diffNode
+	positionKey string
+	contentKey  string
+}
+
+func (n *diffNode) ref() NodeRef {
+	return nodeRef(n.plan)
+}
+
+func nodeRef(plan *LogicalPlan) NodeRef {
+	return NodeRef{ID: plan.ID, NodeType: plan.NodeType, Label: plan.Label()}
+}
+
+func flattenForDiff(plan *LogicalPlan, parent *diffNode) []*diffNode {
+	if plan == nil {
+		return nil
+	}
+
+	node := &diffNode{
+		plan:        plan,
+		parent:      parent,
+		positionKey: string(plan.NodeType) + "|" + strings.Join(plan.Relations(), ","),
+		contentKey:  nodeContent(plan),
+	}
+
+	nodes := []*diffNode{node}
+	for _, child := range plan.Children {
+		nodes = append(nodes, flattenForDiff(child, node)...)
+	}
+	return nodes
+}
+
+// nodeContent describes everything that defines a node logically. Estimates,
+// IDs and metadata are deliberately left out.
+func nodeContent(plan *LogicalPlan) string {
+	var parts []string
+	for _, property := range nodeProperties(plan) {
+		parts = append(parts, property[0]+"="+property[1])
+	}
+	return strings.Join(parts, ";")
+}
+
+func nodeProperties(plan *LogicalPlan) [][2]string {
+	var properties [][2]string
+	add := func(name, value string) {
+		if value != "" {
+			properties = append(properties, [2]string{name, value})
+		}
+	}
+
+	add("table", plan.TableName)
+	add("scan_columns", strings.Join(plan.ScanColumns, ", "))
+	add("partitions", strings.Join(plan.Partitions, ", "))
+	add("alias", plan.Alias)
+	if plan.Predicate != nil {
+		add("predicate", plan.Predicate.Expression.String())
+	}
+	add("join_type", string(plan.JoinType))
+	add("join_condition", plan.JoinCondition.String())
+
+	columns := make([]string, len(plan.Projections))
+	for i, column := range plan.Projections {
+		columns[i] = column.String()
+	}
+	add("projections", strings.Join(columns, ", "))
+
+	groups := make([]string, len(plan.GroupBy))
+	for i, column := range plan.GroupBy {
+		groups[i] = column.String()
+	}
+	add("group_by", strings.Join(groups, ", "))
+
+	aggregates := make([]string, len(plan.Aggregates))
+	for i, agg := range plan.Aggregates {
+		aggregates[i] = agg.String()
+	}
+	add("aggregates", strings.Join(aggregates, ", "))
+
+	keys := make([]string, len(plan.OrderBy))
+	for i, ob := range plan.OrderBy {
+		keys[i] = ob.String()
+	}
+	add("order_by", strings.Join(keys, ", "))
+
+	if plan.LimitCount != nil {
+		add("limit", fmt.Sprint(*plan.LimitCount))
+	}
+	if plan.OffsetCount != nil {
+		add("offset", fmt.Sprint(*plan.OffsetCount))
+	}
+	if plan.UnionAll {
+		add("union_all", "true")
+	}
+
+	return properties
+}
+
+func diffContent(diff *PlanDiff, before, after *LogicalPlan) {
+	beforeProps := propertyMap(before)
+	afterProps := propertyMap(after)
+
+	names := unionKeys(beforeProps, afterProps)
+	for _, name := range names {
+		if beforeProps[name] == afterProps[name] {
+			continue
+		}
+		change := PropertyChange{
+			Before:   nodeRef(before),
+			After:    nodeRef(after),
+			Property: name,
+			From:     beforeProps[name],
+			To:       afterProps[name],
+		}
+		if name == "predicate" || name == "join_condition" {
+			diff.PredicateChanges = append(diff.PredicateChanges, change)
+		} else {
+			diff.PropertyChanges = append(diff.PropertyChanges, change)
+		}
+	}
+}
+
+func diffMetadata(diff *PlanDiff, before, after *LogicalPlan) {
+	beforeMeta := make(map[string]string, len(before.Metadata))
+	for k, v := range before.Metadata {
+		beforeMeta[k] = fmt.Sprint(v)
+	}
+	afterMeta := make(map[string]string, len(after.Metadata))
+	for k, v := range after.Metadata {
+		afterMeta[k] = fmt.Sprint(v)
+	}
+
+	for _, key := range unionKeys(beforeMeta, afterMeta) {
+		if beforeMeta[key] == afterMeta[key] {
+			continue
+		}
+		diff.PhysicalChanges = append(diff.PhysicalChanges, PropertyChange{
+			Before:   nodeRef(before),
+			After:    nodeRef(after),
+			Property: key,
+			From:     beforeMeta[key],
+			To:       afterMeta[key],
+		})
+	}
+}
+
+func propertyMap(plan *LogicalPlan) map[string]string {
+	props := make(map[string]string)
+	for _, property := range nodeProperties(plan) {
+		props[property[0]] = property[1]
+	}
+	return props
+}
+
+func unionKeys(a, b map[string]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]string{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isMoved reports whether a node now sits above different relations, or under
+// a different chain of matched ancestors. Filters are ignored in the ancestor
+// chain so that pushing a filter down is reported once, for the filter, rather
+// than for every node it passes.
+func isMoved(before, after *diffNode, pairs, reverse map[*diffNode]*diffNode) bool {
+	if before.plan.NodeType != NodeTypeScan && before.positionKey != after.positionKey {
+		return true
+	}
+
+	beforeChain := ancestorChain(before, func(n *diffNode) *diffNode { return pairs[n] })
+	afterChain := ancestorChain(after, func(n *diffNode) *diffNode {
+		if reverse[n] == nil {
+			return nil
+		}
+		return n
+	})
+	if len(beforeChain) != len(afterChain) {
+		return true
+	}
+	for i := range beforeChain {
+		if beforeChain[i] != afterChain[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func ancestorChain(node *diffNode, matched func(*diffNode) *diffNode) []*diffNode {
+	var chain []*diffNode
+	for ancestor := node.parent; ancestor != nil; ancestor = ancestor.parent {
+		if ancestor.plan.NodeType == NodeTypeFilter {
+			continue
+		}
+		if m := matched(ancestor); m != nil {
+			chain = append(chain, m)
+		}
+	}
+	return chain
+}
+
+func parentLabel(node *diffNode) string {
+	if node.parent == nil {
+		return "(root)"
+	}
+	return node.parent.plan.Label()
+}
@@ -0,0 +1,130 @@
+package logical_plan
+
+import (
+	"sort"
+	"strings"
+)
+
+// EquivalenceClasses groups the columns that column = column conjuncts make
+// equal, so that equalities and comparisons on one column carry over to the
+// others. Columns are compared by their reference, ignoring case.
+type EquivalenceClasses struct {
+	parent  map[string]string
+	columns map[string]ColumnRef
+}
+
+func NewEquivalenceClasses() *EquivalenceClasses {
+	return &EquivalenceClasses{
+		parent:  make(map[string]string),
+		columns: make(map[string]ColumnRef),
+	}
+}
+
+// ColumnEquality returns the two columns of a column = column conjunct.
+func ColumnEquality(expr *Expression) (ColumnRef, ColumnRef, bool) {
+	if expr == nil || expr.Kind != ExprBinaryOp || expr.BinaryOp != OpEq || !expr.Left.IsColumn() || !expr.Right.IsColumn() {
+		return ColumnRef{}, ColumnRef{}, false
+	}
+	return *expr.Left.Column, *expr.Right.Column, true
+}
+
+// Add merges the classes of the columns a column = column conjunct equates.
+// It reports whether the conjunct was such an equality.
+func (ec *EquivalenceClasses) Add(expr *Expression) bool {
+	left, right, ok := ColumnEquality(expr)
+	if !ok {
+		return false
+	}
+	ec.Union(left, right)
+	return true
+}
+
+func (ec *EquivalenceClasses) Union(a, b ColumnRef) {
+	rootA, rootB := ec.find(a), ec.find(b)
+	if rootA != rootB {
+		ec.parent[rootB] = rootA
+	}
+}
+
+// Equivalent reports whether two columns are known to be equal. A column is
+// only equivalent to itself once an equality has mentioned it, since
+// "x = x" does not hold when x is NULL.
+func (ec *EquivalenceClasses) Equivalent(a, b ColumnRef) bool {
+	keyA, keyB := columnKey(a), columnKey(b)
+	if _, ok := ec.parent[keyA]; !ok {
+		return false
+	}
+	if _, ok := ec.parent[keyB]; !ok {
+		return false
+	}
+	return ec.find(a) == ec.find(b)
+}
+
+// Implies reports whether a column = column conjunct follows from the
+// equalities added so far.
+func (ec *EquivalenceClasses) Implies(expr *Expression) bool {
+	left, right, ok := ColumnEquality(expr)
+	return ok && ec.Equivalent(left, right)
+}
+
+// Members lists the columns equal to ref, including ref itself, sorted by
+// name. It is empty when no equality mentioned ref.
+func (ec *EquivalenceClasses) Members(ref ColumnRef) []ColumnRef {
+	if _, ok := ec.parent[columnKey(ref)]; !ok {
+		return nil
+	}
+	root := ec.find(ref)
+	var members []ColumnRef
+	for key, column := range ec.columns {
+		if ec.root(key) == root {
+			members = append(members, column)
+		}
+	}
+	sortColumnRefs(members)
+	return members
+}
+
+// Classes lists every class, each sorted by column name, in a stable order.
+func (ec *EquivalenceClasses) Classes() [][]ColumnRef {
+	byRoot := make(map[string][]ColumnRef)
+	for key, column := range ec.columns {
+		root := ec.root(key)
+		byRoot[root] = append(byRoot[root], column)
+	}
+	classes := make([][]ColumnRef, 0, len(byRoot))
+	for _, members := range byRoot {
+		sortColumnRefs(members)
+		classes = append(classes, members)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return columnKey(classes[i][0]) < columnKey(classes[j][0])
+	})
+	return classes
+}
+
+func (ec *EquivalenceClasses) find(ref ColumnRef) string {
+	key := columnKey(ref)
+	if _, ok := ec.parent[key]; !ok {
+		ec.parent[key] = key
+		ec.columns[key] = ref
+	}
+	return ec.root(key)
+}
+
+func (ec *EquivalenceClasses) root(key string) string {
+	for ec.parent[key] != key {
+		ec.parent[key] = ec.parent[ec.parent[key]]
+		key = ec.parent[key]
+	}
+	return key
+}
+
+func columnKey(ref ColumnRef) string {
+	return strings.ToLower(ref.String())
+}
+
+func sortColumnRefs(columns []ColumnRef) {
+	sort.Slice(columns, func(i, j int) bool {
+		return columnKey(columns[i]) < columnKey(columns[j])
+	})
+}
@@ -0,0 +1,203 @@
+package logical_plan
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ToDOT renders the plan as a Graphviz digraph. Edges point from child to
+// parent, in the direction rows flow, and get thicker with the child's
+// estimated cardinality. A node shared by several parents, such as a spool, is
+// drawn once with an edge to each of them.
+func ToDOT(plan *LogicalPlan) string {
+	var sb strings.Builder
+	sb.WriteString("digraph plan {\n")
+	sb.WriteString("  rankdir=BT;\n")
+	sb.WriteString("  node [shape=box, style=rounded, fontname=\"Helvetica\"];\n")
+	sb.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+
+	ids := exportIDs(plan)
+	walkExport(plan, func(node *LogicalPlan) {
+		lines := exportLabelLines(node)
+		for i, line := range lines {
+			lines[i] = escapeDOT(line)
+		}
+		sb.WriteString(fmt.Sprintf("  %s [label=\"%s\"];\n", ids[node], strings.Join(lines, "\\n")))
+	})
+	walkExport(plan, func(node *LogicalPlan) {
+		for _, child := range node.Children {
+			attrs := fmt.Sprintf("penwidth=%.1f", edgeWeight(child))
+			if child.EstimatedRows != nil {
+				attrs += fmt.Sprintf(", label=\"%s rows\"", formatCount(*child.EstimatedRows))
+			}
+			sb.WriteString(fmt.Sprintf("  %s -> %s [%s];\n", ids[child], ids[node], attrs))
+		}
+	})
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// ToMermaid renders the plan as a Mermaid flowchart, with the same labels and
+// edge weights as ToDOT.
+func ToMermaid(plan *LogicalPlan) string {
+	var sb strings.Builder
+	sb.WriteString("flowchart BT\n")
+
+	ids := exportIDs(plan)
+	walkExport(plan, func(node *LogicalPlan) {
+		label := strings.Join(exportLabelLines(node), "<br/>")
+		sb.WriteString(fmt.Sprintf("  %s[\"%s\"]\n", ids[node], escapeMermaid(label)))
+	})
+
+	var linkStyles []string
+	edge := 0
+	walkExport(plan, func(node *LogicalPlan) {
+		for _, child := range node.Children {
+			if child.EstimatedRows != nil {
+				sb.WriteString(fmt.Sprintf("  %s -->|%s rows| %s\n", ids[child], formatCount(*child.EstimatedRows), ids[node]))
+			} else {
+				sb.WriteString(fmt.Sprintf("  %s --> %s\n", ids[child], ids[node]))
+			}
+			linkStyles = append(linkStyles, fmt.Sprintf("  linkStyle %d stroke-width:%.1fpx", edge, edgeWeight(child)))
+			edge++
+		}
+	})
+
+	for _, style := range linkStyles {
+		sb.WriteString(style + "\n")
+	}
+	return sb.String()
+}
+
+// walkExport calls fn on every node once, parents before their children.
+func walkExport(plan *LogicalPlan, fn func(*LogicalPlan)) {
+	visited := make(map[*LogicalPlan]bool)
+	var walk func(node *LogicalPlan)
+	walk = func(node *LogicalPlan) {
+		if node == nil || visited[node] {
+			return
+		}
+		visited[node] = true
+		fn(node)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(plan)
+}
+
+// PlanDAG lists every node of a plan once, with the IDs of its children, so
+// a node shared by several parents shows up as such.
+type PlanDAG struct {
+	Root  string    `json:"root"`
+	Nodes []DAGNode `json:"nodes"`
+}
+
+type DAGNode struct {
+	ID       string   `json:"id"`
+	NodeType NodeType `json:"node_type"`
+	Label    string   `json:"label"`
+	Children []string `json:"children,omitempty"`
+	// Parents counts the nodes reading this one.
+	Parents int `json:"parents"`
+}
+
+func ToDAG(plan *LogicalPlan) *PlanDAG {
+	if plan == nil {
+		return nil
+	}
+	dag := &PlanDAG{Root: plan.ID}
+	index := make(map[*LogicalPlan]int)
+	walkExport(plan, func(node *LogicalPlan) {
+		index[node] = len(dag.Nodes)
+		dag.Nodes = append(dag.Nodes, DAGNode{ID: node.ID, NodeType: node.NodeType, Label: node.Label()})
+	})
+	walkExport(plan, func(node *LogicalPlan) {
+		for _, child := range node.Children {
+			dag.Nodes[index[node]].Children = append(dag.Nodes[index[node]].Children, child.ID)
+			dag.Nodes[index[child]].Parents++
+		}
+	})
+	return dag
+}
+
+// exportIDs assigns short identifiers in traversal order, since node IDs
+// such as "node_12" are not stable across optimizer runs.
+func exportIDs(plan *LogicalPlan) map[*LogicalPlan]string {
+	ids := make(map[*LogicalPlan]string)
+	walkExport(plan, func(node *LogicalPlan) {
+		ids[node] = fmt.Sprintf("n%d", len(ids))
+	})
+	return ids
+}
+
+func exportLabelLines(node *LogicalPlan) []string {
+	header := strings.ToUpper(string(node.NodeType))
+	if op, ok := node.Metadata["physical_operator"]; ok {
+		header += fmt.Sprintf(" (%v)", op)
+	} else if scanType, ok := node.Metadata["scan_type"]; ok {
+		header += fmt.Sprintf(" (%v)", scanType)
+	}
+
+	lines := []string{header}
+	if detail := strings.TrimSpace(strings.TrimPrefix(node.Label(), string(node.NodeType))); detail != "" {
+		lines = append(lines, detail)
+	}
+	if index, ok := node.Metadata["index_name"]; ok {
+		lines = append(lines, fmt.Sprintf("index %v", index))
+	}
+	if len(node.ScanColumns) > 0 {
+		lines = append(lines, "reads "+strings.Join(node.ScanColumns, ", "))
+	}
+	if len(node.Partitions) > 0 {
+		lines = append(lines, "partitions "+strings.Join(node.Partitions, ", "))
+	}
+
+	var estimates []string
+	if node.EstimatedRows != nil {
+		estimates = append(estimates, "rows="+formatCount(*node.EstimatedRows))
+	}
+	if node.EstimatedCost != nil {
+		estimates = append(estimates, fmt.Sprintf("cost=%.2f", *node.EstimatedCost))
+	}
+	if len(estimates) > 0 {
+		lines = append(lines, strings.Join(estimates, " "))
+	}
+
+	return lines
+}
+
+func edgeWeight(child *LogicalPlan) float64 {
+	if child.EstimatedRows == nil {
+		return 1
+	}
+	return math.Min(8, 1+math.Log10(float64(*child.EstimatedRows)+1))
+}
+
+func formatCount(n int64) string {
+	switch {
+	case n >= 1000000000:
+		return fmt.Sprintf("%.1fB", float64(n)/1e9)
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+func escapeDOT(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+func escapeMermaid(s string) string {
+	s = strings.ReplaceAll(s, `"`, "#quot;")
+	s = strings.ReplaceAll(s, "<br/>", "\x00")
+	s = strings.ReplaceAll(s, "<", "#lt;")
+	s = strings.ReplaceAll(s, ">", "#gt;")
+	return strings.ReplaceAll(s, "\x00", "<br/>")
+}
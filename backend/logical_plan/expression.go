@@ -0,0 +1,648 @@
+package logical_plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExpressionVersion is written into every encoded expression. Version 1 is the
+// legacy {"type", "value"} shape, which is still accepted when decoding.
+const ExpressionVersion = 2
+
+type ExprKind string
+
+const (
+	ExprColumn    ExprKind = "column"
+	ExprLiteral   ExprKind = "literal"
+	ExprBinaryOp  ExprKind = "binary_op"
+	ExprUnaryOp   ExprKind = "unary_op"
+	ExprFunction  ExprKind = "function"
+	ExprCase      ExprKind = "case"
+	ExprCast      ExprKind = "cast"
+	ExprSubquery  ExprKind = "subquery"
+	ExprParameter ExprKind = "parameter"
+	ExprList      ExprKind = "list"
+)
+
+type BinaryOperator string
+
+const (
+	OpEq      BinaryOperator = "="
+	OpNotEq   BinaryOperator = "<>"
+	OpLt      BinaryOperator = "<"
+	OpLtEq    BinaryOperator = "<="
+	OpGt      BinaryOperator = ">"
+	OpGtEq    BinaryOperator = ">="
+	OpAnd     BinaryOperator = "AND"
+	OpOr      BinaryOperator = "OR"
+	OpAdd     BinaryOperator = "+"
+	OpSub     BinaryOperator = "-"
+	OpMul     BinaryOperator = "*"
+	OpDiv     BinaryOperator = "/"
+	OpMod     BinaryOperator = "%"
+	OpConcat  BinaryOperator = "||"
+	OpLike    BinaryOperator = "LIKE"
+	OpNotLike BinaryOperator = "NOT LIKE"
+	OpIn      BinaryOperator = "IN"
+	OpNotIn   BinaryOperator = "NOT IN"
+)
+
+var binaryOperators = map[string]BinaryOperator{
+	"=":        OpEq,
+	"==":       OpEq,
+	"<>":       OpNotEq,
+	"!=":       OpNotEq,
+	"<":        OpLt,
+	"<=":       OpLtEq,
+	">":        OpGt,
+	">=":       OpGtEq,
+	"AND":      OpAnd,
+	"OR":       OpOr,
+	"+":        OpAdd,
+	"-":        OpSub,
+	"*":        OpMul,
+	"/":        OpDiv,
+	"%":        OpMod,
+	"||":       OpConcat,
+	"LIKE":     OpLike,
+	"NOT LIKE": OpNotLike,
+	"IN":       OpIn,
+	"NOT IN":   OpNotIn,
+}
+
+func ParseBinaryOperator(op string) (BinaryOperator, error) {
+	if parsed, ok := binaryOperators[strings.ToUpper(strings.TrimSpace(op))]; ok {
+		return parsed, nil
+	}
+	return "", fmt.Errorf("unknown binary operator: %s", op)
+}
+
+func (op BinaryOperator) IsComparison() bool {
+	switch op {
+	case OpEq, OpNotEq, OpLt, OpLtEq, OpGt, OpGtEq:
+		return true
+	}
+	return false
+}
+
+type UnaryOperator string
+
+const (
+	OpNot       UnaryOperator = "NOT"
+	OpNeg       UnaryOperator = "-"
+	OpIsNull    UnaryOperator = "IS NULL"
+	OpIsNotNull UnaryOperator = "IS NOT NULL"
+	OpExists    UnaryOperator = "EXISTS"
+)
+
+var unaryOperators = map[string]UnaryOperator{
+	"NOT":         OpNot,
+	"-":           OpNeg,
+	"IS NULL":     OpIsNull,
+	"IS NOT NULL": OpIsNotNull,
+	"EXISTS":      OpExists,
+}
+
+func ParseUnaryOperator(op string) (UnaryOperator, error) {
+	if parsed, ok := unaryOperators[strings.ToUpper(strings.TrimSpace(op))]; ok {
+		return parsed, nil
+	}
+	return "", fmt.Errorf("unknown unary operator: %s", op)
+}
+
+type DataType string
+
+const (
+	DataTypeNull    DataType = "null"
+	DataTypeInt     DataType = "int"
+	DataTypeFloat   DataType = "float"
+	DataTypeString  DataType = "string"
+	DataTypeBoolean DataType = "boolean"
+	DataTypeDate    DataType = "date"
+)
+
+type ColumnRef struct {
+	Table string `json:"table,omitempty"`
+	Name  string `json:"name"`
+}
+
+func (c ColumnRef) String() string {
+	if c.Table == "" {
+		return c.Name
+	}
+	return c.Table + "." + c.Name
+}
+
+// Literal holds a constant with an explicit type. Value is nil, bool, int64,
+// float64 or string (dates are kept as their ISO string).
+type Literal struct {
+	Type  DataType
+	Value interface{}
+}
+
+type WhenClause struct {
+	When *Expression `json:"when"`
+	Then *Expression `json:"then"`
+}
+
+type Parameter struct {
+	Name  string `json:"name,omitempty"`
+	Index int    `json:"index,omitempty"`
+}
+
+type Expression struct {
+	Kind ExprKind `json:"kind"`
+
+	Column  *ColumnRef `json:"column,omitempty"`
+	Literal *Literal   `json:"literal,omitempty"`
+
+	BinaryOp BinaryOperator `json:"binary_op,omitempty"`
+	UnaryOp  UnaryOperator  `json:"unary_op,omitempty"`
+	Left     *Expression    `json:"left,omitempty"`
+	Right    *Expression    `json:"right,omitempty"`
+
+	// Operand is the input of unary operators and casts, and the optional
+	// subject of a simple CASE.
+	Operand *Expression `json:"operand,omitempty"`
+
+	Function string        `json:"function,omitempty"`
+	Args     []*Expression `json:"args,omitempty"`
+
+	WhenClauses []WhenClause `json:"when_clauses,omitempty"`
+	Else        *Expression  `json:"else,omitempty"`
+
+	CastType DataType `json:"cast_type,omitempty"`
+
+	Subquery  *LogicalPlan `json:"subquery,omitempty"`
+	Parameter *Parameter   `json:"parameter,omitempty"`
+}
+
+func NewColumnExpression(table, column string) *Expression {
+	if table == "" {
+		if idx := strings.LastIndex(column, "."); idx > 0 {
+			table, column = column[:idx], column[idx+1:]
+		}
+	}
+	return &Expression{
+		Kind:   ExprColumn,
+		Column: &ColumnRef{Table: table, Name: column},
+	}
+}
+
+func NewLiteralExpression(value interface{}) *Expression {
+	return &Expression{
+		Kind:    ExprLiteral,
+		Literal: NewLiteral(value),
+	}
+}
+
+func NewTypedLiteralExpression(dataType DataType, value interface{}) *Expression {
+	return &Expression{
+		Kind:    ExprLiteral,
+		Literal: &Literal{Type: dataType, Value: value},
+	}
+}
+
+func NewBinaryOpExpression(operator BinaryOperator, left, right *Expression) *Expression {
+	return &Expression{
+		Kind:     ExprBinaryOp,
+		BinaryOp: operator,
+		Left:     left,
+		Right:    right,
+	}
+}
+
+func NewUnaryOpExpression(operator UnaryOperator, operand *Expression) *Expression {
+	return &Expression{
+		Kind:    ExprUnaryOp,
+		UnaryOp: operator,
+		Operand: operand,
+	}
+}
+
+func NewFunctionExpression(funcName string, args []*Expression) *Expression {
+	return &Expression{
+		Kind:     ExprFunction,
+		Function: funcName,
+		Args:     args,
+	}
+}
+
+func NewCaseExpression(operand *Expression, whens []WhenClause, elseExpr *Expression) *Expression {
+	return &Expression{
+		Kind:        ExprCase,
+		Operand:     operand,
+		WhenClauses: whens,
+		Else:        elseExpr,
+	}
+}
+
+func NewCastExpression(operand *Expression, dataType DataType) *Expression {
+	return &Expression{
+		Kind:     ExprCast,
+		Operand:  operand,
+		CastType: dataType,
+	}
+}
+
+func NewSubqueryExpression(plan *LogicalPlan) *Expression {
+	return &Expression{
+		Kind:     ExprSubquery,
+		Subquery: plan,
+	}
+}
+
+func NewParameterExpression(name string, index int) *Expression {
+	return &Expression{
+		Kind:      ExprParameter,
+		Parameter: &Parameter{Name: name, Index: index},
+	}
+}
+
+func NewListExpression(items []*Expression) *Expression {
+	return &Expression{
+		Kind: ExprList,
+		Args: items,
+	}
+}
+
+// NewLiteral normalises Go values into one of the literal representations.
+func NewLiteral(value interface{}) *Literal {
+	switch v := value.(type) {
+	case nil:
+		return &Literal{Type: DataTypeNull}
+	case bool:
+		return &Literal{Type: DataTypeBoolean, Value: v}
+	case int:
+		return &Literal{Type: DataTypeInt, Value: int64(v)}
+	case int32:
+		return &Literal{Type: DataTypeInt, Value: int64(v)}
+	case int64:
+		return &Literal{Type: DataTypeInt, Value: v}
+	case float32:
+		return &Literal{Type: DataTypeFloat, Value: float64(v)}
+	case float64:
+		return &Literal{Type: DataTypeFloat, Value: v}
+	case string:
+		return &Literal{Type: DataTypeString, Value: v}
+	case json.Number:
+		return literalFromNumber(string(v))
+	default:
+		return &Literal{Type: DataTypeString, Value: fmt.Sprint(v)}
+	}
+}
+
+func literalFromNumber(text string) *Literal {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return &Literal{Type: DataTypeInt, Value: i}
+	}
+	f, _ := strconv.ParseFloat(text, 64)
+	return &Literal{Type: DataTypeFloat, Value: f}
+}
+
+func (l Literal) IsNull() bool {
+	return l.Type == DataTypeNull || l.Value == nil
+}
+
+func (l Literal) String() string {
+	if l.IsNull() {
+		return "NULL"
+	}
+	switch v := l.Value.(type) {
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".eEN") {
+			text += ".0"
+		}
+		return text
+	case string:
+		quoted := "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		if l.Type == DataTypeDate {
+			return "DATE " + quoted
+		}
+		return quoted
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (l Literal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type  DataType    `json:"type"`
+		Value interface{} `json:"value"`
+	}{l.Type, l.Value})
+}
+
+func (l *Literal) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type  DataType        `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	literal, err := decodeLiteralValue(raw.Type, raw.Value)
+	if err != nil {
+		return err
+	}
+	*l = *literal
+	return nil
+}
+
+func decodeLiteralValue(dataType DataType, raw json.RawMessage) (*Literal, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		if dataType == "" {
+			dataType = DataTypeNull
+		}
+		return &Literal{Type: dataType}, nil
+	}
+
+	switch dataType {
+	case "":
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		return NewLiteral(value), nil
+	case DataTypeInt:
+		var text string
+		if err := json.Unmarshal(trimmed, &text); err != nil {
+			text = string(trimmed)
+		}
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int literal %s", trimmed)
+		}
+		return &Literal{Type: DataTypeInt, Value: i}, nil
+	case DataTypeFloat:
+		var f float64
+		if err := json.Unmarshal(trimmed, &f); err != nil {
+			return nil, fmt.Errorf("invalid float literal %s", trimmed)
+		}
+		return &Literal{Type: DataTypeFloat, Value: f}, nil
+	case DataTypeBoolean:
+		var b bool
+		if err := json.Unmarshal(trimmed, &b); err != nil {
+			return nil, fmt.Errorf("invalid boolean literal %s", trimmed)
+		}
+		return &Literal{Type: DataTypeBoolean, Value: b}, nil
+	case DataTypeString, DataTypeDate:
+		var s string
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return nil, fmt.Errorf("invalid %s literal %s", dataType, trimmed)
+		}
+		return &Literal{Type: dataType, Value: s}, nil
+	case DataTypeNull:
+		return &Literal{Type: DataTypeNull}, nil
+	default:
+		return nil, fmt.Errorf("unknown literal type: %s", dataType)
+	}
+}
+
+type expressionJSON Expression
+
+func (e Expression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version int `json:"version"`
+		expressionJSON
+	}{ExpressionVersion, expressionJSON(e)})
+}
+
+func (e *Expression) UnmarshalJSON(data []byte) error {
+	var probe struct {
+		Version int      `json:"version"`
+		Kind    ExprKind `json:"kind"`
+		Type    string   `json:"type"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+
+	if probe.Kind == "" {
+		if probe.Type == "" {
+			return fmt.Errorf("expression has neither kind nor type")
+		}
+		return e.unmarshalLegacy(data)
+	}
+
+	if probe.Version > ExpressionVersion {
+		return fmt.Errorf("unsupported expression version %d", probe.Version)
+	}
+
+	var decoded expressionJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = Expression(decoded)
+	return nil
+}
+
+// legacyExpression is the version 1 shape, where the meaning of Value depends
+// on Type: a column name, a literal, an operator or a function name.
+type legacyExpression struct {
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value"`
+	Left     *Expression     `json:"left"`
+	Right    *Expression     `json:"right"`
+	Args     []*Expression   `json:"args"`
+	DataType string          `json:"data_type"`
+}
+
+func (e *Expression) unmarshalLegacy(data []byte) error {
+	var legacy legacyExpression
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	var name string
+	if legacy.Type != "literal" && len(legacy.Value) > 0 {
+		if err := json.Unmarshal(legacy.Value, &name); err != nil {
+			return fmt.Errorf("legacy %s expression must have a string value", legacy.Type)
+		}
+	}
+
+	switch legacy.Type {
+	case "column":
+		*e = *NewColumnExpression("", name)
+	case "literal":
+		literal, err := decodeLiteralValue(DataType(legacy.DataType), legacy.Value)
+		if err != nil {
+			return err
+		}
+		*e = Expression{Kind: ExprLiteral, Literal: literal}
+	case "binary_op":
+		op, err := ParseBinaryOperator(name)
+		if err != nil {
+			return err
+		}
+		*e = *NewBinaryOpExpression(op, legacy.Left, legacy.Right)
+	case "unary_op":
+		op, err := ParseUnaryOperator(name)
+		if err != nil {
+			return err
+		}
+		operand := legacy.Left
+		if operand == nil && len(legacy.Args) > 0 {
+			operand = legacy.Args[0]
+		}
+		*e = *NewUnaryOpExpression(op, operand)
+	case "function":
+		*e = *NewFunctionExpression(name, legacy.Args)
+	default:
+		return fmt.Errorf("unsupported legacy expression type: %s", legacy.Type)
+	}
+	return nil
+}
+
+// SplitConjuncts flattens a tree of ANDs into its conjuncts.
+func SplitConjuncts(expr *Expression) []*Expression {
+	if expr == nil {
+		return nil
+	}
+	if expr.Kind == ExprBinaryOp && expr.BinaryOp == OpAnd {
+		return append(SplitConjuncts(expr.Left), SplitConjuncts(expr.Right)...)
+	}
+	return []*Expression{expr}
+}
+
+// CombineConjuncts is the inverse of SplitConjuncts. It returns nil for an
+// empty list.
+func CombineConjuncts(conjuncts []*Expression) *Expression {
+	var result *Expression
+	for _, conjunct := range conjuncts {
+		if result == nil {
+			result = conjunct
+		} else {
+			result = NewBinaryOpExpression(OpAnd, result, conjunct)
+		}
+	}
+	return result
+}
+
+func (e *Expression) IsLiteral() bool {
+	return e != nil && e.Kind == ExprLiteral && e.Literal != nil
+}
+
+func (e *Expression) IsColumn() bool {
+	return e != nil && e.Kind == ExprColumn && e.Column != nil
+}
+
+func (e *Expression) String() string {
+	if e == nil {
+		return ""
+	}
+
+	switch e.Kind {
+	case ExprColumn:
+		if e.Column == nil {
+			return "?"
+		}
+		return e.Column.String()
+	case ExprLiteral:
+		if e.Literal == nil {
+			return "NULL"
+		}
+		return e.Literal.String()
+	case ExprBinaryOp:
+		prec := binaryPrecedence(e.BinaryOp)
+		return fmt.Sprintf("%s %s %s",
+			e.Left.stringWithPrecedence(prec, false),
+			e.BinaryOp,
+			e.Right.stringWithPrecedence(prec, true))
+	case ExprUnaryOp:
+		switch e.UnaryOp {
+		case OpIsNull, OpIsNotNull:
+			return fmt.Sprintf("%s %s", e.Operand.stringWithPrecedence(3, false), e.UnaryOp)
+		case OpNeg:
+			return "-" + e.Operand.stringWithPrecedence(6, false)
+		default:
+			return fmt.Sprintf("%s %s", e.UnaryOp, e.Operand.stringWithPrecedence(3, false))
+		}
+	case ExprFunction:
+		return fmt.Sprintf("%s(%s)", e.Function, joinExpressions(e.Args))
+	case ExprList:
+		return "(" + joinExpressions(e.Args) + ")"
+	case ExprCase:
+		var sb strings.Builder
+		sb.WriteString("CASE")
+		if e.Operand != nil {
+			sb.WriteString(" " + e.Operand.String())
+		}
+		for _, when := range e.WhenClauses {
+			sb.WriteString(fmt.Sprintf(" WHEN %s THEN %s", when.When, when.Then))
+		}
+		if e.Else != nil {
+			sb.WriteString(" ELSE " + e.Else.String())
+		}
+		sb.WriteString(" END")
+		return sb.String()
+	case ExprCast:
+		return fmt.Sprintf("CAST(%s AS %s)", e.Operand, strings.ToUpper(string(e.CastType)))
+	case ExprSubquery:
+		if e.Subquery == nil {
+			return "(subquery)"
+		}
+		return fmt.Sprintf("(subquery %s)", e.Subquery.ID)
+	case ExprParameter:
+		if e.Parameter == nil {
+			return "?"
+		}
+		if e.Parameter.Name != "" {
+			return ":" + e.Parameter.Name
+		}
+		return fmt.Sprintf("$%d", e.Parameter.Index)
+	default:
+		return string(e.Kind)
+	}
+}
+
+func (e *Expression) stringWithPrecedence(parent int, right bool) string {
+	if e == nil {
+		return "?"
+	}
+	if e.Kind != ExprBinaryOp {
+		return e.String()
+	}
+	prec := binaryPrecedence(e.BinaryOp)
+	if prec < parent || (right && prec == parent) {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+func binaryPrecedence(op BinaryOperator) int {
+	switch op {
+	case OpOr:
+		return 1
+	case OpAnd:
+		return 2
+	case OpAdd, OpSub, OpConcat:
+		return 4
+	case OpMul, OpDiv, OpMod:
+		return 5
+	default:
+		return 3
+	}
+}
+
+func joinExpressions(exprs []*Expression) string {
+	parts := make([]string, len(exprs))
+	for i, expr := range exprs {
+		parts[i] = expr.String()
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,331 @@
+package logical_plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type FingerprintOptions struct {
+	// KeepLiterals keeps literal values (and LIMIT/OFFSET counts). Without it
+	// every literal becomes "?" and the fingerprint identifies the query shape.
+	KeepLiterals bool
+	// IncludePhysical adds the physical choices recorded in Metadata.
+	IncludePhysical bool
+	// OrderedJoins keeps the input order of commutative joins.
+	OrderedJoins bool
+	// KeepAliases keeps every alias as written and the columns and
+	// partitions each scan reads, so two subplans only hash the same when
+	// either can stand in for the other.
+	KeepAliases bool
+}
+
+var physicalMetadataKeys = []string{"physical_operator", "scan_type", "index_name", "scan_direction", "build_side", "presorted"}
+
+// Fingerprint hashes the canonical form of a plan. Node IDs and estimates are
+// ignored, aliases are replaced by table names where that is unambiguous, and
+// commutative joins, conjuncts and commutative operators are put in a fixed
+// order, so logically identical plans hash the same.
+func Fingerprint(plan *LogicalPlan, opts FingerprintOptions) string {
+	sum := sha256.Sum256([]byte(CanonicalForm(plan, opts)))
+	return hex.EncodeToString(sum[:16])
+}
+
+func CanonicalForm(plan *LogicalPlan, opts FingerprintOptions) string {
+	if plan == nil {
+		return "nil"
+	}
+	c := &canonicalizer{opts: opts, aliases: map[string]string{}}
+	if !opts.KeepAliases {
+		c.aliases = canonicalAliases(plan)
+	}
+	return c.plan(plan)
+}
+
+type canonicalizer struct {
+	opts    FingerprintOptions
+	aliases map[string]string
+}
+
+// canonicalAliases maps each alias to its table name, unless the table is
+// scanned more than once (a self join), where the alias is what tells the
+// scans apart.
+func canonicalAliases(plan *LogicalPlan) map[string]string {
+	tableCount := make(map[string]int)
+	var scans []*LogicalPlan
+	var collect func(node *LogicalPlan)
+	collect = func(node *LogicalPlan) {
+		if node == nil {
+			return
+		}
+		if node.NodeType == NodeTypeScan {
+			tableCount[node.TableName]++
+			scans = append(scans, node)
+		}
+		for _, child := range node.Children {
+			collect(child)
+		}
+	}
+	collect(plan)
+
+	aliases := make(map[string]string)
+	for _, scan := range scans {
+		if scan.Alias != "" && tableCount[scan.TableName] == 1 {
+			aliases[scan.Alias] = scan.TableName
+		}
+	}
+	return aliases
+}
+
+func (c *canonicalizer) plan(plan *LogicalPlan) string {
+	var sb strings.Builder
+	sb.WriteString(string(plan.NodeType))
+
+	var attrs []string
+	add := func(name, value string) {
+		if value != "" {
+			attrs = append(attrs, name+"="+value)
+		}
+	}
+
+	switch plan.NodeType {
+	case NodeTypeScan:
+		add("table", plan.TableName)
+		if _, replaced := c.aliases[plan.Alias]; !replaced {
+			add("alias", plan.Alias)
+		}
+		if c.opts.KeepAliases {
+			add("reads", strings.Join(plan.ScanColumns, ","))
+			add("partitions", strings.Join(plan.Partitions, ","))
+		}
+	case NodeTypeSubquery:
+		if c.opts.KeepAliases {
+			add("alias", plan.Alias)
+		}
+	case NodeTypeJoin:
+		add("type", string(plan.JoinType))
+		if plan.JoinCondition != nil {
+			add("on", c.expression(&Expression{
+				Kind:     ExprBinaryOp,
+				BinaryOp: BinaryOperator(plan.JoinCondition.Operator),
+				Left:     plan.JoinCondition.Left,
+				Right:    plan.JoinCondition.Right,
+			}))
+		}
+	}
+
+	if plan.Predicate != nil {
+		add("predicate", c.predicate(plan.Predicate.Expression))
+	}
+
+	columns := make([]string, len(plan.Projections))
+	for i, column := range plan.Projections {
+		columns[i] = c.column(column)
+	}
+	add("columns", strings.Join(columns, ","))
+
+	groups := make([]string, len(plan.GroupBy))
+	for i, column := range plan.GroupBy {
+		groups[i] = c.column(column)
+	}
+	sort.Strings(groups)
+	add("group_by", strings.Join(groups, ","))
+
+	aggregates := make([]string, len(plan.Aggregates))
+	for i, agg := range plan.Aggregates {
+		arg := "*"
+		if agg.Column != nil {
+			arg = c.expression(agg.Column)
+		}
+		aggregates[i] = fmt.Sprintf("%s(%s)", agg.Type, arg)
+	}
+	add("aggregates", strings.Join(aggregates, ","))
+
+	keys := make([]string, len(plan.OrderBy))
+	for i, ob := range plan.OrderBy {
+		keys[i] = c.expression(ob.Expression)
+		if !ob.Ascending {
+			keys[i] += " desc"
+		}
+	}
+	add("order_by", strings.Join(keys, ","))
+
+	if plan.LimitCount != nil {
+		add("limit", c.count(*plan.LimitCount))
+	}
+	if plan.OffsetCount != nil {
+		add("offset", c.count(*plan.OffsetCount))
+	}
+	if plan.UnionAll {
+		add("all", "true")
+	}
+
+	if c.opts.IncludePhysical {
+		for _, key := range physicalMetadataKeys {
+			if value, ok := plan.Metadata[key]; ok {
+				add(key, fmt.Sprint(value))
+			}
+		}
+	}
+
+	if len(attrs) > 0 {
+		sb.WriteString("[" + strings.Join(attrs, ";") + "]")
+	}
+
+	children := make([]string, len(plan.Children))
+	for i, child := range plan.Children {
+		children[i] = c.plan(child)
+	}
+	if plan.NodeType == NodeTypeJoin && isCommutativeJoin(plan.JoinType) && !c.opts.OrderedJoins {
+		sort.Strings(children)
+	}
+	if len(children) > 0 {
+		sb.WriteString("(" + strings.Join(children, ",") + ")")
+	}
+
+	return sb.String()
+}
+
+func isCommutativeJoin(joinType JoinType) bool {
+	switch joinType {
+	case JoinTypeInner, JoinTypeCross, JoinTypeFull, "":
+		return true
+	}
+	return false
+}
+
+func (c *canonicalizer) count(n int64) string {
+	if c.opts.KeepLiterals {
+		return fmt.Sprint(n)
+	}
+	return "?"
+}
+
+func (c *canonicalizer) column(column Column) string {
+	name := c.columnRef(ColumnRef{Table: column.Table, Name: column.Name})
+	if column.Expression != nil {
+		name = c.expression(column.Expression)
+	}
+	if column.Alias != "" {
+		name += " as " + column.Alias
+	}
+	return name
+}
+
+func (c *canonicalizer) columnRef(ref ColumnRef) string {
+	if table, ok := c.aliases[ref.Table]; ok {
+		ref.Table = table
+	}
+	return strings.ToLower(ref.String())
+}
+
+// predicate sorts top-level conjuncts so that "a AND b" and "b AND a" agree.
+func (c *canonicalizer) predicate(expr *Expression) string {
+	conjuncts := SplitConjuncts(expr)
+	parts := make([]string, len(conjuncts))
+	for i, conjunct := range conjuncts {
+		parts[i] = c.expression(conjunct)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " and ")
+}
+
+var flippedComparisons = map[BinaryOperator]BinaryOperator{
+	OpEq:    OpEq,
+	OpNotEq: OpNotEq,
+	OpLt:    OpGt,
+	OpGt:    OpLt,
+	OpLtEq:  OpGtEq,
+	OpGtEq:  OpLtEq,
+}
+
+func (c *canonicalizer) expression(expr *Expression) string {
+	if expr == nil {
+		return ""
+	}
+
+	switch expr.Kind {
+	case ExprColumn:
+		if expr.Column == nil {
+			return "?"
+		}
+		return c.columnRef(*expr.Column)
+	case ExprLiteral:
+		if !c.opts.KeepLiterals {
+			return "?"
+		}
+		if expr.Literal == nil {
+			return "null"
+		}
+		return expr.Literal.String()
+	case ExprBinaryOp:
+		if expr.BinaryOp == OpAnd {
+			return "(" + c.predicate(expr) + ")"
+		}
+		left := c.expression(expr.Left)
+		right := c.expression(expr.Right)
+		op := expr.BinaryOp
+		switch {
+		case op == OpOr || op == OpAdd || op == OpMul:
+			if left > right {
+				left, right = right, left
+			}
+		case op.IsComparison():
+			if left > right {
+				left, right = right, left
+				op = flippedComparisons[op]
+			}
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToLower(string(op)), right)
+	case ExprUnaryOp:
+		return fmt.Sprintf("%s(%s)", strings.ToLower(string(expr.UnaryOp)), c.expression(expr.Operand))
+	case ExprFunction:
+		return fmt.Sprintf("%s(%s)", strings.ToLower(expr.Function), c.expressions(expr.Args))
+	case ExprList:
+		items := make([]string, len(expr.Args))
+		allLiterals := true
+		for i, item := range expr.Args {
+			items[i] = c.expression(item)
+			allLiterals = allLiterals && item.IsLiteral()
+		}
+		if allLiterals && !c.opts.KeepLiterals {
+			return "(?...)"
+		}
+		sort.Strings(items)
+		return "(" + strings.Join(items, ",") + ")"
+	case ExprCase:
+		var sb strings.Builder
+		sb.WriteString("case")
+		if expr.Operand != nil {
+			sb.WriteString(" " + c.expression(expr.Operand))
+		}
+		for _, when := range expr.WhenClauses {
+			sb.WriteString(" when " + c.expression(when.When) + " then " + c.expression(when.Then))
+		}
+		if expr.Else != nil {
+			sb.WriteString(" else " + c.expression(expr.Else))
+		}
+		return sb.String() + " end"
+	case ExprCast:
+		return fmt.Sprintf("cast(%s as %s)", c.expression(expr.Operand), expr.CastType)
+	case ExprSubquery:
+		if expr.Subquery == nil {
+			return "subquery()"
+		}
+		return "subquery(" + CanonicalForm(expr.Subquery, c.opts) + ")"
+	case ExprParameter:
+		return "?"
+	default:
+		return string(expr.Kind)
+	}
+}
+
+func (c *canonicalizer) expressions(exprs []*Expression) string {
+	parts := make([]string, len(exprs))
+	for i, expr := range exprs {
+		parts[i] = c.expression(expr)
+	}
+	return strings.Join(parts, ",")
+}
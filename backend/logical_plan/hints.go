@@ -0,0 +1,71 @@
+package logical_plan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Hint is an optimizer hint from a /*+ ... */ comment, such as
+// LEADING(a b c) or HASH_JOIN(a b): its name and the relations it applies
+// to. Index hints name the relation first and then, optionally, indexes.
+type Hint struct {
+	Name      string   `json:"name"`
+	Arguments []string `json:"arguments,omitempty"`
+}
+
+const (
+	// HintLeading joins its relations first, left-deep in the given order.
+	HintLeading = "LEADING"
+	// HintHashJoin, HintNestedLoopJoin and HintMergeJoin pick the operator
+	// of the join of exactly their relations.
+	HintHashJoin       = "HASH_JOIN"
+	HintNestedLoopJoin = "NL_JOIN"
+	HintMergeJoin      = "MERGE_JOIN"
+	// HintSeqScan reads a relation sequentially, HintIndexScan through one
+	// of the named indexes or any index, and HintNoIndex through none of the
+	// named indexes or no index at all.
+	HintSeqScan   = "SEQSCAN"
+	HintIndexScan = "INDEXSCAN"
+	HintNoIndex   = "NO_INDEX"
+)
+
+// hintAliases maps the pg_hint_plan and Oracle spellings of the hints to
+// their names here.
+var hintAliases = map[string]string{
+	"HASHJOIN":    HintHashJoin,
+	"USE_HASH":    HintHashJoin,
+	"NESTLOOP":    HintNestedLoopJoin,
+	"USE_NL":      HintNestedLoopJoin,
+	"MERGEJOIN":   HintMergeJoin,
+	"USE_MERGE":   HintMergeJoin,
+	"FULL":        HintSeqScan,
+	"INDEX":       HintIndexScan,
+	"NOINDEXSCAN": HintNoIndex,
+}
+
+// CanonicalHintName upper-cases a hint name and replaces aliases. Unknown
+// names are returned upper-cased.
+func CanonicalHintName(name string) string {
+	name = strings.ToUpper(name)
+	if canonical, ok := hintAliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// IsJoinMethodHint reports whether a hint picks a join operator.
+func (h Hint) IsJoinMethodHint() bool {
+	return h.Name == HintHashJoin || h.Name == HintNestedLoopJoin || h.Name == HintMergeJoin
+}
+
+// IsScanHint reports whether a hint picks how a relation is read.
+func (h Hint) IsScanHint() bool {
+	return h.Name == HintSeqScan || h.Name == HintIndexScan || h.Name == HintNoIndex
+}
+
+func (h Hint) String() string {
+	if len(h.Arguments) == 0 {
+		return h.Name
+	}
+	return fmt.Sprintf("%s(%s)", h.Name, strings.Join(h.Arguments, " "))
+}
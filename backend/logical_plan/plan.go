@@ -2,6 +2,7 @@ package logical_plan
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -17,6 +18,9 @@ const (
 	NodeTypeLimit     NodeType = "limit"
 	NodeTypeUnion     NodeType = "union"
 	NodeTypeSubquery  NodeType = "subquery"
+	NodeTypeEmpty     NodeType = "empty"
+	NodeTypeTopN      NodeType = "top_n"
+	NodeTypeSpool     NodeType = "spool"
 )
 
 type JoinType string
@@ -27,8 +31,18 @@ const (
 	JoinTypeRight JoinType = "right"
 	JoinTypeFull  JoinType = "full"
 	JoinTypeCross JoinType = "cross"
+	// JoinTypeSemi keeps the left rows that have a match on the right, once
+	// each; JoinTypeAnti keeps those that have none. Both only output the
+	// left input's columns.
+	JoinTypeSemi JoinType = "semi"
+	JoinTypeAnti JoinType = "anti"
 )
 
+// LeftOnly reports whether a join outputs only its left input's columns.
+func (jt JoinType) LeftOnly() bool {
+	return jt == JoinTypeSemi || jt == JoinTypeAnti
+}
+
 type AggregateType string
 
 const (
@@ -39,19 +53,13 @@ const (
 	AggregateMax   AggregateType = "max"
 )
 
-type Expression struct {
-	Type     string       `json:"type"`
-	Value    interface{}  `json:"value"`
-	Left     *Expression  `json:"left,omitempty"`
-	Right    *Expression  `json:"right,omitempty"`
-	Args     []Expression `json:"args,omitempty"`
-	DataType string       `json:"data_type,omitempty"`
-}
-
+// Column is a column reference, or a computed projection when Expression is
+// set. A computed column is referenced by its Alias (or Name).
 type Column struct {
-	Table string `json:"table,omitempty"`
-	Name  string `json:"name"`
-	Alias string `json:"alias,omitempty"`
+	Table      string      `json:"table,omitempty"`
+	Name       string      `json:"name"`
+	Alias      string      `json:"alias,omitempty"`
+	Expression *Expression `json:"expression,omitempty"`
 }
 
 type Predicate struct {
@@ -81,7 +89,12 @@ type LogicalPlan struct {
 	Children []*LogicalPlan `json:"children,omitempty"`
 
 	TableName string `json:"table_name,omitempty"`
-	Alias     string `json:"alias,omitempty"`
+	// ScanColumns, when set on a scan, lists the only columns it has to read.
+	ScanColumns []string `json:"scan_columns,omitempty"`
+	// Partitions, when set on a scan of a partitioned table, lists the only
+	// partitions it has to read.
+	Partitions []string `json:"partitions,omitempty"`
+	Alias      string   `json:"alias,omitempty"`
 
 	Predicate *Predicate `json:"predicate,omitempty"`
 
@@ -98,10 +111,19 @@ type LogicalPlan struct {
 	LimitCount  *int64 `json:"limit_count,omitempty"`
 	OffsetCount *int64 `json:"offset_count,omitempty"`
 
+	UnionAll bool `json:"union_all,omitempty"`
+
+	// Spool names the result a spool node materializes. Every parent of the
+	// node reads that one result, so the plan is a DAG rather than a tree.
+	Spool string `json:"spool,omitempty"`
+
 	EstimatedRows *int64   `json:"estimated_rows,omitempty"`
 	EstimatedCost *float64 `json:"estimated_cost,omitempty"`
 
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	// Hints, on the root of a plan, are the optimizer hints of its query.
+	Hints []Hint `json:"hints,omitempty"`
 }
 
 func NewScanNode(tableName, alias string) *LogicalPlan {
@@ -145,6 +167,18 @@ func NewJoinNode(left, right *LogicalPlan, joinType JoinType, condition *JoinCon
 	}
 }
 
+// NewSubqueryNode names the output of child, so its columns are referenced
+// as alias.column.
+func NewSubqueryNode(child *LogicalPlan, alias string) *LogicalPlan {
+	return &LogicalPlan{
+		ID:       generateID(),
+		NodeType: NodeTypeSubquery,
+		Children: []*LogicalPlan{child},
+		Alias:    alias,
+		Metadata: make(map[string]interface{}),
+	}
+}
+
 func NewAggregateNode(child *LogicalPlan, groupBy []Column, aggregates []AggregateFunction) *LogicalPlan {
 	return &LogicalPlan{
 		ID:         generateID(),
@@ -177,14 +211,73 @@ func NewLimitNode(child *LogicalPlan, limit *int64, offset *int64) *LogicalPlan
 	}
 }
 
+// NewTopNNode returns the first limit rows after offset in orderBy order,
+// which can be computed with a bounded heap instead of a full sort.
+func NewTopNNode(child *LogicalPlan, orderBy []OrderBy, limit *int64, offset *int64) *LogicalPlan {
+	return &LogicalPlan{
+		ID:          generateID(),
+		NodeType:    NodeTypeTopN,
+		Children:    []*LogicalPlan{child},
+		OrderBy:     orderBy,
+		LimitCount:  limit,
+		OffsetCount: offset,
+		Metadata:    make(map[string]interface{}),
+	}
+}
+
+func NewUnionNode(children []*LogicalPlan, all bool) *LogicalPlan {
+	return &LogicalPlan{
+		ID:       generateID(),
+		NodeType: NodeTypeUnion,
+		Children: children,
+		UnionAll: all,
+		Metadata: make(map[string]interface{}),
+	}
+}
+
+// NewEmptyNode is a relation known to produce no rows, such as a filter whose
+// predicate can never hold. Columns keeps the output schema of the subtree it
+// replaced.
+func NewEmptyNode(columns []Column) *LogicalPlan {
+	return &LogicalPlan{
+		ID:          generateID(),
+		NodeType:    NodeTypeEmpty,
+		Projections: columns,
+		Metadata:    make(map[string]interface{}),
+	}
+}
+
+// NewSpoolNode materializes the result of child once, under name, for every
+// parent the spool node is given to.
+func NewSpoolNode(child *LogicalPlan, name string) *LogicalPlan {
+	return &LogicalPlan{
+		ID:       generateID(),
+		NodeType: NodeTypeSpool,
+		Children: []*LogicalPlan{child},
+		Spool:    name,
+		Metadata: make(map[string]interface{}),
+	}
+}
+
+// Clone copies the plan deeply. A node shared by several parents, such as a
+// spool, is copied once and stays shared in the copy.
 func (lp *LogicalPlan) Clone() *LogicalPlan {
+	return lp.clone(make(map[*LogicalPlan]*LogicalPlan))
+}
+
+func (lp *LogicalPlan) clone(copies map[*LogicalPlan]*LogicalPlan) *LogicalPlan {
+	if clone, ok := copies[lp]; ok {
+		return clone
+	}
 	clone := &LogicalPlan{
 		ID:       generateID(),
 		NodeType: lp.NodeType,
 
-		TableName: lp.TableName,
-		Alias:     lp.Alias,
-		JoinType:  lp.JoinType,
+		TableName:   lp.TableName,
+		ScanColumns: append([]string(nil), lp.ScanColumns...),
+		Partitions:  append([]string(nil), lp.Partitions...),
+		Alias:       lp.Alias,
+		JoinType:    lp.JoinType,
 
 		Projections: make([]Column, len(lp.Projections)),
 		GroupBy:     make([]Column, len(lp.GroupBy)),
@@ -193,6 +286,8 @@ func (lp *LogicalPlan) Clone() *LogicalPlan {
 
 		LimitCount:    lp.LimitCount,
 		OffsetCount:   lp.OffsetCount,
+		UnionAll:      lp.UnionAll,
+		Spool:         lp.Spool,
 		EstimatedRows: lp.EstimatedRows,
 		EstimatedCost: lp.EstimatedCost,
 
@@ -201,11 +296,25 @@ func (lp *LogicalPlan) Clone() *LogicalPlan {
 
 		Metadata: make(map[string]interface{}),
 	}
+	copies[lp] = clone
 
-	copy(clone.Projections, lp.Projections)
+	for _, hint := range lp.Hints {
+		hint.Arguments = append([]string(nil), hint.Arguments...)
+		clone.Hints = append(clone.Hints, hint)
+	}
+	for i, column := range lp.Projections {
+		column.Expression = cloneExpression(column.Expression)
+		clone.Projections[i] = column
+	}
 	copy(clone.GroupBy, lp.GroupBy)
-	copy(clone.Aggregates, lp.Aggregates)
-	copy(clone.OrderBy, lp.OrderBy)
+	for i, agg := range lp.Aggregates {
+		agg.Column = cloneExpression(agg.Column)
+		clone.Aggregates[i] = agg
+	}
+	for i, ob := range lp.OrderBy {
+		ob.Expression = cloneExpression(ob.Expression)
+		clone.OrderBy[i] = ob
+	}
 
 	for k, v := range lp.Metadata {
 		clone.Metadata[k] = v
@@ -213,12 +322,163 @@ func (lp *LogicalPlan) Clone() *LogicalPlan {
 
 	clone.Children = make([]*LogicalPlan, len(lp.Children))
 	for i, child := range lp.Children {
-		clone.Children[i] = child.Clone()
+		clone.Children[i] = child.clone(copies)
 	}
 
 	return clone
 }
 
+func (c Column) String() string {
+	name := c.Name
+	if c.Expression != nil {
+		name = c.Expression.String()
+		if c.Alias == "" && c.Name != "" {
+			name += " AS " + c.Name
+		}
+	} else if c.Table != "" {
+		name = c.Table + "." + c.Name
+	}
+	if c.Alias != "" {
+		name += " AS " + c.Alias
+	}
+	return name
+}
+
+func (jc *JoinCondition) String() string {
+	if jc == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %s %s", jc.Left, jc.Operator, jc.Right)
+}
+
+func (af AggregateFunction) String() string {
+	arg := "*"
+	if af.Column != nil {
+		arg = af.Column.String()
+	}
+	result := fmt.Sprintf("%s(%s)", strings.ToUpper(string(af.Type)), arg)
+	if af.Alias != "" {
+		result += " AS " + af.Alias
+	}
+	return result
+}
+
+func (ob OrderBy) String() string {
+	if ob.Ascending {
+		return ob.Expression.String()
+	}
+	return ob.Expression.String() + " DESC"
+}
+
+// RelationName is the name a scan is referenced by: its alias, or the table
+// name when there is no alias.
+func (lp *LogicalPlan) RelationName() string {
+	if lp.Alias != "" {
+		return lp.Alias
+	}
+	return lp.TableName
+}
+
+// Relations returns the sorted relation names of every scan below lp.
+func (lp *LogicalPlan) Relations() []string {
+	var relations []string
+	var collect func(node *LogicalPlan)
+	collect = func(node *LogicalPlan) {
+		if node == nil {
+			return
+		}
+		if node.NodeType == NodeTypeScan {
+			relations = append(relations, node.RelationName())
+		}
+		for _, child := range node.Children {
+			collect(child)
+		}
+	}
+	collect(lp)
+	sort.Strings(relations)
+	return relations
+}
+
+// Label is a one-line, human readable description of the node itself,
+// without its children or estimates.
+func (lp *LogicalPlan) Label() string {
+	switch lp.NodeType {
+	case NodeTypeScan:
+		if lp.Alias != "" && lp.Alias != lp.TableName {
+			return fmt.Sprintf("scan %s AS %s", lp.TableName, lp.Alias)
+		}
+		return "scan " + lp.TableName
+	case NodeTypeFilter:
+		if lp.Predicate != nil {
+			return "filter " + lp.Predicate.Expression.String()
+		}
+		return "filter"
+	case NodeTypeProject:
+		columns := make([]string, len(lp.Projections))
+		for i, column := range lp.Projections {
+			columns[i] = column.String()
+		}
+		return "project " + strings.Join(columns, ", ")
+	case NodeTypeJoin:
+		label := fmt.Sprintf("%s join", lp.JoinType)
+		if lp.JoinCondition != nil {
+			label += " ON " + lp.JoinCondition.String()
+		}
+		return label
+	case NodeTypeAggregate:
+		parts := make([]string, 0, len(lp.Aggregates))
+		for _, agg := range lp.Aggregates {
+			parts = append(parts, agg.String())
+		}
+		label := "aggregate " + strings.Join(parts, ", ")
+		if len(lp.GroupBy) > 0 {
+			groups := make([]string, len(lp.GroupBy))
+			for i, column := range lp.GroupBy {
+				groups[i] = column.String()
+			}
+			label += " GROUP BY " + strings.Join(groups, ", ")
+		}
+		return label
+	case NodeTypeSort:
+		keys := make([]string, len(lp.OrderBy))
+		for i, ob := range lp.OrderBy {
+			keys[i] = ob.String()
+		}
+		return "sort " + strings.Join(keys, ", ")
+	case NodeTypeLimit:
+		label := "limit"
+		if lp.LimitCount != nil {
+			label += fmt.Sprintf(" %d", *lp.LimitCount)
+		}
+		if lp.OffsetCount != nil {
+			label += fmt.Sprintf(" OFFSET %d", *lp.OffsetCount)
+		}
+		return label
+	case NodeTypeTopN:
+		keys := make([]string, len(lp.OrderBy))
+		for i, ob := range lp.OrderBy {
+			keys[i] = ob.String()
+		}
+		label := "top_n"
+		if lp.LimitCount != nil {
+			label += fmt.Sprintf(" %d", *lp.LimitCount)
+		}
+		if lp.OffsetCount != nil {
+			label += fmt.Sprintf(" OFFSET %d", *lp.OffsetCount)
+		}
+		return label + " ORDER BY " + strings.Join(keys, ", ")
+	case NodeTypeUnion:
+		if lp.UnionAll {
+			return "union all"
+		}
+		return "union"
+	case NodeTypeSpool:
+		return "spool " + lp.Spool
+	default:
+		return string(lp.NodeType)
+	}
+}
+
 func (lp *LogicalPlan) String() string {
 	return lp.toStringWithIndent(0)
 }
@@ -254,6 +514,12 @@ func (lp *LogicalPlan) toStringWithIndent(indent int) string {
 			}
 			result.WriteString("]")
 		}
+	case NodeTypeTopN:
+		if lp.LimitCount != nil {
+			result.WriteString(fmt.Sprintf(" [limit=%d, orderBy=%d]", *lp.LimitCount, len(lp.OrderBy)))
+		}
+	case NodeTypeSpool:
+		result.WriteString(fmt.Sprintf(" [spool=%s]", lp.Spool))
 	}
 
 	if lp.EstimatedRows != nil || lp.EstimatedCost != nil {
@@ -347,23 +613,58 @@ func cloneJoinCondition(jc *JoinCondition) *JoinCondition {
 	}
 }
 
+// Clone returns a deep copy of the expression, including any subquery plan.
+func (e *Expression) Clone() *Expression {
+	return cloneExpression(e)
+}
+
 func cloneExpression(e *Expression) *Expression {
 	if e == nil {
 		return nil
 	}
 
 	clone := &Expression{
-		Type:     e.Type,
-		Value:    e.Value,
-		DataType: e.DataType,
+		Kind:     e.Kind,
+		BinaryOp: e.BinaryOp,
+		UnaryOp:  e.UnaryOp,
+		Function: e.Function,
+		CastType: e.CastType,
 		Left:     cloneExpression(e.Left),
 		Right:    cloneExpression(e.Right),
+		Operand:  cloneExpression(e.Operand),
+		Else:     cloneExpression(e.Else),
+	}
+
+	if e.Column != nil {
+		column := *e.Column
+		clone.Column = &column
+	}
+	if e.Literal != nil {
+		literal := *e.Literal
+		clone.Literal = &literal
+	}
+	if e.Parameter != nil {
+		parameter := *e.Parameter
+		clone.Parameter = &parameter
+	}
+	if e.Subquery != nil {
+		clone.Subquery = e.Subquery.Clone()
 	}
 
 	if e.Args != nil {
-		clone.Args = make([]Expression, len(e.Args))
+		clone.Args = make([]*Expression, len(e.Args))
 		for i, arg := range e.Args {
-			clone.Args[i] = *cloneExpression(&arg)
+			clone.Args[i] = cloneExpression(arg)
+		}
+	}
+
+	if e.WhenClauses != nil {
+		clone.WhenClauses = make([]WhenClause, len(e.WhenClauses))
+		for i, when := range e.WhenClauses {
+			clone.WhenClauses[i] = WhenClause{
+				When: cloneExpression(when.When),
+				Then: cloneExpression(when.Then),
+			}
 		}
 	}
 
@@ -376,38 +677,3 @@ func generateID() string {
 	idCounter++
 	return fmt.Sprintf("node_%d", idCounter)
 }
-
-func NewColumnExpression(table, column string) *Expression {
-	value := column
-	if table != "" {
-		value = table + "." + column
-	}
-	return &Expression{
-		Type:  "column",
-		Value: value,
-	}
-}
-
-func NewLiteralExpression(value interface{}) *Expression {
-	return &Expression{
-		Type:  "literal",
-		Value: value,
-	}
-}
-
-func NewBinaryOpExpression(operator string, left, right *Expression) *Expression {
-	return &Expression{
-		Type:  "binary_op",
-		Value: operator,
-		Left:  left,
-		Right: right,
-	}
-}
-
-func NewFunctionExpression(funcName string, args []Expression) *Expression {
-	return &Expression{
-		Type:  "function",
-		Value: funcName,
-		Args:  args,
-	}
-}
@@ -0,0 +1,126 @@
+package logical_plan
+
+import "strings"
+
+// OrderKey is one column of a sort order.
+type OrderKey struct {
+	Column     ColumnRef `json:"column"`
+	Descending bool      `json:"descending,omitempty"`
+}
+
+// Ordering is the order rows are required or known to be in. An empty
+// ordering requires nothing.
+type Ordering []OrderKey
+
+// OrderingOf converts ORDER BY keys that are all columns.
+func OrderingOf(orderBy []OrderBy) (Ordering, bool) {
+	ordering := make(Ordering, len(orderBy))
+	for i, key := range orderBy {
+		if !key.Expression.IsColumn() {
+			return nil, false
+		}
+		ordering[i] = OrderKey{Column: *key.Expression.Column, Descending: !key.Ascending}
+	}
+	return ordering, len(ordering) > 0
+}
+
+// OrderBy converts the ordering back to ORDER BY keys.
+func (o Ordering) OrderBy() []OrderBy {
+	orderBy := make([]OrderBy, len(o))
+	for i, key := range o {
+		orderBy[i] = OrderBy{Expression: NewColumnExpression(key.Column.Table, key.Column.Name), Ascending: !key.Descending}
+	}
+	return orderBy
+}
+
+func (o Ordering) String() string {
+	if len(o) == 0 {
+		return "any"
+	}
+	keys := make([]string, len(o))
+	for i, key := range o {
+		keys[i] = key.Column.String()
+		if key.Descending {
+			keys[i] += " DESC"
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// SatisfiedBy reports whether rows in the provided order are also in this
+// order, which holds when this order is a prefix of it.
+func (o Ordering) SatisfiedBy(provided Ordering) bool {
+	if len(o) > len(provided) {
+		return false
+	}
+	for i, key := range o {
+		if key.Descending != provided[i].Descending || !strings.EqualFold(key.Column.String(), provided[i].Column.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// PhysicalProperties describe how an operator's output rows are arranged:
+// the order they come in, the columns they are hash partitioned on, and the
+// sets of columns no two rows share values for.
+type PhysicalProperties struct {
+	Ordering     Ordering
+	Partitioning []ColumnRef
+	UniqueKeys   [][]ColumnRef
+}
+
+// IsUnique reports whether the rows are unique on columns, because they
+// include a unique key.
+func (p PhysicalProperties) IsUnique(columns []ColumnRef) bool {
+	for _, key := range p.UniqueKeys {
+		if containsColumns(columns, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Metadata describes the properties for a node's metadata, leaving out
+// those that do not hold.
+func (p PhysicalProperties) Metadata() map[string]interface{} {
+	metadata := make(map[string]interface{})
+	if len(p.Ordering) > 0 {
+		metadata["ordering"] = p.Ordering.String()
+	}
+	if len(p.Partitioning) > 0 {
+		metadata["partitioning"] = columnList(p.Partitioning)
+	}
+	if len(p.UniqueKeys) > 0 {
+		keys := make([]string, len(p.UniqueKeys))
+		for i, key := range p.UniqueKeys {
+			keys[i] = columnList(key)
+		}
+		metadata["unique_keys"] = keys
+	}
+	return metadata
+}
+
+func containsColumns(columns, subset []ColumnRef) bool {
+	for _, wanted := range subset {
+		found := false
+		for _, column := range columns {
+			if strings.EqualFold(column.String(), wanted.String()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(subset) > 0
+}
+
+func columnList(columns []ColumnRef) string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,167 @@
+package logical_plan
+
+// PlanTransformer rewrites a single node. It returns the node that should take
+// its place (the node itself when nothing applies) and whether it changed
+// anything. Unlike PlanVisitor, transformers are free to replace nodes.
+//
+// Transforms work in place: children are replaced in their parent's Children
+// slice, so callers that need the original should Clone it first.
+type PlanTransformer func(*LogicalPlan) (*LogicalPlan, bool, error)
+
+// ExpressionTransformer rewrites a single expression node, the same way
+// PlanTransformer rewrites a plan node.
+type ExpressionTransformer func(*Expression) (*Expression, bool, error)
+
+// TransformDown applies fn pre-order: a node is rewritten before its children,
+// and the traversal continues into the children of the node fn returned.
+func TransformDown(plan *LogicalPlan, fn PlanTransformer) (*LogicalPlan, bool, error) {
+	if plan == nil {
+		return nil, false, nil
+	}
+
+	result, changed, err := fn(plan)
+	if err != nil {
+		return nil, false, err
+	}
+
+	childrenChanged, err := transformChildren(result, func(child *LogicalPlan) (*LogicalPlan, bool, error) {
+		return TransformDown(child, fn)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return result, changed || childrenChanged, nil
+}
+
+// TransformUp applies fn post-order: children are rewritten first, so fn sees
+// a node whose inputs are already in their final shape.
+func TransformUp(plan *LogicalPlan, fn PlanTransformer) (*LogicalPlan, bool, error) {
+	if plan == nil {
+		return nil, false, nil
+	}
+
+	childrenChanged, err := transformChildren(plan, func(child *LogicalPlan) (*LogicalPlan, bool, error) {
+		return TransformUp(child, fn)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	result, changed, err := fn(plan)
+	if err != nil {
+		return nil, false, err
+	}
+	return result, changed || childrenChanged, nil
+}
+
+func transformChildren(plan *LogicalPlan, transform PlanTransformer) (bool, error) {
+	if plan == nil {
+		return false, nil
+	}
+
+	changed := false
+	for i, child := range plan.Children {
+		newChild, childChanged, err := transform(child)
+		if err != nil {
+			return false, err
+		}
+		if childChanged {
+			plan.Children[i] = newChild
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// TransformExpression applies fn post-order to every node of an expression
+// tree. Subquery plans are not entered; transform them separately.
+func TransformExpression(expr *Expression, fn ExpressionTransformer) (*Expression, bool, error) {
+	if expr == nil {
+		return nil, false, nil
+	}
+
+	changed := false
+	rewrite := func(child **Expression) error {
+		result, childChanged, err := TransformExpression(*child, fn)
+		if err != nil {
+			return err
+		}
+		if childChanged {
+			*child = result
+			changed = true
+		}
+		return nil
+	}
+
+	for _, child := range []**Expression{&expr.Left, &expr.Right, &expr.Operand, &expr.Else} {
+		if err := rewrite(child); err != nil {
+			return nil, false, err
+		}
+	}
+	for i := range expr.Args {
+		if err := rewrite(&expr.Args[i]); err != nil {
+			return nil, false, err
+		}
+	}
+	for i := range expr.WhenClauses {
+		if err := rewrite(&expr.WhenClauses[i].When); err != nil {
+			return nil, false, err
+		}
+		if err := rewrite(&expr.WhenClauses[i].Then); err != nil {
+			return nil, false, err
+		}
+	}
+
+	result, selfChanged, err := fn(expr)
+	if err != nil {
+		return nil, false, err
+	}
+	return result, changed || selfChanged, nil
+}
+
+// TransformExpressions rewrites every expression held by this node: the
+// predicate, both sides of the join condition, computed projections,
+// aggregate arguments and sort keys. Children are left alone.
+func (lp *LogicalPlan) TransformExpressions(fn ExpressionTransformer) (bool, error) {
+	var slots []**Expression
+	if lp.Predicate != nil {
+		slots = append(slots, &lp.Predicate.Expression)
+	}
+	if lp.JoinCondition != nil {
+		slots = append(slots, &lp.JoinCondition.Left, &lp.JoinCondition.Right)
+	}
+	for i := range lp.Projections {
+		if lp.Projections[i].Expression != nil {
+			slots = append(slots, &lp.Projections[i].Expression)
+		}
+	}
+	for i := range lp.Aggregates {
+		if lp.Aggregates[i].Column != nil {
+			slots = append(slots, &lp.Aggregates[i].Column)
+		}
+	}
+	for i := range lp.OrderBy {
+		slots = append(slots, &lp.OrderBy[i].Expression)
+	}
+
+	changed := false
+	for _, slot := range slots {
+		result, slotChanged, err := TransformExpression(*slot, fn)
+		if err != nil {
+			return false, err
+		}
+		if slotChanged {
+			*slot = result
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// TransformAllExpressions rewrites the expressions of every node in the plan.
+func TransformAllExpressions(plan *LogicalPlan, fn ExpressionTransformer) (*LogicalPlan, bool, error) {
+	return TransformUp(plan, func(node *LogicalPlan) (*LogicalPlan, bool, error) {
+		changed, err := node.TransformExpressions(fn)
+		return node, changed, err
+	})
+}
@@ -6,12 +6,20 @@ import (
 
 	"retr0-kernel/optiquery/api"
 	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/config"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := api.ValidateOptimizerDefaults(cfg); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
 
 	catalogManager := catalog.NewCatalogManager()
 	r := gin.Default()
@@ -28,10 +36,17 @@ func main() {
 	apiGroup := r.Group("/api")
 	{
 		apiGroup.POST("/parse", api.ParseHandler)
-		apiGroup.POST("/optimize", api.OptimizeHandler)
-		apiGroup.POST("/simulate", api.SimulateHandler)
+		apiGroup.POST("/optimize", api.NewOptimizeHandler(catalogManager, cfg))
+		apiGroup.POST("/simulate", api.NewSimulateHandler(catalogManager, cfg))
+		apiGroup.POST("/plan/diff", api.PlanDiffHandler)
+		apiGroup.POST("/plan/fingerprint", api.PlanFingerprintHandler)
+		apiGroup.POST("/substrait/export", api.NewSubstraitExportHandler(catalogManager))
+		apiGroup.POST("/substrait/import", api.NewSubstraitImportHandler(catalogManager))
+		apiGroup.POST("/lineage", api.NewLineageHandler(catalogManager))
 		apiGroup.POST("/catalog/table", api.NewAddTableHandler(catalogManager))
 		apiGroup.GET("/catalog/tables", api.NewGetTablesHandler(catalogManager))
+		apiGroup.POST("/catalog/view", api.NewAddMaterializedViewHandler(catalogManager))
+		apiGroup.GET("/catalog/views", api.NewGetMaterializedViewsHandler(catalogManager))
 		apiGroup.GET("/catalog/table/:name/stats", api.NewGetTableStatsHandler(catalogManager))
 		apiGroup.POST("/catalog/table/:name/stats", api.NewUpdateStatsHandler(catalogManager))
 	}
@@ -0,0 +1,71 @@
+package optimizer
+
+import (
+	"fmt"
+	"sync"
+
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// ResultCache keeps optimization results keyed by plan fingerprint. Entries
+// are evicted in insertion order once the capacity is reached.
+type ResultCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*cachedResult
+	order    []string
+}
+
+type cachedResult struct {
+	plan    *logical_plan.LogicalPlan
+	explain *ExplainResult
+}
+
+func NewResultCache(capacity int) *ResultCache {
+	return &ResultCache{
+		capacity: capacity,
+		entries:  make(map[string]*cachedResult),
+	}
+}
+
+// CacheKey includes the options, the hints and the catalog version, since the
+// same plan can optimize differently with other settings or once statistics
+// change. Aliases are kept as written, as the cached plan refers to columns
+// through them.
+func CacheKey(strategy string, opts Options, plan *logical_plan.LogicalPlan, catalogVersion uint64) string {
+	return fmt.Sprintf("%s:%s:%v:%d:", strategy, opts.key(), plan.Hints, catalogVersion) + logical_plan.Fingerprint(plan, logical_plan.FingerprintOptions{
+		KeepLiterals: true,
+		OrderedJoins: true,
+		KeepAliases:  true,
+	})
+}
+
+func (rc *ResultCache) Get(key string) (*logical_plan.LogicalPlan, *ExplainResult, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry, ok := rc.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	return entry.plan.Clone(), entry.explain, true
+}
+
+func (rc *ResultCache) Put(key string, plan *logical_plan.LogicalPlan, explain *ExplainResult) {
+	if rc.capacity <= 0 || plan == nil {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if _, exists := rc.entries[key]; !exists {
+		if len(rc.order) >= rc.capacity {
+			oldest := rc.order[0]
+			rc.order = rc.order[1:]
+			delete(rc.entries, oldest)
+		}
+		rc.order = append(rc.order, key)
+	}
+	rc.entries[key] = &cachedResult{plan: plan.Clone(), explain: explain}
+}
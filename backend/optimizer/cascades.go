@@ -0,0 +1,62 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+
+	"retr0-kernel/optiquery/cascades"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// OptimizeWithCascades normalizes the plan with the rewrite rules, then lets
+// the memo-based optimizer pick join orders and physical operators together.
+// The explored memo is returned in the explain result.
+func OptimizeWithCascades(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*logical_plan.LogicalPlan, *ExplainResult, error) {
+	return optimizeWithCascades(context.Background(), plan, catalogMgr, DefaultOptions())
+}
+
+// optimizeWithCascades skips the search when ctx is done before it starts,
+// returning the normalized plan as a partial result.
+func optimizeWithCascades(ctx context.Context, plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager, opts Options) (*logical_plan.LogicalPlan, *ExplainResult, error) {
+	if plan == nil {
+		return nil, nil, fmt.Errorf("cannot optimize nil plan")
+	}
+	opts = opts.WithDefaults(DefaultOptions())
+
+	normalizedPlan, explain, err := NewRuleBasedOptimizerWithOptions(catalogMgr, opts).Optimize(ctx, plan)
+	if err != nil {
+		return nil, explain, err
+	}
+	if explain.interrupted(ctx) {
+		return normalizedPlan, explain, nil
+	}
+
+	search := cascades.NewOptimizer(catalogMgr)
+	search.CostModel = opts.NewCostModel()
+	search.MaxExpressions = opts.MaxMemoExpressions
+	search.DisabledRules = opts.disabledRules(cascades.TransformationRuleNames())
+	result, err := search.Optimize(ctx, normalizedPlan.Clone())
+	if err != nil {
+		return nil, explain, err
+	}
+
+	stats := result.Memo.Statistics
+	explain.AppliedRules = append(explain.AppliedRules, "CascadesSearch")
+	explain.Steps = append(explain.Steps, OptimizationStep{
+		RuleName:    "CascadesSearch",
+		BeforePlan:  normalizedPlan,
+		AfterPlan:   result.Plan,
+		Description: fmt.Sprintf("Applied memo-based search (final cost: %.2f)", result.Cost),
+		Diff:        logical_plan.Diff(normalizedPlan, result.Plan),
+		Details: []string{fmt.Sprintf("explored %d groups with %d logical expressions, costed %d physical alternatives and pruned %d; cost %.2f to %.2f",
+			stats.Groups, stats.LogicalExpressions, stats.PhysicalAlternatives, stats.Pruned, result.OriginalCost, result.Cost)},
+	})
+	if stats.ExplorationInterrupted {
+		explain.markPartial(ctx.Err())
+	}
+	explain.Statistics.TotalRulesApplied = len(explain.AppliedRules)
+	explain.PlanFingerprint = planFingerprint(result.Plan)
+	explain.Memo = result.Memo
+	return result.Plan, explain, nil
+}
@@ -0,0 +1,569 @@
+package optimizer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// ConstantFoldingRule evaluates constant subexpressions and simplifies
+// predicates: boolean identities, duplicate conjuncts and IN-list items, and
+// overlapping ranges on the same column. A filter that can never hold is
+// replaced by an empty node. Catalog, when set, gives that node its schema.
+type ConstantFoldingRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *ConstantFoldingRule) Name() string {
+	return "ConstantFolding"
+}
+
+func (r *ConstantFoldingRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+// ApplyAndDescribe returns one line per simplification made.
+func (r *ConstantFoldingRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	s := &simplifier{}
+	result, _, err := logical_plan.TransformUp(plan, func(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		changed, err := node.TransformExpressions(s.simplify)
+		if err != nil {
+			return nil, false, err
+		}
+		if node.NodeType == logical_plan.NodeTypeFilter && node.Predicate != nil && len(node.Children) == 1 {
+			return r.simplifyFilter(s, node, changed)
+		}
+		return node, changed, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, s.details, nil
+}
+
+func (r *ConstantFoldingRule) simplifyFilter(s *simplifier, node *logical_plan.LogicalPlan, changed bool) (*logical_plan.LogicalPlan, bool, error) {
+	predicate := node.Predicate.Expression
+	conjuncts, conjunctsChanged, contradiction := s.simplifyConjuncts(logical_plan.SplitConjuncts(predicate))
+
+	switch {
+	case contradiction != "":
+		s.logf("replaced filter %s with an empty result: %s", predicate, contradiction)
+		return logical_plan.NewEmptyNode(outputColumns(node.Children[0], r.Catalog)), true, nil
+	case len(conjuncts) == 0:
+		s.logf("removed filter %s, which always holds", predicate)
+		return node.Children[0], true, nil
+	case conjunctsChanged:
+		node.Predicate.Expression = logical_plan.CombineConjuncts(conjuncts)
+		return node, true, nil
+	}
+	return node, changed, nil
+}
+
+// outputColumns lists the columns plan outputs, for the schema of an empty
+// node replacing it.
+func outputColumns(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) []logical_plan.Column {
+	bound, _ := binder.Bind(plan, catalogMgr)
+	if bound == nil {
+		return nil
+	}
+	var columns []logical_plan.Column
+	for _, column := range bound.Output(plan) {
+		columns = append(columns, logical_plan.Column{Table: column.Relation, Name: column.Name})
+	}
+	return columns
+}
+
+type simplifier struct {
+	details []string
+}
+
+func (s *simplifier) logf(format string, args ...interface{}) {
+	s.details = append(s.details, fmt.Sprintf(format, args...))
+}
+
+// simplify rewrites a single expression node whose children have already
+// been simplified.
+func (s *simplifier) simplify(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+	result := s.rewrite(e)
+	if result == e {
+		return e, false, nil
+	}
+	s.logf("simplified %s to %s", e, result)
+	return result, true, nil
+}
+
+func (s *simplifier) rewrite(e *logical_plan.Expression) *logical_plan.Expression {
+	switch e.Kind {
+	case logical_plan.ExprBinaryOp:
+		left, right := e.Left, e.Right
+		switch e.BinaryOp {
+		case logical_plan.OpAnd:
+			switch {
+			case isBoolLiteral(left, false) || isBoolLiteral(right, false):
+				return logical_plan.NewLiteralExpression(false)
+			case isBoolLiteral(left, true):
+				return right
+			case isBoolLiteral(right, true), sameExpression(left, right):
+				return left
+			}
+		case logical_plan.OpOr:
+			switch {
+			case isBoolLiteral(left, true) || isBoolLiteral(right, true):
+				return logical_plan.NewLiteralExpression(true)
+			case isBoolLiteral(left, false):
+				return right
+			case isBoolLiteral(right, false), sameExpression(left, right):
+				return left
+			}
+		case logical_plan.OpIn, logical_plan.OpNotIn:
+			if right != nil && right.Kind == logical_plan.ExprList {
+				if items := distinctExpressions(right.Args); len(items) < len(right.Args) {
+					return logical_plan.NewBinaryOpExpression(e.BinaryOp, left, logical_plan.NewListExpression(items))
+				}
+			}
+		default:
+			if left.IsLiteral() && right.IsLiteral() {
+				if literal, ok := evalBinary(e.BinaryOp, left.Literal, right.Literal); ok {
+					return &logical_plan.Expression{Kind: logical_plan.ExprLiteral, Literal: literal}
+				}
+			}
+		}
+
+	case logical_plan.ExprUnaryOp:
+		operand := e.Operand
+		switch {
+		case e.UnaryOp == logical_plan.OpNot && operand != nil && operand.Kind == logical_plan.ExprUnaryOp && operand.UnaryOp == logical_plan.OpNot:
+			return operand.Operand
+		case !operand.IsLiteral():
+		case e.UnaryOp == logical_plan.OpIsNull:
+			return logical_plan.NewLiteralExpression(operand.Literal.IsNull())
+		case e.UnaryOp == logical_plan.OpIsNotNull:
+			return logical_plan.NewLiteralExpression(!operand.Literal.IsNull())
+		case operand.Literal.IsNull():
+			if e.UnaryOp == logical_plan.OpNot || e.UnaryOp == logical_plan.OpNeg {
+				return operand
+			}
+		case e.UnaryOp == logical_plan.OpNot:
+			if value, ok := operand.Literal.Value.(bool); ok {
+				return logical_plan.NewLiteralExpression(!value)
+			}
+		case e.UnaryOp == logical_plan.OpNeg:
+			switch value := operand.Literal.Value.(type) {
+			case int64:
+				if value != math.MinInt64 {
+					return logical_plan.NewLiteralExpression(-value)
+				}
+			case float64:
+				return logical_plan.NewLiteralExpression(-value)
+			}
+		}
+	}
+	return e
+}
+
+// columnRange collects the comparisons between one column and literals.
+type columnRange struct {
+	members  []*logical_plan.Expression
+	equal    *rangeBound
+	lower    *rangeBound
+	upper    *rangeBound
+	notEqual []*rangeBound
+}
+
+type rangeBound struct {
+	value     *logical_plan.Literal
+	inclusive bool
+	expr      *logical_plan.Expression
+}
+
+// simplifyConjuncts removes duplicate and always-true conjuncts and merges
+// ranges on the same column. When the conjuncts can never all hold it returns
+// the reason instead.
+func (s *simplifier) simplifyConjuncts(conjuncts []*logical_plan.Expression) ([]*logical_plan.Expression, bool, string) {
+	changed := false
+	seen := make(map[string]bool)
+	var distinct []*logical_plan.Expression
+	for _, conjunct := range conjuncts {
+		switch {
+		case isBoolLiteral(conjunct, true):
+			changed = true
+			continue
+		case isBoolLiteral(conjunct, false) || (conjunct.IsLiteral() && conjunct.Literal.IsNull()):
+			return nil, false, fmt.Sprintf("%s never holds", conjunct)
+		}
+		key := conjunct.String()
+		if seen[key] {
+			s.logf("removed duplicate conjunct %s", conjunct)
+			changed = true
+			continue
+		}
+		seen[key] = true
+		distinct = append(distinct, conjunct)
+	}
+
+	ranges := make(map[string]*columnRange)
+	var order []string
+	rangeOf := make(map[int]string)
+	for i, conjunct := range distinct {
+		ref, op, literal, ok := literalComparison(conjunct)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(ref.String())
+		r := ranges[key]
+		if r == nil {
+			r = &columnRange{}
+			ranges[key] = r
+			order = append(order, key)
+		}
+		if !r.accepts(literal) {
+			continue
+		}
+		if reason := r.add(op, &rangeBound{value: literal, expr: conjunct}); reason != "" {
+			return nil, false, reason
+		}
+		if op != logical_plan.OpNotEq {
+			r.members = append(r.members, conjunct)
+			rangeOf[i] = key
+		}
+	}
+
+	merged := make(map[string][]*logical_plan.Expression)
+	for _, key := range order {
+		r := ranges[key]
+		result, reason := r.merge()
+		if reason != "" {
+			return nil, false, reason
+		}
+		if len(result) < len(r.members) {
+			s.logf("merged %s into %s", logical_plan.CombineConjuncts(r.members), logical_plan.CombineConjuncts(result))
+			changed = true
+		}
+		merged[key] = result
+	}
+
+	var result []*logical_plan.Expression
+	emitted := make(map[string]bool)
+	for i, conjunct := range distinct {
+		key, inRange := rangeOf[i]
+		if !inRange {
+			result = append(result, conjunct)
+			continue
+		}
+		if !emitted[key] {
+			emitted[key] = true
+			result = append(result, merged[key]...)
+		}
+	}
+	return result, changed, ""
+}
+
+// accepts reports whether a literal can be ordered against those already in
+// the range.
+func (r *columnRange) accepts(literal *logical_plan.Literal) bool {
+	for _, bound := range []*rangeBound{r.equal, r.lower, r.upper} {
+		if bound != nil {
+			if _, ok := compareLiterals(bound.value, literal); !ok {
+				return false
+			}
+		}
+	}
+	for _, bound := range r.notEqual {
+		if _, ok := compareLiterals(bound.value, literal); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *columnRange) add(op logical_plan.BinaryOperator, bound *rangeBound) string {
+	switch op {
+	case logical_plan.OpEq:
+		if r.equal != nil {
+			if cmp, _ := compareLiterals(r.equal.value, bound.value); cmp != 0 {
+				return fmt.Sprintf("%s contradicts %s", bound.expr, r.equal.expr)
+			}
+			return ""
+		}
+		bound.inclusive = true
+		r.equal = bound
+	case logical_plan.OpNotEq:
+		r.notEqual = append(r.notEqual, bound)
+	case logical_plan.OpGt, logical_plan.OpGtEq:
+		bound.inclusive = op == logical_plan.OpGtEq
+		if r.lower == nil || tighter(bound, r.lower, 1) {
+			r.lower = bound
+		}
+	case logical_plan.OpLt, logical_plan.OpLtEq:
+		bound.inclusive = op == logical_plan.OpLtEq
+		if r.upper == nil || tighter(bound, r.upper, -1) {
+			r.upper = bound
+		}
+	}
+	return ""
+}
+
+// tighter reports whether a bound restricts more than the current one, where
+// direction is 1 for lower bounds and -1 for upper bounds.
+func tighter(bound, current *rangeBound, direction int) bool {
+	cmp, _ := compareLiterals(bound.value, current.value)
+	return cmp*direction > 0 || (cmp == 0 && !bound.inclusive && current.inclusive)
+}
+
+// merge returns the fewest conjuncts equivalent to the range, or the reason
+// it is empty.
+func (r *columnRange) merge() ([]*logical_plan.Expression, string) {
+	if r.equal != nil {
+		for _, bound := range r.notEqual {
+			if cmp, _ := compareLiterals(r.equal.value, bound.value); cmp == 0 {
+				return nil, fmt.Sprintf("%s contradicts %s", bound.expr, r.equal.expr)
+			}
+		}
+		for _, bound := range []*rangeBound{r.lower, r.upper} {
+			if bound != nil && !r.contains(bound, r.equal.value) {
+				return nil, fmt.Sprintf("%s contradicts %s", bound.expr, r.equal.expr)
+			}
+		}
+		return []*logical_plan.Expression{r.equal.expr}, ""
+	}
+
+	if r.lower != nil && r.upper != nil {
+		cmp, _ := compareLiterals(r.lower.value, r.upper.value)
+		if cmp > 0 || (cmp == 0 && !(r.lower.inclusive && r.upper.inclusive)) {
+			return nil, fmt.Sprintf("%s contradicts %s", r.upper.expr, r.lower.expr)
+		}
+	}
+
+	var result []*logical_plan.Expression
+	for _, bound := range []*rangeBound{r.lower, r.upper} {
+		if bound != nil {
+			result = append(result, bound.expr)
+		}
+	}
+	return result, ""
+}
+
+func (r *columnRange) contains(bound *rangeBound, value *logical_plan.Literal) bool {
+	cmp, _ := compareLiterals(value, bound.value)
+	if bound == r.upper {
+		cmp = -cmp
+	}
+	return cmp > 0 || (cmp == 0 && bound.inclusive)
+}
+
+var flippedComparisons = map[logical_plan.BinaryOperator]logical_plan.BinaryOperator{
+	logical_plan.OpEq:    logical_plan.OpEq,
+	logical_plan.OpNotEq: logical_plan.OpNotEq,
+	logical_plan.OpLt:    logical_plan.OpGt,
+	logical_plan.OpLtEq:  logical_plan.OpGtEq,
+	logical_plan.OpGt:    logical_plan.OpLt,
+	logical_plan.OpGtEq:  logical_plan.OpLtEq,
+}
+
+// literalComparison matches "column op literal", either way round, with a
+// non-NULL literal. The operator is returned as if the column were on the
+// left.
+func literalComparison(e *logical_plan.Expression) (logical_plan.ColumnRef, logical_plan.BinaryOperator, *logical_plan.Literal, bool) {
+	if e.Kind != logical_plan.ExprBinaryOp || !e.BinaryOp.IsComparison() {
+		return logical_plan.ColumnRef{}, "", nil, false
+	}
+	switch {
+	case e.Left.IsColumn() && e.Right.IsLiteral() && !e.Right.Literal.IsNull():
+		return *e.Left.Column, e.BinaryOp, e.Right.Literal, true
+	case e.Right.IsColumn() && e.Left.IsLiteral() && !e.Left.Literal.IsNull():
+		return *e.Right.Column, flippedComparisons[e.BinaryOp], e.Left.Literal, true
+	}
+	return logical_plan.ColumnRef{}, "", nil, false
+}
+
+// compareLiterals orders two non-NULL literals of compatible types.
+func compareLiterals(a, b *logical_plan.Literal) (int, bool) {
+	if ai, ok := a.Value.(int64); ok {
+		if bi, ok := b.Value.(int64); ok {
+			return compareOrdered(ai, bi), true
+		}
+	}
+	if af, ok := numericValue(a); ok {
+		if bf, ok := numericValue(b); ok {
+			return compareOrdered(af, bf), true
+		}
+		return 0, false
+	}
+	switch av := a.Value.(type) {
+	case string:
+		if bv, ok := b.Value.(string); ok && a.Type == b.Type {
+			return strings.Compare(av, bv), true
+		}
+	case bool:
+		if bv, ok := b.Value.(bool); ok {
+			switch {
+			case av == bv:
+				return 0, true
+			case bv:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func numericValue(l *logical_plan.Literal) (float64, bool) {
+	switch v := l.Value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// evalBinary folds an operator applied to two literals. It declines anything
+// it cannot evaluate exactly, such as division by zero or integer arithmetic
+// that overflows.
+func evalBinary(op logical_plan.BinaryOperator, a, b *logical_plan.Literal) (*logical_plan.Literal, bool) {
+	switch op {
+	case logical_plan.OpAdd, logical_plan.OpSub, logical_plan.OpMul, logical_plan.OpDiv, logical_plan.OpMod,
+		logical_plan.OpConcat, logical_plan.OpEq, logical_plan.OpNotEq,
+		logical_plan.OpLt, logical_plan.OpLtEq, logical_plan.OpGt, logical_plan.OpGtEq:
+	default:
+		return nil, false
+	}
+	if a.IsNull() || b.IsNull() {
+		return &logical_plan.Literal{Type: logical_plan.DataTypeNull}, true
+	}
+
+	if op.IsComparison() {
+		cmp, ok := compareLiterals(a, b)
+		if !ok {
+			return nil, false
+		}
+		var result bool
+		switch op {
+		case logical_plan.OpEq:
+			result = cmp == 0
+		case logical_plan.OpNotEq:
+			result = cmp != 0
+		case logical_plan.OpLt:
+			result = cmp < 0
+		case logical_plan.OpLtEq:
+			result = cmp <= 0
+		case logical_plan.OpGt:
+			result = cmp > 0
+		case logical_plan.OpGtEq:
+			result = cmp >= 0
+		}
+		return logical_plan.NewLiteral(result), true
+	}
+
+	if op == logical_plan.OpConcat {
+		as, aok := a.Value.(string)
+		bs, bok := b.Value.(string)
+		if !aok || !bok || a.Type != logical_plan.DataTypeString || b.Type != logical_plan.DataTypeString {
+			return nil, false
+		}
+		return logical_plan.NewLiteral(as + bs), true
+	}
+
+	if ai, ok := a.Value.(int64); ok {
+		if bi, ok := b.Value.(int64); ok {
+			switch op {
+			case logical_plan.OpAdd:
+				sum := ai + bi
+				if (sum > ai) != (bi > 0) {
+					return nil, false
+				}
+				return logical_plan.NewLiteral(sum), true
+			case logical_plan.OpSub:
+				difference := ai - bi
+				if (difference < ai) != (bi > 0) {
+					return nil, false
+				}
+				return logical_plan.NewLiteral(difference), true
+			case logical_plan.OpMul:
+				product := ai * bi
+				if ai != 0 && (product/ai != bi || (ai == -1 && bi == math.MinInt64)) {
+					return nil, false
+				}
+				return logical_plan.NewLiteral(product), true
+			case logical_plan.OpDiv:
+				if bi == 0 || (ai == math.MinInt64 && bi == -1) {
+					return nil, false
+				}
+				return logical_plan.NewLiteral(ai / bi), true
+			case logical_plan.OpMod:
+				if bi == 0 {
+					return nil, false
+				}
+				return logical_plan.NewLiteral(ai % bi), true
+			}
+		}
+	}
+
+	af, aok := numericValue(a)
+	bf, bok := numericValue(b)
+	if !aok || !bok {
+		return nil, false
+	}
+	switch op {
+	case logical_plan.OpAdd:
+		return logical_plan.NewLiteral(af + bf), true
+	case logical_plan.OpSub:
+		return logical_plan.NewLiteral(af - bf), true
+	case logical_plan.OpMul:
+		return logical_plan.NewLiteral(af * bf), true
+	case logical_plan.OpDiv:
+		if bf == 0 {
+			return nil, false
+		}
+		return logical_plan.NewLiteral(af / bf), true
+	case logical_plan.OpMod:
+		if bf == 0 {
+			return nil, false
+		}
+		return logical_plan.NewLiteral(math.Mod(af, bf)), true
+	}
+	return nil, false
+}
+
+func isBoolLiteral(e *logical_plan.Expression, value bool) bool {
+	if !e.IsLiteral() {
+		return false
+	}
+	b, ok := e.Literal.Value.(bool)
+	return ok && b == value
+}
+
+func sameExpression(a, b *logical_plan.Expression) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
+
+func distinctExpressions(exprs []*logical_plan.Expression) []*logical_plan.Expression {
+	seen := make(map[string]bool)
+	var result []*logical_plan.Expression
+	for _, expr := range exprs {
+		key := expr.String()
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, expr)
+		}
+	}
+	return result
+}
@@ -1,6 +1,7 @@
 package optimizer
 
 import (
+	"context"
 	"fmt"
 
 	"retr0-kernel/optiquery/catalog"
@@ -11,23 +12,41 @@ import (
 type CostBasedOptimizer struct {
 	costModel  cost_model.CostModel
 	catalogMgr *catalog.CatalogManager
+	options    Options
 }
 
 func NewCostBasedOptimizer(catalogMgr *catalog.CatalogManager) *CostBasedOptimizer {
+	return NewCostBasedOptimizerWithOptions(catalogMgr, DefaultOptions())
+}
+
+func NewCostBasedOptimizerWithOptions(catalogMgr *catalog.CatalogManager, opts Options) *CostBasedOptimizer {
+	if catalogMgr == nil {
+		catalogMgr = catalog.NewCatalogManager()
+	}
+	opts = opts.WithDefaults(DefaultOptions())
 	return &CostBasedOptimizer{
-		costModel:  cost_model.NewSimpleCostModel(),
+		costModel:  opts.NewCostModel(),
 		catalogMgr: catalogMgr,
+		options:    opts,
 	}
 }
 
-func OptimizeWithCost(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, *ExplainResult, error) {
-
-	catalogMgr := catalog.NewCatalogManager()
+func OptimizeWithCost(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*logical_plan.LogicalPlan, *ExplainResult, error) {
 	optimizer := NewCostBasedOptimizer(catalogMgr)
-	return optimizer.Optimize(plan)
+	return optimizer.Optimize(context.Background(), plan)
 }
 
-func (cbo *CostBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, *ExplainResult, error) {
+// Optimize rewrites the plan, answers what it can from materialized views,
+// reorders its joins, aggregates eagerly, picks physical operators and spools
+// repeated subplans. Those stages keep a change only when the cost model
+// estimates it strictly cheaper, which is why they run here rather than among
+// the rewrite rules, and each needs the plan the one before leaves: views
+// match the joins as written, before reordering; eager aggregation splits
+// aggregates over the final join order; and subplans are compared down to
+// their physical operators, so sharing comes last. Once ctx is done the
+// searching stages are skipped, but physical operators are still picked for
+// the plan found so far.
+func (cbo *CostBasedOptimizer) Optimize(ctx context.Context, plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, *ExplainResult, error) {
 	if plan == nil {
 		return nil, nil, fmt.Errorf("cannot optimize nil plan")
 	}
@@ -37,8 +56,9 @@ func (cbo *CostBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logica
 		Steps:        []OptimizationStep{},
 		Statistics:   OptimizationStatistics{},
 	}
+	hints := newPlanHints(plan)
 
-	ruleOptimizedPlan, ruleExplain, err := OptimizeWithRules(plan)
+	ruleOptimizedPlan, ruleExplain, err := NewRuleBasedOptimizerWithOptions(cbo.catalogMgr, cbo.options).Optimize(ctx, plan)
 	if err != nil {
 		return nil, explain, err
 	}
@@ -46,12 +66,78 @@ func (cbo *CostBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logica
 	explain.AppliedRules = append(explain.AppliedRules, ruleExplain.AppliedRules...)
 	explain.Steps = append(explain.Steps, ruleExplain.Steps...)
 
-	costOptimizedPlan, err := cbo.applyCostBasedOptimizations(ruleOptimizedPlan)
+	viewPlan, viewMatches, err := cbo.useMaterializedViews(ctx, ruleOptimizedPlan)
+	if err != nil {
+		return nil, explain, err
+	}
+	explain.MaterializedViews = viewMatches
+
+	var viewDetails []string
+	for _, match := range viewMatches {
+		if match.Used {
+			viewDetails = append(viewDetails, match.String())
+		}
+	}
+	if len(viewDetails) > 0 {
+		explain.AppliedRules = append(explain.AppliedRules, "MaterializedViewRewrite")
+		explain.Steps = append(explain.Steps, OptimizationStep{
+			RuleName:    "MaterializedViewRewrite",
+			BeforePlan:  ruleOptimizedPlan,
+			AfterPlan:   viewPlan,
+			Description: "Applied MaterializedViewRewrite rule",
+			Diff:        logical_plan.Diff(ruleOptimizedPlan, viewPlan),
+			Details:     viewDetails,
+		})
+		ruleOptimizedPlan = viewPlan
+	}
+
+	reorderedPlan, joinOrders, err := cbo.optimizeJoinOrder(ctx, ruleOptimizedPlan.Clone(), hints)
 	if err != nil {
 		return nil, explain, err
 	}
+	explain.JoinOrders = joinOrders
 
-	finalCost, err := cbo.costModel.EstimateCost(costOptimizedPlan, cbo.catalogMgr)
+	var reorderDetails []string
+	for _, choice := range joinOrders {
+		if choice.Reordered {
+			reorderDetails = append(reorderDetails, choice.String())
+		}
+	}
+	if len(reorderDetails) > 0 {
+		explain.AppliedRules = append(explain.AppliedRules, "JoinReordering")
+		explain.Steps = append(explain.Steps, OptimizationStep{
+			RuleName:    "JoinReordering",
+			BeforePlan:  ruleOptimizedPlan,
+			AfterPlan:   reorderedPlan,
+			Description: "Applied JoinReordering rule",
+			Diff:        logical_plan.Diff(ruleOptimizedPlan, reorderedPlan),
+			Details:     reorderDetails,
+		})
+	}
+
+	aggregatedPlan, aggregationDetails, err := cbo.aggregateEagerly(ctx, reorderedPlan.Clone())
+	if err != nil {
+		return nil, explain, err
+	}
+	if len(aggregationDetails) > 0 {
+		explain.AppliedRules = append(explain.AppliedRules, "EagerAggregation")
+		explain.Steps = append(explain.Steps, OptimizationStep{
+			RuleName:    "EagerAggregation",
+			BeforePlan:  reorderedPlan,
+			AfterPlan:   aggregatedPlan,
+			Description: "Applied EagerAggregation rule",
+			Diff:        logical_plan.Diff(reorderedPlan, aggregatedPlan),
+			Details:     aggregationDetails,
+		})
+		reorderedPlan = aggregatedPlan
+	}
+
+	costOptimizedPlan, physicalDetails, err := cbo.applyCostBasedOptimizations(reorderedPlan, hints)
+	if err != nil {
+		return nil, explain, err
+	}
+
+	finalCost, err := planCost(costOptimizedPlan, cbo.costModel, cbo.catalogMgr)
 	if err != nil {
 		return nil, explain, err
 	}
@@ -61,116 +147,139 @@ func (cbo *CostBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logica
 	explain.AppliedRules = append(explain.AppliedRules, "CostBasedOptimization")
 	explain.Steps = append(explain.Steps, OptimizationStep{
 		RuleName:    "CostBasedOptimization",
-		BeforePlan:  ruleOptimizedPlan,
+		BeforePlan:  reorderedPlan,
 		AfterPlan:   costOptimizedPlan,
 		Description: fmt.Sprintf("Applied cost-based optimization (final cost: %.2f)", finalCost.TotalCost),
+		Diff:        logical_plan.Diff(reorderedPlan, costOptimizedPlan),
+		Details:     physicalDetails,
 	})
 
+	sharedPlan, sharedSubplans, err := cbo.shareSubplans(ctx, costOptimizedPlan)
+	if err != nil {
+		return nil, explain, err
+	}
+	explain.SharedSubplans = sharedSubplans
+
+	var sharingDetails []string
+	for _, subplan := range sharedSubplans {
+		if subplan.Spooled {
+			sharingDetails = append(sharingDetails, subplan.String())
+		}
+	}
+	if len(sharingDetails) > 0 {
+		sharedCost, err := planCost(sharedPlan, cbo.costModel, cbo.catalogMgr)
+		if err != nil {
+			return nil, explain, err
+		}
+		cbo.propagateCostEstimates(sharedPlan)
+		explain.AppliedRules = append(explain.AppliedRules, "SubplanSharing")
+		explain.Steps = append(explain.Steps, OptimizationStep{
+			RuleName:    "SubplanSharing",
+			BeforePlan:  costOptimizedPlan,
+			AfterPlan:   sharedPlan,
+			Description: fmt.Sprintf("Applied SubplanSharing rule (final cost: %.2f)", sharedCost.TotalCost),
+			Diff:        logical_plan.Diff(costOptimizedPlan, sharedPlan),
+			Details:     sharingDetails,
+		})
+	}
+	// The shared plan also has the spools the input already had made one
+	// node each again.
+	costOptimizedPlan = sharedPlan
+	if hasSpool(costOptimizedPlan) {
+		explain.PlanDAG = logical_plan.ToDAG(costOptimizedPlan)
+	}
+
+	// A done context cut short or skipped at least one of the stages above.
+	explain.interrupted(ctx)
+
+	explain.Hints = hints.reports()
 	explain.Statistics.TotalRulesApplied = len(explain.AppliedRules)
+	explain.PlanFingerprint = planFingerprint(costOptimizedPlan)
 	return costOptimizedPlan, explain, nil
 }
 
-func (cbo *CostBasedOptimizer) applyCostBasedOptimizations(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, error) {
+func (cbo *CostBasedOptimizer) applyCostBasedOptimizations(plan *logical_plan.LogicalPlan, hints *planHints) (*logical_plan.LogicalPlan, []string, error) {
 	optimizedPlan := plan.Clone()
 
-	optimizedPlan = cbo.optimizeJoinOrder(optimizedPlan)
-
-	optimizedPlan = cbo.selectPhysicalOperators(optimizedPlan)
+	optimizedPlan, details := cbo.selectPhysicalOperators(optimizedPlan, hints)
 
-	return optimizedPlan, nil
+	return optimizedPlan, details, nil
 }
 
-func (cbo *CostBasedOptimizer) optimizeJoinOrder(plan *logical_plan.LogicalPlan) *logical_plan.LogicalPlan {
-	if plan == nil {
-		return nil
+// optimizeJoinOrder reorders every inner join tree with the plan enumerator,
+// unless the options disable join reordering or ctx is done. A LEADING hint
+// fixes the start of the tree that has all of its relations.
+func (cbo *CostBasedOptimizer) optimizeJoinOrder(ctx context.Context, plan *logical_plan.LogicalPlan, hints *planHints) (*logical_plan.LogicalPlan, []JoinOrderChoice, error) {
+	rule := &JoinReorderingRule{
+		Catalog:     cbo.catalogMgr,
+		CostModel:   cbo.costModel,
+		DPThreshold: cbo.options.DPThreshold,
+		MaxPlans:    cbo.options.MaxPlans,
 	}
-
-	if plan.NodeType == logical_plan.NodeTypeJoin && len(plan.Children) == 2 {
-		leftChild := plan.Children[0]
-		rightChild := plan.Children[1]
-
-		currentCost, err := cbo.costModel.EstimateCost(plan, cbo.catalogMgr)
-		if err != nil {
-			return plan
+	leading := hints.leadingHint()
+	if !cbo.options.RuleEnabled(rule.Name()) {
+		if leading != nil {
+			leading.ignore("JoinReordering is disabled")
 		}
-
-		swappedPlan := logical_plan.NewJoinNode(rightChild, leftChild, plan.JoinType, plan.JoinCondition)
-		swappedCost, err := cbo.costModel.EstimateCost(swappedPlan, cbo.catalogMgr)
-		if err != nil {
-			return plan
+		return plan, nil, nil
+	}
+	if ctx.Err() != nil {
+		if leading != nil {
+			leading.ignore("join ordering was skipped: " + ctx.Err().Error())
 		}
-
-		if swappedCost.TotalCost < currentCost.TotalCost {
-			plan = swappedPlan
+		return plan, nil, nil
+	}
+	if leading != nil {
+		rule.Leading = leading.hint.Arguments
+	}
+	result, choices, err := rule.Reorder(ctx, plan)
+	for _, choice := range choices {
+		if len(choice.Leading) > 0 {
+			leading.use()
 		}
 	}
+	return result, choices, err
+}
 
-	for i, child := range plan.Children {
-		plan.Children[i] = cbo.optimizeJoinOrder(child)
+// useMaterializedViews replaces the parts of the plan materialized views
+// answer more cheaply, unless the options disable the rewrite or ctx is done.
+func (cbo *CostBasedOptimizer) useMaterializedViews(ctx context.Context, plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []ViewMatch, error) {
+	rule := &MaterializedViewRewriteRule{Catalog: cbo.catalogMgr, CostModel: cbo.costModel}
+	if !cbo.options.RuleEnabled(rule.Name()) || ctx.Err() != nil {
+		return plan, nil, nil
 	}
-
-	return plan
+	return rule.Rewrite(plan)
 }
 
-func (cbo *CostBasedOptimizer) selectPhysicalOperators(plan *logical_plan.LogicalPlan) *logical_plan.LogicalPlan {
-	if plan == nil {
-		return nil
+// shareSubplans spools repeated subplans computed more cheaply once, unless
+// the options disable subplan sharing or ctx is done.
+func (cbo *CostBasedOptimizer) shareSubplans(ctx context.Context, plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []SharedSubplan, error) {
+	rule := &SubplanSharingRule{Catalog: cbo.catalogMgr, CostModel: cbo.costModel}
+	if !cbo.options.RuleEnabled(rule.Name()) || ctx.Err() != nil {
+		return plan, nil, nil
 	}
+	return rule.Share(plan)
+}
 
-	switch plan.NodeType {
-	case logical_plan.NodeTypeJoin:
-		leftCard, _ := cbo.costModel.EstimateCardinality(plan.Children[0], cbo.catalogMgr)
-		rightCard, _ := cbo.costModel.EstimateCardinality(plan.Children[1], cbo.catalogMgr)
-
-		if leftCard < 1000 && rightCard < 1000 {
-			plan.Metadata["physical_operator"] = "nested_loop_join"
-		} else if leftCard < rightCard {
-
-			plan.Metadata["physical_operator"] = "hash_join"
-			plan.Metadata["build_side"] = "left"
-		} else {
-			plan.Metadata["physical_operator"] = "hash_join"
-			plan.Metadata["build_side"] = "right"
-		}
-
-		if leftCard > 1000000 && rightCard > 1000000 {
-			plan.Metadata["physical_operator"] = "sort_merge_join"
-		}
-
-	case logical_plan.NodeTypeAggregate:
-		cardinality, _ := cbo.costModel.EstimateCardinality(plan, cbo.catalogMgr)
-		if len(plan.GroupBy) == 0 {
-
-			plan.Metadata["physical_operator"] = "hash_aggregate"
-		} else if cardinality < 10000 {
-			plan.Metadata["physical_operator"] = "hash_aggregate"
-		} else {
-
-			plan.Metadata["physical_operator"] = "sort_aggregate"
-		}
-
-	case logical_plan.NodeTypeSort:
-		cardinality, _ := cbo.costModel.EstimateCardinality(plan, cbo.catalogMgr)
-		if cardinality < 100000 {
-			plan.Metadata["physical_operator"] = "quicksort"
-		} else {
-			plan.Metadata["physical_operator"] = "external_sort"
-		}
-
-	case logical_plan.NodeTypeScan:
-
-		if plan.Metadata == nil {
-			plan.Metadata = make(map[string]interface{})
-		}
-		plan.Metadata["scan_type"] = "sequential"
-
+// aggregateEagerly splits aggregates over joins, unless the options disable
+// eager aggregation or ctx is done.
+func (cbo *CostBasedOptimizer) aggregateEagerly(ctx context.Context, plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	rule := &EagerAggregationRule{Catalog: cbo.catalogMgr, CostModel: cbo.costModel}
+	if !cbo.options.RuleEnabled(rule.Name()) || ctx.Err() != nil {
+		return plan, nil, nil
 	}
+	return rule.ApplyAndDescribe(plan)
+}
 
-	for i, child := range plan.Children {
-		plan.Children[i] = cbo.selectPhysicalOperators(child)
+// selectPhysicalOperators picks physical operators bottom-up, tracking the
+// physical properties of every node, and describes the sorts it avoided.
+func (cbo *CostBasedOptimizer) selectPhysicalOperators(plan *logical_plan.LogicalPlan, hints *planHints) (*logical_plan.LogicalPlan, []string) {
+	if plan == nil {
+		return nil, nil
 	}
-
-	return plan
+	planner := &physicalPlanner{catalog: cbo.catalogMgr, costModel: cbo.costModel, hints: hints}
+	result, _ := planner.choose(plan, nil)
+	return result, planner.details
 }
 
 func (cbo *CostBasedOptimizer) propagateCostEstimates(plan *logical_plan.LogicalPlan) {
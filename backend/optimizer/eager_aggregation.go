@@ -0,0 +1,271 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// EagerAggregationRule splits an aggregate over a join into a partial
+// aggregate below the join and a final one above it, so that the join sees
+// one row per group of the input the aggregates read instead of every row:
+// SUM(f.amount) GROUP BY d.region over f JOIN d ON f.d_id = d.id becomes
+// SUM(partial) GROUP BY d.region over (SUM(f.amount) GROUP BY f.d_id) JOIN d.
+// The partial aggregate groups by the columns of its input the join and the
+// final aggregate read, so each of its rows joins exactly as the rows it
+// replaces. Only SUM, COUNT, MIN and MAX split that way. The rule needs the
+// NDV of every partial group-by column and splits only when the cost model
+// estimates the result strictly cheaper.
+type EagerAggregationRule struct {
+	Catalog   *catalog.CatalogManager
+	CostModel cost_model.CostModel
+}
+
+func (r *EagerAggregationRule) Name() string {
+	return "EagerAggregation"
+}
+
+func (r *EagerAggregationRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+func (r *EagerAggregationRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	if r.Catalog == nil {
+		return plan, nil, nil
+	}
+	e := &eagerAggregator{catalog: r.Catalog, costModel: r.CostModel}
+	if e.costModel == nil {
+		e.costModel = cost_model.NewSimpleCostModel()
+	}
+	result, _, err := logical_plan.TransformDown(plan, func(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		if node.NodeType != logical_plan.NodeTypeAggregate {
+			return node, false, nil
+		}
+		return e.split(node)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, e.details, nil
+}
+
+type eagerAggregator struct {
+	catalog   *catalog.CatalogManager
+	costModel cost_model.CostModel
+	details   []string
+}
+
+// split tries to move part of aggregate below each input of the join under
+// it, and keeps the cheapest plan. The partial aggregate is visited again
+// afterwards, so it can move further down a tree of joins.
+func (e *eagerAggregator) split(aggregate *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	if len(aggregate.Children) != 1 || hasSubquery(aggregate) {
+		return aggregate, false, nil
+	}
+	for _, agg := range aggregate.Aggregates {
+		switch agg.Type {
+		case logical_plan.AggregateSum, logical_plan.AggregateCount, logical_plan.AggregateMin, logical_plan.AggregateMax:
+		default:
+			return aggregate, false, nil
+		}
+	}
+
+	// Projections of plain columns between the aggregate and the join only
+	// narrow the join's output, which the partial aggregate does as well.
+	join := aggregate.Children[0]
+	for join.NodeType == logical_plan.NodeTypeProject && len(join.Children) == 1 && plainProjection(join) {
+		join = join.Children[0]
+	}
+	if join.NodeType != logical_plan.NodeTypeJoin || len(join.Children) != 2 || hasSubquery(join) {
+		return aggregate, false, nil
+	}
+	bound, err := binder.Bind(aggregate, e.catalog)
+	if err != nil {
+		return aggregate, false, nil
+	}
+
+	best, err := e.costModel.EstimateCost(aggregate, e.catalog)
+	if err != nil {
+		return aggregate, false, nil
+	}
+	var result *logical_plan.LogicalPlan
+	var detail string
+	for side := 0; side < 2; side++ {
+		candidate, description, ok := e.splitAt(aggregate, join, side, bound)
+		if !ok {
+			continue
+		}
+		cost, err := e.costModel.EstimateCost(candidate, e.catalog)
+		if err != nil || cost.TotalCost >= best.TotalCost {
+			continue
+		}
+		detail = fmt.Sprintf("%s (cost %.2f to %.2f)", description, best.TotalCost, cost.TotalCost)
+		best, result = cost, candidate
+	}
+	if result == nil {
+		return aggregate, false, nil
+	}
+	e.details = append(e.details, detail)
+	return result, true, nil
+}
+
+// splitAt builds the plan with a partial aggregate on the given input of
+// join, or reports that the aggregates cannot be computed there.
+func (e *eagerAggregator) splitAt(aggregate, join *logical_plan.LogicalPlan, side int, bound *binder.BoundPlan) (*logical_plan.LogicalPlan, string, bool) {
+	switch join.JoinType {
+	case logical_plan.JoinTypeInner, "":
+	case logical_plan.JoinTypeLeft:
+		// The NULL-extended side must keep one row per unmatched row.
+		if side != 0 {
+			return nil, "", false
+		}
+	case logical_plan.JoinTypeRight:
+		if side != 1 {
+			return nil, "", false
+		}
+	default:
+		return nil, "", false
+	}
+
+	input := bound.Input(join)
+	leftWidth := len(bound.Output(join.Children[0]))
+	onSide := func(ref logical_plan.ColumnRef) (binder.Column, int, bool) {
+		index, err := input.Resolve(ref)
+		if err != nil {
+			return binder.Column{}, 0, false
+		}
+		if index < leftWidth {
+			return input[index], 0, true
+		}
+		return input[index], 1, true
+	}
+
+	for _, agg := range aggregate.Aggregates {
+		for _, ref := range binder.ExpressionReferences(agg.Column) {
+			if _, s, ok := onSide(ref); !ok || s != side {
+				return nil, "", false
+			}
+		}
+	}
+
+	// The partial aggregate groups by every column of its input read above
+	// it: the group-by columns and the columns the join compares.
+	var groupBy []logical_plan.Column
+	seen := make(map[string]bool)
+	addGroup := func(ref logical_plan.ColumnRef) bool {
+		column, s, ok := onSide(ref)
+		if !ok {
+			return false
+		}
+		if s != side {
+			return true
+		}
+		key := strings.ToLower(column.String())
+		if !seen[key] {
+			seen[key] = true
+			groupBy = append(groupBy, logical_plan.Column{Table: column.Relation, Name: column.Name})
+		}
+		return true
+	}
+	for _, column := range aggregate.GroupBy {
+		if !addGroup(logical_plan.ColumnRef{Table: column.Table, Name: column.Name}) {
+			return nil, "", false
+		}
+	}
+	for _, conjunct := range joinConjuncts(join) {
+		for _, ref := range binder.ExpressionReferences(conjunct) {
+			if !addGroup(ref) {
+				return nil, "", false
+			}
+		}
+	}
+	if len(groupBy) == 0 || !e.knownDistinctValues(join.Children[side], groupBy) {
+		return nil, "", false
+	}
+	// Without a GROUP BY, a COUNT over no joined rows is 0, but the SUM of
+	// partial counts that replaces it would be NULL.
+	if len(aggregate.GroupBy) == 0 {
+		for _, agg := range aggregate.Aggregates {
+			if agg.Type == logical_plan.AggregateCount {
+				return nil, "", false
+			}
+		}
+	}
+
+	partials := make([]logical_plan.AggregateFunction, len(aggregate.Aggregates))
+	finals := make([]logical_plan.AggregateFunction, len(aggregate.Aggregates))
+	for i, agg := range aggregate.Aggregates {
+		name := fmt.Sprintf("partial_%s_%d", strings.ToLower(string(agg.Type)), i+1)
+		partials[i] = logical_plan.AggregateFunction{Type: agg.Type, Column: agg.Column.Clone(), Alias: name}
+
+		finalType := agg.Type
+		if agg.Type == logical_plan.AggregateCount {
+			finalType = logical_plan.AggregateSum
+		}
+		alias := agg.Alias
+		if alias == "" {
+			alias = strings.ToLower(string(agg.Type))
+		}
+		finals[i] = logical_plan.AggregateFunction{Type: finalType, Column: logical_plan.NewColumnExpression("", name), Alias: alias}
+	}
+
+	partial := logical_plan.NewAggregateNode(join.Children[side].Clone(), groupBy, partials)
+	newJoin := join.Clone()
+	newJoin.Children[side] = partial
+	final := logical_plan.NewAggregateNode(newJoin, append([]logical_plan.Column(nil), aggregate.GroupBy...), finals)
+	final.ID = aggregate.ID
+
+	description := fmt.Sprintf("pre-aggregated %s by %s below %s",
+		relationList(join.Children[side]), joinColumnList(groupBy), join.Label())
+	return final, description, true
+}
+
+// knownDistinctValues reports whether the catalog has an NDV for every
+// column, so the partial aggregate's size is estimated rather than guessed.
+func (e *eagerAggregator) knownDistinctValues(input *logical_plan.LogicalPlan, columns []logical_plan.Column) bool {
+	bound, err := binder.Bind(input, e.catalog)
+	if err != nil {
+		return false
+	}
+	output := bound.Output(input)
+	for _, column := range columns {
+		index, err := output.Resolve(logical_plan.ColumnRef{Table: column.Table, Name: column.Name})
+		if err != nil || output[index].Table == "" {
+			return false
+		}
+		table, err := e.catalog.GetTable(output[index].Table)
+		if err != nil {
+			return false
+		}
+		stats := table.Column(output[index].SourceName)
+		if stats == nil || stats.NDV == nil || *stats.NDV <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func plainProjection(project *logical_plan.LogicalPlan) bool {
+	for _, column := range project.Projections {
+		if column.Expression != nil || column.Alias != "" || column.Name == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// relationList names the relations a subtree reads, for descriptions.
+func relationList(plan *logical_plan.LogicalPlan) string {
+	var names []string
+	for name := range relationNames(plan) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,203 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// HintReport says whether the optimizer followed a hint, and why not when it
+// did not.
+type HintReport struct {
+	Hint   string `json:"hint"`
+	Used   bool   `json:"used"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// planHints are the hints of a plan being optimized, checked against its
+// relations, with what became of each. When several hints target the same
+// join or relation the last one wins. A nil *planHints has no hints.
+type planHints struct {
+	hints   []*hintState
+	leading *hintState
+	joins   map[string]*hintState
+	scans   map[string]*hintState
+}
+
+type hintState struct {
+	hint   logical_plan.Hint
+	used   bool
+	reason string
+}
+
+func newPlanHints(plan *logical_plan.LogicalPlan) *planHints {
+	if plan == nil || len(plan.Hints) == 0 {
+		return nil
+	}
+	relations := make(map[string]bool)
+	for _, name := range plan.Relations() {
+		relations[strings.ToLower(name)] = true
+	}
+
+	h := &planHints{joins: make(map[string]*hintState), scans: make(map[string]*hintState)}
+	for _, hint := range plan.Hints {
+		state := &hintState{hint: hint}
+		h.hints = append(h.hints, state)
+		if state.reason = checkHint(hint, relations); state.reason != "" {
+			continue
+		}
+		var previous *hintState
+		switch {
+		case hint.Name == logical_plan.HintLeading:
+			previous, h.leading = h.leading, state
+		case hint.IsJoinMethodHint():
+			key := relationKey(hint.Arguments)
+			previous, h.joins[key] = h.joins[key], state
+		default:
+			key := strings.ToLower(hint.Arguments[0])
+			previous, h.scans[key] = h.scans[key], state
+		}
+		if previous != nil {
+			previous.reason = "overridden by " + hint.String()
+		}
+	}
+	return h
+}
+
+// checkHint returns why a hint cannot apply to a query with relations, or
+// nothing when it can.
+func checkHint(hint logical_plan.Hint, relations map[string]bool) string {
+	var named []string
+	switch {
+	case hint.Name == logical_plan.HintLeading || hint.IsJoinMethodHint():
+		if len(hint.Arguments) < 2 {
+			return "needs at least two relations"
+		}
+		named = hint.Arguments
+	case hint.IsScanHint():
+		if len(hint.Arguments) == 0 {
+			return "needs a relation"
+		}
+		named = hint.Arguments[:1]
+	default:
+		return "unknown hint"
+	}
+	seen := make(map[string]bool)
+	for _, name := range named {
+		if !relations[strings.ToLower(name)] {
+			return fmt.Sprintf("the query has no relation %s", name)
+		}
+		if seen[strings.ToLower(name)] {
+			return fmt.Sprintf("names %s twice", name)
+		}
+		seen[strings.ToLower(name)] = true
+	}
+	return ""
+}
+
+// relationKey identifies a set of relations regardless of order and case.
+func relationKey(relations []string) string {
+	return strings.Join(sortedLower(relations), ",")
+}
+
+func (h *planHints) leadingHint() *hintState {
+	if h == nil {
+		return nil
+	}
+	return h.leading
+}
+
+// forJoin returns the join method hint for the join of exactly the relations
+// under join.
+func (h *planHints) forJoin(join *logical_plan.LogicalPlan) *hintState {
+	if h == nil {
+		return nil
+	}
+	return h.joins[relationKey(join.Relations())]
+}
+
+func (h *planHints) forScan(scan *logical_plan.LogicalPlan) *hintState {
+	if h == nil {
+		return nil
+	}
+	return h.scans[strings.ToLower(scan.RelationName())]
+}
+
+func (s *hintState) use() {
+	s.used, s.reason = true, ""
+}
+
+// ignore records why a hint was not followed, unless it was followed
+// elsewhere.
+func (s *hintState) ignore(reason string) {
+	if !s.used {
+		s.reason = reason
+	}
+}
+
+// reports describes every hint, in the order given. Hints no stage could
+// apply get a reason from their kind.
+func (h *planHints) reports() []HintReport {
+	if h == nil {
+		return nil
+	}
+	reports := make([]HintReport, len(h.hints))
+	for i, state := range h.hints {
+		reports[i] = HintReport{Hint: state.hint.String(), Used: state.used, Reason: state.reason}
+		if state.used || state.reason != "" {
+			continue
+		}
+		switch {
+		case state.hint.Name == logical_plan.HintLeading:
+			reports[i].Reason = "its relations are not all inputs of one tree of inner joins"
+		case state.hint.IsJoinMethodHint():
+			reports[i].Reason = fmt.Sprintf("the plan has no join of exactly %s", strings.Join(state.hint.Arguments, ", "))
+		default:
+			reports[i].Reason = fmt.Sprintf("the plan does not scan %s", state.hint.Arguments[0])
+		}
+	}
+	return reports
+}
+
+// ignoredHints reports every hint of a plan as ignored for reason.
+func ignoredHints(hints []logical_plan.Hint, reason string) []HintReport {
+	var reports []HintReport
+	for _, hint := range hints {
+		reports = append(reports, HintReport{Hint: hint.String(), Reason: reason})
+	}
+	return reports
+}
+
+// hintedIndexes returns the table with only the indexes a scan hint allows:
+// none for SEQSCAN, the named ones or all for INDEXSCAN, and all but the
+// named ones or none for NO_INDEX.
+func hintedIndexes(table *catalog.TableSchema, hint *hintState) *catalog.TableSchema {
+	if hint == nil {
+		return table
+	}
+	named := func(index catalog.Index) bool {
+		for _, name := range hint.hint.Arguments[1:] {
+			if strings.EqualFold(name, index.Name) {
+				return true
+			}
+		}
+		return false
+	}
+	restricted := *table
+	restricted.Indexes = nil
+	for _, index := range table.Indexes {
+		switch hint.hint.Name {
+		case logical_plan.HintIndexScan:
+			if len(hint.hint.Arguments) == 1 || named(index) {
+				restricted.Indexes = append(restricted.Indexes, index)
+			}
+		case logical_plan.HintNoIndex:
+			if len(hint.hint.Arguments) > 1 && !named(index) {
+				restricted.Indexes = append(restricted.Indexes, index)
+			}
+		}
+	}
+	return &restricted
+}
@@ -0,0 +1,311 @@
+package optimizer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// JoinEliminationRule removes joins that neither add, drop nor duplicate rows
+// and whose columns nothing above reads: an outer join to a table that is
+// unique on the join key, and an inner join over a declared, non-nullable
+// foreign key to the table it references. It needs the catalog's keys.
+type JoinEliminationRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *JoinEliminationRule) Name() string {
+	return "JoinElimination"
+}
+
+func (r *JoinEliminationRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+func (r *JoinEliminationRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	if r.Catalog == nil {
+		return plan, nil, nil
+	}
+	bound, err := binder.Bind(plan, r.Catalog)
+	if err != nil {
+		return plan, nil, nil
+	}
+	e := &joinEliminator{bound: bound, catalog: r.Catalog}
+	plan = e.eliminate(plan, nil)
+	return plan, e.details, nil
+}
+
+type joinEliminator struct {
+	bound   *binder.BoundPlan
+	catalog *catalog.CatalogManager
+	details []string
+}
+
+// eliminate walks the plan top-down with the columns each node must produce,
+// worked out as in columnPruner, and replaces removable joins by the input
+// they keep.
+func (e *joinEliminator) eliminate(node *logical_plan.LogicalPlan, required []bool) *logical_plan.LogicalPlan {
+	if required != nil && len(required) != len(e.bound.Output(node)) {
+		required = nil
+	}
+
+	if node.NodeType == logical_plan.NodeTypeJoin && len(node.Children) == 2 && required != nil {
+		leftWidth := len(e.bound.Output(node.Children[0]))
+		if kept, detail := e.eliminateJoin(node, required, leftWidth); detail != "" {
+			e.details = append(e.details, detail)
+			if kept == 0 {
+				return e.eliminate(node.Children[0], required[:leftWidth])
+			}
+			return e.eliminate(node.Children[1], required[leftWidth:])
+		}
+	}
+
+	needed := (&columnPruner{bound: e.bound}).inputRequired(node, required)
+	offset := 0
+	for i, child := range node.Children {
+		width := len(e.bound.Output(child))
+		var childRequired []bool
+		if needed != nil && offset+width <= len(needed) {
+			childRequired = needed[offset : offset+width]
+		}
+		offset += width
+		node.Children[i] = e.eliminate(child, childRequired)
+	}
+	return node
+}
+
+// eliminateJoin returns the input a join can be replaced by, and a
+// description, or an empty description when the join must stay.
+func (e *joinEliminator) eliminateJoin(join *logical_plan.LogicalPlan, required []bool, leftWidth int) (int, string) {
+	unused := func(side int) bool {
+		if side == 0 {
+			return !slices.Contains(required[:leftWidth], true)
+		}
+		return !slices.Contains(required[leftWidth:], true)
+	}
+
+	switch join.JoinType {
+	case logical_plan.JoinTypeLeft:
+		if unused(1) {
+			if detail := e.uniqueOuterSide(join, 1, leftWidth); detail != "" {
+				return 0, detail
+			}
+		}
+	case logical_plan.JoinTypeRight:
+		if unused(0) {
+			if detail := e.uniqueOuterSide(join, 0, leftWidth); detail != "" {
+				return 1, detail
+			}
+		}
+	case logical_plan.JoinTypeInner, "":
+		for _, side := range []int{1, 0} {
+			if unused(side) {
+				if detail := e.foreignKeySide(join, side, leftWidth); detail != "" {
+					return 1 - side, detail
+				}
+			}
+		}
+	}
+	return 0, ""
+}
+
+// uniqueOuterSide checks that the NULL-extended side of an outer join matches
+// at most one row for each row of the preserved side, because the join
+// equates a unique key of its table with values from the preserved side.
+func (e *joinEliminator) uniqueOuterSide(join *logical_plan.LogicalPlan, side, leftWidth int) string {
+	scan := baseScan(join.Children[side], true)
+	if scan == nil {
+		return ""
+	}
+	table, err := e.catalog.GetTable(scan.TableName)
+	if err != nil {
+		return ""
+	}
+
+	var keys []string
+	for _, conjunct := range joinConjuncts(join) {
+		if conjunct.Kind != logical_plan.ExprBinaryOp || conjunct.BinaryOp != logical_plan.OpEq {
+			continue
+		}
+		for _, pair := range [][2]*logical_plan.Expression{{conjunct.Left, conjunct.Right}, {conjunct.Right, conjunct.Left}} {
+			column, ok := e.sideColumn(join, pair[0], leftWidth)
+			if !ok || column.side != side || !strings.EqualFold(column.Table, scan.TableName) {
+				continue
+			}
+			if sides, ok := e.sides(join, pair[1], leftWidth); ok && !sides[side] {
+				keys = append(keys, column.SourceName)
+			}
+		}
+	}
+	if !table.IsUnique(keys) {
+		return ""
+	}
+	return fmt.Sprintf("removed %s join to %s, which is unique on %s and unused above",
+		join.JoinType, scan.Label(), strings.Join(keys, ", "))
+}
+
+// foreignKeySide checks that every row of the other input of an inner join
+// matches exactly one row of side: side is a whole table, and the join only
+// equates its referenced key with a non-nullable foreign key of the other
+// input.
+func (e *joinEliminator) foreignKeySide(join *logical_plan.LogicalPlan, side, leftWidth int) string {
+	scan := baseScan(join.Children[side], false)
+	if scan == nil || !carriesBaseValues(join.Children[1-side]) {
+		return ""
+	}
+	conjuncts := joinConjuncts(join)
+	if len(conjuncts) == 0 {
+		return ""
+	}
+
+	var refColumns, columns []string
+	var fkTable string
+	for _, conjunct := range conjuncts {
+		if conjunct.Kind != logical_plan.ExprBinaryOp || conjunct.BinaryOp != logical_plan.OpEq {
+			return ""
+		}
+		left, okLeft := e.sideColumn(join, conjunct.Left, leftWidth)
+		right, okRight := e.sideColumn(join, conjunct.Right, leftWidth)
+		if !okLeft || !okRight || left.side == right.side {
+			return ""
+		}
+		ref, fk := left, right
+		if right.side == side {
+			ref, fk = right, left
+		}
+		if !strings.EqualFold(ref.Table, scan.TableName) || fk.Table == "" || (fkTable != "" && !strings.EqualFold(fk.Table, fkTable)) {
+			return ""
+		}
+		fkTable = fk.Table
+		refColumns = append(refColumns, ref.SourceName)
+		columns = append(columns, fk.SourceName)
+	}
+
+	referencing, err := e.catalog.GetTable(fkTable)
+	if err != nil {
+		return ""
+	}
+	referenced, err := e.catalog.GetTable(scan.TableName)
+	if err != nil {
+		return ""
+	}
+	for _, name := range columns {
+		if column := referencing.Column(name); column == nil || column.Nullable {
+			return ""
+		}
+	}
+	fk := referencing.ForeignKeyTo(columns, scan.TableName, refColumns)
+	if fk == nil || !referenced.IsUnique(fk.RefColumns) {
+		return ""
+	}
+	return fmt.Sprintf("removed inner join to %s over foreign key %s(%s), which is unused above",
+		scan.Label(), fkTable, strings.Join(columns, ", "))
+}
+
+type sideColumn struct {
+	binder.Column
+	side int
+}
+
+// sideColumn resolves an expression that is a plain column of a table to the
+// join input it comes from.
+func (e *joinEliminator) sideColumn(join *logical_plan.LogicalPlan, expr *logical_plan.Expression, leftWidth int) (sideColumn, bool) {
+	if expr == nil || !expr.IsColumn() {
+		return sideColumn{}, false
+	}
+	input := e.bound.Input(join)
+	index, err := input.Resolve(*expr.Column)
+	if err != nil || input[index].Table == "" || input[index].SourceName == "" {
+		return sideColumn{}, false
+	}
+	side := 0
+	if index >= leftWidth {
+		side = 1
+	}
+	return sideColumn{Column: input[index], side: side}, true
+}
+
+// sides reports which join inputs an expression reads from.
+func (e *joinEliminator) sides(join *logical_plan.LogicalPlan, expr *logical_plan.Expression, leftWidth int) ([2]bool, bool) {
+	var sides [2]bool
+	if containsSubquery(expr) {
+		return sides, false
+	}
+	input := e.bound.Input(join)
+	for _, ref := range binder.ExpressionReferences(expr) {
+		index, err := input.Resolve(ref)
+		if err != nil {
+			return sides, false
+		}
+		if index < leftWidth {
+			sides[0] = true
+		} else {
+			sides[1] = true
+		}
+	}
+	return sides, true
+}
+
+func joinConjuncts(join *logical_plan.LogicalPlan) []*logical_plan.Expression {
+	var conjuncts []*logical_plan.Expression
+	if join.JoinCondition != nil {
+		conjuncts = append(conjuncts, joinConditionExpression(join.JoinCondition))
+	}
+	if join.Predicate != nil {
+		conjuncts = append(conjuncts, logical_plan.SplitConjuncts(join.Predicate.Expression)...)
+	}
+	return conjuncts
+}
+
+// baseScan returns the scan a subtree reads every row from, looking through
+// projections and subquery aliases, and through filters and sorts when
+// allowFilters is set. It returns nil for anything else.
+func baseScan(node *logical_plan.LogicalPlan, allowFilters bool) *logical_plan.LogicalPlan {
+	for {
+		switch node.NodeType {
+		case logical_plan.NodeTypeScan:
+			return node
+		case logical_plan.NodeTypeProject, logical_plan.NodeTypeSubquery:
+			if hasSubquery(node) {
+				return nil
+			}
+		case logical_plan.NodeTypeFilter, logical_plan.NodeTypeSort:
+			if !allowFilters || hasSubquery(node) {
+				return nil
+			}
+		default:
+			return nil
+		}
+		if len(node.Children) != 1 {
+			return nil
+		}
+		node = node.Children[0]
+	}
+}
+
+// carriesBaseValues reports whether every value a subtree outputs for a table
+// column was read from that table: no outer join can have NULL-extended it,
+// and no union mixed in rows of other branches.
+func carriesBaseValues(node *logical_plan.LogicalPlan) bool {
+	switch node.NodeType {
+	case logical_plan.NodeTypeUnion:
+		return false
+	case logical_plan.NodeTypeJoin:
+		switch node.JoinType {
+		case logical_plan.JoinTypeInner, logical_plan.JoinTypeCross, logical_plan.JoinTypeSemi, logical_plan.JoinTypeAnti, "":
+		default:
+			return false
+		}
+	}
+	for _, child := range node.Children {
+		if !carriesBaseValues(child) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
+	"retr0-kernel/optiquery/enumerator"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// JoinReorderingRule hands every maximal tree of inner and cross joins, along
+// with the filters between them, to the plan enumerator. The original order is
+// kept unless the enumerator finds a strictly cheaper one.
+type JoinReorderingRule struct {
+	Catalog   *catalog.CatalogManager
+	CostModel cost_model.CostModel
+	// DPThreshold and MaxPlans bound the enumerator's search; zero keeps its
+	// defaults.
+	DPThreshold int
+	MaxPlans    int
+	// Leading names relations to join first, in order, in the join tree
+	// whose inputs include all of them.
+	Leading []string
+}
+
+// JoinOrderChoice records the orders considered for one join tree.
+type JoinOrderChoice struct {
+	Relations  []string               `json:"relations"`
+	Strategy   string                 `json:"strategy"`
+	Original   enumerator.JoinOrder   `json:"original"`
+	Chosen     enumerator.JoinOrder   `json:"chosen"`
+	Considered []enumerator.JoinOrder `json:"considered"`
+	Reordered  bool                   `json:"reordered"`
+	// Partial is set when the search was cut short by its context.
+	Partial bool `json:"partial,omitempty"`
+	// Implied lists join predicates inferred from column equalities, which
+	// give the enumerator edges between relations not joined directly.
+	Implied []string `json:"implied,omitempty"`
+	// Leading lists the relations the tree was made to join first.
+	Leading []string `json:"leading,omitempty"`
+}
+
+func (c JoinOrderChoice) String() string {
+	if !c.Reordered {
+		return fmt.Sprintf("kept %s (cost %.2f) for %s, %d orders considered by %s",
+			c.Original.Order, c.Original.Cost, strings.Join(c.Relations, ", "), len(c.Considered), c.Strategy)
+	}
+	return fmt.Sprintf("reordered %s (cost %.2f) to %s (cost %.2f), %d orders considered by %s",
+		c.Original.Order, c.Original.Cost, c.Chosen.Order, c.Chosen.Cost, len(c.Considered), c.Strategy)
+}
+
+func (r *JoinReorderingRule) Name() string {
+	return "JoinReordering"
+}
+
+func (r *JoinReorderingRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+// ApplyAndDescribe reports only the join trees that were reordered.
+func (r *JoinReorderingRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	result, choices, err := r.Reorder(context.Background(), plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	var details []string
+	for _, choice := range choices {
+		if choice.Reordered {
+			details = append(details, choice.String())
+		}
+	}
+	return result, details, nil
+}
+
+// Reorder reorders every join tree in the plan and returns what was
+// considered for each, including trees whose order was kept. Once ctx is done
+// each remaining tree gets the best order found in one greedy pass.
+func (r *JoinReorderingRule) Reorder(ctx context.Context, plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []JoinOrderChoice, error) {
+	catalogMgr := r.Catalog
+	if catalogMgr == nil {
+		catalogMgr = catalog.NewCatalogManager()
+	}
+	settings := enumerator.Settings{CostModel: r.CostModel, DPThreshold: r.DPThreshold, MaxPlans: r.MaxPlans}
+	reorderer := &joinReorderer{
+		ctx:        ctx,
+		leading:    r.Leading,
+		catalog:    r.Catalog,
+		enumerator: enumerator.NewPlanEnumeratorWithSettings(catalogMgr, settings),
+	}
+	result, err := reorderer.reorder(plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, reorderer.choices, nil
+}
+
+type joinReorderer struct {
+	ctx        context.Context
+	leading    []string
+	catalog    *catalog.CatalogManager
+	enumerator *enumerator.PlanEnumerator
+	choices    []JoinOrderChoice
+}
+
+// joinRegion is a tree of inner joins flattened into its inputs and the
+// conjuncts of every join condition and filter inside it.
+type joinRegion struct {
+	inputs    []*logical_plan.LogicalPlan
+	conjuncts []*logical_plan.Expression
+	tree      *enumerator.JoinTree
+}
+
+func (j *joinReorderer) reorder(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, error) {
+	if !isJoinRegion(node) {
+		for i, child := range node.Children {
+			result, err := j.reorder(child)
+			if err != nil {
+				return nil, err
+			}
+			node.Children[i] = result
+		}
+		return node, nil
+	}
+
+	region := &joinRegion{}
+	region.tree = region.collect(node)
+	for i, input := range region.inputs {
+		result, err := j.reorder(input)
+		if err != nil {
+			return nil, err
+		}
+		region.inputs[i] = result
+	}
+	return j.reorderRegion(node, region)
+}
+
+// isJoinRegion reports whether node is the top of an inner join tree: an
+// inner or cross join, or filters directly above one.
+func isJoinRegion(node *logical_plan.LogicalPlan) bool {
+	for node.NodeType == logical_plan.NodeTypeFilter && len(node.Children) == 1 {
+		node = node.Children[0]
+	}
+	return isInnerJoin(node)
+}
+
+func isInnerJoin(node *logical_plan.LogicalPlan) bool {
+	if node.NodeType != logical_plan.NodeTypeJoin || len(node.Children) != 2 {
+		return false
+	}
+	switch node.JoinType {
+	case logical_plan.JoinTypeInner, logical_plan.JoinTypeCross, "":
+		return true
+	}
+	return false
+}
+
+func (region *joinRegion) collect(node *logical_plan.LogicalPlan) *enumerator.JoinTree {
+	switch {
+	case node.NodeType == logical_plan.NodeTypeFilter && len(node.Children) == 1 && isJoinRegion(node.Children[0]):
+		if node.Predicate != nil {
+			region.conjuncts = append(region.conjuncts, logical_plan.SplitConjuncts(node.Predicate.Expression)...)
+		}
+		return region.collect(node.Children[0])
+
+	case isInnerJoin(node):
+		if node.JoinCondition != nil {
+			region.conjuncts = append(region.conjuncts, joinConditionExpression(node.JoinCondition))
+		}
+		if node.Predicate != nil {
+			region.conjuncts = append(region.conjuncts, logical_plan.SplitConjuncts(node.Predicate.Expression)...)
+		}
+		return &enumerator.JoinTree{
+			Left:  region.collect(node.Children[0]),
+			Right: region.collect(node.Children[1]),
+		}
+	}
+
+	region.inputs = append(region.inputs, node)
+	return &enumerator.JoinTree{Relation: len(region.inputs) - 1}
+}
+
+func joinConditionExpression(jc *logical_plan.JoinCondition) *logical_plan.Expression {
+	op, err := logical_plan.ParseBinaryOperator(jc.Operator)
+	if err != nil {
+		op = logical_plan.BinaryOperator(jc.Operator)
+	}
+	return logical_plan.NewBinaryOpExpression(op, jc.Left, jc.Right)
+}
+
+// reorderRegion places every conjunct: those reading one input become a
+// filter on it, those reading several become join predicates, and the rest
+// stay in a filter above the joins. Equalities implied by the region's
+// column equalities are added as join predicates too.
+func (j *joinReorderer) reorderRegion(node *logical_plan.LogicalPlan, region *joinRegion) (*logical_plan.LogicalPlan, error) {
+	resolver := newRelationResolver(region.inputs, j.catalog)
+	local := make([][]*logical_plan.Expression, len(region.inputs))
+	var predicates []enumerator.JoinPredicate
+	var residual []*logical_plan.Expression
+
+	implied := impliedEqualities(region.conjuncts, resolver)
+	for _, conjunct := range append(region.conjuncts, implied...) {
+		relations, ok := resolver.relations(conjunct)
+		switch {
+		case !ok || len(relations) == 0:
+			residual = append(residual, conjunct)
+		case len(relations) == 1:
+			local[relations[0]] = append(local[relations[0]], conjunct)
+		default:
+			predicate := enumerator.JoinPredicate{Expression: conjunct, Relations: relations}
+			if conjunct.Kind == logical_plan.ExprBinaryOp && conjunct.BinaryOp.IsComparison() {
+				predicate.LeftRelations, _ = resolver.relations(conjunct.Left)
+				predicate.RightRelations, _ = resolver.relations(conjunct.Right)
+			}
+			predicates = append(predicates, predicate)
+		}
+	}
+
+	relations := make([]enumerator.Relation, len(region.inputs))
+	names := make([]string, len(region.inputs))
+	for i, input := range region.inputs {
+		if len(local[i]) > 0 {
+			input = newFilter(input, local[i])
+		}
+		names[i] = logical_plan.JoinOrder(input)
+		relations[i] = enumerator.Relation{Name: names[i], Plan: input}
+	}
+
+	leading := leadingInputs(j.leading, region.inputs)
+	result, err := j.enumerator.OrderJoins(j.ctx, relations, predicates, region.tree, leading)
+	if err != nil {
+		return nil, fmt.Errorf("ordering joins of %s: %w", strings.Join(names, ", "), err)
+	}
+
+	choice := JoinOrderChoice{
+		Relations:  names,
+		Strategy:   result.Strategy,
+		Partial:    result.Partial,
+		Original:   *result.Original,
+		Chosen:     result.Chosen,
+		Considered: result.Considered,
+		Reordered:  result.Chosen.Order != result.Original.Order,
+	}
+	for _, equality := range implied {
+		choice.Implied = append(choice.Implied, equality.String())
+	}
+	if len(leading) > 0 {
+		choice.Leading = j.leading
+	}
+	j.choices = append(j.choices, choice)
+
+	if !choice.Reordered {
+		return node, nil
+	}
+	if len(residual) > 0 {
+		return newFilter(result.Plan, residual), nil
+	}
+	return result.Plan, nil
+}
+
+// leadingInputs finds the input reading each leading relation. It returns
+// nothing unless every relation is read by a different input.
+func leadingInputs(leading []string, inputs []*logical_plan.LogicalPlan) []int {
+	if len(leading) == 0 {
+		return nil
+	}
+	var indexes []int
+	taken := make(map[int]bool)
+	for _, name := range leading {
+		found := -1
+		for i, input := range inputs {
+			for _, relation := range input.Relations() {
+				if strings.EqualFold(relation, name) {
+					found = i
+				}
+			}
+		}
+		if found < 0 || taken[found] {
+			return nil
+		}
+		taken[found] = true
+		indexes = append(indexes, found)
+	}
+	return indexes
+}
@@ -0,0 +1,122 @@
+package optimizer
+
+import (
+	"fmt"
+
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// LimitPushdownRule fuses a limit over a sort into a top-N, and moves limits
+// closer to the scans: below projections, which keep every row, and as an
+// extra limit into the preserved side of an outer join or each branch of a
+// UNION ALL, which need at most limit+offset rows from it.
+type LimitPushdownRule struct{}
+
+func (r *LimitPushdownRule) Name() string {
+	return "LimitPushdown"
+}
+
+func (r *LimitPushdownRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+func (r *LimitPushdownRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	var details []string
+	result, _, err := logical_plan.TransformDown(plan, func(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		result, detail := pushLimit(node)
+		if detail == "" {
+			return node, false, nil
+		}
+		details = append(details, detail)
+		return result, true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, details, nil
+}
+
+// pushLimit rewrites a limit node and describes what it did, or returns an
+// empty description when it cannot move.
+func pushLimit(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, string) {
+	if node.NodeType != logical_plan.NodeTypeLimit || len(node.Children) != 1 || node.LimitCount == nil {
+		return node, ""
+	}
+	child := node.Children[0]
+	if len(child.Children) == 0 {
+		return node, ""
+	}
+	fetch := *node.LimitCount
+	if node.OffsetCount != nil {
+		fetch += *node.OffsetCount
+	}
+
+	switch child.NodeType {
+	case logical_plan.NodeTypeSort:
+		topN := logical_plan.NewTopNNode(child.Children[0], child.OrderBy, node.LimitCount, node.OffsetCount)
+		return topN, fmt.Sprintf("fused %s and %s into %s", child.Label(), node.Label(), topN.Label())
+
+	case logical_plan.NodeTypeProject:
+		if hasSubquery(child) {
+			return node, ""
+		}
+		node.Children[0] = child.Children[0]
+		child.Children[0] = node
+		return child, fmt.Sprintf("moved %s below projection", node.Label())
+
+	case logical_plan.NodeTypeJoin:
+		var side int
+		switch child.JoinType {
+		case logical_plan.JoinTypeLeft:
+			side = 0
+		case logical_plan.JoinTypeRight:
+			side = 1
+		default:
+			return node, ""
+		}
+		if len(child.Children) != 2 || !limitInput(child, side, fetch) {
+			return node, ""
+		}
+		return node, fmt.Sprintf("pushed limit %d into the preserved side of %s join", fetch, child.JoinType)
+
+	case logical_plan.NodeTypeUnion:
+		if !child.UnionAll {
+			return node, ""
+		}
+		pushed := 0
+		for i := range child.Children {
+			if limitInput(child, i, fetch) {
+				pushed++
+			}
+		}
+		if pushed == 0 {
+			return node, ""
+		}
+		return node, fmt.Sprintf("pushed limit %d into %d branches of union all", fetch, pushed)
+	}
+
+	return node, ""
+}
+
+// limitInput caps the i-th input of node at fetch rows, unless it is already
+// capped at that many or fewer.
+func limitInput(node *logical_plan.LogicalPlan, i int, fetch int64) bool {
+	input := node.Children[i]
+	if isLimitedTo(input, fetch) {
+		return false
+	}
+	node.Children[i] = logical_plan.NewLimitNode(input, &fetch, nil)
+	return true
+}
+
+func isLimitedTo(node *logical_plan.LogicalPlan, fetch int64) bool {
+	for node.NodeType == logical_plan.NodeTypeProject && len(node.Children) == 1 {
+		node = node.Children[0]
+	}
+	switch node.NodeType {
+	case logical_plan.NodeTypeLimit, logical_plan.NodeTypeTopN:
+		return node.LimitCount != nil && *node.LimitCount <= fetch
+	}
+	return false
+}
@@ -0,0 +1,965 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// MaterializedViewRewriteRule answers parts of a query from the materialized
+// views in the catalog. A view matches a block of inner joins, filters and
+// scans, optionally under an aggregate, when it reads the same tables, each
+// of its conditions follows from the block's, and it outputs every column
+// the rest of the query reads. Conditions of the block the view does not
+// apply are applied to its rows by a compensating filter. An aggregate that
+// groups by fewer columns than the view rolls up the view's groups: SUM and
+// COUNT become SUMs of the view's columns, MIN and MAX stay as they are. A
+// view replaces the block only when reading it is estimated strictly cheaper.
+type MaterializedViewRewriteRule struct {
+	Catalog   *catalog.CatalogManager
+	CostModel cost_model.CostModel
+}
+
+// ViewMatch records whether a materialized view answered part of a query,
+// and why not when it did not.
+type ViewMatch struct {
+	View string `json:"view"`
+	Used bool   `json:"used"`
+	// Replaced describes the part of the query the view was matched against.
+	Replaced string `json:"replaced,omitempty"`
+	// Compensation lists the conditions applied to the view's rows.
+	Compensation []string `json:"compensation,omitempty"`
+	// Rollup lists the columns the view's groups were aggregated to.
+	Rollup   []string `json:"rollup,omitempty"`
+	Cost     float64  `json:"cost,omitempty"`
+	ViewCost float64  `json:"view_cost,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+func (m ViewMatch) String() string {
+	description := fmt.Sprintf("answered %s from %s", m.Replaced, m.View)
+	if m.Rollup != nil {
+		description += fmt.Sprintf(", rolled up to (%s)", strings.Join(m.Rollup, ", "))
+	}
+	if len(m.Compensation) > 0 {
+		description += fmt.Sprintf(", filtered by %s", strings.Join(m.Compensation, " AND "))
+	}
+	return fmt.Sprintf("%s (cost %.2f to %.2f)", description, m.Cost, m.ViewCost)
+}
+
+func (r *MaterializedViewRewriteRule) Name() string {
+	return "MaterializedViewRewrite"
+}
+
+func (r *MaterializedViewRewriteRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+// ApplyAndDescribe reports only the views that were used.
+func (r *MaterializedViewRewriteRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	result, matches, err := r.Rewrite(plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	var details []string
+	for _, match := range matches {
+		if match.Used {
+			details = append(details, match.String())
+		}
+	}
+	return result, details, nil
+}
+
+// Rewrite replaces the parts of the plan views answer more cheaply, and
+// reports for every view whether it was used.
+func (r *MaterializedViewRewriteRule) Rewrite(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []ViewMatch, error) {
+	if r.Catalog == nil || plan == nil {
+		return plan, nil, nil
+	}
+	m := &viewMatcher{
+		catalog:   r.Catalog,
+		costModel: r.CostModel,
+		matches:   make(map[string][]ViewMatch),
+		mapping:   make(map[string]logical_plan.ColumnRef),
+		replaced:  make(map[string]string),
+	}
+	if m.costModel == nil {
+		m.costModel = cost_model.NewSimpleCostModel()
+	}
+	var broken []ViewMatch
+	for _, view := range r.Catalog.GetMaterializedViews() {
+		described, err := describeView(view.Name, view.Definition, r.Catalog)
+		if err != nil {
+			broken = append(broken, ViewMatch{View: view.Name, Reason: err.Error()})
+			continue
+		}
+		m.views = append(m.views, described)
+	}
+	if len(m.views) == 0 {
+		return plan, broken, nil
+	}
+
+	work := plan.Clone()
+	if reason := m.prepare(work); reason != "" {
+		reports := broken
+		for _, view := range m.views {
+			reports = append(reports, ViewMatch{View: view.name, Reason: reason})
+		}
+		return plan, reports, nil
+	}
+	result := m.rewrite(work)
+	if len(m.mapping) > 0 || len(m.replaced) > 0 {
+		for _, node := range m.visited {
+			m.rewriteReferences(node)
+		}
+	}
+	return result, append(broken, m.reports()...), nil
+}
+
+// matchableView is a materialized view with its definition normalized for
+// matching.
+type matchableView struct {
+	name    string
+	block   *viewBlock
+	columns []viewColumn
+}
+
+// viewColumn is an output column of a view: a table column, identified by
+// its key, or one of the view's aggregates.
+type viewColumn struct {
+	name      string
+	key       string
+	aggregate int
+	dataType  logical_plan.DataType
+}
+
+// viewBlock is a block of inner joins, filters and scans, optionally under
+// an aggregate, with every column named table.column in lower case, so that
+// blocks over the same tables compare regardless of aliases. Each table may
+// be read only once.
+type viewBlock struct {
+	tables []string
+	// equalities are the conditions comparing two columns with =, which
+	// classes groups.
+	equalities [][2]string
+	classes    columnClasses
+	conjuncts  []*logical_plan.Expression
+	aggregated bool
+	groupBy    []string
+	// aggregates have their output name as Alias.
+	aggregates []logical_plan.AggregateFunction
+}
+
+// MaterializedViewSchema checks that a view definition is a query views can
+// be matched by, and returns the table the view's rows are read from: one
+// column per output column of the definition.
+func MaterializedViewSchema(name string, definition *logical_plan.LogicalPlan, cm *catalog.CatalogManager) (*catalog.TableSchema, error) {
+	view, err := describeView(name, definition, cm)
+	if err != nil {
+		return nil, err
+	}
+	schema := &catalog.TableSchema{Name: name}
+	for _, column := range view.columns {
+		schema.Columns = append(schema.Columns, catalog.Column{
+			Name:     column.name,
+			DataType: catalog.DataType(column.dataType),
+			Nullable: true,
+		})
+	}
+	return schema, nil
+}
+
+func describeView(name string, definition *logical_plan.LogicalPlan, cm *catalog.CatalogManager) (*matchableView, error) {
+	if definition == nil {
+		return nil, fmt.Errorf("materialized view %s has no definition", name)
+	}
+	bound, err := binder.Bind(definition, cm)
+	if err != nil {
+		return nil, fmt.Errorf("materialized view %s: %v", name, err)
+	}
+	root := definition
+	if root.NodeType == logical_plan.NodeTypeProject && len(root.Children) == 1 {
+		root = root.Children[0]
+	}
+	block, reason := normalizeBlock(root, bound)
+	if reason != "" {
+		return nil, fmt.Errorf("materialized view %s must select from inner joins of tables, optionally grouped, but %s", name, reason)
+	}
+
+	view := &matchableView{name: name, block: block}
+	output := bound.Output(root)
+	groupWidth := 0
+	if block.aggregated {
+		groupWidth = len(block.groupBy)
+	}
+	describe := func(index int, name string) viewColumn {
+		column := viewColumn{name: name, aggregate: -1, dataType: output[index].DataType}
+		if index >= groupWidth && block.aggregated {
+			column.aggregate = index - groupWidth
+		} else {
+			column.key = strings.ToLower(output[index].Table + "." + output[index].SourceName)
+		}
+		return column
+	}
+	if root == definition {
+		for i, column := range output {
+			view.columns = append(view.columns, describe(i, column.Name))
+		}
+	} else {
+		for _, projection := range definition.Projections {
+			switch {
+			case projection.Expression != nil:
+				return nil, fmt.Errorf("materialized view %s must not compute %s in its select list", name, projection)
+			case projection.Name == "*":
+				for i, column := range output {
+					if projection.Table == "" || strings.EqualFold(column.Relation, projection.Table) || strings.EqualFold(column.Table, projection.Table) {
+						view.columns = append(view.columns, describe(i, column.Name))
+					}
+				}
+				continue
+			}
+			index, err := output.Resolve(logical_plan.ColumnRef{Table: projection.Table, Name: projection.Name})
+			if err != nil {
+				return nil, fmt.Errorf("materialized view %s: %v", name, err)
+			}
+			columnName := projection.Alias
+			if columnName == "" {
+				columnName = output[index].Name
+			}
+			view.columns = append(view.columns, describe(index, columnName))
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, column := range view.columns {
+		if seen[strings.ToLower(column.name)] {
+			return nil, fmt.Errorf("materialized view %s has two columns named %s, give one an alias", name, column.name)
+		}
+		seen[strings.ToLower(column.name)] = true
+	}
+	return view, nil
+}
+
+// normalizeBlock describes the block rooted at node, or says why it is not
+// one.
+func normalizeBlock(node *logical_plan.LogicalPlan, bound *binder.BoundPlan) (*viewBlock, string) {
+	b := &viewBlock{classes: make(columnClasses)}
+	spj := node
+	if node.NodeType == logical_plan.NodeTypeAggregate {
+		if len(node.Children) != 1 {
+			return nil, "its aggregate has no input"
+		}
+		b.aggregated = true
+		scope := bound.Input(node)
+		for _, column := range node.GroupBy {
+			key, ok := columnKey(scope, logical_plan.ColumnRef{Table: column.Table, Name: column.Name})
+			if !ok {
+				return nil, fmt.Sprintf("it groups by %s, which is not a table column", column)
+			}
+			b.groupBy = append(b.groupBy, key)
+		}
+		for _, agg := range node.Aggregates {
+			normalized := logical_plan.AggregateFunction{Type: agg.Type, Alias: aggregateName(agg)}
+			if agg.Column != nil {
+				expr, ok := canonicalExpression(agg.Column, scope)
+				if !ok {
+					return nil, fmt.Sprintf("it aggregates %s, which reads more than table columns", agg.Column)
+				}
+				normalized.Column = expr
+			}
+			b.aggregates = append(b.aggregates, normalized)
+		}
+		spj = node.Children[0]
+	}
+
+	tables := make(map[string]bool)
+	var reason string
+	var walk func(n *logical_plan.LogicalPlan) bool
+	walk = func(n *logical_plan.LogicalPlan) bool {
+		var conjuncts []*logical_plan.Expression
+		switch n.NodeType {
+		case logical_plan.NodeTypeScan:
+			table := strings.ToLower(n.TableName)
+			if tables[table] {
+				reason = fmt.Sprintf("it reads table %s twice", n.TableName)
+				return false
+			}
+			tables[table] = true
+			if n.Predicate != nil {
+				conjuncts = logical_plan.SplitConjuncts(n.Predicate.Expression)
+			}
+		case logical_plan.NodeTypeFilter:
+			if n.Predicate != nil {
+				conjuncts = logical_plan.SplitConjuncts(n.Predicate.Expression)
+			}
+		case logical_plan.NodeTypeJoin:
+			if n.JoinType != logical_plan.JoinTypeInner && n.JoinType != logical_plan.JoinTypeCross && n.JoinType != "" {
+				reason = fmt.Sprintf("it has a %s join", n.JoinType)
+				return false
+			}
+			conjuncts = joinConjuncts(n)
+		case logical_plan.NodeTypeProject:
+			if !plainProjection(n) {
+				reason = "it computes columns below its joins"
+				return false
+			}
+		default:
+			reason = fmt.Sprintf("it has a %s node", n.NodeType)
+			return false
+		}
+		if hasSubquery(n) {
+			reason = "it has a subquery"
+			return false
+		}
+		for _, conjunct := range conjuncts {
+			if !b.addConjunct(conjunct, bound.Input(n)) {
+				reason = fmt.Sprintf("its condition %s reads more than table columns", conjunct)
+				return false
+			}
+		}
+		for _, child := range n.Children {
+			if !walk(child) {
+				return false
+			}
+		}
+		return true
+	}
+	if !walk(spj) {
+		return nil, reason
+	}
+	for table := range tables {
+		b.tables = append(b.tables, table)
+	}
+	sort.Strings(b.tables)
+	return b, ""
+}
+
+func (b *viewBlock) addConjunct(conjunct *logical_plan.Expression, scope binder.Scope) bool {
+	canonical, ok := canonicalExpression(conjunct, scope)
+	if !ok {
+		return false
+	}
+	if canonical.Kind == logical_plan.ExprBinaryOp && canonical.BinaryOp == logical_plan.OpEq &&
+		canonical.Left.IsColumn() && canonical.Right.IsColumn() {
+		pair := [2]string{canonical.Left.Column.String(), canonical.Right.Column.String()}
+		b.equalities = append(b.equalities, pair)
+		b.classes.union(pair[0], pair[1])
+		return true
+	}
+	b.conjuncts = append(b.conjuncts, canonical)
+	return true
+}
+
+// columnKey names the table column a reference resolves to as
+// table.column, in lower case.
+func columnKey(scope binder.Scope, ref logical_plan.ColumnRef) (string, bool) {
+	index, err := scope.Resolve(ref)
+	if err != nil || scope[index].Table == "" {
+		return "", false
+	}
+	return strings.ToLower(scope[index].Table + "." + scope[index].SourceName), true
+}
+
+// canonicalExpression replaces the column references of expr by their
+// keys, or fails when one is not a table column.
+func canonicalExpression(expr *logical_plan.Expression, scope binder.Scope) (*logical_plan.Expression, bool) {
+	ok := true
+	result, _, _ := logical_plan.TransformExpression(expr.Clone(), func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if !e.IsColumn() {
+			return e, false, nil
+		}
+		key, found := columnKey(scope, *e.Column)
+		if !found {
+			ok = false
+			return e, false, nil
+		}
+		return logical_plan.NewColumnExpression("", key), true, nil
+	})
+	return result, ok
+}
+
+func aggregateName(agg logical_plan.AggregateFunction) string {
+	if agg.Alias != "" {
+		return agg.Alias
+	}
+	return strings.ToLower(string(agg.Type))
+}
+
+// columnClasses groups the column keys equality conditions make equal.
+type columnClasses map[string]string
+
+func (c columnClasses) find(key string) string {
+	for {
+		parent, ok := c[key]
+		if !ok || parent == key {
+			return key
+		}
+		key = parent
+	}
+}
+
+func (c columnClasses) union(a, b string) {
+	if ra, rb := c.find(a), c.find(b); ra != rb {
+		c[ra] = rb
+	}
+}
+
+// normalize names every column of expr by the representative of its class.
+func (c columnClasses) normalize(expr *logical_plan.Expression) *logical_plan.Expression {
+	result, _, _ := logical_plan.TransformExpression(expr.Clone(), func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if !e.IsColumn() {
+			return e, false, nil
+		}
+		return logical_plan.NewColumnExpression("", c.find(e.Column.String())), true, nil
+	})
+	return result
+}
+
+// implies reports whether the condition q guarantees v: they are the same,
+// or both compare the same column with a constant and every value q allows
+// v allows too.
+func implies(q, v *logical_plan.Expression) bool {
+	if sameExpression(q, v) {
+		return true
+	}
+	qColumn, qOp, qValue, ok := literalComparison(q)
+	if !ok {
+		return false
+	}
+	vColumn, vOp, vValue, ok := literalComparison(v)
+	if !ok || qColumn.String() != vColumn.String() {
+		return false
+	}
+	cmp, ok := compareLiterals(qValue, vValue)
+	if !ok {
+		return false
+	}
+	switch vOp {
+	case logical_plan.OpGt:
+		return (qOp == logical_plan.OpGt && cmp >= 0) || ((qOp == logical_plan.OpGtEq || qOp == logical_plan.OpEq) && cmp > 0)
+	case logical_plan.OpGtEq:
+		return (qOp == logical_plan.OpGt || qOp == logical_plan.OpGtEq || qOp == logical_plan.OpEq) && cmp >= 0
+	case logical_plan.OpLt:
+		return (qOp == logical_plan.OpLt && cmp <= 0) || ((qOp == logical_plan.OpLtEq || qOp == logical_plan.OpEq) && cmp < 0)
+	case logical_plan.OpLtEq:
+		return (qOp == logical_plan.OpLt || qOp == logical_plan.OpLtEq || qOp == logical_plan.OpEq) && cmp <= 0
+	case logical_plan.OpEq:
+		return qOp == logical_plan.OpEq && cmp == 0
+	}
+	return false
+}
+
+type viewMatcher struct {
+	catalog   *catalog.CatalogManager
+	costModel cost_model.CostModel
+	views     []*matchableView
+
+	root   *logical_plan.LogicalPlan
+	bound  *binder.BoundPlan
+	refs   map[*logical_plan.LogicalPlan][]binder.Column
+	output binder.Scope
+	// relations are the relation names in use, lower-cased.
+	relations map[string]bool
+	// visited are the nodes outside the replaced blocks, whose references
+	// into them are rewritten to the views' columns.
+	visited []*logical_plan.LogicalPlan
+
+	// mapping takes relation.column of a replaced block, lower-cased, to the
+	// column of the view that holds it, and replaced a relation of a
+	// replaced block to the relation of its view.
+	mapping  map[string]logical_plan.ColumnRef
+	replaced map[string]string
+	matches  map[string][]ViewMatch
+}
+
+// prepare binds the plan and records the columns each node reads, or says
+// why views cannot be matched against it.
+func (m *viewMatcher) prepare(plan *logical_plan.LogicalPlan) string {
+	bound, err := binder.Bind(plan, m.catalog)
+	if err != nil {
+		return "the query's columns could not be resolved"
+	}
+	m.root, m.bound, m.output = plan, bound, bound.Output(plan)
+	m.refs = make(map[*logical_plan.LogicalPlan][]binder.Column)
+	m.relations = make(map[string]bool)
+	var reason string
+	var walk func(node *logical_plan.LogicalPlan)
+	walk = func(node *logical_plan.LogicalPlan) {
+		if node.NodeType == logical_plan.NodeTypeScan {
+			name := strings.ToLower(node.RelationName())
+			if m.relations[name] {
+				reason = fmt.Sprintf("the query reads relation %s twice", node.RelationName())
+			}
+			m.relations[name] = true
+		}
+		scope := bound.Input(node)
+		for _, ref := range binder.References(node) {
+			if index, err := scope.Resolve(ref); err == nil {
+				m.refs[node] = append(m.refs[node], scope[index])
+			}
+		}
+		for _, projection := range node.Projections {
+			if projection.Name != "*" || projection.Expression != nil {
+				continue
+			}
+			for _, column := range scope {
+				if projection.Table == "" || strings.EqualFold(column.Relation, projection.Table) || strings.EqualFold(column.Table, projection.Table) {
+					m.refs[node] = append(m.refs[node], column)
+				}
+			}
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(plan)
+	return reason
+}
+
+// rewrite replaces the outermost blocks a view answers more cheaply,
+// top-down, and returns the node or its replacement.
+func (m *viewMatcher) rewrite(node *logical_plan.LogicalPlan) *logical_plan.LogicalPlan {
+	if replacement := m.replace(node); replacement != nil {
+		return replacement
+	}
+	m.visited = append(m.visited, node)
+	for i, child := range node.Children {
+		node.Children[i] = m.rewrite(child)
+	}
+	return node
+}
+
+// viewRewrite is a block answered from a view.
+type viewRewrite struct {
+	plan     *logical_plan.LogicalPlan
+	relation string
+	mapping  map[string]logical_plan.ColumnRef
+	match    ViewMatch
+}
+
+func (m *viewMatcher) replace(node *logical_plan.LogicalPlan) *logical_plan.LogicalPlan {
+	block, reason := normalizeBlock(node, m.bound)
+	if reason != "" {
+		return nil
+	}
+	description := describeBlock(node, block)
+	original, err := m.costModel.EstimateCost(node, m.catalog)
+	if err != nil {
+		return nil
+	}
+
+	var best *viewRewrite
+	var cheaper []ViewMatch
+	for _, view := range m.views {
+		// An aggregate's input is matched separately against views that do
+		// not aggregate.
+		if !equalStrings(view.block.tables, block.tables) || (block.aggregated && !view.block.aggregated) {
+			continue
+		}
+		candidate, reason := m.match(node, block, view)
+		if reason != "" {
+			m.record(ViewMatch{View: view.name, Replaced: description, Reason: reason})
+			continue
+		}
+		candidate.match.Replaced = description
+		candidate.match.Cost = original.TotalCost
+		cost, err := m.costModel.EstimateCost(candidate.plan, m.catalog)
+		if err != nil {
+			continue
+		}
+		candidate.match.ViewCost = cost.TotalCost
+		if cost.TotalCost >= original.TotalCost {
+			candidate.match.Reason = fmt.Sprintf("reading the view would cost %.2f, not less than %.2f", cost.TotalCost, original.TotalCost)
+			m.record(candidate.match)
+			continue
+		}
+		if best != nil {
+			if best.match.ViewCost <= cost.TotalCost {
+				cheaper = append(cheaper, candidate.match)
+				continue
+			}
+			cheaper = append(cheaper, best.match)
+		}
+		best = candidate
+	}
+	if best == nil {
+		return nil
+	}
+	for _, match := range cheaper {
+		match.Reason = fmt.Sprintf("%s answers it more cheaply", best.match.View)
+		m.record(match)
+	}
+
+	best.match.Used = true
+	m.record(best.match)
+	// Views over some of the block's tables are not matched inside it.
+	for _, view := range m.views {
+		if _, ok := m.matches[view.name]; !ok && readsAll(block.tables, view.block.tables) {
+			m.record(ViewMatch{View: view.name, Reason: fmt.Sprintf("%s answered the %s its tables are read in", best.match.View, description)})
+		}
+	}
+	m.relations[strings.ToLower(best.relation)] = true
+	for key, ref := range best.mapping {
+		m.mapping[key] = ref
+	}
+	for relation := range relationNames(node) {
+		m.replaced[relation] = best.relation
+	}
+	return best.plan
+}
+
+// match builds the plan answering the block from view, or says why the view
+// cannot answer it.
+func (m *viewMatcher) match(node *logical_plan.LogicalPlan, query *viewBlock, view *matchableView) (*viewRewrite, string) {
+	if view.block.aggregated && !query.aggregated {
+		return nil, "the view aggregates rows the query reads individually"
+	}
+	for _, pair := range view.block.equalities {
+		if query.classes.find(pair[0]) != query.classes.find(pair[1]) {
+			return nil, fmt.Sprintf("the view requires %s = %s, which the query does not", pair[0], pair[1])
+		}
+	}
+	queryConjuncts := make([]*logical_plan.Expression, len(query.conjuncts))
+	for i, conjunct := range query.conjuncts {
+		queryConjuncts[i] = query.classes.normalize(conjunct)
+	}
+	viewConjuncts := make([]*logical_plan.Expression, len(view.block.conjuncts))
+	for i, conjunct := range view.block.conjuncts {
+		viewConjuncts[i] = query.classes.normalize(conjunct)
+		implied := false
+		for _, q := range queryConjuncts {
+			if implies(q, viewConjuncts[i]) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			return nil, fmt.Sprintf("the view keeps only rows where %s, which the query does not require", conjunct)
+		}
+	}
+
+	r := &viewRewrite{
+		relation: m.viewRelation(view.name),
+		mapping:  make(map[string]logical_plan.ColumnRef),
+		match:    ViewMatch{View: view.name},
+	}
+	// column finds the view column holding a table column, or one the view
+	// makes equal to it.
+	column := func(key string) (logical_plan.ColumnRef, bool) {
+		var found *viewColumn
+		for i, c := range view.columns {
+			if c.key == "" || view.block.classes.find(c.key) != view.block.classes.find(key) {
+				continue
+			}
+			if found == nil || c.key == key {
+				found = &view.columns[i]
+			}
+		}
+		if found == nil {
+			return logical_plan.ColumnRef{}, false
+		}
+		return logical_plan.ColumnRef{Table: r.relation, Name: found.name}, true
+	}
+
+	var compensation []*logical_plan.Expression
+	for _, pair := range query.equalities {
+		if view.block.classes.find(pair[0]) != view.block.classes.find(pair[1]) {
+			compensation = append(compensation, logical_plan.NewBinaryOpExpression(logical_plan.OpEq,
+				logical_plan.NewColumnExpression("", pair[0]), logical_plan.NewColumnExpression("", pair[1])))
+		}
+	}
+	for i, conjunct := range query.conjuncts {
+		applied := false
+		for _, v := range viewConjuncts {
+			if sameExpression(queryConjuncts[i], v) {
+				applied = true
+				break
+			}
+		}
+		if !applied {
+			compensation = append(compensation, conjunct)
+		}
+	}
+	var filters []*logical_plan.Expression
+	for _, conjunct := range compensation {
+		var missing string
+		translated, _, _ := logical_plan.TransformExpression(conjunct.Clone(), func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+			if !e.IsColumn() {
+				return e, false, nil
+			}
+			ref, ok := column(e.Column.String())
+			if !ok {
+				missing = e.Column.String()
+				return e, false, nil
+			}
+			return logical_plan.NewColumnExpression(ref.Table, ref.Name), true, nil
+		})
+		if missing != "" {
+			return nil, fmt.Sprintf("the query requires %s, but the view does not output %s", conjunct, missing)
+		}
+		filters = append(filters, translated)
+		r.match.Compensation = append(r.match.Compensation, translated.String())
+	}
+
+	alias := ""
+	if r.relation != view.name {
+		alias = r.relation
+	}
+	input := logical_plan.NewScanNode(view.name, alias)
+	if len(filters) > 0 {
+		input = newFilter(input, filters)
+	}
+
+	if !query.aggregated {
+		for _, needed := range m.needed(node) {
+			ref, ok := column(strings.ToLower(needed.Table + "." + needed.SourceName))
+			if !ok {
+				return nil, fmt.Sprintf("the query reads %s, which the view does not output", needed)
+			}
+			r.mapping[strings.ToLower(needed.Relation+"."+needed.Name)] = ref
+		}
+		r.plan = input
+		r.plan.ID = node.ID
+		return r, ""
+	}
+
+	scope := m.bound.Input(node)
+	var groupBy []logical_plan.Column
+	groups := make(map[string]bool)
+	for i, key := range query.groupBy {
+		ref, ok := column(key)
+		if !ok {
+			return nil, fmt.Sprintf("the query groups by %s, which the view does not output", key)
+		}
+		if index, err := scope.Resolve(logical_plan.ColumnRef{Table: node.GroupBy[i].Table, Name: node.GroupBy[i].Name}); err == nil {
+			r.mapping[strings.ToLower(scope[index].Relation+"."+scope[index].Name)] = ref
+		}
+		groups[view.block.classes.find(key)] = true
+		groupBy = append(groupBy, logical_plan.Column{Table: ref.Table, Name: ref.Name})
+	}
+	viewGroups := make(map[string]bool)
+	for _, key := range view.block.groupBy {
+		viewGroups[view.block.classes.find(key)] = true
+	}
+	rollup := len(groups) < len(viewGroups)
+
+	aggregates := make([]logical_plan.AggregateFunction, len(query.aggregates))
+	projections := append([]logical_plan.Column(nil), groupBy...)
+	for i, agg := range query.aggregates {
+		label := logical_plan.AggregateFunction{Type: agg.Type, Column: node.Aggregates[i].Column}.String()
+		var found *viewColumn
+		for j, c := range view.columns {
+			if c.aggregate < 0 {
+				continue
+			}
+			candidate := view.block.aggregates[c.aggregate]
+			if candidate.Type == agg.Type && sameAggregateArgument(candidate.Column, agg.Column, view.block.classes) {
+				found = &view.columns[j]
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Sprintf("the view does not output %s", label)
+		}
+		if rollup && agg.Type == logical_plan.AggregateAvg {
+			return nil, fmt.Sprintf("%s cannot be rolled up from the view's groups", label)
+		}
+		// A global COUNT over no rows is 0, but the SUM of no counts is NULL.
+		if rollup && agg.Type == logical_plan.AggregateCount && len(query.groupBy) == 0 {
+			return nil, fmt.Sprintf("%s without a GROUP BY cannot be rolled up from the view's groups", label)
+		}
+		rolled := agg.Type
+		if agg.Type == logical_plan.AggregateCount {
+			rolled = logical_plan.AggregateSum
+		}
+		// The replacement keeps the name the query's aggregate had, which
+		// without an alias is that of its function.
+		name := agg.Alias
+		if name == "" {
+			name = strings.ToLower(string(agg.Type))
+		}
+		aggregates[i] = logical_plan.AggregateFunction{Type: rolled, Column: logical_plan.NewColumnExpression(r.relation, found.name), Alias: name}
+		projection := logical_plan.Column{Table: r.relation, Name: found.name}
+		if !strings.EqualFold(found.name, name) {
+			projection.Alias = name
+		}
+		projections = append(projections, projection)
+	}
+
+	if rollup {
+		r.match.Rollup = make([]string, len(groupBy))
+		for i, column := range groupBy {
+			r.match.Rollup[i] = column.String()
+		}
+		r.plan = logical_plan.NewAggregateNode(input, groupBy, aggregates)
+	} else {
+		r.plan = logical_plan.NewProjectNode(input, projections)
+	}
+	r.plan.ID = node.ID
+	return r, ""
+}
+
+func sameAggregateArgument(a, b *logical_plan.Expression, classes columnClasses) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return sameExpression(classes.normalize(a), classes.normalize(b))
+}
+
+// needed lists the columns of block's relations that the rest of the query
+// reads or returns.
+func (m *viewMatcher) needed(block *logical_plan.LogicalPlan) []binder.Column {
+	inside := relationNames(block)
+	var columns []binder.Column
+	seen := make(map[string]bool)
+	add := func(column binder.Column) {
+		key := strings.ToLower(column.Relation + "." + column.Name)
+		if column.Table == "" || !inside[strings.ToLower(column.Relation)] || seen[key] {
+			return
+		}
+		seen[key] = true
+		columns = append(columns, column)
+	}
+	var walk func(node *logical_plan.LogicalPlan)
+	walk = func(node *logical_plan.LogicalPlan) {
+		if node == block {
+			return
+		}
+		for _, column := range m.refs[node] {
+			add(column)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(m.root)
+	for _, column := range m.output {
+		add(column)
+	}
+	return columns
+}
+
+// viewRelation is the relation name a scan of the view gets: the view's
+// name, or a numbered alias when that is taken.
+func (m *viewMatcher) viewRelation(name string) string {
+	relation := name
+	for i := 2; m.relations[strings.ToLower(relation)]; i++ {
+		relation = fmt.Sprintf("%s_%d", name, i)
+	}
+	return relation
+}
+
+// rewriteReferences points the references node makes into replaced blocks
+// at the views' columns.
+func (m *viewMatcher) rewriteReferences(node *logical_plan.LogicalPlan) {
+	scope := m.bound.Input(node)
+	lookup := func(ref logical_plan.ColumnRef) (logical_plan.ColumnRef, bool) {
+		index, err := scope.Resolve(ref)
+		if err != nil {
+			return logical_plan.ColumnRef{}, false
+		}
+		target, ok := m.mapping[strings.ToLower(scope[index].Relation+"."+scope[index].Name)]
+		return target, ok
+	}
+	node.TransformExpressions(func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if !e.IsColumn() {
+			return e, false, nil
+		}
+		if target, ok := lookup(*e.Column); ok {
+			return logical_plan.NewColumnExpression(target.Table, target.Name), true, nil
+		}
+		return e, false, nil
+	})
+	if node.NodeType == logical_plan.NodeTypeEmpty {
+		return
+	}
+	for i := range node.Projections {
+		projection := &node.Projections[i]
+		switch {
+		case projection.Expression != nil:
+		case projection.Name == "*":
+			if relation, ok := m.replaced[strings.ToLower(projection.Table)]; ok && projection.Table != "" {
+				projection.Table = relation
+			}
+		default:
+			if target, ok := lookup(logical_plan.ColumnRef{Table: projection.Table, Name: projection.Name}); ok {
+				projection.Table, projection.Name = target.Table, target.Name
+			}
+		}
+	}
+	for i := range node.GroupBy {
+		column := &node.GroupBy[i]
+		if target, ok := lookup(logical_plan.ColumnRef{Table: column.Table, Name: column.Name}); ok {
+			column.Table, column.Name = target.Table, target.Name
+		}
+	}
+}
+
+// record keeps, for each view, the blocks it answered, or else the first
+// block it was matched against, which is the outermost.
+func (m *viewMatcher) record(match ViewMatch) {
+	previous := m.matches[match.View]
+	switch {
+	case match.Used && len(previous) > 0 && !previous[0].Used:
+		m.matches[match.View] = []ViewMatch{match}
+	case match.Used || len(previous) == 0:
+		m.matches[match.View] = append(previous, match)
+	}
+}
+
+func (m *viewMatcher) reports() []ViewMatch {
+	var reports []ViewMatch
+	for _, view := range m.views {
+		matches, ok := m.matches[view.name]
+		if !ok {
+			reports = append(reports, ViewMatch{
+				View:   view.name,
+				Reason: fmt.Sprintf("no part of the query reads exactly the tables %s", strings.Join(view.block.tables, ", ")),
+			})
+			continue
+		}
+		reports = append(reports, matches...)
+	}
+	return reports
+}
+
+func describeBlock(node *logical_plan.LogicalPlan, block *viewBlock) string {
+	relations := relationList(node)
+	switch {
+	case block.aggregated:
+		return "aggregate over " + relations
+	case len(block.tables) > 1:
+		return "join of " + relations
+	default:
+		return "scan of " + relations
+	}
+}
+
+// readsAll reports whether tables includes every one of required.
+func readsAll(tables, required []string) bool {
+	for _, table := range required {
+		i := sort.SearchStrings(tables, table)
+		if i == len(tables) || tables[i] != table {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,264 @@
+package optimizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"retr0-kernel/optiquery/cascades"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
+	"retr0-kernel/optiquery/enumerator"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// Options tune one optimizer run: which rules may fire, how long the search
+// goes on and the constants of the cost model. Unset fields take the
+// defaults.
+type Options struct {
+	// EnabledRules, when given, are the only rules that may fire.
+	EnabledRules  []string `json:"enabled_rules,omitempty"`
+	DisabledRules []string `json:"disabled_rules,omitempty"`
+	// MaxIterations caps the passes of the rewrite rules over the plan.
+	MaxIterations int `json:"max_iterations,omitempty"`
+	// DPThreshold is the largest join tree ordered with dynamic programming
+	// rather than greedily.
+	DPThreshold int `json:"dp_threshold,omitempty"`
+	// MaxPlans is the most join plans dynamic programming costs before it
+	// falls back to greedy ordering.
+	MaxPlans int `json:"max_plans,omitempty"`
+	// MaxMemoExpressions caps the logical expressions the cascades strategy
+	// explores.
+	MaxMemoExpressions int               `json:"max_memo_expressions,omitempty"`
+	CostModel          *CostModelOptions `json:"cost_model,omitempty"`
+	// TimeoutMillis bounds the run on top of any deadline of its context.
+	TimeoutMillis int `json:"timeout_ms,omitempty"`
+}
+
+// CostModelOptions override the constants of the cost model.
+type CostModelOptions struct {
+	SeqScanCostPerPage    *float64 `json:"seq_scan_cost_per_page,omitempty"`
+	RandomScanCostPerPage *float64 `json:"random_scan_cost_per_page,omitempty"`
+	CPUCostPerTuple       *float64 `json:"cpu_cost_per_tuple,omitempty"`
+	JoinCostFactor        *float64 `json:"join_cost_factor,omitempty"`
+	SortCostFactor        *float64 `json:"sort_cost_factor,omitempty"`
+	HashCostFactor        *float64 `json:"hash_cost_factor,omitempty"`
+}
+
+const DefaultMaxIterations = 10
+
+func DefaultOptions() Options {
+	return Options{
+		MaxIterations:      DefaultMaxIterations,
+		DPThreshold:        enumerator.DefaultDPThreshold,
+		MaxPlans:           enumerator.DefaultMaxPlans,
+		MaxMemoExpressions: cascades.DefaultMaxExpressions,
+	}
+}
+
+// rewriteRules are the rules of the rule-based optimizer, in the order they
+// are applied.
+func rewriteRules(catalogMgr *catalog.CatalogManager) []OptimizationRule {
+	return []OptimizationRule{
+		&SubqueryDecorrelationRule{Catalog: catalogMgr},
+		&PredicateTransitivityRule{Catalog: catalogMgr},
+		&PredicatePushdownRule{Catalog: catalogMgr},
+		&PartitionPruningRule{Catalog: catalogMgr},
+		&OuterJoinSimplificationRule{Catalog: catalogMgr},
+		&JoinEliminationRule{Catalog: catalogMgr},
+		&ProjectionPushdownRule{Catalog: catalogMgr},
+		&LimitPushdownRule{},
+		&ConstantFoldingRule{Catalog: catalogMgr},
+	}
+}
+
+// RuleNames lists every rule options can enable or disable: the rewrite
+// rules, the cost-based view matching, join reordering, eager aggregation
+// and subplan sharing, and the transformation rules of the cascades search.
+func RuleNames() []string {
+	var names []string
+	for _, rule := range rewriteRules(nil) {
+		names = append(names, rule.Name())
+	}
+	names = append(names, (&MaterializedViewRewriteRule{}).Name(), (&JoinReorderingRule{}).Name(), (&EagerAggregationRule{}).Name(), (&SubplanSharingRule{}).Name())
+	return append(names, cascades.TransformationRuleNames()...)
+}
+
+// WithDefaults fills in what o leaves unset from defaults. Rule lists and
+// cost model constants given in o replace those of defaults.
+func (o Options) WithDefaults(defaults Options) Options {
+	if o.EnabledRules == nil {
+		o.EnabledRules = defaults.EnabledRules
+	}
+	if o.DisabledRules == nil {
+		o.DisabledRules = defaults.DisabledRules
+	}
+	if o.MaxIterations == 0 {
+		o.MaxIterations = defaults.MaxIterations
+	}
+	if o.DPThreshold == 0 {
+		o.DPThreshold = defaults.DPThreshold
+	}
+	if o.MaxPlans == 0 {
+		o.MaxPlans = defaults.MaxPlans
+	}
+	if o.MaxMemoExpressions == 0 {
+		o.MaxMemoExpressions = defaults.MaxMemoExpressions
+	}
+	if o.CostModel == nil {
+		o.CostModel = defaults.CostModel
+	}
+	if o.TimeoutMillis == 0 {
+		o.TimeoutMillis = defaults.TimeoutMillis
+	}
+	return o
+}
+
+// Validate rejects unknown rule names, negative limits and cost constants
+// that are not positive.
+func (o Options) Validate() error {
+	known := make(map[string]bool)
+	for _, name := range RuleNames() {
+		known[strings.ToLower(name)] = true
+	}
+	for _, name := range append(append([]string(nil), o.EnabledRules...), o.DisabledRules...) {
+		if !known[strings.ToLower(name)] {
+			return fmt.Errorf("unknown rule %s, expected one of %s", name, strings.Join(RuleNames(), ", "))
+		}
+	}
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"max_iterations", o.MaxIterations},
+		{"dp_threshold", o.DPThreshold},
+		{"max_plans", o.MaxPlans},
+		{"max_memo_expressions", o.MaxMemoExpressions},
+		{"timeout_ms", o.TimeoutMillis},
+	} {
+		if limit.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", limit.name, limit.value)
+		}
+	}
+	if o.CostModel != nil {
+		for _, constant := range []struct {
+			name  string
+			value *float64
+		}{
+			{"seq_scan_cost_per_page", o.CostModel.SeqScanCostPerPage},
+			{"random_scan_cost_per_page", o.CostModel.RandomScanCostPerPage},
+			{"cpu_cost_per_tuple", o.CostModel.CPUCostPerTuple},
+			{"join_cost_factor", o.CostModel.JoinCostFactor},
+			{"sort_cost_factor", o.CostModel.SortCostFactor},
+			{"hash_cost_factor", o.CostModel.HashCostFactor},
+		} {
+			if constant.value != nil && *constant.value <= 0 {
+				return fmt.Errorf("cost model %s must be positive, got %g", constant.name, *constant.value)
+			}
+		}
+	}
+	return nil
+}
+
+// RuleEnabled reports whether a rule may fire. Names are compared ignoring
+// case.
+func (o Options) RuleEnabled(name string) bool {
+	matches := func(names []string) bool {
+		for _, candidate := range names {
+			if strings.EqualFold(candidate, name) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(o.EnabledRules) > 0 && !matches(o.EnabledRules) {
+		return false
+	}
+	return !matches(o.DisabledRules)
+}
+
+// disabledRules returns the rules among names that may not fire.
+func (o Options) disabledRules(names []string) map[string]bool {
+	disabled := make(map[string]bool)
+	for _, name := range names {
+		if !o.RuleEnabled(name) {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// NewCostModel builds the cost model with the constants o overrides.
+func (o Options) NewCostModel() *cost_model.SimpleCostModel {
+	cm := cost_model.NewSimpleCostModel()
+	if o.CostModel == nil {
+		return cm
+	}
+	for _, field := range []struct {
+		to   *float64
+		from *float64
+	}{
+		{&cm.SeqScanCostPerPage, o.CostModel.SeqScanCostPerPage},
+		{&cm.RandomScanCostPerPage, o.CostModel.RandomScanCostPerPage},
+		{&cm.CPUCostPerTuple, o.CostModel.CPUCostPerTuple},
+		{&cm.JoinCostFactor, o.CostModel.JoinCostFactor},
+		{&cm.SortCostFactor, o.CostModel.SortCostFactor},
+		{&cm.HashCostFactor, o.CostModel.HashCostFactor},
+	} {
+		if field.from != nil {
+			*field.to = *field.from
+		}
+	}
+	return cm
+}
+
+// key identifies the options for result caching. The timeout is left out, as
+// only complete results are cached.
+func (o Options) key() string {
+	o.TimeoutMillis = 0
+	o.EnabledRules = sortedLower(o.EnabledRules)
+	o.DisabledRules = sortedLower(o.DisabledRules)
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+func sortedLower(names []string) []string {
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	sort.Strings(lower)
+	return lower
+}
+
+// Optimize runs a strategy, rule, cost or cascades, with options. When ctx
+// ends the search early the best plan found so far is returned, and the
+// explain result is marked partial. Only the cost strategy follows the
+// plan's hints; the others report them all as ignored.
+func Optimize(ctx context.Context, strategy string, plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager, opts Options) (*logical_plan.LogicalPlan, *ExplainResult, error) {
+	if opts.TimeoutMillis > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.TimeoutMillis)*time.Millisecond)
+		defer cancel()
+	}
+	optimized, explain, err := optimize(ctx, strategy, plan, catalogMgr, opts)
+	if err == nil && strategy != "cost" {
+		explain.Hints = ignoredHints(plan.Hints, fmt.Sprintf("the %s strategy does not follow hints, only the cost strategy does", strategy))
+	}
+	return optimized, explain, err
+}
+
+func optimize(ctx context.Context, strategy string, plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager, opts Options) (*logical_plan.LogicalPlan, *ExplainResult, error) {
+	switch strategy {
+	case "rule":
+		return NewRuleBasedOptimizerWithOptions(catalogMgr, opts).Optimize(ctx, plan)
+	case "cost":
+		return NewCostBasedOptimizerWithOptions(catalogMgr, opts).Optimize(ctx, plan)
+	case "cascades":
+		return optimizeWithCascades(ctx, plan, catalogMgr, opts)
+	}
+	return nil, nil, fmt.Errorf("unsupported strategy %s", strategy)
+}
@@ -0,0 +1,128 @@
+package optimizer
+
+import (
+	"fmt"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// OuterJoinSimplificationRule weakens outer joins under a filter that rejects
+// the NULL-extended rows they add: a left join becomes an inner join when the
+// filter rejects NULLs from its right side, and a full join becomes a left,
+// right or inner join depending on the sides it rejects NULLs from. The inner
+// joins can then be reordered and have predicates pushed into both sides.
+type OuterJoinSimplificationRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *OuterJoinSimplificationRule) Name() string {
+	return "OuterJoinSimplification"
+}
+
+func (r *OuterJoinSimplificationRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+func (r *OuterJoinSimplificationRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	var details []string
+	result, _, err := logical_plan.TransformDown(plan, func(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		if detail := r.simplify(node); detail != "" {
+			details = append(details, detail)
+			return node, true, nil
+		}
+		return node, false, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, details, nil
+}
+
+func (r *OuterJoinSimplificationRule) simplify(filter *logical_plan.LogicalPlan) string {
+	if filter.NodeType != logical_plan.NodeTypeFilter || len(filter.Children) != 1 || filter.Predicate == nil {
+		return ""
+	}
+	join := filter.Children[0]
+	if join.NodeType != logical_plan.NodeTypeJoin || len(join.Children) != 2 {
+		return ""
+	}
+
+	resolver := newRelationResolver(join.Children, r.Catalog)
+	var rejectsLeft, rejectsRight bool
+	for _, conjunct := range logical_plan.SplitConjuncts(filter.Predicate.Expression) {
+		rejectsLeft = rejectsLeft || rejectsNulls(conjunct, resolver, 0)
+		rejectsRight = rejectsRight || rejectsNulls(conjunct, resolver, 1)
+	}
+
+	joinType := join.JoinType
+	switch {
+	case joinType == logical_plan.JoinTypeLeft && rejectsRight,
+		joinType == logical_plan.JoinTypeRight && rejectsLeft,
+		joinType == logical_plan.JoinTypeFull && rejectsLeft && rejectsRight:
+		joinType = logical_plan.JoinTypeInner
+	case joinType == logical_plan.JoinTypeFull && rejectsLeft:
+		joinType = logical_plan.JoinTypeLeft
+	case joinType == logical_plan.JoinTypeFull && rejectsRight:
+		joinType = logical_plan.JoinTypeRight
+	default:
+		return ""
+	}
+
+	detail := fmt.Sprintf("turned %s join into %s join, since %s rejects its NULL-extended rows",
+		join.JoinType, joinType, filter.Predicate.Expression)
+	join.JoinType = joinType
+	return detail
+}
+
+// rejectsNulls reports whether a predicate is never true when every column of
+// the given input is NULL.
+func rejectsNulls(expr *logical_plan.Expression, resolver *relationResolver, input int) bool {
+	switch expr.Kind {
+	case logical_plan.ExprBinaryOp:
+		switch expr.BinaryOp {
+		case logical_plan.OpAnd:
+			return rejectsNulls(expr.Left, resolver, input) || rejectsNulls(expr.Right, resolver, input)
+		case logical_plan.OpOr:
+			return rejectsNulls(expr.Left, resolver, input) && rejectsNulls(expr.Right, resolver, input)
+		case logical_plan.OpLike, logical_plan.OpNotLike, logical_plan.OpIn, logical_plan.OpNotIn:
+			return isNullOn(expr.Left, resolver, input)
+		}
+		if expr.BinaryOp.IsComparison() {
+			return isNullOn(expr.Left, resolver, input) || isNullOn(expr.Right, resolver, input)
+		}
+
+	case logical_plan.ExprUnaryOp:
+		switch expr.UnaryOp {
+		case logical_plan.OpIsNotNull:
+			return isNullOn(expr.Operand, resolver, input)
+		case logical_plan.OpNot:
+			// NOT of an unknown comparison is still unknown.
+			operand := expr.Operand
+			return operand.Kind == logical_plan.ExprBinaryOp && operand.BinaryOp.IsComparison() && rejectsNulls(operand, resolver, input)
+		}
+	}
+	return false
+}
+
+// isNullOn reports whether an expression is NULL whenever every column of
+// the given input is NULL: it reads such a column only through operators
+// that return NULL for a NULL operand.
+func isNullOn(expr *logical_plan.Expression, resolver *relationResolver, input int) bool {
+	switch expr.Kind {
+	case logical_plan.ExprColumn:
+		relations, ok := resolver.relations(expr)
+		return ok && len(relations) == 1 && relations[0] == input
+	case logical_plan.ExprBinaryOp:
+		switch expr.BinaryOp {
+		case logical_plan.OpAdd, logical_plan.OpSub, logical_plan.OpMul, logical_plan.OpDiv, logical_plan.OpMod, logical_plan.OpConcat:
+			return isNullOn(expr.Left, resolver, input) || isNullOn(expr.Right, resolver, input)
+		}
+	case logical_plan.ExprUnaryOp:
+		return expr.UnaryOp == logical_plan.OpNeg && isNullOn(expr.Operand, resolver, input)
+	case logical_plan.ExprCast:
+		return isNullOn(expr.Operand, resolver, input)
+	}
+	return false
+}
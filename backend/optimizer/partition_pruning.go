@@ -0,0 +1,263 @@
+package optimizer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// PartitionPruningRule narrows the scan of a partitioned table to the
+// partitions the filter directly above it can match. Comparisons of the
+// partition column with literals, IN-lists of literals and ANDs and ORs of
+// them prune; any other predicate keeps every partition. A filter no
+// partition can match is replaced by an empty node.
+type PartitionPruningRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *PartitionPruningRule) Name() string {
+	return "PartitionPruning"
+}
+
+func (r *PartitionPruningRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+// ApplyAndDescribe returns one line per scan pruned.
+func (r *PartitionPruningRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	if r.Catalog == nil {
+		return plan, nil, nil
+	}
+	var details []string
+	result, _, err := logical_plan.TransformDown(plan, func(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		if node.NodeType != logical_plan.NodeTypeFilter || node.Predicate == nil || len(node.Children) != 1 {
+			return node, false, nil
+		}
+		scan := node.Children[0]
+		if scan.NodeType != logical_plan.NodeTypeScan {
+			return node, false, nil
+		}
+		table, err := r.Catalog.GetTable(scan.TableName)
+		if err != nil || table.Partitioning == nil {
+			return node, false, nil
+		}
+
+		pruner := &partitionPruner{table: table, relation: scan.RelationName()}
+		partitions := pruner.prune(table.Partitioning, logical_plan.SplitConjuncts(node.Predicate.Expression))
+		if scan.Partitions != nil {
+			var kept []string
+			for _, name := range partitions {
+				if indexOf(scan.Partitions, name) >= 0 {
+					kept = append(kept, name)
+				}
+			}
+			partitions = kept
+		}
+
+		total := len(table.Partitioning.Leaves())
+		switch {
+		case len(partitions) == 0:
+			details = append(details, fmt.Sprintf("replaced filter %s with an empty result: no partition of %s can match",
+				node.Predicate.Expression, scan.RelationName()))
+			return logical_plan.NewEmptyNode(outputColumns(scan, r.Catalog)), true, nil
+		case len(partitions) == total, scan.Partitions != nil && len(partitions) == len(scan.Partitions):
+			return node, false, nil
+		}
+		scan.Partitions = partitions
+		details = append(details, fmt.Sprintf("scan of %s reads %d of %d partitions: %s",
+			scan.RelationName(), len(partitions), total, strings.Join(partitions, ", ")))
+		return node, true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, details, nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+type partitionPruner struct {
+	table    *catalog.TableSchema
+	relation string
+}
+
+// prune lists the leaf partitions every conjunct may match.
+func (p *partitionPruner) prune(partitioning *catalog.Partitioning, conjuncts []*logical_plan.Expression) []string {
+	var names []string
+	for _, partition := range partitioning.Partitions {
+		matches := true
+		for _, conjunct := range conjuncts {
+			if !p.mayMatch(partitioning, partition, conjunct) {
+				matches = false
+				break
+			}
+		}
+		switch {
+		case !matches:
+		case partition.Subpartitions != nil:
+			names = append(names, p.prune(partition.Subpartitions, conjuncts)...)
+		default:
+			names = append(names, partition.Name)
+		}
+	}
+	return names
+}
+
+// mayMatch is false only when no row of the partition can satisfy e.
+func (p *partitionPruner) mayMatch(partitioning *catalog.Partitioning, partition catalog.Partition, e *logical_plan.Expression) bool {
+	if e.Kind == logical_plan.ExprBinaryOp {
+		switch e.BinaryOp {
+		case logical_plan.OpAnd:
+			return p.mayMatch(partitioning, partition, e.Left) && p.mayMatch(partitioning, partition, e.Right)
+		case logical_plan.OpOr:
+			return p.mayMatch(partitioning, partition, e.Left) || p.mayMatch(partitioning, partition, e.Right)
+		case logical_plan.OpIn:
+			if !e.Left.IsColumn() || !p.partitionColumn(partitioning, *e.Left.Column) || e.Right.Kind != logical_plan.ExprList {
+				return true
+			}
+			for _, item := range e.Right.Args {
+				if !item.IsLiteral() {
+					return true
+				}
+				if !item.Literal.IsNull() && p.compare(partitioning, partition, logical_plan.OpEq, item.Literal) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if column, op, literal, ok := literalComparison(e); ok && p.partitionColumn(partitioning, column) {
+		return p.compare(partitioning, partition, op, literal)
+	}
+	return true
+}
+
+func (p *partitionPruner) partitionColumn(partitioning *catalog.Partitioning, column logical_plan.ColumnRef) bool {
+	if !strings.EqualFold(column.Name, partitioning.Column) {
+		return false
+	}
+	return column.Table == "" || strings.EqualFold(column.Table, p.relation) || strings.EqualFold(column.Table, p.table.Name)
+}
+
+// compare is whether some row of the partition may hold "column op v".
+// Bounds and values that cannot be compared with v keep the partition.
+func (p *partitionPruner) compare(partitioning *catalog.Partitioning, partition catalog.Partition, op logical_plan.BinaryOperator, v *logical_plan.Literal) bool {
+	switch partitioning.Type {
+	case catalog.PartitionRange:
+		// Rows of the partition lie in [From, To).
+		fromBelow := func(strict bool) bool {
+			if partition.From == nil {
+				return true
+			}
+			c, ok := compareBound(*partition.From, v)
+			return !ok || c < 0 || (!strict && c == 0)
+		}
+		belowTo := func() bool {
+			if partition.To == nil {
+				return true
+			}
+			c, ok := compareBound(*partition.To, v)
+			return !ok || c > 0
+		}
+		switch op {
+		case logical_plan.OpEq:
+			return fromBelow(false) && belowTo()
+		case logical_plan.OpLt:
+			return fromBelow(true)
+		case logical_plan.OpLtEq:
+			return fromBelow(false)
+		case logical_plan.OpGt, logical_plan.OpGtEq:
+			return belowTo()
+		}
+		return true
+
+	case catalog.PartitionList:
+		if partition.Default {
+			if op != logical_plan.OpEq {
+				return true
+			}
+			for _, other := range partitioning.Partitions {
+				for _, value := range other.Values {
+					if c, ok := compareBound(value, v); ok && c == 0 && !other.Default {
+						return false
+					}
+				}
+			}
+			return true
+		}
+		return listMayMatch(partition, op, v)
+
+	case catalog.PartitionHash:
+		if op != logical_plan.OpEq {
+			return true
+		}
+		return partition.Remainder == catalog.HashPartition(fmt.Sprint(v.Value), len(partitioning.Partitions))
+	}
+	return true
+}
+
+func listMayMatch(partition catalog.Partition, op logical_plan.BinaryOperator, v *logical_plan.Literal) bool {
+	for _, value := range partition.Values {
+		c, ok := compareBound(value, v)
+		if !ok {
+			return true
+		}
+		switch op {
+		case logical_plan.OpEq:
+			ok = c == 0
+		case logical_plan.OpNotEq:
+			ok = c != 0
+		case logical_plan.OpLt:
+			ok = c < 0
+		case logical_plan.OpLtEq:
+			ok = c <= 0
+		case logical_plan.OpGt:
+			ok = c > 0
+		case logical_plan.OpGtEq:
+			ok = c >= 0
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compareBound orders a bound or value from the catalog, read as the type of
+// v, against v.
+func compareBound(text string, v *logical_plan.Literal) (int, bool) {
+	var bound *logical_plan.Literal
+	switch v.Value.(type) {
+	case int64:
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			bound = &logical_plan.Literal{Type: logical_plan.DataTypeInt, Value: i}
+		} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+			bound = &logical_plan.Literal{Type: logical_plan.DataTypeFloat, Value: f}
+		}
+	case float64:
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			bound = &logical_plan.Literal{Type: logical_plan.DataTypeFloat, Value: f}
+		}
+	case string:
+		bound = &logical_plan.Literal{Type: v.Type, Value: text}
+	case bool:
+		if b, err := strconv.ParseBool(text); err == nil {
+			bound = &logical_plan.Literal{Type: logical_plan.DataTypeBoolean, Value: b}
+		}
+	}
+	if bound == nil {
+		return 0, false
+	}
+	return compareLiterals(bound, v)
+}
@@ -0,0 +1,477 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// physicalPlanner picks a physical operator for every node bottom-up and
+// tracks the properties each one delivers: the order of its rows, how they
+// are partitioned and which columns are unique. Operators that need ordered
+// input pass the order down as an interesting order. A scan whose table has
+// a btree index on it reads the table through the index, a sort whose input
+// already arrives in its order is removed, and merge joins and sort
+// aggregates over ordered input skip their sorts. Scan and join method hints
+// override these choices.
+type physicalPlanner struct {
+	catalog   *catalog.CatalogManager
+	costModel cost_model.CostModel
+	hints     *planHints
+	details   []string
+}
+
+// choose sets the physical operator of plan, preferring ones that deliver
+// interesting, and returns the plan, which loses removed sorts, with the
+// properties of its output.
+func (p *physicalPlanner) choose(plan *logical_plan.LogicalPlan, interesting logical_plan.Ordering) (*logical_plan.LogicalPlan, logical_plan.PhysicalProperties) {
+	if plan.Metadata == nil {
+		plan.Metadata = make(map[string]interface{})
+	}
+	var props logical_plan.PhysicalProperties
+
+	switch plan.NodeType {
+	case logical_plan.NodeTypeScan:
+		props = p.chooseScan(plan, interesting)
+
+	case logical_plan.NodeTypeFilter, logical_plan.NodeTypeLimit:
+		props = p.chooseChildren(plan, interesting)
+
+	case logical_plan.NodeTypeProject:
+		if !projectionKeeps(plan, interesting) {
+			interesting = nil
+		}
+		props = projectProperties(plan, p.chooseChildren(plan, interesting))
+
+	case logical_plan.NodeTypeSort:
+		ordering, ok := logical_plan.OrderingOf(plan.OrderBy)
+		child, input := p.choose(plan.Children[0], ordering)
+		if ok && ordering.SatisfiedBy(input.Ordering) {
+			p.details = append(p.details, fmt.Sprintf("removed sort on %s: its input is already in that order", ordering))
+			return child, input
+		}
+		plan.Children[0] = child
+		cardinality, _ := p.costModel.EstimateCardinality(plan, p.catalog)
+		if cardinality < 100000 {
+			plan.Metadata["physical_operator"] = "quicksort"
+		} else {
+			plan.Metadata["physical_operator"] = "external_sort"
+		}
+		props = logical_plan.PhysicalProperties{Ordering: ordering, UniqueKeys: input.UniqueKeys}
+
+	case logical_plan.NodeTypeTopN:
+		ordering, ok := logical_plan.OrderingOf(plan.OrderBy)
+		child, input := p.choose(plan.Children[0], ordering)
+		if ok && ordering.SatisfiedBy(input.Ordering) {
+			p.details = append(p.details, fmt.Sprintf("replaced top-N on %s with a limit: its input is already in that order", ordering))
+			limit := logical_plan.NewLimitNode(child, plan.LimitCount, plan.OffsetCount)
+			limit.ID = plan.ID
+			limit.Metadata["physical_properties"] = input.Metadata()
+			return limit, input
+		}
+		plan.Children[0] = child
+		plan.Metadata["physical_operator"] = "heap_top_n"
+		props = logical_plan.PhysicalProperties{Ordering: ordering, UniqueKeys: input.UniqueKeys}
+
+	case logical_plan.NodeTypeAggregate:
+		props = p.chooseAggregate(plan)
+
+	case logical_plan.NodeTypeJoin:
+		props = p.chooseJoin(plan)
+
+	default:
+		p.chooseChildren(plan, nil)
+	}
+
+	if metadata := props.Metadata(); len(metadata) > 0 {
+		plan.Metadata["physical_properties"] = metadata
+	}
+	return plan, props
+}
+
+// chooseChildren chooses operators for the inputs of a node that keeps the
+// order of its first input, and returns that input's properties.
+func (p *physicalPlanner) chooseChildren(plan *logical_plan.LogicalPlan, interesting logical_plan.Ordering) logical_plan.PhysicalProperties {
+	var props logical_plan.PhysicalProperties
+	for i, child := range plan.Children {
+		var childProps logical_plan.PhysicalProperties
+		if i == 0 {
+			plan.Children[i], childProps = p.choose(child, interesting)
+			props = childProps
+		} else {
+			plan.Children[i], _ = p.choose(child, nil)
+		}
+	}
+	if len(plan.Children) != 1 {
+		return logical_plan.PhysicalProperties{}
+	}
+	return props
+}
+
+// chooseScan reads the table through a btree index when that delivers the
+// interesting order, and sequentially otherwise. Scans are unique on the
+// table's primary key and unique indexes. A scan hint limits the indexes
+// that may be used, and INDEXSCAN reads through one even without an order to
+// deliver.
+func (p *physicalPlanner) chooseScan(scan *logical_plan.LogicalPlan, interesting logical_plan.Ordering) logical_plan.PhysicalProperties {
+	scan.Metadata["scan_type"] = "sequential"
+	hint := p.hints.forScan(scan)
+	table, err := p.catalog.GetTable(scan.TableName)
+	if err != nil {
+		if hint != nil && hint.hint.Name == logical_plan.HintIndexScan {
+			hint.ignore(fmt.Sprintf("the catalog has no table %s", scan.TableName))
+		} else if hint != nil {
+			hint.use()
+		}
+		return logical_plan.PhysicalProperties{}
+	}
+	relation := scan.RelationName()
+	var props logical_plan.PhysicalProperties
+	keys := [][]string{table.PrimaryKey}
+	for _, index := range table.Indexes {
+		if index.Unique {
+			keys = append(keys, index.Columns)
+		}
+	}
+	for _, key := range keys {
+		// A key that contains another one adds nothing.
+		if refs := columnRefs(relation, key); len(refs) > 0 && !props.IsUnique(refs) {
+			props.UniqueKeys = append(props.UniqueKeys, refs)
+		}
+	}
+
+	usable := hintedIndexes(table, hint)
+	if index, backward := indexFor(scan, usable, interesting); index != nil {
+		scan.Metadata["scan_type"] = "index"
+		scan.Metadata["index_name"] = index.Name
+		scan.Metadata["ordered"] = true
+		if backward {
+			scan.Metadata["scan_direction"] = "backward"
+		}
+		props.Ordering = interesting
+		p.details = append(p.details, fmt.Sprintf("scanned %s through index %s for order %s", relation, index.Name, interesting))
+	} else if hint != nil && hint.hint.Name == logical_plan.HintIndexScan {
+		if len(usable.Indexes) == 0 {
+			reason := fmt.Sprintf("table %s has no index", scan.TableName)
+			if len(hint.hint.Arguments) > 1 {
+				reason += " " + strings.Join(hint.hint.Arguments[1:], " or ")
+			}
+			hint.ignore(reason)
+			return props
+		}
+		scan.Metadata["scan_type"] = "index"
+		scan.Metadata["index_name"] = usable.Indexes[0].Name
+		p.details = append(p.details, fmt.Sprintf("scanned %s through index %s as hinted by %s", relation, usable.Indexes[0].Name, hint.hint))
+	}
+	if hint != nil {
+		hint.use()
+	}
+	return props
+}
+
+// chooseAggregate asks for input ordered on the group-by columns and uses a
+// sort aggregate, which then needs no sort, when it gets it. Otherwise the
+// choice depends on the number of groups.
+func (p *physicalPlanner) chooseAggregate(aggregate *logical_plan.LogicalPlan) logical_plan.PhysicalProperties {
+	keys := groupKeys(aggregate)
+	grouped := groupOrdering(keys)
+	interesting := grouped
+	if len(grouped) == 0 || !p.canProvide(aggregate.Children[0], grouped) {
+		interesting = nil
+	}
+	input := p.chooseChildren(aggregate, interesting)
+
+	var props logical_plan.PhysicalProperties
+	if len(keys) > 0 {
+		props.UniqueKeys = [][]logical_plan.ColumnRef{keys}
+	}
+	cardinality, _ := p.costModel.EstimateCardinality(aggregate, p.catalog)
+	switch {
+	case len(interesting) > 0 && interesting.SatisfiedBy(input.Ordering):
+		aggregate.Metadata["physical_operator"] = "sort_aggregate"
+		aggregate.Metadata["presorted"] = true
+		props.Ordering = grouped
+		p.details = append(p.details, fmt.Sprintf("sort aggregate reads its input already ordered on %s", grouped))
+	case len(keys) == 0 || cardinality < 10000:
+		aggregate.Metadata["physical_operator"] = "hash_aggregate"
+		props.Partitioning = keys
+	default:
+		aggregate.Metadata["physical_operator"] = "sort_aggregate"
+		props.Ordering = grouped
+	}
+	return props
+}
+
+// chooseJoin uses a sort-merge join without sorting when both inputs can be
+// read in join key order, and otherwise picks by input size: nested loops
+// for small inputs, a hash join building the smaller input, and a sort-merge
+// join when both are huge. A join method hint for the join's relations
+// decides instead, though hash and merge joins need an equality condition.
+func (p *physicalPlanner) chooseJoin(join *logical_plan.LogicalPlan) logical_plan.PhysicalProperties {
+	leftKey, rightKey, equi := p.joinKeys(join)
+	hint := p.hints.forJoin(join)
+	if hint != nil && hint.hint.Name != logical_plan.HintNestedLoopJoin && !equi {
+		hint.ignore("needs an equality join condition")
+		hint = nil
+	}
+	var leftOrder, rightOrder logical_plan.Ordering
+	if equi && (hint == nil || hint.hint.Name == logical_plan.HintMergeJoin) {
+		leftOrder = logical_plan.Ordering{{Column: leftKey}}
+		rightOrder = logical_plan.Ordering{{Column: rightKey}}
+		if !p.canProvide(join.Children[0], leftOrder) || !p.canProvide(join.Children[1], rightOrder) {
+			leftOrder, rightOrder = nil, nil
+		}
+	}
+	var left, right logical_plan.PhysicalProperties
+	join.Children[0], left = p.choose(join.Children[0], leftOrder)
+	join.Children[1], right = p.choose(join.Children[1], rightOrder)
+	props := p.joinUniqueness(join, left, right, leftKey, rightKey)
+
+	if len(leftOrder) > 0 && leftOrder.SatisfiedBy(left.Ordering) && rightOrder.SatisfiedBy(right.Ordering) {
+		join.Metadata["physical_operator"] = "sort_merge_join"
+		join.Metadata["presorted"] = true
+		p.details = append(p.details, fmt.Sprintf("merge join on %s = %s reads both inputs in key order", leftKey, rightKey))
+		if hint != nil {
+			hint.use()
+		}
+		props.Ordering = mergeJoinOrdering(join.JoinType, leftKey, rightKey)
+		return props
+	}
+
+	leftCard, _ := p.costModel.EstimateCardinality(join.Children[0], p.catalog)
+	rightCard, _ := p.costModel.EstimateCardinality(join.Children[1], p.catalog)
+	if hint != nil {
+		join.Metadata["physical_operator"] = hintedJoinOperators[hint.hint.Name]
+		if hint.hint.Name == logical_plan.HintHashJoin {
+			join.Metadata["build_side"] = "right"
+			if leftCard < rightCard {
+				join.Metadata["build_side"] = "left"
+			}
+		}
+		hint.use()
+		p.details = append(p.details, fmt.Sprintf("joined %s with %s as hinted by %s", strings.Join(join.Relations(), ", "), join.Metadata["physical_operator"], hint.hint))
+	} else if leftCard < 1000 && rightCard < 1000 {
+		join.Metadata["physical_operator"] = "nested_loop_join"
+	} else if leftCard < rightCard {
+		join.Metadata["physical_operator"] = "hash_join"
+		join.Metadata["build_side"] = "left"
+	} else {
+		join.Metadata["physical_operator"] = "hash_join"
+		join.Metadata["build_side"] = "right"
+	}
+
+	if hint == nil && leftCard > 1000000 && rightCard > 1000000 {
+		join.Metadata["physical_operator"] = "sort_merge_join"
+	}
+	if join.JoinType.LeftOnly() && join.Metadata["physical_operator"] == "hash_join" {
+		// Semi and anti joins probe with the left rows they return.
+		join.Metadata["build_side"] = "right"
+	}
+
+	switch join.Metadata["physical_operator"] {
+	case "nested_loop_join":
+		// The outer input is read once, in order.
+		props.Ordering = left.Ordering
+	case "hash_join":
+		// Probe rows come out in the order they are read, and each build
+		// partition meets the probe rows hashed to it.
+		if join.Metadata["build_side"] == "left" {
+			props.Ordering = right.Ordering
+			if equi {
+				props.Partitioning = []logical_plan.ColumnRef{rightKey}
+			}
+		} else {
+			props.Ordering = left.Ordering
+			if equi {
+				props.Partitioning = []logical_plan.ColumnRef{leftKey}
+			}
+		}
+	case "sort_merge_join":
+		if equi {
+			props.Ordering = mergeJoinOrdering(join.JoinType, leftKey, rightKey)
+		}
+	}
+	return props
+}
+
+// mergeJoinOrdering is the order a merge join's output comes out in. A right
+// or full join also returns unmatched right rows, whose left key is NULL, so
+// only the right key stays in order for a right join and neither does for a
+// full join.
+func mergeJoinOrdering(joinType logical_plan.JoinType, leftKey, rightKey logical_plan.ColumnRef) logical_plan.Ordering {
+	switch joinType {
+	case logical_plan.JoinTypeRight:
+		return logical_plan.Ordering{{Column: rightKey}}
+	case logical_plan.JoinTypeFull:
+		return nil
+	}
+	return logical_plan.Ordering{{Column: leftKey}}
+}
+
+var hintedJoinOperators = map[string]string{
+	logical_plan.HintHashJoin:       "hash_join",
+	logical_plan.HintNestedLoopJoin: "nested_loop_join",
+	logical_plan.HintMergeJoin:      "sort_merge_join",
+}
+
+// joinKeys returns the columns of an equi-join condition, the first from
+// the left input.
+func (p *physicalPlanner) joinKeys(join *logical_plan.LogicalPlan) (logical_plan.ColumnRef, logical_plan.ColumnRef, bool) {
+	condition := join.JoinCondition
+	if condition == nil || condition.Operator != "=" || !condition.Left.IsColumn() || !condition.Right.IsColumn() || len(join.Children) != 2 {
+		return logical_plan.ColumnRef{}, logical_plan.ColumnRef{}, false
+	}
+	left, right := *condition.Left.Column, *condition.Right.Column
+	leftNames, rightNames := relationNames(join.Children[0]), relationNames(join.Children[1])
+	switch {
+	case leftNames[strings.ToLower(left.Table)] && rightNames[strings.ToLower(right.Table)]:
+		return left, right, true
+	case leftNames[strings.ToLower(right.Table)] && rightNames[strings.ToLower(left.Table)]:
+		return right, left, true
+	}
+	return logical_plan.ColumnRef{}, logical_plan.ColumnRef{}, false
+}
+
+// joinUniqueness works out the unique keys of a join's output. Each row of
+// one input matches at most one row of the other when the other is unique on
+// its join key, so the first input's keys stay unique. Semi and anti joins
+// return rows of their left input, at most once each.
+func (p *physicalPlanner) joinUniqueness(join *logical_plan.LogicalPlan, left, right logical_plan.PhysicalProperties, leftKey, rightKey logical_plan.ColumnRef) logical_plan.PhysicalProperties {
+	var props logical_plan.PhysicalProperties
+	if join.JoinType.LeftOnly() {
+		props.UniqueKeys = left.UniqueKeys
+		return props
+	}
+	if leftKey.Name == "" {
+		return props
+	}
+	if right.IsUnique([]logical_plan.ColumnRef{rightKey}) {
+		props.UniqueKeys = append(props.UniqueKeys, left.UniqueKeys...)
+	}
+	if left.IsUnique([]logical_plan.ColumnRef{leftKey}) {
+		props.UniqueKeys = append(props.UniqueKeys, right.UniqueKeys...)
+	}
+	return props
+}
+
+// canProvide reports whether plan can deliver rows in ordering without a
+// sort, through an index, an existing sort or a sort aggregate.
+func (p *physicalPlanner) canProvide(plan *logical_plan.LogicalPlan, ordering logical_plan.Ordering) bool {
+	switch plan.NodeType {
+	case logical_plan.NodeTypeScan:
+		table, err := p.catalog.GetTable(plan.TableName)
+		if err != nil {
+			return false
+		}
+		index, _ := indexFor(plan, hintedIndexes(table, p.hints.forScan(plan)), ordering)
+		return index != nil
+	case logical_plan.NodeTypeFilter, logical_plan.NodeTypeLimit:
+		return len(plan.Children) == 1 && p.canProvide(plan.Children[0], ordering)
+	case logical_plan.NodeTypeProject:
+		return len(plan.Children) == 1 && projectionKeeps(plan, ordering) && p.canProvide(plan.Children[0], ordering)
+	case logical_plan.NodeTypeSort, logical_plan.NodeTypeTopN:
+		sorted, ok := logical_plan.OrderingOf(plan.OrderBy)
+		return ok && ordering.SatisfiedBy(sorted)
+	case logical_plan.NodeTypeAggregate:
+		grouped := groupOrdering(groupKeys(plan))
+		return len(grouped) > 0 && ordering.SatisfiedBy(grouped) && p.canProvide(plan.Children[0], grouped)
+	}
+	return false
+}
+
+// indexFor finds a btree index that returns a scan's rows in ordering,
+// reading it backward when every key is descending.
+func indexFor(scan *logical_plan.LogicalPlan, table *catalog.TableSchema, ordering logical_plan.Ordering) (*catalog.Index, bool) {
+	if len(ordering) == 0 {
+		return nil, false
+	}
+	relation := scan.RelationName()
+	columns := make([]string, len(ordering))
+	for i, key := range ordering {
+		if key.Column.Table != "" && !strings.EqualFold(key.Column.Table, relation) || key.Descending != ordering[0].Descending {
+			return nil, false
+		}
+		columns[i] = key.Column.Name
+	}
+	return table.OrderedIndex(columns), ordering[0].Descending
+}
+
+// projectionKeeps reports whether every column of the ordering passes
+// through the projection unchanged.
+func projectionKeeps(project *logical_plan.LogicalPlan, ordering logical_plan.Ordering) bool {
+	for _, key := range ordering {
+		if !projects(project, key.Column) {
+			return false
+		}
+	}
+	return true
+}
+
+func projects(project *logical_plan.LogicalPlan, ref logical_plan.ColumnRef) bool {
+	for _, column := range project.Projections {
+		if column.Expression != nil || column.Alias != "" {
+			continue
+		}
+		if column.Name == "*" || strings.EqualFold(column.Name, ref.Name) &&
+			(column.Table == "" || ref.Table == "" || strings.EqualFold(column.Table, ref.Table)) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectProperties keeps the properties of a projection's input that only
+// involve columns it passes through.
+func projectProperties(project *logical_plan.LogicalPlan, input logical_plan.PhysicalProperties) logical_plan.PhysicalProperties {
+	var props logical_plan.PhysicalProperties
+	for _, key := range input.Ordering {
+		if !projects(project, key.Column) {
+			break
+		}
+		props.Ordering = append(props.Ordering, key)
+	}
+	keeps := func(columns []logical_plan.ColumnRef) bool {
+		for _, column := range columns {
+			if !projects(project, column) {
+				return false
+			}
+		}
+		return true
+	}
+	if keeps(input.Partitioning) {
+		props.Partitioning = input.Partitioning
+	}
+	for _, key := range input.UniqueKeys {
+		if keeps(key) {
+			props.UniqueKeys = append(props.UniqueKeys, key)
+		}
+	}
+	return props
+}
+
+func groupKeys(aggregate *logical_plan.LogicalPlan) []logical_plan.ColumnRef {
+	keys := make([]logical_plan.ColumnRef, len(aggregate.GroupBy))
+	for i, column := range aggregate.GroupBy {
+		keys[i] = logical_plan.ColumnRef{Table: column.Table, Name: column.Name}
+	}
+	return keys
+}
+
+func groupOrdering(columns []logical_plan.ColumnRef) logical_plan.Ordering {
+	ordering := make(logical_plan.Ordering, len(columns))
+	for i, column := range columns {
+		ordering[i] = logical_plan.OrderKey{Column: column}
+	}
+	return ordering
+}
+
+func columnRefs(relation string, names []string) []logical_plan.ColumnRef {
+	refs := make([]logical_plan.ColumnRef, len(names))
+	for i, name := range names {
+		refs[i] = logical_plan.ColumnRef{Table: relation, Name: name}
+	}
+	return refs
+}
@@ -0,0 +1,343 @@
+package optimizer
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// PredicatePushdownRule splits filters into their AND conjuncts and moves each
+// one as close to the scans as its column references allow. Catalog, when
+// set, is used to find the side of a join an unqualified column comes from.
+type PredicatePushdownRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *PredicatePushdownRule) Name() string {
+	return "PredicatePushdown"
+}
+
+func (r *PredicatePushdownRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	return logical_plan.TransformDown(plan, r.pushDown)
+}
+
+func (r *PredicatePushdownRule) pushDown(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	if node.NodeType != logical_plan.NodeTypeFilter || len(node.Children) != 1 || node.Predicate == nil {
+		return node, false, nil
+	}
+
+	child := node.Children[0]
+	conjuncts := logical_plan.SplitConjuncts(node.Predicate.Expression)
+
+	switch child.NodeType {
+	case logical_plan.NodeTypeFilter:
+		if child.Predicate == nil || len(child.Children) != 1 {
+			return node, false, nil
+		}
+		merged := append(conjuncts, logical_plan.SplitConjuncts(child.Predicate.Expression)...)
+		result, _, err := r.pushDown(newFilter(child.Children[0], merged))
+		return result, true, err
+
+	case logical_plan.NodeTypeSort:
+		if len(child.Children) != 1 {
+			return node, false, nil
+		}
+		node.Children[0] = child.Children[0]
+		child.Children[0] = node
+		return child, true, nil
+
+	case logical_plan.NodeTypeProject:
+		return pushBelowProject(node, child, conjuncts)
+
+	case logical_plan.NodeTypeJoin:
+		return r.pushIntoJoin(node, child, conjuncts)
+	}
+
+	return node, false, nil
+}
+
+// pushBelowProject moves the conjuncts that only read columns the projection
+// passes through, rewriting aliases back to the underlying column. Conjuncts
+// on computed columns stay above.
+func pushBelowProject(filter, project *logical_plan.LogicalPlan, conjuncts []*logical_plan.Expression) (*logical_plan.LogicalPlan, bool, error) {
+	if len(project.Children) != 1 {
+		return filter, false, nil
+	}
+
+	var pushed, kept []*logical_plan.Expression
+	for _, conjunct := range conjuncts {
+		if rewritten, ok := rewriteThroughProject(conjunct, project.Projections); ok {
+			pushed = append(pushed, rewritten)
+		} else {
+			kept = append(kept, conjunct)
+		}
+	}
+	if len(pushed) == 0 {
+		return filter, false, nil
+	}
+
+	project.Children[0] = newFilter(project.Children[0], pushed)
+	if len(kept) == 0 {
+		return project, true, nil
+	}
+	return newFilter(project, kept), true, nil
+}
+
+var errNotPushable = errors.New("not pushable")
+
+func rewriteThroughProject(expr *logical_plan.Expression, projections []logical_plan.Column) (*logical_plan.Expression, bool) {
+	if containsSubquery(expr) {
+		return nil, false
+	}
+
+	rewritten, _, err := logical_plan.TransformExpression(expr.Clone(), func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if !e.IsColumn() {
+			return e, false, nil
+		}
+		column, found := projectedColumn(*e.Column, projections)
+		switch {
+		case !found && passesThrough(*e.Column, projections):
+			return e, false, nil
+		case !found || column.Expression != nil:
+			return nil, false, errNotPushable
+		}
+		table := column.Table
+		if table == "" {
+			table = e.Column.Table
+		}
+		return logical_plan.NewColumnExpression(table, column.Name), true, nil
+	})
+	if err != nil {
+		return nil, false
+	}
+	return rewritten, true
+}
+
+// projectedColumn finds the projection that produces a referenced column. An
+// aliased or computed column can only be referenced unqualified.
+func projectedColumn(ref logical_plan.ColumnRef, projections []logical_plan.Column) (logical_plan.Column, bool) {
+	for _, column := range projections {
+		if column.Name == "*" && column.Expression == nil {
+			continue
+		}
+		name := column.Name
+		derived := column.Alias != "" || column.Expression != nil
+		if column.Alias != "" {
+			name = column.Alias
+		}
+		if !strings.EqualFold(name, ref.Name) {
+			continue
+		}
+		if ref.Table == "" || (!derived && (column.Table == "" || strings.EqualFold(column.Table, ref.Table))) {
+			return column, true
+		}
+	}
+	return logical_plan.Column{}, false
+}
+
+func passesThrough(ref logical_plan.ColumnRef, projections []logical_plan.Column) bool {
+	for _, column := range projections {
+		if column.Name == "*" && column.Expression == nil && (column.Table == "" || strings.EqualFold(column.Table, ref.Table)) {
+			return true
+		}
+	}
+	return false
+}
+
+type joinSide int
+
+const (
+	sideNone joinSide = iota
+	sideLeft
+	sideRight
+	sideBoth
+	sideUnknown
+)
+
+// pushIntoJoin sends single-side conjuncts into the join's inputs. Inner and
+// cross joins accept them on both sides; an outer join only on its preserved
+// side, since filtering the other side would turn dropped rows into
+// NULL-extended ones instead. Semi and anti joins only output their left
+// side.
+func (r *PredicatePushdownRule) pushIntoJoin(filter, join *logical_plan.LogicalPlan, conjuncts []*logical_plan.Expression) (*logical_plan.LogicalPlan, bool, error) {
+	if len(join.Children) != 2 {
+		return filter, false, nil
+	}
+
+	var allowLeft, allowRight bool
+	switch join.JoinType {
+	case logical_plan.JoinTypeInner, logical_plan.JoinTypeCross, "":
+		allowLeft, allowRight = true, true
+	case logical_plan.JoinTypeLeft, logical_plan.JoinTypeSemi, logical_plan.JoinTypeAnti:
+		allowLeft = true
+	case logical_plan.JoinTypeRight:
+		allowRight = true
+	}
+
+	resolver := newRelationResolver(join.Children, r.Catalog)
+	var left, right, residual []*logical_plan.Expression
+	for _, conjunct := range conjuncts {
+		switch side := resolver.side(conjunct); {
+		case side == sideLeft && allowLeft:
+			left = append(left, conjunct)
+		case side == sideRight && allowRight:
+			right = append(right, conjunct)
+		default:
+			residual = append(residual, conjunct)
+		}
+	}
+	if len(left) == 0 && len(right) == 0 {
+		return filter, false, nil
+	}
+
+	if len(left) > 0 {
+		join.Children[0] = newFilter(join.Children[0], left)
+	}
+	if len(right) > 0 {
+		join.Children[1] = newFilter(join.Children[1], right)
+	}
+	if len(residual) == 0 {
+		return join, true, nil
+	}
+	return newFilter(join, residual), true, nil
+}
+
+// side reports which input of the join a conjunct reads from.
+func (r *relationResolver) side(conjunct *logical_plan.Expression) joinSide {
+	relations, ok := r.relations(conjunct)
+	switch {
+	case !ok:
+		return sideUnknown
+	case len(relations) == 0:
+		return sideNone
+	case len(relations) == 2:
+		return sideBoth
+	case relations[0] == 0:
+		return sideLeft
+	default:
+		return sideRight
+	}
+}
+
+// relationResolver finds which of a set of sibling subtrees, such as the
+// inputs of a join, each column reference reads from.
+type relationResolver struct {
+	inputs      []*logical_plan.LogicalPlan
+	catalog     *catalog.CatalogManager
+	names       []map[string]bool
+	scopes      []binder.Scope
+	scopesBound bool
+}
+
+func newRelationResolver(inputs []*logical_plan.LogicalPlan, cm *catalog.CatalogManager) *relationResolver {
+	return &relationResolver{inputs: inputs, catalog: cm}
+}
+
+// relations returns the sorted, distinct inputs an expression reads from. It
+// fails when a reference cannot be attributed to exactly one input, or the
+// expression holds a subquery.
+func (r *relationResolver) relations(expr *logical_plan.Expression) ([]int, bool) {
+	if containsSubquery(expr) {
+		return nil, false
+	}
+
+	seen := make(map[int]bool)
+	var relations []int
+	for _, ref := range binder.ExpressionReferences(expr) {
+		index := r.resolve(ref)
+		if index < 0 {
+			return nil, false
+		}
+		if !seen[index] {
+			seen[index] = true
+			relations = append(relations, index)
+		}
+	}
+	sort.Ints(relations)
+	return relations, true
+}
+
+// resolve uses the qualifier when there is one, and otherwise the schemas of
+// the inputs. It returns -1 when the reference is unknown or ambiguous.
+func (r *relationResolver) resolve(ref logical_plan.ColumnRef) int {
+	match := -1
+	if ref.Table != "" {
+		if r.names == nil {
+			for _, input := range r.inputs {
+				r.names = append(r.names, qualifiers(input))
+			}
+		}
+		for i, names := range r.names {
+			if names[strings.ToLower(ref.Table)] {
+				if match >= 0 {
+					return -1
+				}
+				match = i
+			}
+		}
+		return match
+	}
+
+	if !r.scopesBound {
+		r.scopes = make([]binder.Scope, len(r.inputs))
+		for i, input := range r.inputs {
+			if bound, _ := binder.Bind(input, r.catalog); bound != nil {
+				r.scopes[i] = bound.Output(input)
+			}
+		}
+		r.scopesBound = true
+	}
+	for i, scope := range r.scopes {
+		if _, err := scope.Resolve(ref); err == nil {
+			if match >= 0 {
+				return -1
+			}
+			match = i
+		}
+	}
+	return match
+}
+
+// qualifiers collects the names columns of a subtree can be qualified with:
+// relation and table names of its scans, or a subquery's alias, which hides
+// everything below it.
+func qualifiers(plan *logical_plan.LogicalPlan) map[string]bool {
+	names := make(map[string]bool)
+	var walk func(node *logical_plan.LogicalPlan)
+	walk = func(node *logical_plan.LogicalPlan) {
+		switch {
+		case node.NodeType == logical_plan.NodeTypeSubquery && node.Alias != "":
+			names[strings.ToLower(node.Alias)] = true
+			return
+		case node.NodeType == logical_plan.NodeTypeScan:
+			names[strings.ToLower(node.RelationName())] = true
+			names[strings.ToLower(node.TableName)] = true
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(plan)
+	return names
+}
+
+func containsSubquery(expr *logical_plan.Expression) bool {
+	found := false
+	logical_plan.TransformExpression(expr, func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if e.Kind == logical_plan.ExprSubquery {
+			found = true
+		}
+		return e, false, nil
+	})
+	return found
+}
+
+func newFilter(child *logical_plan.LogicalPlan, conjuncts []*logical_plan.Expression) *logical_plan.LogicalPlan {
+	return logical_plan.NewFilterNode(child, &logical_plan.Predicate{
+		Expression: logical_plan.CombineConjuncts(conjuncts),
+	})
+}
@@ -0,0 +1,203 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// PredicateTransitivityRule tracks which columns the conditions of a tree of
+// inner joins make equal, and copies comparisons with a constant on one of
+// them to the others: given a.x = b.x AND a.x = 5 it adds b.x = 5 as a filter
+// on b, where PredicatePushdown can take it down to the scan. The implied
+// join edges themselves are added when joins are reordered.
+type PredicateTransitivityRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *PredicateTransitivityRule) Name() string {
+	return "PredicateTransitivity"
+}
+
+func (r *PredicateTransitivityRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+func (r *PredicateTransitivityRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	t := &transitivity{catalog: r.Catalog}
+	t.visit(plan)
+	return plan, t.details, nil
+}
+
+type transitivity struct {
+	catalog *catalog.CatalogManager
+	details []string
+}
+
+func (t *transitivity) visit(node *logical_plan.LogicalPlan) {
+	if !isJoinRegion(node) {
+		for _, child := range node.Children {
+			t.visit(child)
+		}
+		return
+	}
+
+	region := &joinRegion{}
+	region.collect(node)
+	for _, input := range region.inputs {
+		t.visit(input)
+	}
+	if replacements := t.infer(region); len(replacements) > 0 {
+		replaceRegionInputs(node, replacements)
+	}
+}
+
+// infer adds the comparisons with constants implied by the region's column
+// equalities to the inputs they read, and returns the inputs it filtered.
+func (t *transitivity) infer(region *joinRegion) map[*logical_plan.LogicalPlan]*logical_plan.LogicalPlan {
+	facts := append([]*logical_plan.Expression(nil), region.conjuncts...)
+	for _, input := range region.inputs {
+		for node := input; node.NodeType == logical_plan.NodeTypeFilter && len(node.Children) == 1; node = node.Children[0] {
+			if node.Predicate != nil {
+				facts = append(facts, logical_plan.SplitConjuncts(node.Predicate.Expression)...)
+			}
+		}
+	}
+
+	classes := logical_plan.NewEquivalenceClasses()
+	for _, fact := range facts {
+		classes.Add(fact)
+	}
+
+	resolver := newRelationResolver(region.inputs, t.catalog)
+	known := make(map[string]bool)
+	for _, fact := range facts {
+		known[fact.String()] = true
+	}
+	inferred := make([][]*logical_plan.Expression, len(region.inputs))
+	for _, fact := range facts {
+		ref, op, _, ok := literalComparison(fact)
+		if !ok {
+			continue
+		}
+		members := classes.Members(ref)
+		literal := fact.Right
+		if fact.Left.IsLiteral() {
+			literal = fact.Left
+		}
+		for _, member := range members {
+			if strings.EqualFold(member.String(), ref.String()) {
+				continue
+			}
+			conjunct := logical_plan.NewBinaryOpExpression(op, logical_plan.NewColumnExpression(member.Table, member.Name), literal.Clone())
+			if known[conjunct.String()] {
+				continue
+			}
+			relations, ok := resolver.relations(conjunct)
+			if !ok || len(relations) != 1 || enforces(region.inputs[relations[0]], conjunct) {
+				continue
+			}
+			known[conjunct.String()] = true
+			inferred[relations[0]] = append(inferred[relations[0]], conjunct)
+			t.details = append(t.details, fmt.Sprintf("inferred %s from %s, since %s", conjunct, fact, joinColumns(members, " = ")))
+		}
+	}
+
+	replacements := make(map[*logical_plan.LogicalPlan]*logical_plan.LogicalPlan)
+	for i, conjuncts := range inferred {
+		if len(conjuncts) > 0 {
+			replacements[region.inputs[i]] = newFilter(region.inputs[i], conjuncts)
+		}
+	}
+	return replacements
+}
+
+// enforces reports whether a filter below node, reached through filters and
+// projections, already holds conjunct.
+func enforces(node *logical_plan.LogicalPlan, conjunct *logical_plan.Expression) bool {
+	for len(node.Children) == 1 {
+		switch node.NodeType {
+		case logical_plan.NodeTypeFilter:
+			if node.Predicate != nil {
+				for _, existing := range logical_plan.SplitConjuncts(node.Predicate.Expression) {
+					if existing.String() == conjunct.String() {
+						return true
+					}
+				}
+			}
+		case logical_plan.NodeTypeProject:
+			rewritten, ok := rewriteThroughProject(conjunct, node.Projections)
+			if !ok {
+				return false
+			}
+			conjunct = rewritten
+		default:
+			return false
+		}
+		node = node.Children[0]
+	}
+	return false
+}
+
+// replaceRegionInputs swaps inputs of the join region rooted at node.
+func replaceRegionInputs(node *logical_plan.LogicalPlan, replacements map[*logical_plan.LogicalPlan]*logical_plan.LogicalPlan) {
+	for i, child := range node.Children {
+		if replacement, ok := replacements[child]; ok {
+			node.Children[i] = replacement
+		} else if isJoinRegion(child) {
+			replaceRegionInputs(child, replacements)
+		}
+	}
+}
+
+// impliedEqualities returns the column equalities that follow from the
+// conjuncts but are not among them, between columns of different relations.
+func impliedEqualities(conjuncts []*logical_plan.Expression, resolver *relationResolver) []*logical_plan.Expression {
+	classes := logical_plan.NewEquivalenceClasses()
+	for _, conjunct := range conjuncts {
+		classes.Add(conjunct)
+	}
+
+	direct := make(map[string]bool)
+	for _, conjunct := range conjuncts {
+		if left, right, ok := logical_plan.ColumnEquality(conjunct); ok {
+			direct[pairKey(left, right)] = true
+		}
+	}
+	var implied []*logical_plan.Expression
+	for _, class := range classes.Classes() {
+		for i, left := range class {
+			for _, right := range class[i+1:] {
+				if direct[pairKey(left, right)] {
+					continue
+				}
+				equality := logical_plan.NewBinaryOpExpression(logical_plan.OpEq,
+					logical_plan.NewColumnExpression(left.Table, left.Name),
+					logical_plan.NewColumnExpression(right.Table, right.Name))
+				if relations, ok := resolver.relations(equality); ok && len(relations) == 2 {
+					implied = append(implied, equality)
+				}
+			}
+		}
+	}
+	return implied
+}
+
+func pairKey(a, b logical_plan.ColumnRef) string {
+	x, y := strings.ToLower(a.String()), strings.ToLower(b.String())
+	if x > y {
+		x, y = y, x
+	}
+	return x + "=" + y
+}
+
+func joinColumns(columns []logical_plan.ColumnRef, sep string) string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.String()
+	}
+	return strings.Join(names, sep)
+}
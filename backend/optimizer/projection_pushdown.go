@@ -0,0 +1,227 @@
+package optimizer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// ProjectionPushdownRule prunes the columns nothing above reads. The columns
+// each node must produce are computed top-down from the root's output: joins
+// and aggregates get a narrow projection below them, projections drop unused
+// columns, and scans list the columns they read in ScanColumns. Redundant
+// SELECT * projections are removed.
+type ProjectionPushdownRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *ProjectionPushdownRule) Name() string {
+	return "ProjectionPushdown"
+}
+
+func (r *ProjectionPushdownRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+func (r *ProjectionPushdownRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	var details []string
+	plan, _, err := logical_plan.TransformDown(plan, func(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		if node.NodeType == logical_plan.NodeTypeProject && len(node.Children) == 1 && isRedundantProjection(node.Projections) {
+			details = append(details, "removed projection of *")
+			return node.Children[0], true, nil
+		}
+		return node, false, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Pruning is only safe when every reference resolves to one column.
+	bound, err := binder.Bind(plan, r.Catalog)
+	if err != nil {
+		return plan, details, nil
+	}
+	pruner := &columnPruner{bound: bound}
+	pruner.prune(plan, nil)
+	return plan, append(details, pruner.details...), nil
+}
+
+func isRedundantProjection(projections []logical_plan.Column) bool {
+	return len(projections) == 1 && projections[0].Name == "*" && projections[0].Table == "" && projections[0].Expression == nil
+}
+
+type columnPruner struct {
+	bound   *binder.BoundPlan
+	details []string
+}
+
+// prune narrows node so it produces the required columns of its output, by
+// index. A nil required means every column.
+func (p *columnPruner) prune(node *logical_plan.LogicalPlan, required []bool) {
+	output := p.bound.Output(node)
+	if required != nil && len(required) != len(output) {
+		required = nil
+	}
+
+	switch node.NodeType {
+	case logical_plan.NodeTypeScan:
+		p.annotateScan(node, output, required)
+		return
+	case logical_plan.NodeTypeProject:
+		p.dropUnusedProjections(node, output, required)
+	}
+
+	needed := p.inputRequired(node, required)
+	offset := 0
+	for i, child := range node.Children {
+		childOutput := p.bound.Output(child)
+		var childRequired []bool
+		if needed != nil && offset+len(childOutput) <= len(needed) {
+			childRequired = needed[offset : offset+len(childOutput)]
+		}
+		offset += len(childOutput)
+
+		p.prune(child, childRequired)
+
+		narrowBelow := node.NodeType == logical_plan.NodeTypeJoin || node.NodeType == logical_plan.NodeTypeAggregate
+		if narrowBelow && child.NodeType != logical_plan.NodeTypeProject && isPartial(childRequired) {
+			node.Children[i] = p.narrow(node, child, childOutput, childRequired)
+		}
+	}
+}
+
+// inputRequired marks the input columns a node reads to produce the required
+// output columns. It returns nil, meaning every input column, when that
+// cannot be worked out, such as for nodes with subqueries whose correlated
+// references are not visible.
+func (p *columnPruner) inputRequired(node *logical_plan.LogicalPlan, required []bool) []bool {
+	input := p.bound.Input(node)
+	if hasSubquery(node) {
+		return nil
+	}
+
+	var needed []bool
+	switch node.NodeType {
+	case logical_plan.NodeTypeFilter, logical_plan.NodeTypeSort, logical_plan.NodeTypeLimit, logical_plan.NodeTypeTopN, logical_plan.NodeTypeJoin:
+		// These pass their input through unchanged, except that semi and anti
+		// joins drop their right input's columns.
+		if required == nil {
+			return nil
+		}
+		needed = make([]bool, len(input))
+		if copy(needed, required) != len(input) && !node.JoinType.LeftOnly() {
+			return nil
+		}
+	case logical_plan.NodeTypeProject, logical_plan.NodeTypeAggregate:
+		needed = make([]bool, len(input))
+	case logical_plan.NodeTypeSubquery:
+		return required
+	default:
+		return nil
+	}
+
+	for _, ref := range binder.References(node) {
+		index, err := input.Resolve(ref)
+		if err != nil {
+			return nil
+		}
+		needed[index] = true
+	}
+	for _, column := range node.Projections {
+		if column.Name != "*" || column.Expression != nil {
+			continue
+		}
+		for i, c := range input {
+			if column.Table == "" || strings.EqualFold(c.Relation, column.Table) || strings.EqualFold(c.Table, column.Table) {
+				needed[i] = true
+			}
+		}
+	}
+	return needed
+}
+
+// dropUnusedProjections removes the projected columns nothing above reads,
+// keeping at least one. Projections of * are left alone, since their output
+// cannot be matched to a single entry.
+func (p *columnPruner) dropUnusedProjections(node *logical_plan.LogicalPlan, output binder.Scope, required []bool) {
+	if required == nil || len(node.Projections) != len(output) || !slices.Contains(required, true) {
+		return
+	}
+	for _, column := range node.Projections {
+		if column.Name == "*" && column.Expression == nil {
+			return
+		}
+	}
+
+	var kept []logical_plan.Column
+	var dropped []string
+	for i, column := range node.Projections {
+		if required[i] {
+			kept = append(kept, column)
+		} else {
+			dropped = append(dropped, column.String())
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+
+	node.Projections = kept
+	p.details = append(p.details, fmt.Sprintf("dropped unused columns %s from projection", strings.Join(dropped, ", ")))
+}
+
+// narrow puts a projection of the required columns on top of child.
+func (p *columnPruner) narrow(parent, child *logical_plan.LogicalPlan, output binder.Scope, required []bool) *logical_plan.LogicalPlan {
+	var columns []logical_plan.Column
+	var names []string
+	for i, column := range output {
+		if required[i] {
+			columns = append(columns, logical_plan.Column{Table: column.Relation, Name: column.Name})
+			names = append(names, column.String())
+		}
+	}
+	p.details = append(p.details, fmt.Sprintf("projected %s below %s", strings.Join(names, ", "), parent.NodeType))
+	return logical_plan.NewProjectNode(child, columns)
+}
+
+func (p *columnPruner) annotateScan(node *logical_plan.LogicalPlan, output binder.Scope, required []bool) {
+	var columns []string
+	if isPartial(required) {
+		for i, column := range output {
+			if required[i] {
+				columns = append(columns, column.SourceName)
+			}
+		}
+	}
+	if slices.Equal(columns, node.ScanColumns) {
+		return
+	}
+
+	node.ScanColumns = columns
+	if columns == nil {
+		p.details = append(p.details, fmt.Sprintf("%s reads every column", node.Label()))
+		return
+	}
+	p.details = append(p.details, fmt.Sprintf("%s reads %s of %d columns", node.Label(), strings.Join(columns, ", "), len(output)))
+}
+
+// isPartial reports whether some, but not all, columns are required.
+func isPartial(required []bool) bool {
+	return slices.Contains(required, true) && slices.Contains(required, false)
+}
+
+func hasSubquery(node *logical_plan.LogicalPlan) bool {
+	found := false
+	node.TransformExpressions(func(expr *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if expr.Kind == logical_plan.ExprSubquery {
+			found = true
+		}
+		return expr, false, nil
+	})
+	return found
+}
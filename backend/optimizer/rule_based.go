@@ -1,8 +1,12 @@
 package optimizer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
+	"retr0-kernel/optiquery/cascades"
+	"retr0-kernel/optiquery/catalog"
 	"retr0-kernel/optiquery/logical_plan"
 )
 
@@ -11,10 +15,53 @@ type OptimizationRule interface {
 	Name() string
 }
 
+// DescribingRule is implemented by rules that report each rewrite they make.
+// The lines end up in the step's Details.
+type DescribingRule interface {
+	OptimizationRule
+	ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error)
+}
+
 type ExplainResult struct {
-	AppliedRules []string               `json:"applied_rules"`
-	Steps        []OptimizationStep     `json:"steps"`
-	Statistics   OptimizationStatistics `json:"statistics"`
+	AppliedRules    []string               `json:"applied_rules"`
+	Steps           []OptimizationStep     `json:"steps"`
+	Statistics      OptimizationStatistics `json:"statistics"`
+	PlanFingerprint string                 `json:"plan_fingerprint,omitempty"`
+	JoinOrders      []JoinOrderChoice      `json:"join_orders,omitempty"`
+	// Memo is the search space explored by the cascades strategy.
+	Memo *cascades.MemoExport `json:"memo,omitempty"`
+	// Partial is set when the optimizer's context ended the search early.
+	// The plan is then the best found by that time.
+	Partial       bool   `json:"partial,omitempty"`
+	PartialReason string `json:"partial_reason,omitempty"`
+	// Hints reports which of the query's hints were followed.
+	Hints []HintReport `json:"hints,omitempty"`
+	// MaterializedViews reports which views answered part of the query.
+	MaterializedViews []ViewMatch `json:"materialized_views,omitempty"`
+	// SharedSubplans reports the subplans that occur more than once and
+	// whether each was spooled. PlanDAG then lists every node of the plan
+	// once, as spools have several parents.
+	SharedSubplans []SharedSubplan       `json:"shared_subplans,omitempty"`
+	PlanDAG        *logical_plan.PlanDAG `json:"plan_dag,omitempty"`
+}
+
+// interrupted reports whether ctx is done, marking the result partial when
+// it is.
+func (e *ExplainResult) interrupted(ctx context.Context) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	e.markPartial(ctx.Err())
+	return true
+}
+
+func (e *ExplainResult) markPartial(err error) {
+	e.Partial = true
+	if errors.Is(err, context.DeadlineExceeded) {
+		e.PartialReason = "optimization timed out"
+	} else {
+		e.PartialReason = "optimization was canceled"
+	}
 }
 
 type OptimizationStep struct {
@@ -22,6 +69,8 @@ type OptimizationStep struct {
 	BeforePlan  *logical_plan.LogicalPlan `json:"before_plan"`
 	AfterPlan   *logical_plan.LogicalPlan `json:"after_plan"`
 	Description string                    `json:"description"`
+	Diff        *logical_plan.PlanDiff    `json:"diff,omitempty"`
+	Details     []string                  `json:"details,omitempty"`
 }
 
 type OptimizationStatistics struct {
@@ -30,26 +79,40 @@ type OptimizationStatistics struct {
 }
 
 type RuleBasedOptimizer struct {
-	rules []OptimizationRule
+	rules         []OptimizationRule
+	maxIterations int
 }
 
 func NewRuleBasedOptimizer() *RuleBasedOptimizer {
-	return &RuleBasedOptimizer{
-		rules: []OptimizationRule{
-			&PredicatePushdownRule{},
-			&ProjectionPushdownRule{},
-			&ConstantFoldingRule{},
-			&JoinReorderingRule{},
-		},
+	return NewRuleBasedOptimizerWithCatalog(nil)
+}
+
+// NewRuleBasedOptimizerWithCatalog lets rules resolve unqualified column
+// references against table schemas. A nil catalog is allowed.
+func NewRuleBasedOptimizerWithCatalog(catalogMgr *catalog.CatalogManager) *RuleBasedOptimizer {
+	return NewRuleBasedOptimizerWithOptions(catalogMgr, DefaultOptions())
+}
+
+// NewRuleBasedOptimizerWithOptions leaves out the rules opts disables.
+func NewRuleBasedOptimizerWithOptions(catalogMgr *catalog.CatalogManager, opts Options) *RuleBasedOptimizer {
+	opts = opts.WithDefaults(DefaultOptions())
+	rbo := &RuleBasedOptimizer{maxIterations: opts.MaxIterations}
+	for _, rule := range rewriteRules(catalogMgr) {
+		if opts.RuleEnabled(rule.Name()) {
+			rbo.rules = append(rbo.rules, rule)
+		}
 	}
+	return rbo
 }
 
-func OptimizeWithRules(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, *ExplainResult, error) {
-	optimizer := NewRuleBasedOptimizer()
-	return optimizer.Optimize(plan)
+func OptimizeWithRules(plan *logical_plan.LogicalPlan, catalogMgr *catalog.CatalogManager) (*logical_plan.LogicalPlan, *ExplainResult, error) {
+	optimizer := NewRuleBasedOptimizerWithCatalog(catalogMgr)
+	return optimizer.Optimize(context.Background(), plan)
 }
 
-func (rbo *RuleBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, *ExplainResult, error) {
+// Optimize applies the rules until none changes the plan. Once ctx is done no
+// further rules are applied and the plan rewritten so far is returned.
+func (rbo *RuleBasedOptimizer) Optimize(ctx context.Context, plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, *ExplainResult, error) {
 	if plan == nil {
 		return nil, nil, fmt.Errorf("cannot optimize nil plan")
 	}
@@ -63,13 +126,24 @@ func (rbo *RuleBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logica
 	currentPlan := plan.Clone()
 	totalRulesApplied := 0
 
-	maxIterations := 10
-	for iteration := 0; iteration < maxIterations; iteration++ {
+	for iteration := 0; iteration < rbo.maxIterations; iteration++ {
 		changed := false
 
 		for _, rule := range rbo.rules {
+			if explain.interrupted(ctx) {
+				break
+			}
 			beforePlan := currentPlan.Clone()
-			optimizedPlan, ruleApplied, err := rule.Apply(currentPlan)
+			var optimizedPlan *logical_plan.LogicalPlan
+			var ruleApplied bool
+			var details []string
+			var err error
+			if describing, ok := rule.(DescribingRule); ok {
+				optimizedPlan, details, err = describing.ApplyAndDescribe(currentPlan)
+				ruleApplied = len(details) > 0
+			} else {
+				optimizedPlan, ruleApplied, err = rule.Apply(currentPlan)
+			}
 			if err != nil {
 				return nil, explain, fmt.Errorf("error applying rule %s: %w", rule.Name(), err)
 			}
@@ -79,8 +153,10 @@ func (rbo *RuleBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logica
 				explain.Steps = append(explain.Steps, OptimizationStep{
 					RuleName:    rule.Name(),
 					BeforePlan:  beforePlan,
-					AfterPlan:   optimizedPlan,
+					AfterPlan:   optimizedPlan.Clone(),
 					Description: fmt.Sprintf("Applied %s rule", rule.Name()),
+					Diff:        logical_plan.Diff(beforePlan, optimizedPlan),
+					Details:     details,
 				})
 
 				currentPlan = optimizedPlan
@@ -89,141 +165,22 @@ func (rbo *RuleBasedOptimizer) Optimize(plan *logical_plan.LogicalPlan) (*logica
 			}
 		}
 
-		if !changed {
+		if !changed || explain.Partial {
 			break
 		}
 	}
 
 	explain.Statistics.TotalRulesApplied = totalRulesApplied
+	explain.PlanFingerprint = planFingerprint(currentPlan)
 	return currentPlan, explain, nil
 }
 
-type PredicatePushdownRule struct{}
-
-func (r *PredicatePushdownRule) Name() string {
-	return "PredicatePushdown"
-}
-
-func (r *PredicatePushdownRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
-	return r.applyRecursive(plan)
-}
-
-func (r *PredicatePushdownRule) applyRecursive(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
-	if plan == nil {
-		return nil, false, nil
-	}
-
-	changed := false
-
-	if plan.NodeType == logical_plan.NodeTypeFilter && len(plan.Children) == 1 {
-		child := plan.Children[0]
-
-		switch child.NodeType {
-		case logical_plan.NodeTypeProject:
-
-			if canPushFilterBelowProject(plan.Predicate, child) {
-
-				newFilter := logical_plan.NewFilterNode(child.Children[0], plan.Predicate)
-				newProject := logical_plan.NewProjectNode(newFilter, child.Projections)
-				plan = newProject
-				changed = true
-			}
-		case logical_plan.NodeTypeJoin:
-
-			leftPushable, rightPushable := canPushFilterToJoinSides(plan.Predicate, child)
-			if leftPushable || rightPushable {
-
-				changed = true
-			}
-		}
-	}
-
-	for i, child := range plan.Children {
-		optimizedChild, childChanged, err := r.applyRecursive(child)
-		if err != nil {
-			return nil, false, err
-		}
-		if childChanged {
-			plan.Children[i] = optimizedChild
-			changed = true
-		}
-	}
-
-	return plan, changed, nil
-}
-
-type ProjectionPushdownRule struct{}
-
-func (r *ProjectionPushdownRule) Name() string {
-	return "ProjectionPushdown"
-}
-
-func (r *ProjectionPushdownRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
-	return r.applyRecursive(plan)
-}
-
-func (r *ProjectionPushdownRule) applyRecursive(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
-	if plan == nil {
-		return nil, false, nil
-	}
-
-	changed := false
-
-	if plan.NodeType == logical_plan.NodeTypeProject && len(plan.Children) == 1 {
-		child := plan.Children[0]
-
-		if isRedundantProjection(plan.Projections) {
-			plan = child
-			changed = true
-		}
-	}
-
-	for i, child := range plan.Children {
-		optimizedChild, childChanged, err := r.applyRecursive(child)
-		if err != nil {
-			return nil, false, err
-		}
-		if childChanged {
-			plan.Children[i] = optimizedChild
-			changed = true
-		}
-	}
-
-	return plan, changed, nil
-}
-
-type ConstantFoldingRule struct{}
-
-func (r *ConstantFoldingRule) Name() string {
-	return "ConstantFolding"
-}
-
-func (r *ConstantFoldingRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
-
-	return plan, false, nil
-}
-
-type JoinReorderingRule struct{}
-
-func (r *JoinReorderingRule) Name() string {
-	return "JoinReordering"
-}
-
-func (r *JoinReorderingRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
-
-	return plan, false, nil
-}
-
-func canPushFilterBelowProject(predicate *logical_plan.Predicate, projectNode *logical_plan.LogicalPlan) bool {
-
-	return true
-}
-
-func canPushFilterToJoinSides(predicate *logical_plan.Predicate, joinNode *logical_plan.LogicalPlan) (bool, bool) {
-
-	return false, false
-}
-
-func isRedundantProjection(projections []logical_plan.Column) bool {
-	return len(projections) == 1 && projections[0].Name == "*"
+// planFingerprint identifies the exact plan an optimizer produced, including
+// physical choices, so results can be compared across optimizer versions.
+func planFingerprint(plan *logical_plan.LogicalPlan) string {
+	return logical_plan.Fingerprint(plan, logical_plan.FingerprintOptions{
+		KeepLiterals:    true,
+		IncludePhysical: true,
+		OrderedJoins:    true,
+	})
 }
@@ -0,0 +1,324 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// SubplanSharingRule finds subplans that occur more than once, such as a CTE
+// or derived table referenced several times or the same join in every branch
+// of a UNION, by fingerprinting each subtree. Every set of identical subplans
+// is then either computed once per occurrence or materialized once into a
+// spool node that each occurrence's parent reads, whichever the cost model
+// estimates cheaper; writing a spool is taken to cost as much as reading it
+// back. Subplans only match when their aliases, literals, join order and
+// physical operators all agree.
+type SubplanSharingRule struct {
+	Catalog   *catalog.CatalogManager
+	CostModel cost_model.CostModel
+}
+
+// SharedSubplan records the choice made for one subplan that occurs several
+// times.
+type SharedSubplan struct {
+	Subplan     string `json:"subplan"`
+	Fingerprint string `json:"fingerprint"`
+	// Consumers are the IDs of the nodes reading the subplan.
+	Consumers []string `json:"consumers"`
+	Spooled   bool     `json:"spooled"`
+	Spool     string   `json:"spool,omitempty"`
+	SpoolID   string   `json:"spool_id,omitempty"`
+	// Cost is one computation of the subplan and ReadCost one read of its
+	// spool.
+	Cost       float64 `json:"cost"`
+	ReadCost   float64 `json:"read_cost"`
+	InlineCost float64 `json:"inline_cost"`
+	SpoolCost  float64 `json:"spool_cost"`
+}
+
+func (s SharedSubplan) String() string {
+	if s.Spooled {
+		return fmt.Sprintf("spooled the %s read by %d consumers as %s (cost %.2f to %.2f)",
+			s.Subplan, len(s.Consumers), s.Spool, s.InlineCost, s.SpoolCost)
+	}
+	return fmt.Sprintf("inlined the %s read by %d consumers (cost %.2f, %.2f spooled)",
+		s.Subplan, len(s.Consumers), s.InlineCost, s.SpoolCost)
+}
+
+func (r *SubplanSharingRule) Name() string {
+	return "SubplanSharing"
+}
+
+func (r *SubplanSharingRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+// ApplyAndDescribe reports only the subplans that were spooled.
+func (r *SubplanSharingRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	result, shared, err := r.Share(plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	var details []string
+	for _, subplan := range shared {
+		if subplan.Spooled {
+			details = append(details, subplan.String())
+		}
+	}
+	return result, details, nil
+}
+
+// Share spools the repeated subplans that are cheaper to compute once, and
+// reports the choice made for each, including those left inline. Larger
+// subplans are considered first; occurrences inside a copy a spool made
+// redundant are not considered again.
+func (r *SubplanSharingRule) Share(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []SharedSubplan, error) {
+	if plan == nil {
+		return nil, nil, nil
+	}
+	s := &subplanSharer{
+		catalog:   r.Catalog,
+		costModel: r.CostModel,
+		groups:    make(map[string]*subplanGroup),
+		spools:    make(map[string]*logical_plan.LogicalPlan),
+		discarded: make(map[*logical_plan.LogicalPlan]bool),
+	}
+	if s.catalog == nil {
+		s.catalog = catalog.NewCatalogManager()
+	}
+	if s.costModel == nil {
+		s.costModel = cost_model.NewSimpleCostModel()
+	}
+
+	result := plan.Clone()
+	s.collect(result, make(map[*logical_plan.LogicalPlan]bool))
+
+	var candidates []*subplanGroup
+	for _, group := range s.order {
+		if len(group.occurrences) > 1 {
+			candidates = append(candidates, group)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].size > candidates[j].size
+	})
+
+	var shared []SharedSubplan
+	for _, group := range candidates {
+		subplan, err := s.share(group)
+		if err != nil {
+			return nil, nil, err
+		}
+		if subplan != nil {
+			shared = append(shared, *subplan)
+		}
+	}
+	return result, shared, nil
+}
+
+type subplanSharer struct {
+	catalog   *catalog.CatalogManager
+	costModel cost_model.CostModel
+	groups    map[string]*subplanGroup
+	order     []*subplanGroup
+	// spools are the spool nodes of the plan by name.
+	spools map[string]*logical_plan.LogicalPlan
+	// discarded are the nodes of occurrences replaced by a spool.
+	discarded map[*logical_plan.LogicalPlan]bool
+}
+
+// subplanGroup is a set of identical subplans.
+type subplanGroup struct {
+	fingerprint string
+	size        int
+	occurrences []subplanOccurrence
+}
+
+// subplanOccurrence is the place of a subplan in the plan: a child of parent.
+type subplanOccurrence struct {
+	parent *logical_plan.LogicalPlan
+	index  int
+}
+
+func (o subplanOccurrence) node() *logical_plan.LogicalPlan {
+	return o.parent.Children[o.index]
+}
+
+// collect groups the children of every node by fingerprint. Spool nodes
+// given with the same name, as in a plan read back from JSON, are made one
+// node again. Scans, empty relations and spools are not worth sharing and
+// get no group.
+func (s *subplanSharer) collect(node *logical_plan.LogicalPlan, visited map[*logical_plan.LogicalPlan]bool) {
+	if visited[node] {
+		return
+	}
+	visited[node] = true
+	for i, child := range node.Children {
+		if child.NodeType == logical_plan.NodeTypeSpool {
+			if spool, ok := s.spools[child.Spool]; ok {
+				node.Children[i] = spool
+				continue
+			}
+			s.spools[child.Spool] = child
+		}
+		s.collect(child, visited)
+
+		switch child.NodeType {
+		case logical_plan.NodeTypeScan, logical_plan.NodeTypeEmpty, logical_plan.NodeTypeSpool:
+			continue
+		}
+		fingerprint := logical_plan.Fingerprint(child, logical_plan.FingerprintOptions{
+			KeepLiterals:    true,
+			IncludePhysical: true,
+			OrderedJoins:    true,
+			KeepAliases:     true,
+		})
+		group, ok := s.groups[fingerprint]
+		if !ok {
+			group = &subplanGroup{fingerprint: fingerprint, size: planSize(child)}
+			s.groups[fingerprint] = group
+			s.order = append(s.order, group)
+		}
+		group.occurrences = append(group.occurrences, subplanOccurrence{parent: node, index: i})
+	}
+}
+
+// share costs computing the group's subplan at each remaining occurrence
+// against computing it once into a spool, and spools it when that is
+// strictly cheaper. It returns nothing when fewer than two occurrences
+// remain.
+func (s *subplanSharer) share(group *subplanGroup) (*SharedSubplan, error) {
+	var occurrences []subplanOccurrence
+	for _, occurrence := range group.occurrences {
+		if !s.discarded[occurrence.parent] {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	if len(occurrences) < 2 {
+		return nil, nil
+	}
+
+	kept := occurrences[0].node()
+	estimate, err := s.costModel.EstimateCost(kept, s.catalog)
+	if err != nil {
+		return nil, fmt.Errorf("estimating cost of %s: %w", kept.Label(), err)
+	}
+	spool := logical_plan.NewSpoolNode(kept, s.spoolName())
+	read, err := s.costModel.EstimateCost(spool, s.catalog)
+	if err != nil {
+		return nil, fmt.Errorf("estimating cost of %s: %w", spool.Label(), err)
+	}
+
+	n := float64(len(occurrences))
+	subplan := &SharedSubplan{
+		Subplan:     fmt.Sprintf("%s over %s", kept.NodeType, relationList(kept)),
+		Fingerprint: group.fingerprint,
+		Cost:        estimate.TotalCost,
+		ReadCost:    read.TotalCost,
+		InlineCost:  n * estimate.TotalCost,
+		SpoolCost:   estimate.TotalCost + (n+1)*read.TotalCost,
+	}
+	for _, occurrence := range occurrences {
+		subplan.Consumers = append(subplan.Consumers, occurrence.parent.ID)
+	}
+	if subplan.SpoolCost >= subplan.InlineCost {
+		return subplan, nil
+	}
+
+	subplan.Spooled = true
+	subplan.Spool = spool.Spool
+	subplan.SpoolID = spool.ID
+	s.spools[spool.Spool] = spool
+	for i, occurrence := range occurrences {
+		if i > 0 {
+			s.discard(occurrence.node())
+		}
+		occurrence.parent.Children[occurrence.index] = spool
+	}
+	return subplan, nil
+}
+
+func (s *subplanSharer) discard(node *logical_plan.LogicalPlan) {
+	s.discarded[node] = true
+	for _, child := range node.Children {
+		s.discard(child)
+	}
+}
+
+func (s *subplanSharer) spoolName() string {
+	for i := len(s.spools) + 1; ; i++ {
+		name := fmt.Sprintf("spool_%d", i)
+		if _, taken := s.spools[name]; !taken {
+			return name
+		}
+	}
+}
+
+func planSize(node *logical_plan.LogicalPlan) int {
+	size := 1
+	for _, child := range node.Children {
+		size += planSize(child)
+	}
+	return size
+}
+
+// planCost is the cost of a plan whose spools are each produced once, however
+// many parents read them. The cost model charges every parent for reading a
+// spool; producing it is the cost of its input plus writing it, which costs
+// as much as one read. Spools are told apart by name, as a plan read back
+// from JSON has a copy of a spool under each of its parents.
+func planCost(plan *logical_plan.LogicalPlan, costModel cost_model.CostModel, catalogMgr *catalog.CatalogManager) (*cost_model.CostEstimate, error) {
+	estimate, err := costModel.EstimateCost(plan, catalogMgr)
+	if err != nil {
+		return nil, err
+	}
+	total := *estimate
+	produced := make(map[string]bool)
+	var produce func(node *logical_plan.LogicalPlan) error
+	produce = func(node *logical_plan.LogicalPlan) error {
+		if node.NodeType == logical_plan.NodeTypeSpool && len(node.Children) == 1 {
+			if produced[node.Spool] {
+				return nil
+			}
+			produced[node.Spool] = true
+			input, err := costModel.EstimateCost(node.Children[0], catalogMgr)
+			if err != nil {
+				return err
+			}
+			write, err := costModel.EstimateCost(node, catalogMgr)
+			if err != nil {
+				return err
+			}
+			total.TotalCost += input.TotalCost + write.TotalCost
+			total.CPUCost += input.CPUCost + write.CPUCost
+			total.IOCost += input.IOCost + write.IOCost
+		}
+		for _, child := range node.Children {
+			if err := produce(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := produce(plan); err != nil {
+		return nil, err
+	}
+	return &total, nil
+}
+
+func hasSpool(plan *logical_plan.LogicalPlan) bool {
+	if plan.NodeType == logical_plan.NodeTypeSpool {
+		return true
+	}
+	for _, child := range plan.Children {
+		if hasSpool(child) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,499 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"retr0-kernel/optiquery/binder"
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+// SubqueryDecorrelationRule unnests subqueries in filters and projections so
+// the other rules and the join enumerator can work on them. EXISTS and IN
+// become semi joins and NOT EXISTS and NOT IN anti joins, taking along the
+// conditions that correlate the subquery with the outer query. A correlated
+// scalar subquery over an aggregate becomes a left join to that aggregate,
+// grouped by the correlated columns.
+type SubqueryDecorrelationRule struct {
+	Catalog *catalog.CatalogManager
+}
+
+func (r *SubqueryDecorrelationRule) Name() string {
+	return "SubqueryDecorrelation"
+}
+
+func (r *SubqueryDecorrelationRule) Apply(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	result, details, err := r.ApplyAndDescribe(plan)
+	return result, len(details) > 0, err
+}
+
+func (r *SubqueryDecorrelationRule) ApplyAndDescribe(plan *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, []string, error) {
+	d := &decorrelator{catalog: r.Catalog, aliases: relationNames(plan)}
+	result, _, err := logical_plan.TransformDown(plan, func(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		switch node.NodeType {
+		case logical_plan.NodeTypeFilter:
+			return d.filter(node)
+		case logical_plan.NodeTypeProject:
+			return d.project(node)
+		}
+		return node, false, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, d.details, nil
+}
+
+type decorrelator struct {
+	catalog *catalog.CatalogManager
+	aliases map[string]bool
+	details []string
+}
+
+// filter turns the filter's subquery conjuncts into joins below it. Scalar
+// subqueries add columns to the filter's input, so a projection restores its
+// original columns on top. The filter is left as it was, and nothing is
+// reported, when those columns cannot be listed.
+func (d *decorrelator) filter(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	if len(node.Children) != 1 || node.Predicate == nil || !containsSubquery(node.Predicate.Expression) {
+		return node, false, nil
+	}
+	// pending collects the details until the rewrite is known to stick.
+	pending := &decorrelator{catalog: d.catalog, aliases: d.aliases}
+	original := node.Children[0]
+	child := original
+	var kept []*logical_plan.Expression
+	changed, widened := false, false
+	for _, conjunct := range logical_plan.SplitConjuncts(node.Predicate.Expression) {
+		if join, ok := pending.unnestPredicate(child, conjunct); ok {
+			child, changed = join, true
+			continue
+		}
+		// Unnesting rewrites the conjunct in place, so work on a copy.
+		if rewritten, join, ok := pending.unnestScalars(child, conjunct.Clone()); ok {
+			child, conjunct, changed, widened = join, rewritten, true, true
+		}
+		kept = append(kept, conjunct)
+	}
+	if !changed {
+		return node, false, nil
+	}
+
+	result := child
+	if len(kept) > 0 {
+		result = newFilter(child, kept)
+	}
+	if widened {
+		columns, ok := d.outputColumns(original)
+		if !ok {
+			return node, false, nil
+		}
+		result = logical_plan.NewProjectNode(result, columns)
+	}
+	d.details = append(d.details, pending.details...)
+	return result, true, nil
+}
+
+// project unnests scalar subqueries in computed columns. The projection's
+// output does not change, so no columns need restoring, unless it has a *.
+func (d *decorrelator) project(node *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+	if len(node.Children) != 1 || !hasSubquery(node) {
+		return node, false, nil
+	}
+	for _, column := range node.Projections {
+		if column.Name == "*" && column.Expression == nil {
+			return node, false, nil
+		}
+	}
+
+	changed := false
+	for i, column := range node.Projections {
+		if column.Expression == nil {
+			continue
+		}
+		if rewritten, join, ok := d.unnestScalars(node.Children[0], column.Expression); ok {
+			node.Children[0] = join
+			node.Projections[i].Expression = rewritten
+			changed = true
+		}
+	}
+	return node, changed, nil
+}
+
+// unnestPredicate turns a conjunct that is [NOT] EXISTS or [NOT] IN over a
+// subquery into a semi or anti join of child with the subquery.
+func (d *decorrelator) unnestPredicate(child *logical_plan.LogicalPlan, conjunct *logical_plan.Expression) (*logical_plan.LogicalPlan, bool) {
+	negated := false
+	expr := conjunct
+	if expr.Kind == logical_plan.ExprUnaryOp && expr.UnaryOp == logical_plan.OpNot {
+		negated, expr = true, expr.Operand
+	}
+
+	var subquery, operand *logical_plan.Expression
+	switch {
+	case expr.Kind == logical_plan.ExprUnaryOp && expr.UnaryOp == logical_plan.OpExists:
+		subquery = expr.Operand
+	case expr.Kind == logical_plan.ExprBinaryOp && expr.BinaryOp == logical_plan.OpIn:
+		subquery, operand = expr.Right, expr.Left
+	case expr.Kind == logical_plan.ExprBinaryOp && expr.BinaryOp == logical_plan.OpNotIn:
+		subquery, operand = expr.Right, expr.Left
+		negated = !negated
+	default:
+		return nil, false
+	}
+	if subquery == nil || subquery.Kind != logical_plan.ExprSubquery || subquery.Subquery == nil || (operand != nil && containsSubquery(operand)) {
+		return nil, false
+	}
+
+	s, ok := d.split(subquery.Subquery, child)
+	if !ok {
+		return nil, false
+	}
+	conjuncts := s.correlated
+	if operand != nil {
+		value, ok := s.singleColumn()
+		if !ok {
+			return nil, false
+		}
+		equality := logical_plan.NewBinaryOpExpression(logical_plan.OpEq, operand, value)
+		if negated && (d.nullable(operand, child) || d.nullable(value, s.body)) {
+			// x NOT IN (...) is not true when x or any value is NULL, so
+			// those rows must count as matches of the anti join.
+			equality = logical_plan.NewBinaryOpExpression(logical_plan.OpOr,
+				logical_plan.NewBinaryOpExpression(logical_plan.OpOr, equality,
+					logical_plan.NewUnaryOpExpression(logical_plan.OpIsNull, operand)),
+				logical_plan.NewUnaryOpExpression(logical_plan.OpIsNull, value))
+		}
+		conjuncts = append([]*logical_plan.Expression{equality}, conjuncts...)
+	}
+
+	joinType := logical_plan.JoinTypeSemi
+	if negated {
+		joinType = logical_plan.JoinTypeAnti
+	}
+	join := s.join(child, joinType, conjuncts)
+	description := fmt.Sprintf("turned %s into %s", conjunct, join.Label())
+	if join.Predicate != nil {
+		separator := " AND "
+		if join.JoinCondition == nil {
+			separator = " ON "
+		}
+		description += separator + join.Predicate.Expression.String()
+	}
+	d.details = append(d.details, description)
+	return join, true
+}
+
+// unnestScalars replaces each correlated scalar subquery in expr by a column
+// of a left join of child with the subquery's aggregate.
+func (d *decorrelator) unnestScalars(child *logical_plan.LogicalPlan, expr *logical_plan.Expression) (*logical_plan.Expression, *logical_plan.LogicalPlan, bool) {
+	changed := false
+	rewritten, _, _ := logical_plan.TransformExpression(expr, func(e *logical_plan.Expression) (*logical_plan.Expression, bool, error) {
+		if e.Kind != logical_plan.ExprSubquery || e.Subquery == nil {
+			return e, false, nil
+		}
+		column, join, ok := d.unnestScalar(child, e)
+		if !ok {
+			return e, false, nil
+		}
+		child, changed = join, true
+		return column, true, nil
+	})
+	return rewritten, child, changed
+}
+
+func (d *decorrelator) unnestScalar(child *logical_plan.LogicalPlan, subquery *logical_plan.Expression) (*logical_plan.Expression, *logical_plan.LogicalPlan, bool) {
+	top := subquery.Subquery
+	var project *logical_plan.LogicalPlan
+	if top.NodeType == logical_plan.NodeTypeProject && len(top.Children) == 1 {
+		project, top = top, top.Children[0]
+	}
+	if top.NodeType != logical_plan.NodeTypeAggregate || len(top.Children) != 1 || len(top.GroupBy) > 0 || len(top.Aggregates) != 1 {
+		return nil, nil, false
+	}
+	aggregate := top.Aggregates[0]
+	name := aggregate.Alias
+	if name == "" {
+		name = strings.ToLower(string(aggregate.Type))
+	}
+	if project != nil && (len(project.Projections) != 1 || project.Projections[0].Expression != nil || !strings.EqualFold(project.Projections[0].Name, name)) {
+		return nil, nil, false
+	}
+
+	s, ok := d.split(top.Children[0], child)
+	if !ok || len(s.correlated) == 0 {
+		return nil, nil, false
+	}
+
+	// Every correlated conjunct must equate an inner column with an outer
+	// expression, so grouping by the inner columns gives one row per match.
+	alias := d.newAlias()
+	var groupBy []logical_plan.Column
+	var conjuncts []*logical_plan.Expression
+	names := map[string]bool{strings.ToLower(name): true}
+	for _, conjunct := range s.correlated {
+		inner, outer, ok := s.correlation(conjunct)
+		if !ok || names[strings.ToLower(inner.Name)] {
+			return nil, nil, false
+		}
+		names[strings.ToLower(inner.Name)] = true
+		groupBy = append(groupBy, logical_plan.Column{Table: inner.Table, Name: inner.Name})
+		conjuncts = append(conjuncts, logical_plan.NewBinaryOpExpression(logical_plan.OpEq, outer, logical_plan.NewColumnExpression(alias, inner.Name)))
+	}
+
+	aggregate.Alias = name
+	grouped := logical_plan.NewAggregateNode(s.body, groupBy, []logical_plan.AggregateFunction{aggregate})
+	d.aliases[alias] = true
+
+	s.body, s.inner = logical_plan.NewSubqueryNode(grouped, alias), map[string]bool{alias: true}
+	join := s.join(child, logical_plan.JoinTypeLeft, conjuncts)
+
+	column := logical_plan.NewColumnExpression(alias, name)
+	if aggregate.Type == logical_plan.AggregateCount {
+		// COUNT over no rows is 0, not the NULL the left join produces.
+		column = logical_plan.NewFunctionExpression("COALESCE", []*logical_plan.Expression{column, logical_plan.NewLiteralExpression(0)})
+	}
+	d.details = append(d.details, fmt.Sprintf("turned scalar %s into %s over %s grouped by %s",
+		subquery, join.Label(), aggregate, joinColumnList(groupBy)))
+	return column, join, true
+}
+
+// outputColumns lists references to the columns of node, for restoring its
+// output above a join that added more.
+func (d *decorrelator) outputColumns(node *logical_plan.LogicalPlan) ([]logical_plan.Column, bool) {
+	bound, err := binder.Bind(node, d.catalog)
+	if err != nil {
+		return nil, false
+	}
+	seen := make(map[string]bool)
+	var columns []logical_plan.Column
+	for _, c := range bound.Output(node) {
+		key := strings.ToLower(c.String())
+		if seen[key] {
+			return nil, false
+		}
+		seen[key] = true
+		columns = append(columns, logical_plan.Column{Table: c.Relation, Name: c.Name})
+	}
+	return columns, len(columns) > 0
+}
+
+// nullable reports whether expr, evaluated over plan, may be NULL. Only
+// columns the catalog declares NOT NULL are known not to be.
+func (d *decorrelator) nullable(expr *logical_plan.Expression, plan *logical_plan.LogicalPlan) bool {
+	if d.catalog == nil || !expr.IsColumn() {
+		return true
+	}
+	bound, err := binder.Bind(plan, d.catalog)
+	if err != nil {
+		return true
+	}
+	output := bound.Output(plan)
+	index, err := output.Resolve(*expr.Column)
+	if err != nil || output[index].Table == "" {
+		return true
+	}
+	table, err := d.catalog.GetTable(output[index].Table)
+	if err != nil {
+		return true
+	}
+	column := table.Column(output[index].SourceName)
+	return column == nil || column.Nullable
+}
+
+func (d *decorrelator) newAlias() string {
+	for i := 1; ; i++ {
+		alias := fmt.Sprintf("sq%d", i)
+		if !d.aliases[alias] {
+			return alias
+		}
+	}
+}
+
+// splitSubquery is a subquery taken apart for unnesting: its projection, the
+// conjuncts of its top filters that read outer columns, and the rest.
+type splitSubquery struct {
+	project    *logical_plan.LogicalPlan
+	body       *logical_plan.LogicalPlan
+	correlated []*logical_plan.Expression
+	inner      map[string]bool
+	outer      map[string]bool
+}
+
+// split removes the correlated conjuncts from the filters at the top of a
+// subquery. It fails when outer columns are read anywhere else, or when the
+// subquery and the outer query share a relation name, which would make
+// references above the join ambiguous.
+func (d *decorrelator) split(plan, outer *logical_plan.LogicalPlan) (*splitSubquery, bool) {
+	s := &splitSubquery{inner: relationNames(plan), outer: relationNames(outer)}
+	for name := range s.inner {
+		if s.outer[name] {
+			return nil, false
+		}
+	}
+
+	node := plan
+	if node.NodeType == logical_plan.NodeTypeProject && len(node.Children) == 1 {
+		s.project, node = node, node.Children[0]
+	}
+	var local []*logical_plan.Expression
+	for node.NodeType == logical_plan.NodeTypeFilter && len(node.Children) == 1 {
+		if node.Predicate != nil {
+			for _, conjunct := range logical_plan.SplitConjuncts(node.Predicate.Expression) {
+				switch {
+				case !s.readsOuter(binder.ExpressionReferences(conjunct)):
+					local = append(local, conjunct)
+				case containsSubquery(conjunct):
+					return nil, false
+				default:
+					s.correlated = append(s.correlated, conjunct)
+				}
+			}
+		}
+		node = node.Children[0]
+	}
+
+	correlatedBelow := false
+	logical_plan.TransformDown(node, func(n *logical_plan.LogicalPlan) (*logical_plan.LogicalPlan, bool, error) {
+		if s.readsOuter(binder.References(n)) || hasSubquery(n) {
+			correlatedBelow = true
+		}
+		return n, false, nil
+	})
+	if correlatedBelow {
+		return nil, false
+	}
+
+	s.body = node
+	if len(local) > 0 {
+		s.body = newFilter(node, local)
+	}
+	return s, true
+}
+
+// readsOuter reports whether any reference is qualified with a relation of
+// the outer query and not of the subquery, which hides outer names.
+func (s *splitSubquery) readsOuter(refs []logical_plan.ColumnRef) bool {
+	for _, ref := range refs {
+		if s.isOuter(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *splitSubquery) isOuter(ref logical_plan.ColumnRef) bool {
+	table := strings.ToLower(ref.Table)
+	return table != "" && !s.inner[table] && s.outer[table]
+}
+
+func (s *splitSubquery) isInner(ref logical_plan.ColumnRef) bool {
+	return ref.Table != "" && s.inner[strings.ToLower(ref.Table)]
+}
+
+// singleColumn is the value an IN subquery produces: its only projected
+// column.
+func (s *splitSubquery) singleColumn() (*logical_plan.Expression, bool) {
+	if s.project == nil || len(s.project.Projections) != 1 {
+		return nil, false
+	}
+	column := s.project.Projections[0]
+	switch {
+	case column.Expression != nil:
+		return column.Expression, !containsSubquery(column.Expression)
+	case column.Name == "*":
+		return nil, false
+	}
+	return logical_plan.NewColumnExpression(column.Table, column.Name), true
+}
+
+// correlation splits an inner_column = outer_expression conjunct.
+func (s *splitSubquery) correlation(conjunct *logical_plan.Expression) (logical_plan.ColumnRef, *logical_plan.Expression, bool) {
+	if conjunct.Kind != logical_plan.ExprBinaryOp || conjunct.BinaryOp != logical_plan.OpEq {
+		return logical_plan.ColumnRef{}, nil, false
+	}
+	for _, pair := range [][2]*logical_plan.Expression{{conjunct.Left, conjunct.Right}, {conjunct.Right, conjunct.Left}} {
+		if pair[0].IsColumn() && s.isInner(*pair[0].Column) && s.onlyOuter(pair[1]) {
+			return *pair[0].Column, pair[1], true
+		}
+	}
+	return logical_plan.ColumnRef{}, nil, false
+}
+
+func (s *splitSubquery) onlyOuter(expr *logical_plan.Expression) bool {
+	refs := binder.ExpressionReferences(expr)
+	for _, ref := range refs {
+		if !s.isOuter(ref) {
+			return false
+		}
+	}
+	return len(refs) > 0
+}
+
+// join joins outer with the subquery's body. The first comparison of an
+// outer expression with an inner one becomes the join condition, oriented
+// with the outer side first, and the other conjuncts the join's predicate.
+func (s *splitSubquery) join(outer *logical_plan.LogicalPlan, joinType logical_plan.JoinType, conjuncts []*logical_plan.Expression) *logical_plan.LogicalPlan {
+	var condition *logical_plan.JoinCondition
+	var residual []*logical_plan.Expression
+	for _, conjunct := range conjuncts {
+		if condition == nil && conjunct.Kind == logical_plan.ExprBinaryOp && conjunct.BinaryOp.IsComparison() {
+			left, right := conjunct.Left, conjunct.Right
+			switch {
+			case s.onlyOuter(left) && s.onlyInner(right):
+				condition = &logical_plan.JoinCondition{Left: left, Right: right, Operator: string(conjunct.BinaryOp)}
+				continue
+			case s.onlyInner(left) && s.onlyOuter(right):
+				condition = &logical_plan.JoinCondition{Left: right, Right: left, Operator: string(flippedComparisons[conjunct.BinaryOp])}
+				continue
+			}
+		}
+		residual = append(residual, conjunct)
+	}
+
+	join := logical_plan.NewJoinNode(outer, s.body, joinType, condition)
+	if len(residual) > 0 {
+		join.Predicate = &logical_plan.Predicate{Expression: logical_plan.CombineConjuncts(residual)}
+	}
+	return join
+}
+
+func (s *splitSubquery) onlyInner(expr *logical_plan.Expression) bool {
+	refs := binder.ExpressionReferences(expr)
+	for _, ref := range refs {
+		if !s.isInner(ref) {
+			return false
+		}
+	}
+	return len(refs) > 0
+}
+
+// relationNames collects the names a subtree's columns can be qualified
+// with, lower-cased, not looking below subquery aliases.
+func relationNames(plan *logical_plan.LogicalPlan) map[string]bool {
+	names := make(map[string]bool)
+	var walk func(node *logical_plan.LogicalPlan)
+	walk = func(node *logical_plan.LogicalPlan) {
+		switch {
+		case node.NodeType == logical_plan.NodeTypeSubquery && node.Alias != "":
+			names[strings.ToLower(node.Alias)] = true
+			return
+		case node.NodeType == logical_plan.NodeTypeScan:
+			names[strings.ToLower(node.RelationName())] = true
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(plan)
+	return names
+}
+
+func joinColumnList(columns []logical_plan.Column) string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+var (
+	commentPattern = regexp.MustCompile(`'[^']*'|"[^"]*"|/\*[\s\S]*?\*/|--[^\n]*`)
+	hintPattern    = regexp.MustCompile(`^[\s,]*([^\s(),]+)(?:\s*\(([^()]*)\))?`)
+)
+
+// stripComments blanks out the comments of a query, leaving string literals
+// alone, and returns the bodies of its /*+ ... */ hint comments.
+func stripComments(query string) (string, []string) {
+	var hints []string
+	stripped := commentPattern.ReplaceAllStringFunc(query, func(match string) string {
+		switch {
+		case strings.HasPrefix(match, "/*+"):
+			hints = append(hints, strings.TrimSuffix(strings.TrimPrefix(match, "/*+"), "*/"))
+		case strings.HasPrefix(match, "/*"), strings.HasPrefix(match, "--"):
+		default:
+			return match
+		}
+		return " "
+	})
+	return stripped, hints
+}
+
+// ParseHints parses the body of a hint comment: hints such as
+// LEADING(a b c), INDEXSCAN(t idx_t_a) or a bare ORDERED, separated by spaces
+// or commas, with their arguments separated by spaces or commas. Names are
+// matched ignoring case and aliases such as NESTLOOP or USE_HASH become their
+// canonical names. Unknown hints are kept, for the optimizer to report as
+// ignored, so only unbalanced parentheses are an error.
+func ParseHints(text string) ([]logical_plan.Hint, error) {
+	var hints []logical_plan.Hint
+	for rest := text; strings.Trim(rest, " \t\r\n,") != ""; {
+		match := hintPattern.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("unbalanced parentheses in hint comment %q", strings.TrimSpace(text))
+		}
+		hint := logical_plan.Hint{Name: logical_plan.CanonicalHintName(match[1])}
+		for _, argument := range strings.FieldsFunc(match[2], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+			hint.Arguments = append(hint.Arguments, strings.Trim(argument, `"`))
+		}
+		hints = append(hints, hint)
+		rest = rest[len(match[0]):]
+	}
+	return hints, nil
+}
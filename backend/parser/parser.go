@@ -31,6 +31,7 @@ func ParseAthena(query string) (*logical_plan.LogicalPlan, error) {
 
 func (p *SQLParser) Parse(query string) (*logical_plan.LogicalPlan, error) {
 
+	query, hintComments := stripComments(query)
 	p.tokens = tokenize(query)
 	p.pos = 0
 
@@ -38,9 +39,23 @@ func (p *SQLParser) Parse(query string) (*logical_plan.LogicalPlan, error) {
 		return nil, fmt.Errorf("empty query")
 	}
 
+	var hints []logical_plan.Hint
+	for _, comment := range hintComments {
+		parsed, err := ParseHints(comment)
+		if err != nil {
+			return nil, err
+		}
+		hints = append(hints, parsed...)
+	}
+
 	switch strings.ToUpper(p.tokens[0]) {
 	case "SELECT":
-		return p.parseSelect()
+		plan, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		plan.Hints = hints
+		return plan, nil
 	default:
 		return nil, fmt.Errorf("unsupported query type: %s", p.tokens[0])
 	}
@@ -51,7 +66,7 @@ func (p *SQLParser) parseSelect() (*logical_plan.LogicalPlan, error) {
 		return nil, fmt.Errorf("expected SELECT")
 	}
 
-	projections, err := p.parseProjections()
+	projections, aggregates, err := p.parseProjections()
 	if err != nil {
 		return nil, err
 	}
@@ -76,15 +91,15 @@ func (p *SQLParser) parseSelect() (*logical_plan.LogicalPlan, error) {
 		currentPlan = logical_plan.NewFilterNode(currentPlan, predicate)
 	}
 
+	var groupBy []logical_plan.Column
 	if p.peekToken() != "" && strings.ToUpper(p.peekToken()) == "GROUP" {
 		if p.consumeToken("GROUP") && p.consumeToken("BY") {
-			groupBy, aggregates, err := p.parseGroupBy(projections)
-			if err != nil {
-				return nil, err
-			}
-			currentPlan = logical_plan.NewAggregateNode(currentPlan, groupBy, aggregates)
+			groupBy = p.parseGroupBy()
 		}
 	}
+	if groupBy != nil || aggregates != nil {
+		currentPlan = logical_plan.NewAggregateNode(currentPlan, groupBy, aggregates)
+	}
 
 	if p.peekToken() != "" && strings.ToUpper(p.peekToken()) == "ORDER" {
 		if p.consumeToken("ORDER") && p.consumeToken("BY") {
@@ -112,8 +127,20 @@ func (p *SQLParser) parseSelect() (*logical_plan.LogicalPlan, error) {
 	return currentPlan, nil
 }
 
-func (p *SQLParser) parseProjections() ([]logical_plan.Column, error) {
+var aggregateTypes = map[string]logical_plan.AggregateType{
+	"COUNT": logical_plan.AggregateCount,
+	"SUM":   logical_plan.AggregateSum,
+	"AVG":   logical_plan.AggregateAvg,
+	"MIN":   logical_plan.AggregateMin,
+	"MAX":   logical_plan.AggregateMax,
+}
+
+// parseProjections parses the select list. An aggregate such as SUM(o.total)
+// becomes an aggregate function, projected by its alias or, without one, by
+// the lower-cased function name.
+func (p *SQLParser) parseProjections() ([]logical_plan.Column, []logical_plan.AggregateFunction, error) {
 	var projections []logical_plan.Column
+	var aggregates []logical_plan.AggregateFunction
 
 	for {
 		token := p.nextToken()
@@ -121,22 +148,41 @@ func (p *SQLParser) parseProjections() ([]logical_plan.Column, error) {
 			break
 		}
 
-		if token == "*" {
-			projections = append(projections, logical_plan.Column{Name: "*"})
-		} else {
-
+		var projection logical_plan.Column
+		aggType, isAggregate := aggregateTypes[strings.ToUpper(token)]
+		switch {
+		case token == "*":
+			projection = logical_plan.Column{Name: "*"}
+		case isAggregate && p.consumeToken("("):
+			argument := p.nextToken()
+			if argument == "" || !p.consumeToken(")") {
+				return nil, nil, fmt.Errorf("expected %s(column)", strings.ToUpper(token))
+			}
+			aggregate := logical_plan.AggregateFunction{Type: aggType}
+			if argument != "*" {
+				aggregate.Column = logical_plan.NewColumnExpression("", argument)
+			}
+			if p.consumeToken("AS") {
+				aggregate.Alias = p.nextToken()
+			}
+			name := aggregate.Alias
+			if name == "" {
+				name = strings.ToLower(string(aggType))
+			}
+			aggregates = append(aggregates, aggregate)
+			projection = logical_plan.Column{Name: name}
+		default:
 			parts := strings.Split(token, ".")
 			if len(parts) == 2 {
-				projections = append(projections, logical_plan.Column{
-					Table: parts[0],
-					Name:  parts[1],
-				})
+				projection = logical_plan.Column{Table: parts[0], Name: parts[1]}
 			} else {
-				projections = append(projections, logical_plan.Column{
-					Name: token,
-				})
+				projection = logical_plan.Column{Name: token}
+			}
+			if p.consumeToken("AS") {
+				projection.Alias = p.nextToken()
 			}
 		}
+		projections = append(projections, projection)
 
 		if p.peekToken() == "," {
 			p.consumeToken(",")
@@ -149,7 +195,7 @@ func (p *SQLParser) parseProjections() ([]logical_plan.Column, error) {
 		}
 	}
 
-	return projections, nil
+	return projections, aggregates, nil
 }
 
 func (p *SQLParser) parseFromClause() (*logical_plan.LogicalPlan, error) {
@@ -259,7 +305,23 @@ func (p *SQLParser) parseJoinCondition() (*logical_plan.JoinCondition, error) {
 	}, nil
 }
 
+// parsePredicate parses comparisons of a column with a value, joined by AND.
 func (p *SQLParser) parsePredicate() (*logical_plan.Predicate, error) {
+	var conjuncts []*logical_plan.Expression
+	for {
+		comparison, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		conjuncts = append(conjuncts, comparison)
+		if !p.consumeToken("AND") {
+			break
+		}
+	}
+	return &logical_plan.Predicate{Expression: logical_plan.CombineConjuncts(conjuncts)}, nil
+}
+
+func (p *SQLParser) parseComparison() (*logical_plan.Expression, error) {
 
 	column := p.nextToken()
 	operator := p.nextToken()
@@ -269,6 +331,11 @@ func (p *SQLParser) parsePredicate() (*logical_plan.Predicate, error) {
 		return nil, fmt.Errorf("invalid predicate")
 	}
 
+	op, err := logical_plan.ParseBinaryOperator(operator)
+	if err != nil {
+		return nil, err
+	}
+
 	var parsedValue interface{}
 	if intVal, err := strconv.Atoi(value); err == nil {
 		parsedValue = intVal
@@ -279,20 +346,15 @@ func (p *SQLParser) parsePredicate() (*logical_plan.Predicate, error) {
 		parsedValue = strings.Trim(value, "'\"")
 	}
 
-	predicate := &logical_plan.Predicate{
-		Expression: logical_plan.NewBinaryOpExpression(
-			operator,
-			logical_plan.NewColumnExpression("", column),
-			logical_plan.NewLiteralExpression(parsedValue),
-		),
-	}
-
-	return predicate, nil
+	return logical_plan.NewBinaryOpExpression(
+		op,
+		logical_plan.NewColumnExpression("", column),
+		logical_plan.NewLiteralExpression(parsedValue),
+	), nil
 }
 
-func (p *SQLParser) parseGroupBy(projections []logical_plan.Column) ([]logical_plan.Column, []logical_plan.AggregateFunction, error) {
+func (p *SQLParser) parseGroupBy() []logical_plan.Column {
 	var groupBy []logical_plan.Column
-	var aggregates []logical_plan.AggregateFunction
 
 	for {
 		token := p.nextToken()
@@ -300,7 +362,8 @@ func (p *SQLParser) parseGroupBy(projections []logical_plan.Column) ([]logical_p
 			break
 		}
 
-		groupBy = append(groupBy, logical_plan.Column{Name: token})
+		column := logical_plan.NewColumnExpression("", token).Column
+		groupBy = append(groupBy, logical_plan.Column{Table: column.Table, Name: column.Name})
 
 		if p.peekToken() == "," {
 			p.consumeToken(",")
@@ -310,35 +373,7 @@ func (p *SQLParser) parseGroupBy(projections []logical_plan.Column) ([]logical_p
 		break
 	}
 
-	for _, proj := range projections {
-		if strings.Contains(strings.ToUpper(proj.Name), "COUNT") ||
-			strings.Contains(strings.ToUpper(proj.Name), "SUM") ||
-			strings.Contains(strings.ToUpper(proj.Name), "AVG") ||
-			strings.Contains(strings.ToUpper(proj.Name), "MIN") ||
-			strings.Contains(strings.ToUpper(proj.Name), "MAX") {
-
-			var aggType logical_plan.AggregateType
-			switch {
-			case strings.Contains(strings.ToUpper(proj.Name), "COUNT"):
-				aggType = logical_plan.AggregateCount
-			case strings.Contains(strings.ToUpper(proj.Name), "SUM"):
-				aggType = logical_plan.AggregateSum
-			case strings.Contains(strings.ToUpper(proj.Name), "AVG"):
-				aggType = logical_plan.AggregateAvg
-			case strings.Contains(strings.ToUpper(proj.Name), "MIN"):
-				aggType = logical_plan.AggregateMin
-			case strings.Contains(strings.ToUpper(proj.Name), "MAX"):
-				aggType = logical_plan.AggregateMax
-			}
-
-			aggregates = append(aggregates, logical_plan.AggregateFunction{
-				Type:  aggType,
-				Alias: proj.Alias,
-			})
-		}
-	}
-
-	return groupBy, aggregates, nil
+	return groupBy
 }
 
 func (p *SQLParser) parseOrderBy() ([]logical_plan.OrderBy, error) {
@@ -416,7 +451,7 @@ func (p *SQLParser) consumeToken(expected string) bool {
 
 func tokenize(query string) []string {
 
-	re := regexp.MustCompile(`\w+|[(),.=<>!]+|'[^']*'|"[^"]*"`)
+	re := regexp.MustCompile(`\w+(?:\.\w+)*|\*|[(),]|[=<>!]+|'[^']*'|"[^"]*"`)
 	tokens := re.FindAllString(query, -1)
 
 	var cleanTokens []string
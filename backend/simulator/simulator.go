@@ -1,9 +1,12 @@
 package simulator
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/cost_model"
 	"retr0-kernel/optiquery/logical_plan"
 )
 
@@ -18,33 +21,48 @@ type ExecutionMetrics struct {
 	OperatorMetrics map[string]interface{} `json:"operator_metrics"`
 	Connector       string                 `json:"connector"`
 	SimulationOnly  bool                   `json:"simulation_only"`
+	// Partial is set when the context ended the simulation early. The
+	// metrics then cover only the operators simulated by that time.
+	Partial bool `json:"partial,omitempty"`
 }
 
 type Simulator interface {
-	SimulateExecution(plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error)
+	SimulateExecution(ctx context.Context, plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error)
 }
 
-func SimulateExecution(plan *logical_plan.LogicalPlan, connector string, options map[string]interface{}) (*ExecutionMetrics, error) {
+func SimulateExecution(ctx context.Context, plan *logical_plan.LogicalPlan, connector string, options map[string]interface{}, catalogMgr *catalog.CatalogManager) (*ExecutionMetrics, error) {
 	switch connector {
 	case "postgres":
-		simulator := NewPostgresSimulator()
-		return simulator.SimulateExecution(plan, options)
+		simulator := NewPostgresSimulator(catalogMgr)
+		return simulator.SimulateExecution(ctx, plan, options)
 	case "mongo":
-		simulator := NewMongoSimulator()
-		return simulator.SimulateExecution(plan, options)
+		simulator := NewMongoSimulator(catalogMgr)
+		return simulator.SimulateExecution(ctx, plan, options)
 	default:
-		simulator := NewGenericSimulator()
-		return simulator.SimulateExecution(plan, options)
+		simulator := NewGenericSimulator(catalogMgr)
+		return simulator.SimulateExecution(ctx, plan, options)
 	}
 }
 
-type GenericSimulator struct{}
+// GenericSimulator simulates a plan from the row counts of its nodes. Nodes
+// without an estimate get one from the cost model and the catalog's
+// statistics before simulating.
+type GenericSimulator struct {
+	catalog   *catalog.CatalogManager
+	costModel cost_model.CostModel
+}
 
-func NewGenericSimulator() *GenericSimulator {
-	return &GenericSimulator{}
+func NewGenericSimulator(catalogMgr *catalog.CatalogManager) *GenericSimulator {
+	if catalogMgr == nil {
+		catalogMgr = catalog.NewCatalogManager()
+	}
+	return &GenericSimulator{
+		catalog:   catalogMgr,
+		costModel: cost_model.NewSimpleCostModel(),
+	}
 }
 
-func (gs *GenericSimulator) SimulateExecution(plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error) {
+func (gs *GenericSimulator) SimulateExecution(ctx context.Context, plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error) {
 	if plan == nil {
 		return nil, fmt.Errorf("cannot simulate nil plan")
 	}
@@ -57,7 +75,12 @@ func (gs *GenericSimulator) SimulateExecution(plan *logical_plan.LogicalPlan, op
 		SimulationOnly:  true,
 	}
 
-	err := gs.simulateNode(plan, metrics)
+	plan = plan.Clone()
+	if err := gs.estimateRows(plan); err != nil {
+		return nil, err
+	}
+
+	err := gs.simulateNode(ctx, plan, metrics)
 	if err != nil {
 		return nil, err
 	}
@@ -66,18 +89,43 @@ func (gs *GenericSimulator) SimulateExecution(plan *logical_plan.LogicalPlan, op
 	return metrics, nil
 }
 
-func (gs *GenericSimulator) simulateNode(plan *logical_plan.LogicalPlan, metrics *ExecutionMetrics) error {
+// estimateRows fills in the estimated rows of every node that has none.
+func (gs *GenericSimulator) estimateRows(plan *logical_plan.LogicalPlan) error {
+	for _, child := range plan.Children {
+		if err := gs.estimateRows(child); err != nil {
+			return err
+		}
+	}
+	if plan.EstimatedRows != nil {
+		return nil
+	}
+	rows, err := gs.costModel.EstimateCardinality(plan, gs.catalog)
+	if err != nil {
+		return fmt.Errorf("estimating rows of %s: %w", plan.NodeType, err)
+	}
+	plan.EstimatedRows = &rows
+	return nil
+}
+
+// simulateNode simulates the children of a node before the node itself. Once
+// ctx is done the remaining operators are skipped and the metrics are partial.
+func (gs *GenericSimulator) simulateNode(ctx context.Context, plan *logical_plan.LogicalPlan, metrics *ExecutionMetrics) error {
 	if plan == nil {
 		return nil
 	}
 
 	for _, child := range plan.Children {
-		err := gs.simulateNode(child, metrics)
+		err := gs.simulateNode(ctx, child, metrics)
 		if err != nil {
 			return err
 		}
 	}
 
+	if ctx.Err() != nil {
+		metrics.Partial = true
+		return nil
+	}
+
 	switch plan.NodeType {
 	case logical_plan.NodeTypeScan:
 		return gs.simulateScan(plan, metrics)
@@ -93,6 +141,10 @@ func (gs *GenericSimulator) simulateNode(plan *logical_plan.LogicalPlan, metrics
 		return gs.simulateSort(plan, metrics)
 	case logical_plan.NodeTypeLimit:
 		return gs.simulateLimit(plan, metrics)
+	case logical_plan.NodeTypeTopN:
+		return gs.simulateTopN(plan, metrics)
+	case logical_plan.NodeTypeEmpty:
+		return gs.simulateEmpty(plan, metrics)
 	default:
 		return fmt.Errorf("unsupported node type for simulation: %s", plan.NodeType)
 	}
@@ -106,6 +158,9 @@ func (gs *GenericSimulator) simulateScan(plan *logical_plan.LogicalPlan, metrics
 	}
 
 	pagesRead := estimatedRows / 100
+	if table, err := gs.catalog.GetTable(plan.TableName); err == nil {
+		pagesRead = int64(float64(pagesRead) * cost_model.ScannedFraction(plan, table))
+	}
 	if pagesRead < 1 {
 		pagesRead = 1
 	}
@@ -118,12 +173,21 @@ func (gs *GenericSimulator) simulateScan(plan *logical_plan.LogicalPlan, metrics
 	cpuTime := time.Duration(estimatedRows*10) * time.Microsecond
 	metrics.CPUTime += cpuTime
 
-	metrics.OperatorMetrics[plan.ID+"_scan"] = map[string]interface{}{
+	scanType := "sequential"
+	if t, ok := plan.Metadata["scan_type"].(string); ok {
+		scanType = t
+	}
+	scanMetrics := map[string]interface{}{
 		"table_name":   plan.TableName,
 		"rows_scanned": estimatedRows,
 		"pages_read":   pagesRead,
-		"scan_type":    "sequential",
+		"scan_type":    scanType,
+		"columns_read": plan.ScanColumns,
+	}
+	if plan.Partitions != nil {
+		scanMetrics["partitions_read"] = plan.Partitions
 	}
+	metrics.OperatorMetrics[plan.ID+"_scan"] = scanMetrics
 
 	return nil
 }
@@ -136,6 +200,12 @@ func (gs *GenericSimulator) simulateFilter(plan *logical_plan.LogicalPlan, metri
 
 	selectivity := 0.3
 	outputRows := int64(float64(inputRows) * selectivity)
+	if plan.EstimatedRows != nil {
+		outputRows = *plan.EstimatedRows
+		if inputRows > 0 {
+			selectivity = float64(outputRows) / float64(inputRows)
+		}
+	}
 
 	metrics.RowsProcessed += inputRows
 	metrics.RowsReturned = outputRows
@@ -216,6 +286,9 @@ func (gs *GenericSimulator) simulateJoin(plan *logical_plan.LogicalPlan, metrics
 
 		sortTime := time.Duration(leftRows*int64(logBase2(float64(leftRows)))+
 			rightRows*int64(logBase2(float64(rightRows)))) * time.Microsecond * 5
+		if presorted, _ := plan.Metadata["presorted"].(bool); presorted {
+			sortTime = 0
+		}
 		mergeTime := time.Duration((leftRows+rightRows)*5) * time.Microsecond
 		cpuTime = sortTime + mergeTime
 		memoryUsed = (leftRows + rightRows) * 100
@@ -227,6 +300,19 @@ func (gs *GenericSimulator) simulateJoin(plan *logical_plan.LogicalPlan, metrics
 		memoryUsed = leftRows * 100
 		outputRows = int64(float64(leftRows*rightRows) * 0.1)
 	}
+	if plan.JoinType.LeftOnly() {
+		// Semi and anti joins hash the right input and stop probing a left
+		// row at its first match.
+		switch joinAlgorithm {
+		case "hash_join":
+			memoryUsed = rightRows * 150
+		case "nested_loop_join":
+			cpuTime /= 2
+		}
+	}
+	if plan.EstimatedRows != nil {
+		outputRows = *plan.EstimatedRows
+	}
 
 	metrics.RowsProcessed += leftRows + rightRows
 	metrics.RowsReturned = outputRows
@@ -288,6 +374,9 @@ func (gs *GenericSimulator) simulateAggregate(plan *logical_plan.LogicalPlan, me
 	case "sort_aggregate":
 
 		sortTime := time.Duration(inputRows*int64(logBase2(float64(inputRows)))*10) * time.Microsecond
+		if presorted, _ := plan.Metadata["presorted"].(bool); presorted {
+			sortTime = 0
+		}
 		aggTime := time.Duration(inputRows*5) * time.Microsecond
 		cpuTime = sortTime + aggTime
 		memoryUsed = inputRows * 100
@@ -436,16 +525,60 @@ func (gs *GenericSimulator) simulateLimit(plan *logical_plan.LogicalPlan, metric
 	return nil
 }
 
+// simulateTopN models a bounded heap: every input row is compared against
+// the heap's root, and only replacements pay for a sift down.
+func (gs *GenericSimulator) simulateTopN(plan *logical_plan.LogicalPlan, metrics *ExecutionMetrics) error {
+	inputRows := int64(1000)
+	if len(plan.Children) > 0 && plan.Children[0].EstimatedRows != nil {
+		inputRows = *plan.Children[0].EstimatedRows
+	}
+
+	heapSize := cost_model.TopNHeapSize(plan, inputRows)
+	outputRows := heapSize
+	if plan.OffsetCount != nil {
+		outputRows = max(heapSize-*plan.OffsetCount, 0)
+	}
+
+	comparisons := inputRows * int64(logBase2(float64(max(heapSize, 2))))
+	memoryUsed := heapSize * 150
+
+	metrics.RowsProcessed += inputRows
+	metrics.RowsReturned = outputRows
+	metrics.CPUTime += time.Duration(comparisons*20) * time.Microsecond
+	metrics.MemoryUsed += memoryUsed
+
+	metrics.OperatorMetrics[plan.ID+"_top_n"] = map[string]interface{}{
+		"input_rows":   inputRows,
+		"output_rows":  outputRows,
+		"heap_size":    heapSize,
+		"comparisons":  comparisons,
+		"memory_used":  memoryUsed,
+		"sort_columns": len(plan.OrderBy),
+	}
+
+	return nil
+}
+
+func (gs *GenericSimulator) simulateEmpty(plan *logical_plan.LogicalPlan, metrics *ExecutionMetrics) error {
+	metrics.RowsReturned = 0
+
+	metrics.OperatorMetrics[plan.ID+"_empty"] = map[string]interface{}{
+		"output_rows": 0,
+	}
+
+	return nil
+}
+
 type PostgresSimulator struct {
 	GenericSimulator
 }
 
-func NewPostgresSimulator() *PostgresSimulator {
-	return &PostgresSimulator{}
+func NewPostgresSimulator(catalogMgr *catalog.CatalogManager) *PostgresSimulator {
+	return &PostgresSimulator{GenericSimulator: *NewGenericSimulator(catalogMgr)}
 }
 
-func (ps *PostgresSimulator) SimulateExecution(plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error) {
-	metrics, err := ps.GenericSimulator.SimulateExecution(plan, options)
+func (ps *PostgresSimulator) SimulateExecution(ctx context.Context, plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error) {
+	metrics, err := ps.GenericSimulator.SimulateExecution(ctx, plan, options)
 	if err != nil {
 		return nil, err
 	}
@@ -491,12 +624,12 @@ type MongoSimulator struct {
 	GenericSimulator
 }
 
-func NewMongoSimulator() *MongoSimulator {
-	return &MongoSimulator{}
+func NewMongoSimulator(catalogMgr *catalog.CatalogManager) *MongoSimulator {
+	return &MongoSimulator{GenericSimulator: *NewGenericSimulator(catalogMgr)}
 }
 
-func (ms *MongoSimulator) SimulateExecution(plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error) {
-	metrics, err := ms.GenericSimulator.SimulateExecution(plan, options)
+func (ms *MongoSimulator) SimulateExecution(ctx context.Context, plan *logical_plan.LogicalPlan, options map[string]interface{}) (*ExecutionMetrics, error) {
+	metrics, err := ms.GenericSimulator.SimulateExecution(ctx, plan, options)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,637 @@
+package substrait
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+type exporter struct {
+	catalog   *catalog.CatalogManager
+	uris      map[string]uint32
+	functions map[string]uint32
+	plan      *Plan
+	issues    []string
+}
+
+// Export converts a logical plan to a Substrait plan. Table schemas come from
+// the catalog, since Substrait refers to columns by position. Every construct
+// that has no Substrait equivalent is collected into an *UnsupportedError.
+func Export(plan *logical_plan.LogicalPlan, cm *catalog.CatalogManager) (*Plan, error) {
+	e := &exporter{
+		catalog:   cm,
+		uris:      make(map[string]uint32),
+		functions: make(map[string]uint32),
+		plan: &Plan{
+			Version: &Version{MajorNumber: 0, MinorNumber: 54, Producer: "optiquery"},
+		},
+	}
+
+	rel, fields := e.rel(plan)
+	if len(e.issues) > 0 {
+		return nil, &UnsupportedError{Issues: e.issues}
+	}
+
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	e.plan.Relations = []PlanRel{{Root: &RelRoot{Input: rel, Names: names}}}
+	return e.plan, nil
+}
+
+func (e *exporter) unsupported(format string, args ...interface{}) {
+	e.issues = append(e.issues, fmt.Sprintf(format, args...))
+}
+
+func (e *exporter) rel(node *logical_plan.LogicalPlan) (*Rel, []field) {
+	if node == nil {
+		e.unsupported("missing plan node")
+		return nil, nil
+	}
+
+	switch node.NodeType {
+	case logical_plan.NodeTypeScan:
+		return e.read(node)
+
+	case logical_plan.NodeTypeFilter:
+		input, fields := e.input(node)
+		return &Rel{Filter: &FilterRel{
+			Input:     input,
+			Condition: e.predicate(node, fields),
+		}}, fields
+
+	case logical_plan.NodeTypeProject:
+		return e.project(node)
+
+	case logical_plan.NodeTypeJoin:
+		return e.join(node)
+
+	case logical_plan.NodeTypeAggregate:
+		return e.aggregate(node)
+
+	case logical_plan.NodeTypeSort:
+		input, fields := e.input(node)
+		return e.sort(node, input, fields), fields
+
+	case logical_plan.NodeTypeLimit:
+		input, fields := e.input(node)
+		return fetch(node, input), fields
+
+	case logical_plan.NodeTypeTopN:
+		// Substrait has no top-N relation; a fetch over a sort means the same.
+		input, fields := e.input(node)
+		return fetch(node, e.sort(node, input, fields)), fields
+
+	case logical_plan.NodeTypeUnion:
+		op := SetOpUnionDistinct
+		if node.UnionAll {
+			op = SetOpUnionAll
+		}
+		set := &SetRel{Op: op}
+		var fields []field
+		for i, child := range node.Children {
+			input, childFields := e.rel(child)
+			if i == 0 {
+				fields = childFields
+			} else if len(childFields) != len(fields) {
+				e.unsupported("union inputs have %d and %d columns", len(fields), len(childFields))
+			}
+			set.Inputs = append(set.Inputs, input)
+		}
+		return &Rel{Set: set}, fields
+
+	case logical_plan.NodeTypeSubquery:
+		input, fields := e.input(node)
+		if node.Alias != "" {
+			requalified := make([]field, len(fields))
+			for i, f := range fields {
+				requalified[i] = field{qualifier: node.Alias, name: f.name, dataType: f.dataType}
+			}
+			fields = requalified
+		}
+		return input, fields
+
+	default:
+		e.unsupported("node type %s", node.NodeType)
+		return nil, nil
+	}
+}
+
+func (e *exporter) sort(node *logical_plan.LogicalPlan, input *Rel, fields []field) *Rel {
+	sorts := make([]SortField, len(node.OrderBy))
+	for i, ob := range node.OrderBy {
+		direction := SortAscNullsLast
+		if !ob.Ascending {
+			direction = SortDescNullsFirst
+		}
+		sorts[i] = SortField{Expr: e.expression(ob.Expression, fields), Direction: direction}
+	}
+	return &Rel{Sort: &SortRel{Input: input, Sorts: sorts}}
+}
+
+func fetch(node *logical_plan.LogicalPlan, input *Rel) *Rel {
+	rel := &FetchRel{Input: input, Count: -1}
+	if node.LimitCount != nil {
+		rel.Count = Int64(*node.LimitCount)
+	}
+	if node.OffsetCount != nil {
+		rel.Offset = Int64(*node.OffsetCount)
+	}
+	return &Rel{Fetch: rel}
+}
+
+func (e *exporter) input(node *logical_plan.LogicalPlan) (*Rel, []field) {
+	if len(node.Children) != 1 {
+		e.unsupported("%s node with %d children", node.NodeType, len(node.Children))
+		return nil, nil
+	}
+	return e.rel(node.Children[0])
+}
+
+func (e *exporter) read(node *logical_plan.LogicalPlan) (*Rel, []field) {
+	if e.catalog == nil {
+		e.unsupported("scan %s: no catalog to read its schema from", node.TableName)
+		return nil, nil
+	}
+	table, err := e.catalog.GetTable(node.TableName)
+	if err != nil {
+		e.unsupported("scan %s: %v", node.TableName, err)
+		return nil, nil
+	}
+
+	schema := &NamedStruct{Struct: &StructType{Nullability: NullabilityRequired}}
+	fields := make([]field, len(table.Columns))
+	for i, column := range table.Columns {
+		dataType := logical_plan.DataType(column.DataType)
+		schema.Names = append(schema.Names, column.Name)
+		schema.Struct.Types = append(schema.Struct.Types, toSubstraitType(dataType, column.Nullable))
+		fields[i] = field{
+			qualifier: node.RelationName(),
+			table:     node.TableName,
+			name:      column.Name,
+			dataType:  dataType,
+		}
+	}
+
+	read := &ReadRel{
+		BaseSchema: schema,
+		NamedTable: &NamedTable{Names: []string{node.TableName}},
+	}
+	if node.Predicate != nil {
+		read.Filter = e.expression(node.Predicate.Expression, fields)
+	}
+	return &Rel{Read: read}, fields
+}
+
+// project selects columns through an emit mapping, so plain column
+// projections need no expressions at all. Computed columns are appended after
+// the input's fields, where Substrait places project expressions.
+func (e *exporter) project(node *logical_plan.LogicalPlan) (*Rel, []field) {
+	input, inputFields := e.input(node)
+
+	var mapping []int32
+	var fields []field
+	var expressions []*Expression
+	for _, column := range node.Projections {
+		if column.Expression != nil {
+			expressions = append(expressions, e.expression(column.Expression, inputFields))
+			mapping = append(mapping, int32(len(inputFields)+len(expressions)-1))
+			name := column.Alias
+			if name == "" {
+				name = column.Name
+			}
+			fields = append(fields, field{name: name, dataType: typeOf(column.Expression, inputFields)})
+			continue
+		}
+
+		if column.Name == "*" {
+			for i, f := range inputFields {
+				if column.Table == "" || matchesQualifier(f, column.Table) {
+					mapping = append(mapping, int32(i))
+					fields = append(fields, f)
+				}
+			}
+			continue
+		}
+
+		index, ok := e.resolve(column.Table, column.Name, inputFields)
+		if !ok {
+			continue
+		}
+		mapping = append(mapping, int32(index))
+		f := inputFields[index]
+		if column.Alias != "" {
+			f = field{name: column.Alias, dataType: f.dataType}
+		}
+		fields = append(fields, f)
+	}
+
+	return &Rel{Project: &ProjectRel{
+		Common:      &RelCommon{Emit: &Emit{OutputMapping: mapping}},
+		Input:       input,
+		Expressions: expressions,
+	}}, fields
+}
+
+func (e *exporter) join(node *logical_plan.LogicalPlan) (*Rel, []field) {
+	if len(node.Children) != 2 {
+		e.unsupported("join node with %d children", len(node.Children))
+		return nil, nil
+	}
+	left, leftFields := e.rel(node.Children[0])
+	right, rightFields := e.rel(node.Children[1])
+	fields := append(append([]field{}, leftFields...), rightFields...)
+
+	var conjuncts []*logical_plan.Expression
+	if jc := node.JoinCondition; jc != nil {
+		conjuncts = append(conjuncts, &logical_plan.Expression{
+			Kind:     logical_plan.ExprBinaryOp,
+			BinaryOp: logical_plan.BinaryOperator(jc.Operator),
+			Left:     jc.Left,
+			Right:    jc.Right,
+		})
+	}
+	if node.Predicate != nil {
+		conjuncts = append(conjuncts, node.Predicate.Expression)
+	}
+
+	if node.JoinType == logical_plan.JoinTypeCross && len(conjuncts) == 0 {
+		return &Rel{Cross: &CrossRel{Left: left, Right: right}}, fields
+	}
+
+	var joinType string
+	switch node.JoinType {
+	case logical_plan.JoinTypeInner, logical_plan.JoinTypeCross, "":
+		joinType = JoinTypeInner
+	case logical_plan.JoinTypeLeft:
+		joinType = JoinTypeLeft
+	case logical_plan.JoinTypeRight:
+		joinType = JoinTypeRight
+	case logical_plan.JoinTypeFull:
+		joinType = JoinTypeOuter
+	case logical_plan.JoinTypeSemi:
+		joinType = JoinTypeSemi
+	case logical_plan.JoinTypeAnti:
+		joinType = JoinTypeAnti
+	default:
+		e.unsupported("join type %s", node.JoinType)
+	}
+
+	join := &JoinRel{Left: left, Right: right, Type: joinType}
+	if condition := logical_plan.CombineConjuncts(conjuncts); condition != nil {
+		join.Expression = e.expression(condition, fields)
+	} else {
+		join.Expression = &Expression{Literal: &Literal{Boolean: boolPtr(true)}}
+	}
+	if node.JoinType.LeftOnly() {
+		return &Rel{Join: join}, leftFields
+	}
+	return &Rel{Join: join}, fields
+}
+
+func (e *exporter) aggregate(node *logical_plan.LogicalPlan) (*Rel, []field) {
+	input, inputFields := e.input(node)
+	aggregate := &AggregateRel{Input: input}
+
+	var fields []field
+	grouping := Grouping{}
+	for _, column := range node.GroupBy {
+		index, ok := e.resolve(column.Table, column.Name, inputFields)
+		if !ok {
+			continue
+		}
+		grouping.GroupingExpressions = append(grouping.GroupingExpressions, fieldReference(index))
+		fields = append(fields, inputFields[index])
+	}
+	if len(node.GroupBy) > 0 {
+		aggregate.Groupings = []Grouping{grouping}
+	}
+
+	for _, agg := range node.Aggregates {
+		name, ok := aggregateFunctions[agg.Type]
+		if !ok {
+			e.unsupported("aggregate function %s", agg.Type)
+			continue
+		}
+
+		function := &AggregateFunction{
+			FunctionReference: e.functionAnchor(name),
+			Phase:             "AGGREGATION_PHASE_INITIAL_TO_RESULT",
+			Invocation:        "AGGREGATION_INVOCATION_ALL",
+		}
+		argType := logical_plan.DataType("")
+		if agg.Column != nil {
+			function.Arguments = []FunctionArgument{{Value: e.expression(agg.Column, inputFields)}}
+			argType = typeOf(agg.Column, inputFields)
+		}
+
+		outputType := argType
+		switch agg.Type {
+		case logical_plan.AggregateCount:
+			outputType = logical_plan.DataTypeInt
+		case logical_plan.AggregateAvg:
+			outputType = logical_plan.DataTypeFloat
+		}
+		function.OutputType = toSubstraitType(outputType, true)
+
+		aggregate.Measures = append(aggregate.Measures, Measure{Measure: function})
+		alias := agg.Alias
+		if alias == "" {
+			alias = name
+		}
+		fields = append(fields, field{name: alias, dataType: outputType})
+	}
+
+	return &Rel{Aggregate: aggregate}, fields
+}
+
+func (e *exporter) predicate(node *logical_plan.LogicalPlan, fields []field) *Expression {
+	if node.Predicate == nil || node.Predicate.Expression == nil {
+		e.unsupported("%s without a predicate", node.NodeType)
+		return nil
+	}
+	return e.expression(node.Predicate.Expression, fields)
+}
+
+func (e *exporter) expression(expr *logical_plan.Expression, fields []field) *Expression {
+	if expr == nil {
+		e.unsupported("missing expression")
+		return nil
+	}
+
+	switch expr.Kind {
+	case logical_plan.ExprColumn:
+		if expr.Column == nil {
+			e.unsupported("column expression without a column")
+			return nil
+		}
+		index, ok := e.resolve(expr.Column.Table, expr.Column.Name, fields)
+		if !ok {
+			return nil
+		}
+		return fieldReference(index)
+
+	case logical_plan.ExprLiteral:
+		return e.literal(expr.Literal)
+
+	case logical_plan.ExprBinaryOp:
+		switch expr.BinaryOp {
+		case logical_plan.OpIn, logical_plan.OpNotIn:
+			if expr.Right == nil || expr.Right.Kind != logical_plan.ExprList {
+				e.unsupported("%s with a subquery: %s", expr.BinaryOp, expr)
+				return nil
+			}
+			list := &Expression{SingularOrList: &SingularOrList{
+				Value:   e.expression(expr.Left, fields),
+				Options: e.expressions(expr.Right.Args, fields),
+			}}
+			if expr.BinaryOp == logical_plan.OpNotIn {
+				return e.call("not", logical_plan.DataTypeBoolean, list)
+			}
+			return list
+		case logical_plan.OpNotLike:
+			like := e.call("like", logical_plan.DataTypeBoolean,
+				e.expression(expr.Left, fields), e.expression(expr.Right, fields))
+			return e.call("not", logical_plan.DataTypeBoolean, like)
+		}
+
+		name, ok := binaryFunctions[expr.BinaryOp]
+		if !ok {
+			e.unsupported("operator %s", expr.BinaryOp)
+			return nil
+		}
+		return e.call(name, typeOf(expr, fields), e.expression(expr.Left, fields), e.expression(expr.Right, fields))
+
+	case logical_plan.ExprUnaryOp:
+		name, ok := unaryFunctions[expr.UnaryOp]
+		if !ok {
+			e.unsupported("operator %s: %s", expr.UnaryOp, expr)
+			return nil
+		}
+		return e.call(name, typeOf(expr, fields), e.expression(expr.Operand, fields))
+
+	case logical_plan.ExprFunction:
+		name := strings.ToLower(expr.Function)
+		if !scalarFunctions[name] {
+			e.unsupported("function %s", expr.Function)
+			return nil
+		}
+		return e.call(name, typeOf(expr, fields), e.expressions(expr.Args, fields)...)
+
+	case logical_plan.ExprCase:
+		ifThen := &IfThen{}
+		for _, when := range expr.WhenClauses {
+			condition := e.expression(when.When, fields)
+			if expr.Operand != nil {
+				condition = e.call("equal", logical_plan.DataTypeBoolean, e.expression(expr.Operand, fields), condition)
+			}
+			ifThen.Ifs = append(ifThen.Ifs, IfClause{If: condition, Then: e.expression(when.Then, fields)})
+		}
+		if expr.Else != nil {
+			ifThen.Else = e.expression(expr.Else, fields)
+		}
+		return &Expression{IfThen: ifThen}
+
+	case logical_plan.ExprCast:
+		t := toSubstraitType(expr.CastType, true)
+		if t == nil {
+			e.unsupported("cast to %s", expr.CastType)
+			return nil
+		}
+		return &Expression{Cast: &Cast{
+			Type:            t,
+			Input:           e.expression(expr.Operand, fields),
+			FailureBehavior: "FAILURE_BEHAVIOR_THROW_EXCEPTION",
+		}}
+
+	default:
+		e.unsupported("%s expression: %s", expr.Kind, expr)
+		return nil
+	}
+}
+
+func (e *exporter) expressions(exprs []*logical_plan.Expression, fields []field) []*Expression {
+	result := make([]*Expression, len(exprs))
+	for i, expr := range exprs {
+		result[i] = e.expression(expr, fields)
+	}
+	return result
+}
+
+func (e *exporter) literal(literal *logical_plan.Literal) *Expression {
+	if literal == nil || literal.IsNull() {
+		return &Expression{Literal: &Literal{Null: &Type{I64: &TypeInfo{Nullability: NullabilityNullable}}}}
+	}
+
+	switch value := literal.Value.(type) {
+	case bool:
+		return &Expression{Literal: &Literal{Boolean: &value}}
+	case int64:
+		i := Int64(value)
+		return &Expression{Literal: &Literal{I64: &i}}
+	case float64:
+		return &Expression{Literal: &Literal{Fp64: &value}}
+	case string:
+		if literal.Type == logical_plan.DataTypeDate {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				e.unsupported("date literal %q", value)
+				return nil
+			}
+			days := int32(date.Unix() / 86400)
+			return &Expression{Literal: &Literal{Date: &days}}
+		}
+		return &Expression{Literal: &Literal{String: &value}}
+	default:
+		e.unsupported("literal %v", literal.Value)
+		return nil
+	}
+}
+
+func (e *exporter) call(name string, outputType logical_plan.DataType, args ...*Expression) *Expression {
+	function := &ScalarFunction{
+		FunctionReference: e.functionAnchor(name),
+		OutputType:        toSubstraitType(outputType, true),
+	}
+	for _, arg := range args {
+		function.Arguments = append(function.Arguments, FunctionArgument{Value: arg})
+	}
+	return &Expression{ScalarFunction: function}
+}
+
+// functionAnchor declares the function, and its extension file, the first
+// time it is used.
+func (e *exporter) functionAnchor(name string) uint32 {
+	if anchor, ok := e.functions[name]; ok {
+		return anchor
+	}
+
+	uri := extensionsBaseURI + functionURIs[name]
+	uriAnchor, ok := e.uris[uri]
+	if !ok {
+		uriAnchor = uint32(len(e.uris) + 1)
+		e.uris[uri] = uriAnchor
+		e.plan.ExtensionURIs = append(e.plan.ExtensionURIs, ExtensionURI{ExtensionURIAnchor: uriAnchor, URI: uri})
+	}
+
+	anchor := uint32(len(e.functions) + 1)
+	e.functions[name] = anchor
+	e.plan.Extensions = append(e.plan.Extensions, ExtensionDeclaration{ExtensionFunction: &ExtensionFunction{
+		ExtensionURIReference: uriAnchor,
+		FunctionAnchor:        anchor,
+		Name:                  name,
+	}})
+	return anchor
+}
+
+// resolve finds a column by name, qualified by either the relation name or
+// the table name.
+func (e *exporter) resolve(table, name string, fields []field) (int, bool) {
+	var matches []int
+	for i, f := range fields {
+		if strings.EqualFold(f.name, name) && (table == "" || matchesQualifier(f, table)) {
+			matches = append(matches, i)
+		}
+	}
+
+	column := logical_plan.ColumnRef{Table: table, Name: name}
+	switch len(matches) {
+	case 1:
+		return matches[0], true
+	case 0:
+		e.unsupported("column %s not found in %s", column, describeFields(fields))
+	default:
+		e.unsupported("column %s is ambiguous", column)
+	}
+	return 0, false
+}
+
+func matchesQualifier(f field, table string) bool {
+	return strings.EqualFold(f.qualifier, table) || strings.EqualFold(f.table, table)
+}
+
+func describeFields(fields []field) string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = logical_plan.ColumnRef{Table: f.qualifier, Name: f.name}.String()
+	}
+	sort.Strings(names)
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+func fieldReference(index int) *Expression {
+	return &Expression{Selection: &FieldReference{
+		DirectReference: &ReferenceSegment{StructField: &StructField{Field: int32(index)}},
+		RootReference:   &struct{}{},
+	}}
+}
+
+// typeOf infers the result type of an expression, or "" when unknown.
+func typeOf(expr *logical_plan.Expression, fields []field) logical_plan.DataType {
+	if expr == nil {
+		return ""
+	}
+
+	switch expr.Kind {
+	case logical_plan.ExprColumn:
+		if expr.Column == nil {
+			return ""
+		}
+		for _, f := range fields {
+			if strings.EqualFold(f.name, expr.Column.Name) && (expr.Column.Table == "" || matchesQualifier(f, expr.Column.Table)) {
+				return f.dataType
+			}
+		}
+		return ""
+	case logical_plan.ExprLiteral:
+		if expr.Literal == nil {
+			return ""
+		}
+		return expr.Literal.Type
+	case logical_plan.ExprBinaryOp:
+		switch expr.BinaryOp {
+		case logical_plan.OpAdd, logical_plan.OpSub, logical_plan.OpMul, logical_plan.OpDiv, logical_plan.OpMod:
+			left, right := typeOf(expr.Left, fields), typeOf(expr.Right, fields)
+			if left == logical_plan.DataTypeFloat || right == logical_plan.DataTypeFloat {
+				return logical_plan.DataTypeFloat
+			}
+			return left
+		case logical_plan.OpConcat:
+			return logical_plan.DataTypeString
+		default:
+			return logical_plan.DataTypeBoolean
+		}
+	case logical_plan.ExprUnaryOp:
+		if expr.UnaryOp == logical_plan.OpNeg {
+			return typeOf(expr.Operand, fields)
+		}
+		return logical_plan.DataTypeBoolean
+	case logical_plan.ExprFunction:
+		switch strings.ToLower(expr.Function) {
+		case "upper", "lower":
+			return logical_plan.DataTypeString
+		}
+		if len(expr.Args) > 0 {
+			return typeOf(expr.Args[0], fields)
+		}
+		return ""
+	case logical_plan.ExprCase:
+		if len(expr.WhenClauses) > 0 {
+			return typeOf(expr.WhenClauses[0].Then, fields)
+		}
+		return typeOf(expr.Else, fields)
+	case logical_plan.ExprCast:
+		return expr.CastType
+	default:
+		return ""
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
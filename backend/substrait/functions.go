@@ -0,0 +1,163 @@
+package substrait
+
+import (
+	"fmt"
+	"strings"
+
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+const extensionsBaseURI = "https://github.com/substrait-io/substrait/blob/main/extensions/"
+
+// functionURIs maps every function the converter emits to the standard
+// extension file that defines it.
+var functionURIs = map[string]string{
+	"equal":       "functions_comparison.yaml",
+	"not_equal":   "functions_comparison.yaml",
+	"lt":          "functions_comparison.yaml",
+	"lte":         "functions_comparison.yaml",
+	"gt":          "functions_comparison.yaml",
+	"gte":         "functions_comparison.yaml",
+	"is_null":     "functions_comparison.yaml",
+	"is_not_null": "functions_comparison.yaml",
+	"coalesce":    "functions_comparison.yaml",
+	"and":         "functions_boolean.yaml",
+	"or":          "functions_boolean.yaml",
+	"not":         "functions_boolean.yaml",
+	"add":         "functions_arithmetic.yaml",
+	"subtract":    "functions_arithmetic.yaml",
+	"multiply":    "functions_arithmetic.yaml",
+	"divide":      "functions_arithmetic.yaml",
+	"modulus":     "functions_arithmetic.yaml",
+	"negate":      "functions_arithmetic.yaml",
+	"abs":         "functions_arithmetic.yaml",
+	"sum":         "functions_arithmetic.yaml",
+	"avg":         "functions_arithmetic.yaml",
+	"min":         "functions_arithmetic.yaml",
+	"max":         "functions_arithmetic.yaml",
+	"concat":      "functions_string.yaml",
+	"like":        "functions_string.yaml",
+	"upper":       "functions_string.yaml",
+	"lower":       "functions_string.yaml",
+	"count":       "functions_aggregate_generic.yaml",
+}
+
+var binaryFunctions = map[logical_plan.BinaryOperator]string{
+	logical_plan.OpEq:     "equal",
+	logical_plan.OpNotEq:  "not_equal",
+	logical_plan.OpLt:     "lt",
+	logical_plan.OpLtEq:   "lte",
+	logical_plan.OpGt:     "gt",
+	logical_plan.OpGtEq:   "gte",
+	logical_plan.OpAnd:    "and",
+	logical_plan.OpOr:     "or",
+	logical_plan.OpAdd:    "add",
+	logical_plan.OpSub:    "subtract",
+	logical_plan.OpMul:    "multiply",
+	logical_plan.OpDiv:    "divide",
+	logical_plan.OpMod:    "modulus",
+	logical_plan.OpConcat: "concat",
+	logical_plan.OpLike:   "like",
+}
+
+var unaryFunctions = map[logical_plan.UnaryOperator]string{
+	logical_plan.OpNot:       "not",
+	logical_plan.OpNeg:       "negate",
+	logical_plan.OpIsNull:    "is_null",
+	logical_plan.OpIsNotNull: "is_not_null",
+}
+
+var aggregateFunctions = map[logical_plan.AggregateType]string{
+	logical_plan.AggregateCount: "count",
+	logical_plan.AggregateSum:   "sum",
+	logical_plan.AggregateAvg:   "avg",
+	logical_plan.AggregateMin:   "min",
+	logical_plan.AggregateMax:   "max",
+}
+
+// scalarFunctions are the plain function calls with a Substrait equivalent.
+var scalarFunctions = map[string]bool{
+	"abs":      true,
+	"coalesce": true,
+	"upper":    true,
+	"lower":    true,
+}
+
+// functionName strips the signature from a compound name such as
+// "equal:any_any".
+func functionName(name string) string {
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// UnsupportedError lists every construct that could not be converted.
+type UnsupportedError struct {
+	Issues []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported constructs: %s", strings.Join(e.Issues, "; "))
+}
+
+func toSubstraitType(dataType logical_plan.DataType, nullable bool) *Type {
+	info := &TypeInfo{Nullability: NullabilityRequired}
+	if nullable {
+		info.Nullability = NullabilityNullable
+	}
+
+	switch dataType {
+	case logical_plan.DataTypeInt:
+		return &Type{I64: info}
+	case logical_plan.DataTypeFloat:
+		return &Type{Fp64: info}
+	case logical_plan.DataTypeString:
+		return &Type{String: info}
+	case logical_plan.DataTypeBoolean:
+		return &Type{Bool: info}
+	case logical_plan.DataTypeDate:
+		return &Type{Date: info}
+	default:
+		return nil
+	}
+}
+
+func fromSubstraitType(t *Type) (logical_plan.DataType, bool) {
+	switch {
+	case t == nil || len(t.Unknown) > 0:
+		return "", false
+	case t.I8 != nil, t.I16 != nil, t.I32 != nil, t.I64 != nil:
+		return logical_plan.DataTypeInt, true
+	case t.Fp32 != nil, t.Fp64 != nil:
+		return logical_plan.DataTypeFloat, true
+	case t.String != nil:
+		return logical_plan.DataTypeString, true
+	case t.Bool != nil:
+		return logical_plan.DataTypeBoolean, true
+	case t.Date != nil:
+		return logical_plan.DataTypeDate, true
+	default:
+		return "", false
+	}
+}
+
+func typeName(t *Type) string {
+	if t == nil {
+		return "unknown"
+	}
+	if len(t.Unknown) > 0 {
+		return strings.Join(t.Unknown, ",")
+	}
+	dataType, _ := fromSubstraitType(t)
+	return string(dataType)
+}
+
+// field is one column of a relation's output, in Substrait's positional
+// order. Qualifier is the name the optimizer's expressions use for it.
+type field struct {
+	qualifier string
+	table     string
+	name      string
+	dataType  logical_plan.DataType
+}
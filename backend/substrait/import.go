@@ -0,0 +1,658 @@
+package substrait
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"retr0-kernel/optiquery/catalog"
+	"retr0-kernel/optiquery/logical_plan"
+)
+
+type importer struct {
+	catalog   *catalog.CatalogManager
+	functions map[uint32]string
+	scans     map[string]int
+	issues    []string
+}
+
+// Import converts a Substrait plan into a logical plan. Column names come from
+// each read's base schema, or from the catalog when the producer left it out.
+// Every construct without a logical plan equivalent is collected into an
+// *UnsupportedError.
+func Import(plan *Plan, cm *catalog.CatalogManager) (*logical_plan.LogicalPlan, error) {
+	if plan == nil || len(plan.Relations) == 0 {
+		return nil, fmt.Errorf("plan has no relations")
+	}
+
+	i := &importer{
+		catalog:   cm,
+		functions: make(map[uint32]string),
+		scans:     make(map[string]int),
+	}
+	for _, extension := range plan.Extensions {
+		if fn := extension.ExtensionFunction; fn != nil {
+			i.functions[fn.FunctionAnchor] = functionName(fn.Name)
+		}
+	}
+	if len(plan.Relations) > 1 {
+		i.unsupported("%d relation trees, only the first is imported", len(plan.Relations))
+	}
+
+	var result *logical_plan.LogicalPlan
+	if root := plan.Relations[0].Root; root != nil {
+		var fields []field
+		result, fields = i.rel(root.Input)
+		result = i.rename(result, fields, root.Names)
+	} else {
+		result, _ = i.rel(plan.Relations[0].Rel)
+	}
+
+	if len(i.issues) > 0 {
+		return nil, &UnsupportedError{Issues: i.issues}
+	}
+	return result, nil
+}
+
+func (i *importer) unsupported(format string, args ...interface{}) {
+	i.issues = append(i.issues, fmt.Sprintf(format, args...))
+}
+
+// rename applies the root's output names with a projection, when they differ
+// from the names the plan already produces.
+func (i *importer) rename(plan *logical_plan.LogicalPlan, fields []field, names []string) *logical_plan.LogicalPlan {
+	if plan == nil || len(names) == 0 {
+		return plan
+	}
+	if len(names) != len(fields) {
+		i.unsupported("root names %d columns, but the plan produces %d", len(names), len(fields))
+		return plan
+	}
+
+	renamed := false
+	columns := make([]logical_plan.Column, len(fields))
+	for n, f := range fields {
+		columns[n] = logical_plan.Column{Table: f.qualifier, Name: f.name}
+		if names[n] != f.name {
+			columns[n].Alias = names[n]
+			renamed = true
+		}
+	}
+	if !renamed {
+		return plan
+	}
+	if plan.NodeType == logical_plan.NodeTypeProject && len(plan.Projections) == len(columns) {
+		for n := range columns {
+			plan.Projections[n].Alias = columns[n].Alias
+		}
+		return plan
+	}
+	return logical_plan.NewProjectNode(plan, columns)
+}
+
+func (i *importer) rel(rel *Rel) (*logical_plan.LogicalPlan, []field) {
+	if rel == nil {
+		i.unsupported("missing relation")
+		return nil, nil
+	}
+	if len(rel.Unknown) > 0 {
+		i.unsupported("relation type %s", strings.Join(rel.Unknown, ", "))
+		return nil, nil
+	}
+
+	var node *logical_plan.LogicalPlan
+	var fields []field
+
+	switch {
+	case rel.Read != nil:
+		node, fields = i.read(rel.Read)
+
+	case rel.Filter != nil:
+		var input *logical_plan.LogicalPlan
+		input, fields = i.rel(rel.Filter.Input)
+		node = logical_plan.NewFilterNode(input, &logical_plan.Predicate{
+			Expression: i.expression(rel.Filter.Condition, fields),
+		})
+
+	case rel.Project != nil:
+		node, fields = i.project(rel.Project)
+
+	case rel.Join != nil:
+		node, fields = i.join(rel.Join)
+
+	case rel.Cross != nil:
+		left, leftFields := i.rel(rel.Cross.Left)
+		right, rightFields := i.rel(rel.Cross.Right)
+		node = logical_plan.NewJoinNode(left, right, logical_plan.JoinTypeCross, nil)
+		fields = append(append([]field{}, leftFields...), rightFields...)
+
+	case rel.Aggregate != nil:
+		node, fields = i.aggregate(rel.Aggregate)
+
+	case rel.Sort != nil:
+		var input *logical_plan.LogicalPlan
+		input, fields = i.rel(rel.Sort.Input)
+		orderBy := make([]logical_plan.OrderBy, len(rel.Sort.Sorts))
+		for n, sf := range rel.Sort.Sorts {
+			switch sf.Direction {
+			case "", SortAscNullsFirst, SortAscNullsLast, SortDescNullsFirst, SortDescNullsLast:
+			default:
+				i.unsupported("sort direction %s", sf.Direction)
+			}
+			orderBy[n] = logical_plan.OrderBy{
+				Expression: i.expression(sf.Expr, fields),
+				Ascending:  !strings.HasPrefix(sf.Direction, "SORT_DIRECTION_DESC"),
+			}
+		}
+		node = logical_plan.NewSortNode(input, orderBy)
+
+	case rel.Fetch != nil:
+		var input *logical_plan.LogicalPlan
+		input, fields = i.rel(rel.Fetch.Input)
+		var limit, offset *int64
+		if rel.Fetch.Count >= 0 {
+			count := int64(rel.Fetch.Count)
+			limit = &count
+		}
+		if rel.Fetch.Offset > 0 {
+			skip := int64(rel.Fetch.Offset)
+			offset = &skip
+		}
+		node = logical_plan.NewLimitNode(input, limit, offset)
+
+	case rel.Set != nil:
+		var all bool
+		switch rel.Set.Op {
+		case SetOpUnionAll:
+			all = true
+		case SetOpUnionDistinct:
+		default:
+			i.unsupported("set operation %s", rel.Set.Op)
+		}
+		if len(rel.Set.Inputs) < 2 {
+			i.unsupported("set operation needs at least two inputs, got %d", len(rel.Set.Inputs))
+		}
+		var inputs []*logical_plan.LogicalPlan
+		for n, input := range rel.Set.Inputs {
+			child, childFields := i.rel(input)
+			if n == 0 {
+				fields = childFields
+			} else if child != nil && inputs[0] != nil && len(childFields) != len(fields) {
+				i.unsupported("set operation input %d has %d columns, but the first has %d", n, len(childFields), len(fields))
+			}
+			inputs = append(inputs, child)
+		}
+		node = logical_plan.NewUnionNode(inputs, all)
+
+	default:
+		i.unsupported("empty relation")
+		return nil, nil
+	}
+
+	if common := rel.common(); common != nil && common.Emit != nil && rel.Project == nil {
+		node, fields = i.emit(node, fields, common.Emit)
+	}
+	return node, fields
+}
+
+func (i *importer) read(read *ReadRel) (*logical_plan.LogicalPlan, []field) {
+	if len(read.Unknown) > 0 {
+		i.unsupported("read option %s", strings.Join(read.Unknown, ", "))
+	}
+	if read.NamedTable == nil || len(read.NamedTable.Names) == 0 {
+		i.unsupported("read without a named table")
+		return nil, nil
+	}
+
+	table := read.NamedTable.Names[len(read.NamedTable.Names)-1]
+
+	// A table read more than once gets an alias per extra read so that column
+	// references stay unambiguous.
+	alias := ""
+	if count := i.scans[table]; count > 0 {
+		alias = fmt.Sprintf("%s_%d", table, count+1)
+	}
+	i.scans[table]++
+
+	scan := logical_plan.NewScanNode(table, alias)
+	qualifier := scan.RelationName()
+
+	var fields []field
+	if read.BaseSchema != nil && len(read.BaseSchema.Names) > 0 {
+		for n, name := range read.BaseSchema.Names {
+			var dataType logical_plan.DataType
+			if read.BaseSchema.Struct != nil && n < len(read.BaseSchema.Struct.Types) {
+				dataType, _ = fromSubstraitType(read.BaseSchema.Struct.Types[n])
+			}
+			fields = append(fields, field{qualifier: qualifier, table: table, name: name, dataType: dataType})
+		}
+	} else if i.catalog != nil {
+		schema, err := i.catalog.GetTable(table)
+		if err != nil {
+			i.unsupported("read %s without a base schema: %v", table, err)
+			return scan, nil
+		}
+		for _, column := range schema.Columns {
+			fields = append(fields, field{
+				qualifier: qualifier,
+				table:     table,
+				name:      column.Name,
+				dataType:  logical_plan.DataType(column.DataType),
+			})
+		}
+	} else {
+		i.unsupported("read %s without a base schema", table)
+		return scan, nil
+	}
+
+	if read.Filter != nil {
+		return logical_plan.NewFilterNode(scan, &logical_plan.Predicate{
+			Expression: i.expression(read.Filter, fields),
+		}), fields
+	}
+	return scan, fields
+}
+
+func (i *importer) project(project *ProjectRel) (*logical_plan.LogicalPlan, []field) {
+	input, inputFields := i.rel(project.Input)
+
+	// The output is the input followed by the expressions. Expressions that
+	// only select a column become plain projections, the rest computed
+	// columns named after their position.
+	available := append([]field{}, inputFields...)
+	computed := make(map[int]*logical_plan.Expression)
+	for n, expr := range project.Expressions {
+		converted := i.expression(expr, inputFields)
+		if index, ok := fieldIndex(expr); ok && converted != nil {
+			available = append(available, inputFields[index])
+			continue
+		}
+		computed[len(available)] = converted
+		available = append(available, field{name: fmt.Sprintf("expr_%d", n)})
+	}
+
+	mapping := make([]int32, len(available))
+	for n := range available {
+		mapping[n] = int32(n)
+	}
+	if project.Common != nil && project.Common.Emit != nil {
+		mapping = project.Common.Emit.OutputMapping
+	}
+
+	var columns []logical_plan.Column
+	var fields []field
+	for _, index := range mapping {
+		if index < 0 || int(index) >= len(available) {
+			i.unsupported("emit index %d out of range", index)
+			continue
+		}
+		f := available[index]
+		if expr, ok := computed[int(index)]; ok {
+			columns = append(columns, logical_plan.Column{Name: f.name, Expression: expr})
+		} else {
+			columns = append(columns, logical_plan.Column{Table: f.qualifier, Name: f.name})
+		}
+		fields = append(fields, f)
+	}
+	return logical_plan.NewProjectNode(input, columns), fields
+}
+
+// emit applies an output mapping on a relation other than a projection.
+func (i *importer) emit(node *logical_plan.LogicalPlan, fields []field, emit *Emit) (*logical_plan.LogicalPlan, []field) {
+	var columns []logical_plan.Column
+	var emitted []field
+	for _, index := range emit.OutputMapping {
+		if index < 0 || int(index) >= len(fields) {
+			i.unsupported("emit index %d out of range", index)
+			continue
+		}
+		f := fields[index]
+		columns = append(columns, logical_plan.Column{Table: f.qualifier, Name: f.name})
+		emitted = append(emitted, f)
+	}
+	return logical_plan.NewProjectNode(node, columns), emitted
+}
+
+// join keeps the first column-to-column comparison across the two inputs as
+// the join condition. The remaining conjuncts go to a filter above an inner
+// join or into the predicate of a semi or anti join; outer joins cannot carry
+// them.
+func (i *importer) join(join *JoinRel) (*logical_plan.LogicalPlan, []field) {
+	left, leftFields := i.rel(join.Left)
+	right, rightFields := i.rel(join.Right)
+	fields := append(append([]field{}, leftFields...), rightFields...)
+
+	var joinType logical_plan.JoinType
+	switch join.Type {
+	case JoinTypeInner:
+		joinType = logical_plan.JoinTypeInner
+	case JoinTypeLeft:
+		joinType = logical_plan.JoinTypeLeft
+	case JoinTypeRight:
+		joinType = logical_plan.JoinTypeRight
+	case JoinTypeOuter:
+		joinType = logical_plan.JoinTypeFull
+	case JoinTypeSemi:
+		joinType = logical_plan.JoinTypeSemi
+	case JoinTypeAnti:
+		joinType = logical_plan.JoinTypeAnti
+	default:
+		i.unsupported("join type %s", join.Type)
+		joinType = logical_plan.JoinType(strings.ToLower(strings.TrimPrefix(join.Type, "JOIN_TYPE_")))
+	}
+
+	var condition *logical_plan.JoinCondition
+	var residual []*logical_plan.Expression
+	if join.Expression != nil {
+		for _, conjunct := range logical_plan.SplitConjuncts(i.expression(join.Expression, fields)) {
+			if condition == nil {
+				condition = joinCondition(conjunct, leftFields, rightFields)
+				if condition != nil {
+					continue
+				}
+			}
+			if conjunct.IsLiteral() && conjunct.Literal.Value == true {
+				continue
+			}
+			residual = append(residual, conjunct)
+		}
+	}
+
+	var node *logical_plan.LogicalPlan
+	if condition == nil && joinType == logical_plan.JoinTypeInner && len(residual) == 0 {
+		node = logical_plan.NewJoinNode(left, right, logical_plan.JoinTypeCross, nil)
+	} else {
+		node = logical_plan.NewJoinNode(left, right, joinType, condition)
+	}
+
+	if join.PostJoinFilter != nil {
+		residual = append(residual, i.expression(join.PostJoinFilter, fields))
+	}
+	if joinType.LeftOnly() {
+		// The right input's columns are not visible above a semi or anti
+		// join, so the rest of the condition stays on the join.
+		if len(residual) > 0 {
+			node.Predicate = &logical_plan.Predicate{Expression: logical_plan.CombineConjuncts(residual)}
+		}
+		return node, leftFields
+	}
+	if len(residual) > 0 {
+		if joinType != logical_plan.JoinTypeInner {
+			i.unsupported("%s join condition beyond a single comparison: %s", joinType, logical_plan.CombineConjuncts(residual))
+		}
+		node = logical_plan.NewFilterNode(node, &logical_plan.Predicate{
+			Expression: logical_plan.CombineConjuncts(residual),
+		})
+	}
+	return node, fields
+}
+
+func joinCondition(expr *logical_plan.Expression, leftFields, rightFields []field) *logical_plan.JoinCondition {
+	if expr == nil || expr.Kind != logical_plan.ExprBinaryOp || !expr.BinaryOp.IsComparison() ||
+		!expr.Left.IsColumn() || !expr.Right.IsColumn() {
+		return nil
+	}
+
+	inLeft := func(e *logical_plan.Expression) bool { return hasField(leftFields, *e.Column) }
+	inRight := func(e *logical_plan.Expression) bool { return hasField(rightFields, *e.Column) }
+
+	switch {
+	case inLeft(expr.Left) && inRight(expr.Right):
+		return &logical_plan.JoinCondition{Left: expr.Left, Right: expr.Right, Operator: string(expr.BinaryOp)}
+	case inRight(expr.Left) && inLeft(expr.Right) && expr.BinaryOp == logical_plan.OpEq:
+		return &logical_plan.JoinCondition{Left: expr.Right, Right: expr.Left, Operator: string(expr.BinaryOp)}
+	}
+	return nil
+}
+
+func hasField(fields []field, column logical_plan.ColumnRef) bool {
+	for _, f := range fields {
+		if f.qualifier == column.Table && f.name == column.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *importer) aggregate(aggregate *AggregateRel) (*logical_plan.LogicalPlan, []field) {
+	input, inputFields := i.rel(aggregate.Input)
+
+	if len(aggregate.Groupings) > 1 {
+		i.unsupported("%d grouping sets", len(aggregate.Groupings))
+	}
+
+	var groupExprs []*Expression
+	if len(aggregate.Groupings) > 0 {
+		grouping := aggregate.Groupings[0]
+		groupExprs = grouping.GroupingExpressions
+		for _, ref := range grouping.ExpressionReferences {
+			if int(ref) >= len(aggregate.GroupingExpressions) {
+				i.unsupported("grouping expression reference %d out of range", ref)
+				continue
+			}
+			groupExprs = append(groupExprs, aggregate.GroupingExpressions[ref])
+		}
+	}
+
+	var groupBy []logical_plan.Column
+	var fields []field
+	for _, expr := range groupExprs {
+		index, ok := fieldIndex(expr)
+		if !ok || index < 0 || index >= len(inputFields) {
+			i.unsupported("grouping by a computed expression")
+			continue
+		}
+		f := inputFields[index]
+		groupBy = append(groupBy, logical_plan.Column{Table: f.qualifier, Name: f.name})
+		fields = append(fields, f)
+	}
+
+	var aggregates []logical_plan.AggregateFunction
+	for n, measure := range aggregate.Measures {
+		fn := measure.Measure
+		if fn == nil {
+			i.unsupported("measure without a function")
+			continue
+		}
+		if measure.Filter != nil {
+			i.unsupported("filtered measure")
+		}
+		if fn.Invocation == "AGGREGATION_INVOCATION_DISTINCT" {
+			i.unsupported("DISTINCT aggregate")
+		}
+		if fn.Phase != "" && fn.Phase != "AGGREGATION_PHASE_INITIAL_TO_RESULT" {
+			i.unsupported("aggregation phase %s", fn.Phase)
+		}
+
+		name := i.functions[fn.FunctionReference]
+		var aggType logical_plan.AggregateType
+		for t, fnName := range aggregateFunctions {
+			if fnName == name {
+				aggType = t
+			}
+		}
+		if aggType == "" {
+			i.unsupported("aggregate function %q", name)
+			continue
+		}
+
+		agg := logical_plan.AggregateFunction{Type: aggType, Alias: fmt.Sprintf("%s_%d", name, n)}
+		switch len(fn.Arguments) {
+		case 0:
+		case 1:
+			agg.Column = i.expression(fn.Arguments[0].Value, inputFields)
+		default:
+			i.unsupported("%s with %d arguments", name, len(fn.Arguments))
+		}
+		aggregates = append(aggregates, agg)
+
+		dataType, _ := fromSubstraitType(fn.OutputType)
+		fields = append(fields, field{name: agg.Alias, dataType: dataType})
+	}
+
+	return logical_plan.NewAggregateNode(input, groupBy, aggregates), fields
+}
+
+func fieldIndex(expr *Expression) (int, bool) {
+	if expr == nil || expr.Selection == nil || len(expr.Selection.Unknown) > 0 {
+		return 0, false
+	}
+	ref := expr.Selection.DirectReference
+	if ref == nil || ref.StructField == nil || ref.StructField.Child != nil {
+		return 0, false
+	}
+	return int(ref.StructField.Field), true
+}
+
+func (i *importer) expression(expr *Expression, fields []field) *logical_plan.Expression {
+	if expr == nil {
+		i.unsupported("missing expression")
+		return nil
+	}
+	if len(expr.Unknown) > 0 {
+		i.unsupported("expression type %s", strings.Join(expr.Unknown, ", "))
+		return nil
+	}
+
+	switch {
+	case expr.Selection != nil:
+		index, ok := fieldIndex(expr)
+		if !ok {
+			i.unsupported("field reference other than a direct struct field")
+			return nil
+		}
+		if index < 0 || index >= len(fields) {
+			i.unsupported("field %d out of range", index)
+			return nil
+		}
+		return logical_plan.NewColumnExpression(fields[index].qualifier, fields[index].name)
+
+	case expr.Literal != nil:
+		return i.literal(expr.Literal)
+
+	case expr.ScalarFunction != nil:
+		return i.scalarFunction(expr.ScalarFunction, fields)
+
+	case expr.IfThen != nil:
+		var whens []logical_plan.WhenClause
+		for _, clause := range expr.IfThen.Ifs {
+			whens = append(whens, logical_plan.WhenClause{
+				When: i.expression(clause.If, fields),
+				Then: i.expression(clause.Then, fields),
+			})
+		}
+		var elseExpr *logical_plan.Expression
+		if expr.IfThen.Else != nil {
+			elseExpr = i.expression(expr.IfThen.Else, fields)
+		}
+		return logical_plan.NewCaseExpression(nil, whens, elseExpr)
+
+	case expr.Cast != nil:
+		dataType, ok := fromSubstraitType(expr.Cast.Type)
+		if !ok {
+			i.unsupported("cast to %s", typeName(expr.Cast.Type))
+			return nil
+		}
+		return logical_plan.NewCastExpression(i.expression(expr.Cast.Input, fields), dataType)
+
+	case expr.SingularOrList != nil:
+		options := make([]*logical_plan.Expression, len(expr.SingularOrList.Options))
+		for n, option := range expr.SingularOrList.Options {
+			options[n] = i.expression(option, fields)
+		}
+		return logical_plan.NewBinaryOpExpression(logical_plan.OpIn,
+			i.expression(expr.SingularOrList.Value, fields), logical_plan.NewListExpression(options))
+
+	default:
+		i.unsupported("empty expression")
+		return nil
+	}
+}
+
+func (i *importer) scalarFunction(fn *ScalarFunction, fields []field) *logical_plan.Expression {
+	name, ok := i.functions[fn.FunctionReference]
+	if !ok {
+		i.unsupported("function reference %d is not declared", fn.FunctionReference)
+		return nil
+	}
+
+	args := make([]*logical_plan.Expression, len(fn.Arguments))
+	for n, arg := range fn.Arguments {
+		args[n] = i.expression(arg.Value, fields)
+	}
+
+	for op, fnName := range binaryFunctions {
+		if fnName != name {
+			continue
+		}
+		if len(args) < 2 || (len(args) > 2 && op != logical_plan.OpAnd && op != logical_plan.OpOr) {
+			i.unsupported("%s with %d arguments", name, len(args))
+			return nil
+		}
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = logical_plan.NewBinaryOpExpression(op, result, arg)
+		}
+		return result
+	}
+
+	for op, fnName := range unaryFunctions {
+		if fnName != name {
+			continue
+		}
+		if len(args) != 1 {
+			i.unsupported("%s with %d arguments", name, len(args))
+			return nil
+		}
+		if op == logical_plan.OpNot && args[0] != nil && args[0].Kind == logical_plan.ExprBinaryOp {
+			switch args[0].BinaryOp {
+			case logical_plan.OpLike:
+				return logical_plan.NewBinaryOpExpression(logical_plan.OpNotLike, args[0].Left, args[0].Right)
+			case logical_plan.OpIn:
+				return logical_plan.NewBinaryOpExpression(logical_plan.OpNotIn, args[0].Left, args[0].Right)
+			}
+		}
+		return logical_plan.NewUnaryOpExpression(op, args[0])
+	}
+
+	if scalarFunctions[name] {
+		return logical_plan.NewFunctionExpression(strings.ToUpper(name), args)
+	}
+
+	i.unsupported("function %q", name)
+	return nil
+}
+
+func (i *importer) literal(literal *Literal) *logical_plan.Expression {
+	if len(literal.Unknown) > 0 {
+		i.unsupported("literal type %s", strings.Join(literal.Unknown, ", "))
+		return nil
+	}
+
+	switch {
+	case literal.Boolean != nil:
+		return logical_plan.NewLiteralExpression(*literal.Boolean)
+	case literal.I8 != nil:
+		return logical_plan.NewLiteralExpression(int64(*literal.I8))
+	case literal.I16 != nil:
+		return logical_plan.NewLiteralExpression(int64(*literal.I16))
+	case literal.I32 != nil:
+		return logical_plan.NewLiteralExpression(int64(*literal.I32))
+	case literal.I64 != nil:
+		return logical_plan.NewLiteralExpression(int64(*literal.I64))
+	case literal.Fp32 != nil:
+		return logical_plan.NewLiteralExpression(*literal.Fp32)
+	case literal.Fp64 != nil:
+		return logical_plan.NewLiteralExpression(*literal.Fp64)
+	case literal.String != nil:
+		return logical_plan.NewLiteralExpression(*literal.String)
+	case literal.Date != nil:
+		date := time.Unix(int64(*literal.Date)*86400, 0).UTC().Format("2006-01-02")
+		return logical_plan.NewTypedLiteralExpression(logical_plan.DataTypeDate, date)
+	case literal.Null != nil:
+		return logical_plan.NewTypedLiteralExpression(logical_plan.DataTypeNull, nil)
+	default:
+		i.unsupported("empty literal")
+		return nil
+	}
+}
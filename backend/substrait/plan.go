@@ -0,0 +1,405 @@
+package substrait
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The types below follow the protobuf JSON encoding of the Substrait plan
+// messages, restricted to what the optimizer can represent. Messages that can
+// hold other variants remember the keys they did not recognise in Unknown, so
+// imports can report them instead of silently dropping them.
+
+type Plan struct {
+	Version       *Version               `json:"version,omitempty"`
+	ExtensionURIs []ExtensionURI         `json:"extensionUris,omitempty"`
+	Extensions    []ExtensionDeclaration `json:"extensions,omitempty"`
+	Relations     []PlanRel              `json:"relations"`
+}
+
+type Version struct {
+	MajorNumber int    `json:"majorNumber"`
+	MinorNumber int    `json:"minorNumber"`
+	PatchNumber int    `json:"patchNumber,omitempty"`
+	Producer    string `json:"producer,omitempty"`
+}
+
+type ExtensionURI struct {
+	ExtensionURIAnchor uint32 `json:"extensionUriAnchor"`
+	URI                string `json:"uri"`
+}
+
+type ExtensionDeclaration struct {
+	ExtensionFunction *ExtensionFunction `json:"extensionFunction,omitempty"`
+}
+
+type ExtensionFunction struct {
+	ExtensionURIReference uint32 `json:"extensionUriReference"`
+	FunctionAnchor        uint32 `json:"functionAnchor"`
+	Name                  string `json:"name"`
+}
+
+type PlanRel struct {
+	Root *RelRoot `json:"root,omitempty"`
+	Rel  *Rel     `json:"rel,omitempty"`
+}
+
+type RelRoot struct {
+	Input *Rel     `json:"input"`
+	Names []string `json:"names,omitempty"`
+}
+
+type RelCommon struct {
+	Direct *struct{} `json:"direct,omitempty"`
+	Emit   *Emit     `json:"emit,omitempty"`
+}
+
+type Emit struct {
+	OutputMapping []int32 `json:"outputMapping"`
+}
+
+type Rel struct {
+	Read      *ReadRel      `json:"read,omitempty"`
+	Filter    *FilterRel    `json:"filter,omitempty"`
+	Fetch     *FetchRel     `json:"fetch,omitempty"`
+	Aggregate *AggregateRel `json:"aggregate,omitempty"`
+	Sort      *SortRel      `json:"sort,omitempty"`
+	Join      *JoinRel      `json:"join,omitempty"`
+	Project   *ProjectRel   `json:"project,omitempty"`
+	Set       *SetRel       `json:"set,omitempty"`
+	Cross     *CrossRel     `json:"cross,omitempty"`
+
+	Unknown []string `json:"-"`
+}
+
+func (r *Rel) UnmarshalJSON(data []byte) error {
+	type plain Rel
+	return decodeTracked(data, (*plain)(r), &r.Unknown)
+}
+
+// common returns the RelCommon of whichever relation is set.
+func (r *Rel) common() *RelCommon {
+	switch {
+	case r.Read != nil:
+		return r.Read.Common
+	case r.Filter != nil:
+		return r.Filter.Common
+	case r.Fetch != nil:
+		return r.Fetch.Common
+	case r.Aggregate != nil:
+		return r.Aggregate.Common
+	case r.Sort != nil:
+		return r.Sort.Common
+	case r.Join != nil:
+		return r.Join.Common
+	case r.Project != nil:
+		return r.Project.Common
+	case r.Set != nil:
+		return r.Set.Common
+	case r.Cross != nil:
+		return r.Cross.Common
+	}
+	return nil
+}
+
+type ReadRel struct {
+	Common     *RelCommon   `json:"common,omitempty"`
+	BaseSchema *NamedStruct `json:"baseSchema,omitempty"`
+	Filter     *Expression  `json:"filter,omitempty"`
+	NamedTable *NamedTable  `json:"namedTable,omitempty"`
+
+	Unknown []string `json:"-"`
+}
+
+func (r *ReadRel) UnmarshalJSON(data []byte) error {
+	type plain ReadRel
+	return decodeTracked(data, (*plain)(r), &r.Unknown)
+}
+
+type NamedTable struct {
+	Names []string `json:"names"`
+}
+
+type NamedStruct struct {
+	Names  []string    `json:"names"`
+	Struct *StructType `json:"struct,omitempty"`
+}
+
+type StructType struct {
+	Types       []*Type `json:"types"`
+	Nullability string  `json:"nullability,omitempty"`
+}
+
+type FilterRel struct {
+	Common    *RelCommon  `json:"common,omitempty"`
+	Input     *Rel        `json:"input"`
+	Condition *Expression `json:"condition"`
+}
+
+// FetchRel.Count is -1 when there is no limit, only an offset.
+type FetchRel struct {
+	Common *RelCommon `json:"common,omitempty"`
+	Input  *Rel       `json:"input"`
+	Offset Int64      `json:"offset,omitempty"`
+	Count  Int64      `json:"count,omitempty"`
+}
+
+type AggregateRel struct {
+	Common              *RelCommon    `json:"common,omitempty"`
+	Input               *Rel          `json:"input"`
+	Groupings           []Grouping    `json:"groupings,omitempty"`
+	Measures            []Measure     `json:"measures,omitempty"`
+	GroupingExpressions []*Expression `json:"groupingExpressions,omitempty"`
+}
+
+// Grouping lists its expressions inline, or refers to the relation's
+// GroupingExpressions in newer producers.
+type Grouping struct {
+	GroupingExpressions  []*Expression `json:"groupingExpressions,omitempty"`
+	ExpressionReferences []uint32      `json:"expressionReferences,omitempty"`
+}
+
+type Measure struct {
+	Measure *AggregateFunction `json:"measure"`
+	Filter  *Expression        `json:"filter,omitempty"`
+}
+
+type AggregateFunction struct {
+	FunctionReference uint32             `json:"functionReference"`
+	Arguments         []FunctionArgument `json:"arguments,omitempty"`
+	OutputType        *Type              `json:"outputType,omitempty"`
+	Phase             string             `json:"phase,omitempty"`
+	Invocation        string             `json:"invocation,omitempty"`
+}
+
+type SortRel struct {
+	Common *RelCommon  `json:"common,omitempty"`
+	Input  *Rel        `json:"input"`
+	Sorts  []SortField `json:"sorts"`
+}
+
+type SortField struct {
+	Expr      *Expression `json:"expr"`
+	Direction string      `json:"direction,omitempty"`
+}
+
+const (
+	SortAscNullsFirst  = "SORT_DIRECTION_ASC_NULLS_FIRST"
+	SortAscNullsLast   = "SORT_DIRECTION_ASC_NULLS_LAST"
+	SortDescNullsFirst = "SORT_DIRECTION_DESC_NULLS_FIRST"
+	SortDescNullsLast  = "SORT_DIRECTION_DESC_NULLS_LAST"
+)
+
+type JoinRel struct {
+	Common         *RelCommon  `json:"common,omitempty"`
+	Left           *Rel        `json:"left"`
+	Right          *Rel        `json:"right"`
+	Expression     *Expression `json:"expression,omitempty"`
+	PostJoinFilter *Expression `json:"postJoinFilter,omitempty"`
+	Type           string      `json:"type"`
+}
+
+const (
+	JoinTypeInner = "JOIN_TYPE_INNER"
+	JoinTypeOuter = "JOIN_TYPE_OUTER"
+	JoinTypeLeft  = "JOIN_TYPE_LEFT"
+	JoinTypeRight = "JOIN_TYPE_RIGHT"
+	JoinTypeSemi  = "JOIN_TYPE_LEFT_SEMI"
+	JoinTypeAnti  = "JOIN_TYPE_LEFT_ANTI"
+)
+
+type CrossRel struct {
+	Common *RelCommon `json:"common,omitempty"`
+	Left   *Rel       `json:"left"`
+	Right  *Rel       `json:"right"`
+}
+
+// ProjectRel outputs its input's fields followed by Expressions, unless an
+// emit mapping selects among them.
+type ProjectRel struct {
+	Common      *RelCommon    `json:"common,omitempty"`
+	Input       *Rel          `json:"input"`
+	Expressions []*Expression `json:"expressions,omitempty"`
+}
+
+type SetRel struct {
+	Common *RelCommon `json:"common,omitempty"`
+	Inputs []*Rel     `json:"inputs"`
+	Op     string     `json:"op"`
+}
+
+const (
+	SetOpUnionAll      = "SET_OP_UNION_ALL"
+	SetOpUnionDistinct = "SET_OP_UNION_DISTINCT"
+)
+
+type Expression struct {
+	Literal        *Literal        `json:"literal,omitempty"`
+	Selection      *FieldReference `json:"selection,omitempty"`
+	ScalarFunction *ScalarFunction `json:"scalarFunction,omitempty"`
+	IfThen         *IfThen         `json:"ifThen,omitempty"`
+	Cast           *Cast           `json:"cast,omitempty"`
+	SingularOrList *SingularOrList `json:"singularOrList,omitempty"`
+
+	Unknown []string `json:"-"`
+}
+
+func (e *Expression) UnmarshalJSON(data []byte) error {
+	type plain Expression
+	return decodeTracked(data, (*plain)(e), &e.Unknown)
+}
+
+type Literal struct {
+	Boolean  *bool    `json:"boolean,omitempty"`
+	I8       *int32   `json:"i8,omitempty"`
+	I16      *int32   `json:"i16,omitempty"`
+	I32      *int32   `json:"i32,omitempty"`
+	I64      *Int64   `json:"i64,omitempty"`
+	Fp32     *float64 `json:"fp32,omitempty"`
+	Fp64     *float64 `json:"fp64,omitempty"`
+	String   *string  `json:"string,omitempty"`
+	Date     *int32   `json:"date,omitempty"`
+	Null     *Type    `json:"null,omitempty"`
+	Nullable bool     `json:"nullable,omitempty"`
+
+	Unknown []string `json:"-"`
+}
+
+func (l *Literal) UnmarshalJSON(data []byte) error {
+	type plain Literal
+	return decodeTracked(data, (*plain)(l), &l.Unknown)
+}
+
+// FieldReference only supports direct references into the input struct;
+// Child is set for nested access, which the optimizer cannot represent.
+type FieldReference struct {
+	DirectReference *ReferenceSegment `json:"directReference,omitempty"`
+	RootReference   *struct{}         `json:"rootReference,omitempty"`
+
+	Unknown []string `json:"-"`
+}
+
+func (f *FieldReference) UnmarshalJSON(data []byte) error {
+	type plain FieldReference
+	return decodeTracked(data, (*plain)(f), &f.Unknown)
+}
+
+type ReferenceSegment struct {
+	StructField *StructField `json:"structField,omitempty"`
+}
+
+type StructField struct {
+	Field int32             `json:"field"`
+	Child *ReferenceSegment `json:"child,omitempty"`
+}
+
+type ScalarFunction struct {
+	FunctionReference uint32             `json:"functionReference"`
+	Arguments         []FunctionArgument `json:"arguments,omitempty"`
+	OutputType        *Type              `json:"outputType,omitempty"`
+}
+
+type FunctionArgument struct {
+	Value *Expression `json:"value,omitempty"`
+}
+
+type IfThen struct {
+	Ifs  []IfClause  `json:"ifs"`
+	Else *Expression `json:"else,omitempty"`
+}
+
+type IfClause struct {
+	If   *Expression `json:"if"`
+	Then *Expression `json:"then"`
+}
+
+type Cast struct {
+	Type            *Type       `json:"type"`
+	Input           *Expression `json:"input"`
+	FailureBehavior string      `json:"failureBehavior,omitempty"`
+}
+
+type SingularOrList struct {
+	Value   *Expression   `json:"value"`
+	Options []*Expression `json:"options"`
+}
+
+type Type struct {
+	Bool   *TypeInfo `json:"bool,omitempty"`
+	I8     *TypeInfo `json:"i8,omitempty"`
+	I16    *TypeInfo `json:"i16,omitempty"`
+	I32    *TypeInfo `json:"i32,omitempty"`
+	I64    *TypeInfo `json:"i64,omitempty"`
+	Fp32   *TypeInfo `json:"fp32,omitempty"`
+	Fp64   *TypeInfo `json:"fp64,omitempty"`
+	String *TypeInfo `json:"string,omitempty"`
+	Date   *TypeInfo `json:"date,omitempty"`
+
+	Unknown []string `json:"-"`
+}
+
+func (t *Type) UnmarshalJSON(data []byte) error {
+	type plain Type
+	return decodeTracked(data, (*plain)(t), &t.Unknown)
+}
+
+type TypeInfo struct {
+	Nullability string `json:"nullability,omitempty"`
+}
+
+const (
+	NullabilityNullable = "NULLABILITY_NULLABLE"
+	NullabilityRequired = "NULLABILITY_REQUIRED"
+)
+
+// Int64 follows the protobuf JSON mapping: it is written as a string and read
+// from either a string or a number.
+type Int64 int64
+
+func (i Int64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(i), 10))
+}
+
+func (i *Int64) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64 %s", data)
+	}
+	*i = Int64(value)
+	return nil
+}
+
+// decodeTracked decodes data into v and records the object keys v has no
+// field for.
+func decodeTracked(data []byte, v interface{}, unknown *[]string) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	known := make(map[string]bool)
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+
+	*unknown = nil
+	for key := range raw {
+		if !known[key] {
+			*unknown = append(*unknown, key)
+		}
+	}
+	sort.Strings(*unknown)
+	return nil
+}
@@ -238,6 +238,804 @@ nonexistent_table='{
 }'
 test_endpoint "POST" "/api/simulate" "$nonexistent_table" 200 "Nonexistent table simulation"
 
+# Test 14: Catalog statistics drive join order
+print_status "INFO" "Testing that catalog statistics drive join order..."
+for table in "stats_a 10" "stats_b 1000" "stats_c 100000"; do
+    set -- $table
+    stats_table='{
+  "name": "'$1'",
+  "row_count": '$2',
+  "columns": [
+    {"name": "id", "data_type": "int", "nullable": false},
+    {"name": "a_id", "data_type": "int", "nullable": false},
+    {"name": "c_id", "data_type": "int", "nullable": false}
+  ]
+}'
+    test_endpoint "POST" "/api/catalog/table" "$stats_table" 201 "Add table $1 with $2 rows"
+done
+
+three_way_join='{
+  "strategy": "cost",
+  "logicalPlan": {
+    "id": "join_ab_c",
+    "node_type": "join",
+    "join_type": "inner",
+    "join_condition": {
+      "left": {"type": "column", "value": "stats_b.c_id"},
+      "right": {"type": "column", "value": "stats_c.id"},
+      "operator": "="
+    },
+    "children": [
+      {
+        "id": "join_ab",
+        "node_type": "join",
+        "join_type": "inner",
+        "join_condition": {
+          "left": {"type": "column", "value": "stats_a.id"},
+          "right": {"type": "column", "value": "stats_b.a_id"},
+          "operator": "="
+        },
+        "children": [
+          {"id": "scan_a", "node_type": "scan", "table_name": "stats_a"},
+          {"id": "scan_b", "node_type": "scan", "table_name": "stats_b"}
+        ]
+      },
+      {"id": "scan_c", "node_type": "scan", "table_name": "stats_c"}
+    ]
+  }
+}'
+
+chosen_join_order() {
+    curl -s -X POST -H "Content-Type: application/json" -d "$three_way_join" "$BASE_URL/api/optimize" |
+        grep -o '"chosen":{"order":"[^"]*"' | sed -e 's/.*"order":"//' -e 's/"$//'
+}
+
+order_before=$(chosen_join_order)
+print_status "INFO" "Join order with stats_a small and stats_c large: $order_before"
+
+test_endpoint "POST" "/api/catalog/table/stats_a/stats" '{"row_count": 100000}' 200 "Grow stats_a to 100000 rows"
+test_endpoint "POST" "/api/catalog/table/stats_c/stats" '{"row_count": 10, "column_stats": {"id": {"ndv": 10}}}' 200 "Shrink stats_c to 10 rows"
+
+order_after=$(chosen_join_order)
+print_status "INFO" "Join order with stats_a large and stats_c small: $order_after"
+
+TESTS_RUN=$((TESTS_RUN + 1))
+# stats_a is now the largest input, so it should be joined last
+if [ -n "$order_before" ] && [ "$order_before" != "$order_after" ] && [[ "$order_after" == *"⋈ stats_a)" ]]; then
+    print_status "PASS" "Updated statistics flip the join order"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Updated statistics flip the join order (before: $order_before, after: $order_after)"
+fi
+
+# Test 15: Key constraints eliminate unused joins
+test_endpoint "POST" "/api/catalog/table" '{"name": "lookup_status", "row_count": 10, "primary_key": ["id"], "columns": [{"name": "id", "data_type": "int"}, {"name": "label", "data_type": "string"}]}' 201 "Add lookup table with primary key"
+test_endpoint "POST" "/api/catalog/table" '{"name": "bad_keys", "row_count": 10, "primary_key": ["missing"], "columns": [{"name": "id", "data_type": "int"}]}' 400 "Reject primary key on unknown column"
+
+lookup_join='{
+  "strategy": "rule",
+  "logicalPlan": {
+    "id": "project_1",
+    "node_type": "project",
+    "projections": [{"table": "stats_b", "name": "id"}],
+    "children": [
+      {
+        "id": "join_1",
+        "node_type": "join",
+        "join_type": "left",
+        "join_condition": {
+          "left": {"type": "column", "value": "stats_b.a_id"},
+          "right": {"type": "column", "value": "lookup_status.id"},
+          "operator": "="
+        },
+        "children": [
+          {"id": "scan_b", "node_type": "scan", "table_name": "stats_b"},
+          {"id": "scan_l", "node_type": "scan", "table_name": "lookup_status"}
+        ]
+      }
+    ]
+  }
+}'
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if curl -s -X POST -H "Content-Type: application/json" -d "$lookup_join" "$BASE_URL/api/optimize" | grep -q '"JoinElimination"'; then
+    print_status "PASS" "Unused left join to a unique lookup table is removed"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Unused left join to a unique lookup table is removed"
+fi
+
+# Test 16: Constants carry over column equalities
+transitive_filter='{
+  "strategy": "rule",
+  "logicalPlan": {
+    "id": "filter_1",
+    "node_type": "filter",
+    "predicate": {
+      "expression": {
+        "type": "binary_op",
+        "value": "AND",
+        "left": {
+          "type": "binary_op",
+          "value": "=",
+          "left": {"type": "column", "value": "stats_a.id"},
+          "right": {"type": "column", "value": "stats_b.a_id"}
+        },
+        "right": {
+          "type": "binary_op",
+          "value": "=",
+          "left": {"type": "column", "value": "stats_a.id"},
+          "right": {"type": "literal", "value": 5}
+        }
+      }
+    },
+    "children": [
+      {
+        "id": "join_1",
+        "node_type": "join",
+        "join_type": "cross",
+        "children": [
+          {"id": "scan_a", "node_type": "scan", "table_name": "stats_a"},
+          {"id": "scan_b", "node_type": "scan", "table_name": "stats_b"}
+        ]
+      }
+    ]
+  }
+}'
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if curl -s -X POST -H "Content-Type: application/json" -d "$transitive_filter" "$BASE_URL/api/optimize" | grep -q 'inferred stats_b.a_id = 5'; then
+    print_status "PASS" "Equality with a constant is inferred across a join"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Equality with a constant is inferred across a join"
+fi
+
+# Test 17: EXISTS becomes a semi join
+exists_filter='{
+  "strategy": "rule",
+  "logicalPlan": {
+    "id": "filter_1",
+    "node_type": "filter",
+    "predicate": {
+      "expression": {
+        "version": 2,
+        "kind": "unary_op",
+        "unary_op": "EXISTS",
+        "operand": {
+          "version": 2,
+          "kind": "subquery",
+          "subquery": {
+            "id": "filter_2",
+            "node_type": "filter",
+            "predicate": {
+              "expression": {
+                "version": 2,
+                "kind": "binary_op",
+                "binary_op": "=",
+                "left": {"version": 2, "kind": "column", "column": {"table": "stats_b", "name": "a_id"}},
+                "right": {"version": 2, "kind": "column", "column": {"table": "stats_a", "name": "id"}}
+              }
+            },
+            "children": [{"id": "scan_b", "node_type": "scan", "table_name": "stats_b"}]
+          }
+        }
+      }
+    },
+    "children": [{"id": "scan_a", "node_type": "scan", "table_name": "stats_a"}]
+  }
+}'
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if curl -s -X POST -H "Content-Type: application/json" -d "$exists_filter" "$BASE_URL/api/optimize" | grep -q 'into semi join ON stats_a.id = stats_b.a_id'; then
+    print_status "PASS" "Correlated EXISTS is turned into a semi join"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Correlated EXISTS is turned into a semi join"
+fi
+
+# Test 18: Aggregates move below a join when the NDVs make it cheaper
+test_endpoint "POST" "/api/catalog/table/stats_a/stats" '{"row_count": 100000, "column_stats": {"c_id": {"ndv": 10}}}' 200 "Give stats_a.c_id 10 distinct values"
+
+eager_aggregate='{
+  "strategy": "cost",
+  "logicalPlan": {
+    "id": "agg_1",
+    "node_type": "aggregate",
+    "group_by": [{"table": "stats_c", "name": "id"}],
+    "aggregates": [{"type": "sum", "column": {"type": "column", "value": "stats_a.id"}, "alias": "total"}],
+    "children": [
+      {
+        "id": "join_1",
+        "node_type": "join",
+        "join_type": "inner",
+        "join_condition": {
+          "left": {"type": "column", "value": "stats_a.c_id"},
+          "right": {"type": "column", "value": "stats_c.id"},
+          "operator": "="
+        },
+        "children": [
+          {"id": "scan_a", "node_type": "scan", "table_name": "stats_a"},
+          {"id": "scan_c", "node_type": "scan", "table_name": "stats_c"}
+        ]
+      }
+    ]
+  }
+}'
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if curl -s -X POST -H "Content-Type: application/json" -d "$eager_aggregate" "$BASE_URL/api/optimize" | grep -q 'pre-aggregated stats_a by stats_a.c_id'; then
+    print_status "PASS" "SUM is pre-aggregated on the join key below the join"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "SUM is pre-aggregated on the join key below the join"
+fi
+
+# Test 19: Memo-based optimizer
+cascades_join=$(echo "$three_way_join" | sed 's/"strategy": "cost"/"strategy": "cascades"/')
+cascades_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$cascades_join" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$cascades_response" | grep -q '"memo"' && echo "$cascades_response" | grep -q '"JoinAssociativity"' && echo "$cascades_response" | grep -q '"physical_operator":"hash_join"'; then
+    print_status "PASS" "Cascades strategy returns the explored memo and a physical plan"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Cascades strategy returns the explored memo and a physical plan"
+fi
+
+# Test 20: An ORDER BY matching a btree index becomes an ordered index scan
+events_table='{
+  "name": "events",
+  "row_count": 500000,
+  "columns": [
+    {"name": "id", "data_type": "int", "nullable": false},
+    {"name": "created_at", "data_type": "timestamp", "nullable": false}
+  ],
+  "primary_key": ["id"],
+  "indexes": [{"name": "idx_events_created_at", "columns": ["created_at"], "unique": false, "type": "btree"}]
+}'
+test_endpoint "POST" "/api/catalog/table" "$events_table" 201 "Add table events with a btree index"
+
+ordered_scan='{
+  "strategy": "cost",
+  "logicalPlan": {
+    "id": "sort_1",
+    "node_type": "sort",
+    "order_by": [{"expression": {"type": "column", "value": "events.created_at"}, "ascending": true}],
+    "children": [{"id": "scan_events", "node_type": "scan", "table_name": "events"}]
+  }
+}'
+ordered_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$ordered_scan" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$ordered_response" | grep -q '"scan_type":"index"' && echo "$ordered_response" | grep -q 'removed sort on events.created_at' && ! echo "$ordered_response" | grep -q '"optimizedPlan":{[^}]*"node_type":"sort"'; then
+    print_status "PASS" "Sort over an indexed column is replaced by an ordered index scan"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Sort over an indexed column is replaced by an ordered index scan"
+fi
+
+# Test 21: Options switch rules off and reject unknown ones
+with_pushdown=$(curl -s -X POST -H "Content-Type: application/json" -d "$transitive_filter" "$BASE_URL/api/optimize")
+no_pushdown=$(echo "$transitive_filter" | sed 's/"strategy": "rule",/"strategy": "rule", "options": {"disabled_rules": ["PredicatePushdown"], "max_iterations": 2},/')
+no_pushdown_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$no_pushdown" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$with_pushdown" | grep -q '"rule_name":"PredicatePushdown"' && echo "$no_pushdown_response" | grep -q '"max_iterations":2' &&
+    ! echo "$no_pushdown_response" | grep -q '"rule_name":"PredicatePushdown"'; then
+    print_status "PASS" "Disabled rules do not fire"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Disabled rules do not fire"
+fi
+
+unknown_rule=$(echo "$three_way_join" | sed 's/"strategy": "cost",/"strategy": "cost", "options": {"disabled_rules": ["NoSuchRule"]},/')
+test_endpoint "POST" "/api/optimize" "$unknown_rule" 400 "Unknown rule in options"
+
+# Test 22: A timeout returns the best plan found so far, marked partial
+chain_join='{"id": "scan_t0", "node_type": "scan", "table_name": "chain_t0"}'
+for i in $(seq 1 11); do
+    chain_join='{"id": "join_t'$i'", "node_type": "join", "join_type": "inner",
+      "join_condition": {"left": {"type": "column", "value": "chain_t'$((i - 1))'.id"}, "right": {"type": "column", "value": "chain_t'$i'.id"}, "operator": "="},
+      "children": ['"$chain_join"', {"id": "scan_t'$i'", "node_type": "scan", "table_name": "chain_t'$i'"}]}'
+done
+timed_out='{"strategy": "cascades", "options": {"timeout_ms": 1}, "logicalPlan": '"$chain_join"'}'
+timed_out_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$timed_out" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$timed_out_response" | grep -q '"partial":true' && echo "$timed_out_response" | grep -q '"partial_reason":"optimization timed out"' &&
+    echo "$timed_out_response" | grep -q '"optimizedPlan":{'; then
+    print_status "PASS" "Timed out optimization returns a partial plan"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Timed out optimization returns a partial plan"
+fi
+
+# Test 23: Hints from SQL comments are parsed and followed by the cost strategy
+test_endpoint "POST" "/api/parse" '{"dialect": "sql", "query": "SELECT /*+ LEADING(o c) NESTLOOP(c o) */ * FROM customers c JOIN orders o ON c.id = o.customer_id"}' 200 "Parse hint comment"
+test_endpoint "POST" "/api/parse" '{"dialect": "sql", "query": "SELECT /*+ LEADING(o c */ * FROM customers c"}' 400 "Hint comment with unbalanced parentheses"
+
+hinted=$(echo "$three_way_join" | sed 's/"logicalPlan": {/"logicalPlan": {"hints": [{"name": "LEADING", "arguments": ["stats_c", "stats_b"]}, {"name": "NL_JOIN", "arguments": ["stats_b", "stats_c"]}, {"name": "NOPE", "arguments": ["x"]}],/')
+hinted_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$hinted" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$hinted_response" | grep -q '"hint":"LEADING(stats_c stats_b)","used":true' && echo "$hinted_response" | grep -q '"hint":"NL_JOIN(stats_b stats_c)","used":true' &&
+    echo "$hinted_response" | grep -q '"reason":"unknown hint"' && echo "$hinted_response" | grep -q '"physical_operator":"nested_loop_join"'; then
+    print_status "PASS" "Cost strategy follows hints and reports unknown ones"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Cost strategy follows hints and reports unknown ones"
+fi
+
+# Test 24: Aggregates over joins are answered from a materialized view
+test_endpoint "POST" "/api/catalog/table" '{"name": "mv_customers", "row_count": 100000, "columns": [{"name": "id", "data_type": "int"}, {"name": "region", "data_type": "string"}], "primary_key": ["id"]}' 201 "Add mv_customers table"
+test_endpoint "POST" "/api/catalog/table" '{"name": "mv_orders", "row_count": 2000000, "columns": [{"name": "id", "data_type": "int"}, {"name": "customer_id", "data_type": "int"}, {"name": "total", "data_type": "float"}, {"name": "month", "data_type": "int"}], "primary_key": ["id"]}' 201 "Add mv_orders table"
+test_endpoint "POST" "/api/catalog/view" '{"name": "sales_by_region_month", "query": "SELECT c.region, o.month, SUM(o.total) AS total_sales FROM mv_customers c JOIN mv_orders o ON c.id = o.customer_id GROUP BY c.region, o.month", "row_count": 48, "column_stats": {"region": {"ndv": 4}, "month": {"ndv": 12}}}' 201 "Add materialized view"
+test_endpoint "POST" "/api/catalog/view" '{"name": "mv_bad", "query": "SELECT * FROM mv_customers c JOIN mv_orders o ON c.id = o.customer_id"}' 400 "Materialized view with clashing column names"
+test_endpoint "GET" "/api/catalog/views" "" 200 "List materialized views"
+
+rollup='{
+  "strategy": "cost",
+  "logicalPlan": {
+    "id": "agg", "node_type": "aggregate",
+    "group_by": [{"table": "c", "name": "region"}],
+    "aggregates": [{"type": "sum", "column": {"type": "column", "value": "o.total"}, "alias": "revenue"}],
+    "children": [{
+      "id": "join", "node_type": "join", "join_type": "inner",
+      "join_condition": {"left": {"type": "column", "value": "c.id"}, "right": {"type": "column", "value": "o.customer_id"}, "operator": "="},
+      "children": [
+        {"id": "scan_c", "node_type": "scan", "table_name": "mv_customers", "alias": "c"},
+        {"id": "scan_o", "node_type": "scan", "table_name": "mv_orders", "alias": "o"}
+      ]
+    }]
+  }
+}'
+rollup_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$rollup" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$rollup_response" | grep -q '"view":"sales_by_region_month","used":true' && echo "$rollup_response" | grep -q '"rollup":\["sales_by_region_month.region"\]' &&
+    echo "$rollup_response" | grep -q '"optimizedPlan":{[^}]*"node_type":"aggregate"' && echo "$rollup_response" | grep -q '"table_name":"sales_by_region_month"' &&
+    echo "$rollup_response" | grep -q '"rule_name":"MaterializedViewRewrite"'; then
+    print_status "PASS" "Aggregate is rolled up from a materialized view"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Aggregate is rolled up from a materialized view"
+fi
+
+# Test 25: An aggregate repeated in both branches of a UNION is computed once into a spool
+spool_branch() {
+    echo '{"id": "filter_'$1'", "node_type": "filter",
+      "predicate": {"expression": {"type": "binary_op", "value": "'$2'", "left": {"type": "column", "value": "revenue"}, "right": {"type": "literal", "value": '$3'}}},
+      "children": [{
+        "id": "agg_'$1'", "node_type": "aggregate",
+        "group_by": [{"table": "c", "name": "region"}],
+        "aggregates": [{"type": "sum", "column": {"type": "column", "value": "o.total"}, "alias": "revenue"}],
+        "children": [{
+          "id": "join_'$1'", "node_type": "join", "join_type": "inner",
+          "join_condition": {"left": {"type": "column", "value": "c.id"}, "right": {"type": "column", "value": "o.customer_id"}, "operator": "="},
+          "children": [
+            {"id": "scan_c_'$1'", "node_type": "scan", "table_name": "mv_customers", "alias": "c"},
+            {"id": "scan_o_'$1'", "node_type": "scan", "table_name": "mv_orders", "alias": "o"}
+          ]
+        }]
+      }]
+    }'
+}
+shared='{
+  "strategy": "cost",
+  "format": "mermaid",
+  "options": {"disabled_rules": ["MaterializedViewRewrite"]},
+  "logicalPlan": {
+    "id": "union", "node_type": "union", "union_all": true,
+    "children": ['"$(spool_branch high '>' 1000)"', '"$(spool_branch low '<' 10)"']
+  }
+}'
+shared_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$shared" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$shared_response" | grep -q '"subplan":"aggregate over c, o"' && echo "$shared_response" | grep -q '"spooled":true,"spool":"spool_1"' &&
+    echo "$shared_response" | grep -q '"rule_name":"SubplanSharing"' && echo "$shared_response" | grep -q '"node_type":"spool","label":"spool spool_1","children":\[[^]]*\],"parents":2' &&
+    [ "$(echo "$shared_response" | grep -o 'SPOOL' | wc -l)" -eq 1 ]; then
+    print_status "PASS" "Repeated subplan is spooled once and shown as a DAG"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Repeated subplan is spooled once and shown as a DAG"
+fi
+
+# Test 26: Filters on the partition columns prune the partitions a scan reads
+test_endpoint "POST" "/api/catalog/table" '{"name": "p_orders", "columns": [{"name": "id", "data_type": "int"}, {"name": "order_date", "data_type": "date"}, {"name": "region", "data_type": "string"}], "primary_key": ["id"], "partitioning": {"type": "range", "column": "order_date", "partitions": [{"name": "h1", "from": "2024-01-01", "to": "2024-07-01", "subpartitions": {"type": "list", "column": "region", "partitions": [{"name": "h1_eu", "values": ["eu"], "row_count": 300000}, {"name": "h1_us", "values": ["us"], "row_count": 500000}, {"name": "h1_other", "default": true, "row_count": 200000}]}}, {"name": "h2", "from": "2024-07-01", "row_count": 1000000}]}}' 201 "Add partitioned table"
+test_endpoint "POST" "/api/catalog/table" '{"name": "p_bad", "columns": [{"name": "id", "data_type": "int"}], "partitioning": {"type": "hash", "column": "id", "partitions": [{"name": "p0", "remainder": 0}, {"name": "p1", "remainder": 0}]}}' 400 "Partitioning with a repeated hash remainder"
+
+pruned='{
+  "strategy": "cost",
+  "logicalPlan": {
+    "id": "filter", "node_type": "filter",
+    "predicate": {"expression": {"kind": "binary_op", "binary_op": "AND",
+      "left": {"kind": "binary_op", "binary_op": "<", "left": {"kind": "column", "column": {"name": "order_date"}}, "right": {"kind": "literal", "literal": {"type": "date", "value": "2024-03-01"}}},
+      "right": {"kind": "binary_op", "binary_op": "IN", "left": {"kind": "column", "column": {"name": "region"}},
+        "right": {"kind": "list", "args": [{"kind": "literal", "literal": {"type": "string", "value": "eu"}}, {"kind": "literal", "literal": {"type": "string", "value": "us"}}]}}}},
+    "children": [{"id": "scan", "node_type": "scan", "table_name": "p_orders"}]
+  }
+}'
+pruned_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$pruned" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$pruned_response" | grep -q '"optimizedPlan":{.*"partitions":\["h1_eu","h1_us"\]' && echo "$pruned_response" | grep -q '"rule_name":"PartitionPruning"' &&
+    echo "$pruned_response" | grep -q 'scan of p_orders reads 2 of 4 partitions: h1_eu, h1_us'; then
+    print_status "PASS" "Scan of a partitioned table reads only the matching partitions"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Scan of a partitioned table reads only the matching partitions"
+fi
+
+# Test 27: Plans that differ only in an alias are cached apart
+aliased() {
+    echo '{"strategy": "rule", "logicalPlan": {"id": "filter", "node_type": "filter",
+      "predicate": {"expression": {"type": "binary_op", "value": ">", "left": {"type": "column", "value": "'$1'.id"}, "right": {"type": "literal", "value": 5}}},
+      "children": [{"id": "scan", "node_type": "scan", "table_name": "test_table", "alias": "'$1'"}]}}'
+}
+curl -s -X POST -H "Content-Type: application/json" -d "$(aliased c1)" "$BASE_URL/api/optimize" > /dev/null
+alias_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$(aliased c2)" "$BASE_URL/api/optimize")
+alias_repeat=$(curl -s -X POST -H "Content-Type: application/json" -d "$(aliased c2)" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$alias_response" | grep -q '"alias":"c2"' && ! echo "$alias_response" | grep -q 'c1' && ! echo "$alias_response" | grep -q '"cached":true' &&
+    echo "$alias_repeat" | grep -q '"cached":true'; then
+    print_status "PASS" "Plans differing only in alias get their own cache entries"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Plans differing only in alias get their own cache entries"
+fi
+
+# Test 28: Constant folding simplifies predicates but leaves overflowing arithmetic alone
+folding='{
+  "strategy": "rule",
+  "logicalPlan": {
+    "id": "filter", "node_type": "filter",
+    "predicate": {"expression": {"type": "binary_op", "value": "AND",
+      "left": {"type": "binary_op", "value": ">", "left": {"type": "column", "value": "id"},
+        "right": {"type": "binary_op", "value": "+", "left": {"type": "literal", "value": 4}, "right": {"type": "literal", "value": 6}}},
+      "right": {"type": "binary_op", "value": "AND",
+        "left": {"type": "binary_op", "value": ">", "left": {"type": "column", "value": "id"}, "right": {"type": "literal", "value": 5}},
+        "right": {"type": "binary_op", "value": "<", "left": {"type": "column", "value": "id"},
+          "right": {"type": "binary_op", "value": "+", "left": {"type": "literal", "value": 9223372036854775807}, "right": {"type": "literal", "value": 1}}}}}},
+    "children": [{"id": "scan", "node_type": "scan", "table_name": "test_table"}]
+  }
+}'
+contradiction='{
+  "strategy": "rule",
+  "logicalPlan": {
+    "id": "filter", "node_type": "filter",
+    "predicate": {"expression": {"type": "binary_op", "value": "AND",
+      "left": {"type": "binary_op", "value": "=", "left": {"type": "column", "value": "id"}, "right": {"type": "literal", "value": 1}},
+      "right": {"type": "binary_op", "value": "=", "left": {"type": "column", "value": "id"}, "right": {"type": "literal", "value": 2}}}},
+    "children": [{"id": "scan", "node_type": "scan", "table_name": "test_table"}]
+  }
+}'
+folding_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$folding" "$BASE_URL/api/optimize")
+contradiction_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$contradiction" "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$folding_response" | grep -q 'simplified 4 + 6 to 10' && echo "$folding_response" | grep -q 'merged id \\u003e 10 AND id \\u003e 5 into id \\u003e 10' &&
+    ! echo "$folding_response" | grep -q 'simplified 9223372036854775807 + 1'; then
+    print_status "PASS" "Constant folding merges ranges and does not fold an overflowing sum"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Constant folding merges ranges and does not fold an overflowing sum"
+fi
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$contradiction_response" | grep -q '"optimizedPlan":{[^}]*"node_type":"empty"'; then
+    print_status "PASS" "A contradictory filter becomes an empty node"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "A contradictory filter becomes an empty node"
+fi
+
+# Test 29: Legacy expressions come back in the typed format
+simple_filter='{"id": "filter", "node_type": "filter",
+  "predicate": {"expression": {"type": "binary_op", "value": ">", "left": {"type": "column", "value": "id"}, "right": {"type": "literal", "value": 25}}},
+  "children": [{"id": "scan", "node_type": "scan", "table_name": "test_table"}]}'
+typed_response=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "rule", "logicalPlan": '"$simple_filter"'}' "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$typed_response" | grep -q '"kind":"binary_op","binary_op":"\\u003e","left":{"version":2,"kind":"column","column":{"name":"id"}}' &&
+    echo "$typed_response" | grep -q '"literal":{"type":"int","value":25}'; then
+    print_status "PASS" "Legacy expression is returned as a typed expression with an int literal"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Legacy expression is returned as a typed expression with an int literal"
+fi
+
+test_endpoint "POST" "/api/optimize" '{"strategy": "rule", "logicalPlan": {"id": "filter", "node_type": "filter", "predicate": {"expression": {"version": 3, "kind": "literal", "literal": {"type": "bool", "value": true}}}, "children": [{"id": "scan", "node_type": "scan", "table_name": "test_table"}]}}' 400 "Expression with an unsupported version"
+
+# Test 30: Plan diffs report what changed between two plans
+diff_request='{"before": '"$simple_filter"', "after": '"${simple_filter/25/30}"'}'
+diff_response=$(curl -s -X POST -H "Content-Type: application/json" -d "$diff_request" "$BASE_URL/api/plan/diff")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$diff_response" | grep -q '"identical":false' &&
+    echo "$diff_response" | grep -q '"property":"predicate","from":"id \\u003e 25","to":"id \\u003e 30"' &&
+    ! echo "$diff_response" | grep -q '"added"\|"removed"'; then
+    print_status "PASS" "Diff reports the changed predicate and nothing else"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Diff reports the changed predicate and nothing else"
+fi
+
+# Test 31: Fingerprints ignore node IDs, and the shape fingerprint ignores literals
+fingerprint_of() {
+    curl -s -X POST -H "Content-Type: application/json" -d '{"logicalPlan": '"$1"'}' "$BASE_URL/api/plan/fingerprint"
+}
+fingerprint_base=$(fingerprint_of "$simple_filter")
+fingerprint_renamed=$(fingerprint_of "${simple_filter/\"id\": \"filter\"/\"id\": \"renamed\"}")
+fingerprint_literal=$(fingerprint_of "${simple_filter/25/30}")
+exact_of() { echo "$1" | grep -o '"exact":"[0-9a-f]*"'; }
+shape_of() { echo "$1" | grep -o '"shape":"[0-9a-f]*"'; }
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if [ -n "$(exact_of "$fingerprint_base")" ] && [ "$(exact_of "$fingerprint_base")" = "$(exact_of "$fingerprint_renamed")" ] &&
+    [ "$(shape_of "$fingerprint_base")" = "$(shape_of "$fingerprint_literal")" ] && [ "$(exact_of "$fingerprint_base")" != "$(exact_of "$fingerprint_literal")" ]; then
+    print_status "PASS" "Fingerprints match across node IDs and shapes match across literals"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Fingerprints match across node IDs and shapes match across literals"
+fi
+
+# Test 32: Plans render as DOT and Mermaid graphs
+dot_response=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "rule", "format": "dot", "logicalPlan": '"$simple_filter"'}' "$BASE_URL/api/optimize")
+mermaid_response=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "rule", "logicalPlan": '"$simple_filter"'}' "$BASE_URL/api/optimize?format=mermaid")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$dot_response" | grep -q '"rendered":"digraph plan {' && echo "$dot_response" | grep -q 'n0 \[label=\\"FILTER\\\\nid \\u003e 25\\"\]' &&
+    echo "$dot_response" | grep -q 'n1 -\\u003e n0'; then
+    print_status "PASS" "DOT output draws the scan feeding the filter"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "DOT output draws the scan feeding the filter"
+fi
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$mermaid_response" | grep -q '"rendered":"flowchart BT' && echo "$mermaid_response" | grep -q 'n1\[\\"SCAN\\u003cbr/\\u003etest_table\\"\]' &&
+    echo "$mermaid_response" | grep -q 'n1 --\\u003e n0'; then
+    print_status "PASS" "Mermaid output draws the scan feeding the filter"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Mermaid output draws the scan feeding the filter"
+fi
+
+# Test 33: Plans round-trip through Substrait
+substrait_plan=$(curl -s -X POST -H "Content-Type: application/json" -d '{"logicalPlan": '"$simple_filter"'}' "$BASE_URL/api/substrait/export")
+imported=$(curl -s -X POST -H "Content-Type: application/json" -d "$substrait_plan" "$BASE_URL/api/substrait/import")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$substrait_plan" | grep -q '"filter":{"input":{"read":{"baseSchema":{"names":\["id","name"\]' &&
+    echo "$substrait_plan" | grep -q '"namedTable":{"names":\["test_table"\]}' && echo "$substrait_plan" | grep -q '"name":"gt"' &&
+    echo "$imported" | grep -q '"node_type":"filter","children":\[{"id":"[^"]*","node_type":"scan","table_name":"test_table"}\]' &&
+    echo "$imported" | grep -q '"column":{"table":"test_table","name":"id"}},"right":{"version":2,"kind":"literal","literal":{"type":"int","value":25}}'; then
+    print_status "PASS" "Filter over a scan survives a Substrait export and import"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Filter over a scan survives a Substrait export and import"
+fi
+
+# Test 34: Rules report a step only when they rewrite something
+unchanged_response=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "rule", "logicalPlan": '"$simple_filter"'}' "$BASE_URL/api/optimize")
+star_response=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "rule", "logicalPlan": {"id": "project", "node_type": "project", "projections": [{"name": "*"}], "children": [{"id": "scan", "node_type": "scan", "table_name": "test_table"}]}}' "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$unchanged_response" | grep -q '"applied_rules":\[\],"steps":\[\]' &&
+    echo "$star_response" | grep -q '"optimizedPlan":{"id":"[^"]*","node_type":"scan","table_name":"test_table"}' &&
+    echo "$star_response" | grep -q '"applied_rules":\["ProjectionPushdown"\]' && echo "$star_response" | grep -q '"details":\["removed projection of \*"\]'; then
+    print_status "PASS" "Only the rule that removed a projection of * is recorded"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Only the rule that removed a projection of * is recorded"
+fi
+
+# Test 35: Lineage traces output columns back to their source columns
+lineage_response=$(curl -s -X POST -H "Content-Type: application/json" -d '{"logicalPlan": '"$simple_filter"'}' "$BASE_URL/api/lineage")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$lineage_response" | grep -q '{"output":"output.0","source":"test_table.id","kind":"filtered"' &&
+    echo "$lineage_response" | grep -q '{"output":"output.1","source":"test_table.name","kind":"filtered"' &&
+    echo "$lineage_response" | grep -q '"indirect":\[{"source":"test_table.id","kind":"filter","node_id":"filter"'; then
+    print_status "PASS" "Lineage maps each output to its column and the filter to test_table.id"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Lineage maps each output to its column and the filter to test_table.id"
+fi
+
+# Test 36: Conjuncts are pushed below joins, to the preserved side only for outer joins
+pushdown_plan() {
+    echo '{"strategy": "rule", "logicalPlan": {"id": "filter", "node_type": "filter",
+      "predicate": {"expression": '"$2"'},
+      "children": [{"id": "join", "node_type": "join", "join_type": "'$1'",
+        "join_condition": {"left": {"type": "column", "value": "stats_a.id"}, "right": {"type": "column", "value": "stats_b.a_id"}, "operator": "="},
+        "children": [{"id": "scan_a", "node_type": "scan", "table_name": "stats_a"}, {"id": "scan_b", "node_type": "scan", "table_name": "stats_b"}]}]}}'
+}
+a_conjunct='{"type": "binary_op", "value": ">", "left": {"type": "column", "value": "stats_a.c_id"}, "right": {"type": "literal", "value": 5}}'
+b_conjunct='{"type": "binary_op", "value": "<", "left": {"type": "column", "value": "stats_b.c_id"}, "right": {"type": "literal", "value": 3}}'
+both_conjunct='{"type": "binary_op", "value": ">", "left": {"type": "binary_op", "value": "+", "left": {"type": "column", "value": "stats_a.c_id"}, "right": {"type": "column", "value": "stats_b.c_id"}}, "right": {"type": "literal", "value": 1}}'
+b_is_null='{"type": "unary_op", "value": "IS NULL", "left": {"type": "column", "value": "stats_b.c_id"}}'
+inner_pushed=$(curl -s -X POST -H "Content-Type: application/json" \
+    -d "$(pushdown_plan inner '{"type": "binary_op", "value": "AND", "left": '"$a_conjunct"', "right": {"type": "binary_op", "value": "AND", "left": '"$b_conjunct"', "right": '"$both_conjunct"'}}')" \
+    "$BASE_URL/api/optimize" | sed 's/,"explain":.*//')
+left_pushed=$(curl -s -X POST -H "Content-Type: application/json" \
+    -d "$(pushdown_plan left '{"type": "binary_op", "value": "AND", "left": '"$a_conjunct"', "right": '"$b_is_null"'}')" \
+    "$BASE_URL/api/optimize" | sed 's/,"explain":.*//')
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$inner_pushed" | grep -q '"optimizedPlan":{"id":"[^"]*","node_type":"filter","children":\[{"id":"[^"]*","node_type":"join"' &&
+    echo "$inner_pushed" | grep -q '"node_type":"filter","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_a"}\]' &&
+    echo "$inner_pushed" | grep -q '"node_type":"filter","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_b"}\]'; then
+    print_status "PASS" "Single-table conjuncts move below an inner join and the residual stays above"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Single-table conjuncts move below an inner join and the residual stays above"
+fi
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$left_pushed" | grep -q '"node_type":"filter","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_a"}\]' &&
+    echo "$left_pushed" | grep -q '{"id":"[^"]*","node_type":"scan","table_name":"stats_b"}\],"join_type":"left"' &&
+    echo "$left_pushed" | grep -q '"predicate":{"expression":{"version":2,"kind":"unary_op","unary_op":"IS NULL"'; then
+    print_status "PASS" "Only the preserved side of a left join receives a pushed conjunct"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Only the preserved side of a left join receives a pushed conjunct"
+fi
+
+# Test 37: The cost strategy reports the join orders it considered
+two_way_join='{"id": "join", "node_type": "join", "join_type": "inner",
+  "join_condition": {"left": {"type": "column", "value": "stats_a.id"}, "right": {"type": "column", "value": "stats_b.a_id"}, "operator": "="},
+  "children": [{"id": "scan_a", "node_type": "scan", "table_name": "stats_a"}, {"id": "scan_b", "node_type": "scan", "table_name": "stats_b"}]}'
+dp_orders=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "cost", "logicalPlan": '"$two_way_join"'}' "$BASE_URL/api/optimize")
+greedy_orders=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "cost", "options": {"dp_threshold": 1}, "logicalPlan": '"$two_way_join"'}' "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$dp_orders" | grep -q '"join_orders":\[{"relations":\["stats_a","stats_b"\],"strategy":"dynamic_programming","original":{"order":"(stats_a ⋈ stats_b)"' &&
+    echo "$dp_orders" | grep -q '"considered":\[{"order":"([a-z_]* ⋈ [a-z_]*)","cost":[0-9.e+]*},{"order":"([a-z_]* ⋈ [a-z_]*)"' &&
+    echo "$greedy_orders" | grep -q '"join_orders":\[{"relations":\["stats_a","stats_b"\],"strategy":"greedy"'; then
+    print_status "PASS" "Join orders list the search strategy and both considered orders"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Join orders list the search strategy and both considered orders"
+fi
+
+# Test 38: Scans read only the columns the query uses
+pruned_columns=$(curl -s -X POST -H "Content-Type: application/json" \
+    -d '{"strategy": "rule", "logicalPlan": {"id": "project", "node_type": "project", "projections": [{"table": "stats_a", "name": "id"}], "children": ['"$two_way_join"']}}' \
+    "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$pruned_columns" | grep -q '"node_type":"project","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_a","scan_columns":\["id"\]}\]' &&
+    echo "$pruned_columns" | grep -q '"node_type":"project","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_b","scan_columns":\["a_id"\]}\]' &&
+    echo "$pruned_columns" | grep -q 'scan stats_b reads a_id of 3 columns'; then
+    print_status "PASS" "Each side of the join is narrowed to the column it needs"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Each side of the join is narrowed to the column it needs"
+fi
+
+# Test 39: Sort plus limit becomes Top-N, and limits are copied into UNION ALL branches
+top_n_response=$(curl -s -X POST -H "Content-Type: application/json" \
+    -d '{"strategy": "rule", "logicalPlan": {"id": "limit", "node_type": "limit", "limit_count": 10, "children": [{"id": "sort", "node_type": "sort", "order_by": [{"expression": {"type": "column", "value": "id"}, "ascending": true}], "children": [{"id": "scan", "node_type": "scan", "table_name": "test_table"}]}]}}' \
+    "$BASE_URL/api/optimize" | sed 's/,"explain":.*//')
+union_limit=$(curl -s -X POST -H "Content-Type: application/json" \
+    -d '{"strategy": "rule", "logicalPlan": {"id": "limit", "node_type": "limit", "limit_count": 10, "children": [{"id": "union", "node_type": "union", "union_all": true, "children": [{"id": "scan_a", "node_type": "scan", "table_name": "stats_a"}, {"id": "scan_b", "node_type": "scan", "table_name": "stats_b"}]}]}}' \
+    "$BASE_URL/api/optimize" | sed 's/,"explain":.*//')
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$top_n_response" | grep -q '"optimizedPlan":{"id":"[^"]*","node_type":"top_n","children":\[{"id":"[^"]*","node_type":"scan","table_name":"test_table"}\]' &&
+    echo "$top_n_response" | grep -q '"ascending":true}\],"limit_count":10}'; then
+    print_status "PASS" "Sort under a limit is fused into a Top-N node"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Sort under a limit is fused into a Top-N node"
+fi
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$union_limit" | grep -q '"optimizedPlan":{"id":"[^"]*","node_type":"limit","children":\[{"id":"[^"]*","node_type":"union"' &&
+    echo "$union_limit" | grep -q '"node_type":"limit","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_a"}\],"limit_count":10}' &&
+    echo "$union_limit" | grep -q '"node_type":"limit","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_b"}\],"limit_count":10}'; then
+    print_status "PASS" "Each UNION ALL branch gets its own limit under the outer one"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Each UNION ALL branch gets its own limit under the outer one"
+fi
+
+# Test 40: A right or full merge join is not ordered by its left key
+for table in mj_a mj_b; do
+    test_endpoint "POST" "/api/catalog/table" '{"name": "'$table'", "row_count": 2000000, "columns": [{"name": "x", "data_type": "int", "nullable": false}], "indexes": [{"name": "idx_'$table'_x", "columns": ["x"], "unique": false, "type": "btree"}]}' 201 "Add table $table with a btree index"
+done
+merge_join_sort() {
+    echo '{"strategy": "cost", "logicalPlan": {"id": "sort", "node_type": "sort",
+      "order_by": [{"expression": {"type": "column", "value": "'$2'.x"}, "ascending": true}],
+      "children": [{"id": "join", "node_type": "join", "join_type": "'$1'",
+        "join_condition": {"left": {"type": "column", "value": "mj_a.x"}, "right": {"type": "column", "value": "mj_b.x"}, "operator": "="},
+        "children": [{"id": "scan_a", "node_type": "scan", "table_name": "mj_a"}, {"id": "scan_b", "node_type": "scan", "table_name": "mj_b"}]}]}}'
+}
+sorted_by() {
+    curl -s -X POST -H "Content-Type: application/json" -d "$(merge_join_sort $1 $2)" "$BASE_URL/api/optimize"
+}
+inner_by_a=$(sorted_by inner mj_a)
+right_by_a=$(sorted_by right mj_a)
+right_by_b=$(sorted_by right mj_b)
+full_by_a=$(sorted_by full mj_a)
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$inner_by_a" | grep -q 'removed sort on mj_a.x' && echo "$right_by_b" | grep -q 'removed sort on mj_b.x' &&
+    echo "$right_by_a" | grep -q '"optimizedPlan":{"id":"[^"]*","node_type":"sort"' && ! echo "$right_by_a" | grep -q 'removed sort' &&
+    echo "$full_by_a" | grep -q '"optimizedPlan":{"id":"[^"]*","node_type":"sort"' && ! echo "$full_by_a" | grep -q 'removed sort'; then
+    print_status "PASS" "Sorts on the NULL-extended key of a right or full merge join are kept"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Sorts on the NULL-extended key of a right or full merge join are kept"
+fi
+
+# Test 41: A COUNT without a GROUP BY is not rolled up from a grouped view
+test_endpoint "POST" "/api/catalog/view" '{"name": "customers_by_region", "query": "SELECT region, COUNT(*) AS cnt FROM mv_customers GROUP BY region", "row_count": 4, "column_stats": {"region": {"ndv": 4}}}' 201 "Add materialized view of counts by region"
+count_customers() {
+    curl -s -X POST -H "Content-Type: application/json" \
+        -d '{"strategy": "cost", "logicalPlan": {"id": "agg", "node_type": "aggregate", '"$1"' "aggregates": [{"type": "count"}], "children": [{"id": "scan", "node_type": "scan", "table_name": "mv_customers"}]}}' \
+        "$BASE_URL/api/optimize"
+}
+global_count=$(count_customers '')
+grouped_count=$(count_customers '"group_by": [{"table": "mv_customers", "name": "region"}],')
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$global_count" | grep -q '"view":"customers_by_region","used":false,"replaced":"aggregate over mv_customers","reason":"COUNT(\*) without a GROUP BY cannot be rolled up' &&
+    ! echo "$global_count" | grep -q '"table_name":"customers_by_region"' && echo "$grouped_count" | grep -q '"view":"customers_by_region","used":true'; then
+    print_status "PASS" "Global COUNT reads the base table while a grouped COUNT uses the view"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Global COUNT reads the base table while a grouped COUNT uses the view"
+fi
+
+# Test 42: Each step's after_plan is the plan as that rule left it
+transitivity_after=$(curl -s -X POST -H "Content-Type: application/json" -d "$transitive_filter" "$BASE_URL/api/optimize" |
+    sed -e 's/.*"rule_name":"PredicateTransitivity"//' -e 's/"rule_name":"PredicatePushdown".*//' -e 's/.*"after_plan"://')
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$transitivity_after" | grep -q '"node_type":"join","children":\[{"id":"[^"]*","node_type":"scan","table_name":"stats_a"}'; then
+    print_status "PASS" "PredicateTransitivity step does not show the later pushdown"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "PredicateTransitivity step does not show the later pushdown"
+fi
+
+# Test 43: Hints without arguments are kept and reported as unknown
+bare_hints=$(curl -s -X POST -H "Content-Type: application/json" -d '{"dialect": "sql", "query": "SELECT /*+ ORDERED, ALL_ROWS LEADING(a b) */ * FROM test_table"}' "$BASE_URL/api/parse")
+bare_report=$(curl -s -X POST -H "Content-Type: application/json" -d '{"strategy": "cost", "logicalPlan": {"id": "scan", "node_type": "scan", "table_name": "test_table", "hints": [{"name": "ORDERED"}]}}' "$BASE_URL/api/optimize")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$bare_hints" | grep -q '"hints":\[{"name":"ORDERED"},{"name":"ALL_ROWS"},{"name":"LEADING","arguments":\["a","b"\]}\]' &&
+    echo "$bare_report" | grep -q '{"hint":"ORDERED","used":false,"reason":"unknown hint"}'; then
+    print_status "PASS" "Bare hint names parse and are reported as unknown"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Bare hint names parse and are reported as unknown"
+fi
+
+# Test 44: Substrait set operations need two or more inputs of the same width
+set_read='{"read": {"baseSchema": {"names": ["id", "name"], "struct": {"types": [{"i64": {}}, {"string": {}}]}}, "namedTable": {"names": ["test_table"]}}}'
+set_narrow='{"project": {"common": {"emit": {"outputMapping": [0]}}, "input": '"$set_read"', "expressions": []}}'
+set_plan() {
+    echo '{"plan": {"relations": [{"root": {"input": {"set": {"op": "SET_OP_UNION_ALL"'"$1"'}}}}]}}'
+}
+test_endpoint "POST" "/api/substrait/import" "$(set_plan ', "inputs": ['"$set_read"', '"$set_read"']')" 200 "Import a union of two reads"
+single_input=$(curl -s -X POST -H "Content-Type: application/json" -d "$(set_plan ', "inputs": ['"$set_read"']')" "$BASE_URL/api/substrait/import")
+mixed_widths=$(curl -s -X POST -H "Content-Type: application/json" -d "$(set_plan ', "inputs": ['"$set_read"', '"$set_narrow"']')" "$BASE_URL/api/substrait/import")
+
+TESTS_RUN=$((TESTS_RUN + 1))
+if echo "$single_input" | grep -q '"unsupported":\["set operation needs at least two inputs, got 1"\]' &&
+    echo "$mixed_widths" | grep -q '"unsupported":\["set operation input 1 has 1 columns, but the first has 2"\]'; then
+    print_status "PASS" "Set operations with one input or mismatched widths are reported"
+    TESTS_PASSED=$((TESTS_PASSED + 1))
+else
+    print_status "FAIL" "Set operations with one input or mismatched widths are reported"
+fi
+
 # Summary
 echo
 echo "=== Test Results ==="
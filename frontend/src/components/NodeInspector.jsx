@@ -3,7 +3,7 @@ import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
 import { Badge } from '@/components/ui/badge'
 import { Separator } from '@/components/ui/separator'
 import { useQueryStore } from '@/stores/queryStore'
-import { formatCost, formatRows } from '@/lib/utils'
+import { formatCost, formatRows, formatExpression } from '@/lib/utils'
 
 export function NodeInspector() {
     const { selectedNode } = useQueryStore()
@@ -129,7 +129,7 @@ export function NodeInspector() {
                                     {selectedNode.aggregates.map((agg, idx) => (
                                         <div key={idx} className="text-sm text-muted-foreground">
                                             {agg.type.toUpperCase()}
-                                            {agg.column && `(${agg.column.value || agg.column})`}
+                                            {agg.column && `(${formatExpression(agg.column)})`}
                                             {agg.alias && ` AS ${agg.alias}`}
                                         </div>
                                     ))}
@@ -154,7 +154,7 @@ export function NodeInspector() {
                                 <div className="space-y-1">
                                     {selectedNode.order_by.map((order, idx) => (
                                         <div key={idx} className="text-sm text-muted-foreground">
-                                            {order.expression?.value || 'column'} {order.ascending ? 'ASC' : 'DESC'}
+                                            {formatExpression(order.expression) || 'column'} {order.ascending ? 'ASC' : 'DESC'}
                                         </div>
                                     ))}
                                 </div>
@@ -200,21 +200,13 @@ export function NodeInspector() {
 
     const renderPredicate = (predicate) => {
         if (!predicate?.expression) return 'N/A'
-        const expr = predicate.expression
-
-        if (expr.type === 'binary_op') {
-            const left = expr.left?.value || 'column'
-            const right = expr.right?.value || 'value'
-            return `${left} ${expr.value} ${right}`
-        }
-
-        return JSON.stringify(expr, null, 2)
+        return formatExpression(predicate.expression)
     }
 
     const renderJoinCondition = (condition) => {
         if (!condition) return 'N/A'
-        const left = condition.left?.value || 'left'
-        const right = condition.right?.value || 'right'
+        const left = formatExpression(condition.left) || 'left'
+        const right = formatExpression(condition.right) || 'right'
         return `${left} ${condition.operator} ${right}`
     }
 
@@ -45,4 +45,42 @@ export function debounce(func, wait) {
 
 export function deepClone(obj) {
     return JSON.parse(JSON.stringify(obj))
-}
\ No newline at end of file
+}
+export function formatExpression(expr) {
+    if (!expr) return ''
+    if (!expr.kind) {
+        // legacy {type, value} shape
+        if (expr.type === 'binary_op') {
+            return `${formatExpression(expr.left)} ${expr.value} ${formatExpression(expr.right)}`
+        }
+        return expr.value !== undefined ? String(expr.value) : JSON.stringify(expr)
+    }
+    switch (expr.kind) {
+        case 'column':
+            return expr.column.table ? `${expr.column.table}.${expr.column.name}` : expr.column.name
+        case 'literal': {
+            const { type, value } = expr.literal
+            if (value === null || value === undefined) return 'NULL'
+            if (type === 'string') return `'${value}'`
+            if (type === 'date') return `DATE '${value}'`
+            return String(value)
+        }
+        case 'binary_op':
+            return `${formatExpression(expr.left)} ${expr.binary_op} ${formatExpression(expr.right)}`
+        case 'unary_op':
+            if (expr.unary_op === 'IS NULL' || expr.unary_op === 'IS NOT NULL') {
+                return `${formatExpression(expr.operand)} ${expr.unary_op}`
+            }
+            return `${expr.unary_op} ${formatExpression(expr.operand)}`
+        case 'function':
+            return `${expr.function}(${(expr.args || []).map(formatExpression).join(', ')})`
+        case 'list':
+            return `(${(expr.args || []).map(formatExpression).join(', ')})`
+        case 'cast':
+            return `CAST(${formatExpression(expr.operand)} AS ${expr.cast_type.toUpperCase()})`
+        case 'parameter':
+            return expr.parameter?.name ? `:${expr.parameter.name}` : `$${expr.parameter?.index}`
+        default:
+            return expr.kind
+    }
+}